@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bcdl/internal"
+	"flag"
+	"log"
+	"os"
+)
+
+// runClean removes what crashed or interrupted runs left behind in the library.
+func runClean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	outpath := fs.String("outpath", os.Getenv("BCDL_OUTPATH"), "Library directory to clean up [$BCDL_OUTPATH]")
+	olderThan := fs.Duration("older-than", internal.DefaultCleanAge, "Only remove leftovers older than this, younger ones may belong to a running download")
+	dryRun := fs.Bool("dry-run", false, "List what would be removed without removing it")
+	fs.Parse(args)
+
+	if *outpath == "" {
+		log.Fatalf("Pass the library directory to clean up with --outpath")
+	}
+
+	report, err := internal.Clean(*outpath, *olderThan, *dryRun)
+
+	for _, path := range report.Files {
+		if *dryRun {
+			log.Printf("Would remove %s\n", path)
+		} else {
+			log.Printf("Removed %s\n", path)
+		}
+	}
+
+	if err != nil {
+		log.Fatalf("Could not clean up %s: %v", *outpath, err)
+	}
+
+	if *dryRun {
+		log.Printf("%d files, %s would be freed\n", len(report.Files), internal.FormatSize(report.Bytes))
+	} else {
+		log.Printf("Removed %d files, freeing %s\n", len(report.Files), internal.FormatSize(report.Bytes))
+	}
+}
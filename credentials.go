@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bcdl/internal"
+	"log"
+	"os"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// credentials are what a run signs into Bandcamp with.
+type credentials struct {
+	identity string
+	cookies  []playwright.OptionalCookie
+	// saved is set when the identity came from `bcdl login` and should be kept up to date
+	saved bool
+}
+
+// resolveCredentials finds the identity cookie of the Bandcamp user, in order of precedence,
+// from a cookies.txt file, a browser, BCDL_IDENTITY or what `bcdl login` saved for the
+// account. The identity is empty if none of them have one.
+func resolveCredentials(username, cookiesFile, identityFrom string) (credentials, error) {
+	var creds credentials
+	var err error
+
+	switch {
+	case cookiesFile != "":
+		if creds.cookies, err = internal.ReadCookiesFile(cookiesFile); err != nil {
+			return creds, err
+		}
+
+		creds.identity = internal.IdentityFromCookies(creds.cookies)
+	case identityFrom != "":
+		if creds.identity, err = internal.ImportIdentity(identityFrom); err != nil {
+			return creds, err
+		}
+	case os.Getenv("BCDL_IDENTITY") != "":
+		// Keeps the identity off the command line in containers and cron jobs
+		creds.identity = os.Getenv("BCDL_IDENTITY")
+	default:
+		if creds.identity, err = internal.LoadIdentity(username); err != nil {
+			log.Printf("Ignoring saved identity: %v\n", err)
+		}
+
+		creds.saved = creds.identity != ""
+	}
+
+	return creds, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bcdl/internal"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// runDaemon keeps several profiles of the config file downloading at once, e.g. one per
+// account of a household.
+func runDaemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("BCDL_CONFIG"), "Config file to read the profiles from (default: bcdl/config.toml in your config directory) [$BCDL_CONFIG]")
+	profiles := fs.String("profiles", "", "Comma-separated profiles to run (default: every profile of the config file)")
+	watch := fs.Duration("watch", 15*time.Minute, "How often to check for new purchases, for profiles that don't set watch")
+	metrics := fs.String("metrics", "", "Serve Prometheus metrics of the profiles at /metrics on this address, e.g. :9090, and take items to download next at /get, see bcdl get")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: bcdl daemon [flags] [-- FLAGS]\n\nFLAGS after -- are passed to every profile, e.g. -- --extract --staging.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *configPath == "" {
+		path, err := internal.DefaultConfigPath()
+
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		*configPath = path
+	}
+
+	config, err := internal.LoadConfig(*configPath)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	names := splitList(*profiles)
+
+	if len(names) == 0 {
+		for name := range config.Profiles {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+	}
+
+	if len(names) == 0 {
+		log.Fatalf("%s has no profiles to run, add one per account as [profile.NAME]", *configPath)
+	}
+
+	var run []internal.DaemonProfile
+
+	for _, name := range names {
+		profile, err := config.Resolve(name)
+
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		// Nobody is there to answer the questions of the TUI
+		if profile.Username == "" || (profile.Directory == "" || profile.FileType == "") && len(profile.Targets) == 0 {
+			log.Fatalf("Profile %s needs a username, directory and filetype or targets to run unattended", name)
+		}
+
+		profileArgs := []string{"--config", *configPath, "--profile", name, "--control-stdin"}
+
+		if profile.Watch == 0 {
+			profileArgs = append(profileArgs, "--watch", watch.String())
+		}
+
+		run = append(run, internal.DaemonProfile{Name: name, Args: append(profileArgs, fs.Args()...)})
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		log.Fatalf("Could not find the bcdl executable: %v", err)
+	}
+
+	// Once for every profile, instead of each of them racing to download Chromium
+	if err := internal.InstallDriver(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	daemon := internal.NewDaemon(executable, run)
+
+	if *metrics != "" {
+		go func() {
+			if err := daemon.Serve(ctx, *metrics); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}()
+	}
+
+	log.Printf("Running profiles %v\n", names)
+	daemon.Run(ctx)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bcdl/internal"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// doctorCheck is one thing `bcdl doctor` verifies. run returns a short detail on success.
+type doctorCheck struct {
+	name string
+	run  func() (string, error)
+	// hint tells the user how to fix a failed check
+	hint func(error) string
+}
+
+// runDoctor checks that everything a download needs is in place and explains how to fix
+// whatever isn't. It exits with status 1 if any check fails.
+//
+// With --fix, a broken Playwright install is replaced first.
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	username := fs.String("username", os.Getenv("BCDL_USERNAME"), "Bandcamp username to check the identity cookie against")
+	outpath := fs.String("outpath", os.Getenv("BCDL_OUTPATH"), "Directory downloads will be saved to")
+	identityFrom := fs.String("identity-from", "", "Check the identity cookie of a browser instead: firefox, chrome, chromium, brave or auto")
+	cookiesFile := fs.String("cookies-file", "", "Check the identity cookie in a cookies.txt file instead")
+	fix := fs.Bool("fix", false, "Reinstall the Playwright driver and Chromium into bcdl's own directory if they don't work")
+	fs.Parse(args)
+
+	if *fix {
+		fixPlaywright()
+	}
+
+	checks := []doctorCheck{
+		{
+			name: "Playwright driver",
+			run: func() (string, error) {
+				status, err := internal.CheckDriver()
+				return fmt.Sprintf("%s in %s", status.Installed, status.Dir), err
+			},
+			hint: func(error) string {
+				return "Run `bcdl doctor --fix` to install the version bcdl needs"
+			},
+		},
+		{
+			name: "Browser",
+			run: func() (string, error) {
+				version, err := internal.CheckBrowser()
+				return "Chromium " + version, err
+			},
+			hint: func(error) string {
+				return "Run `bcdl doctor --fix` to reinstall Chromium. If it still fails, install its system dependencies with `go run github.com/playwright-community/playwright-go/cmd/playwright install-deps chromium`"
+			},
+		},
+		{
+			name: "Identity",
+			run: func() (string, error) {
+				return checkIdentity(*username, *cookiesFile, *identityFrom)
+			},
+			hint: func(err error) string {
+				if errors.Is(err, internal.ErrNotSignedIn) {
+					return "Sign in again with `bcdl login` or copy a fresh identity cookie from your browser"
+				}
+
+				return "Run `bcdl login`, or pass --username and one of --identity-from or --cookies-file"
+			},
+		},
+		{
+			name: "Output directory",
+			run: func() (string, error) {
+				return checkWritable(*outpath)
+			},
+			hint: func(error) string {
+				return "Pass --outpath with a directory you can write to"
+			},
+		},
+		{
+			name: "Free space",
+			run: func() (string, error) {
+				return checkFreeSpace(*outpath)
+			},
+			hint: func(error) string {
+				return "Free up space or pick a directory on another disk with --outpath"
+			},
+		},
+	}
+
+	failed := 0
+
+	for _, check := range checks {
+		detail, err := check.run()
+
+		if err != nil {
+			failed++
+			fmt.Printf("✗ %s: %v\n  %s\n", check.name, err, check.hint(err))
+			continue
+		}
+
+		fmt.Printf("✓ %s: %s\n", check.name, detail)
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d of %d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+
+	fmt.Println("\nEverything looks good")
+}
+
+// fixPlaywright reinstalls the Playwright driver and Chromium unless both already work.
+func fixPlaywright() {
+	_, driverErr := internal.CheckDriver()
+	_, browserErr := internal.CheckBrowser()
+
+	if driverErr == nil && browserErr == nil {
+		return
+	}
+
+	fmt.Println("Reinstalling Playwright...")
+
+	status, err := internal.ReinstallDriver()
+
+	if err != nil {
+		fmt.Printf("Could not reinstall Playwright: %v\n\n", err)
+		return
+	}
+
+	fmt.Printf("Installed Playwright %s into %s\n\n", status.Installed, status.Dir)
+}
+
+// checkIdentity makes sure the identity the download would use signs into the expected user.
+func checkIdentity(username, cookiesFile, identityFrom string) (string, error) {
+	if username == "" {
+		return "", errors.New("No username given")
+	}
+
+	creds, err := resolveCredentials(username, cookiesFile, identityFrom)
+
+	if err != nil {
+		return "", err
+	}
+
+	if creds.identity == "" {
+		return "", errors.New("No identity cookie found")
+	}
+
+	fan, err := internal.VerifyIdentity(internal.NewUserWithCookies(username, creds.identity, creds.cookies))
+
+	if err != nil {
+		return "", err
+	}
+
+	return "signed in as " + fan.Username, nil
+}
+
+// checkWritable creates and removes a file in dir, or in its parent when dir doesn't exist
+// yet since the download creates it.
+func checkWritable(dir string) (string, error) {
+	dir, err := existingDir(dir)
+
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(dir, ".bcdl-doctor-*")
+
+	if err != nil {
+		return "", fmt.Errorf("Could not write to %s: %w", dir, err)
+	}
+
+	f.Close()
+	os.Remove(f.Name())
+
+	return dir + " is writable", nil
+}
+
+// checkFreeSpace reports how much room is left next to dir.
+func checkFreeSpace(dir string) (string, error) {
+	dir, err := existingDir(dir)
+
+	if err != nil {
+		return "", err
+	}
+
+	free, err := internal.FreeSpace(dir)
+
+	if err != nil {
+		return "", err
+	}
+
+	if free == 0 {
+		return "", fmt.Errorf("%s is full", dir)
+	}
+
+	return internal.FormatSize(int64(free)) + " available", nil
+}
+
+// existingDir returns dir, or its parent if dir hasn't been created yet.
+func existingDir(dir string) (string, error) {
+	if dir == "" {
+		return "", errors.New("No output directory given")
+	}
+
+	info, err := os.Stat(dir)
+
+	if errors.Is(err, os.ErrNotExist) {
+		parent := filepath.Dir(filepath.Clean(dir))
+
+		if info, err = os.Stat(parent); err == nil && info.IsDir() {
+			return parent, nil
+		}
+
+		return "", fmt.Errorf("Neither %s nor its parent exist", dir)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Could not read %s: %w", dir, err)
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return dir, nil
+}
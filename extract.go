@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bcdl/internal"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// runExtract unpacks the downloaded archives of a library into Artist/Album directories.
+func runExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	outpath := fs.String("outpath", os.Getenv("BCDL_OUTPATH"), "Library directory to extract [$BCDL_OUTPATH]")
+	discLayout := fs.String("disc-layout", "flat", "How to lay out multi-disc releases: flat, subfolders or combined")
+	playlists := fs.Bool("playlists", false, "Write an M3U8 playlist into every album directory")
+	beets := fs.Bool("beets", false, "Run `beet import -q` on the newly extracted albums")
+	beetsQueue := fs.String("beets-queue", "", "Append the newly extracted album directories to this file for a later `beet import` instead")
+	unicodeForm := fs.String("normalize", "", "Normalize file names to a unicode form: nfc or nfd")
+	ascii := fs.Bool("ascii", false, "Transliterate file names to plain ASCII")
+	sanitize := fs.Bool("sanitize", runtime.GOOS == "windows", "Replace characters Windows and SMB shares can't store in file names")
+	replacement := fs.String("replacement", "_", "With --sanitize, what unsafe characters are replaced with")
+	var replace replaceFlags
+	fs.Var(&replace, "replace", "With --sanitize, replace a single character as CHAR=TEXT. Repeat for every character")
+	fs.Parse(args)
+
+	if *outpath == "" {
+		log.Fatalf("Pass the library directory to extract with --outpath")
+	}
+
+	layout, err := internal.ParseDiscLayout(*discLayout)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	form, err := internal.ParseUnicodeForm(*unicodeForm)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	opts := internal.ExtractOptions{
+		DiscLayout: layout,
+		Playlists:  *playlists,
+		Filenames:  internal.FilenamePolicy{Form: form, ASCII: *ascii, Sanitize: *sanitize, Replacement: *replacement, Replacements: replace},
+	}
+
+	var extracted, skipped, failed int
+	var albums []string
+
+	err = internal.ExtractLibrary(*outpath, opts, func(album internal.ExtractedAlbum, err error) {
+		switch {
+		case err != nil:
+			failed++
+			log.Printf("Could not extract %s: %v\n", album.Archive, err)
+		case album.Skipped:
+			skipped++
+		default:
+			extracted++
+			albums = append(albums, filepath.Join(*outpath, filepath.FromSlash(album.Dir)))
+			log.Printf("Extracted %s into %s\n", album.Archive, album.Dir)
+		}
+	})
+
+	if err != nil {
+		log.Fatalf("Could not extract %s: %v", *outpath, err)
+	}
+
+	log.Printf("Extracted %d albums, %d were already extracted, %d failed\n", extracted, skipped, failed)
+
+	if *beets || *beetsQueue != "" {
+		beetsOpts := internal.DefaultBeetsOptions()
+		beetsOpts.Queue = *beetsQueue
+
+		if err := internal.ImportIntoBeets(albums, beetsOpts); err != nil {
+			log.Printf("Could not import the albums into beets: %v", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
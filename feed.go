@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bcdl/internal"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runFeed prints the fan feed, new releases of followed artists and purchases of followed
+// fans, or keeps watching it for stories matching keywords.
+func runFeed(args []string) {
+	fs := flag.NewFlagSet("feed", flag.ExitOnError)
+	username := fs.String("username", os.Getenv("BCDL_USERNAME"), "Fail unless the identity cookie belongs to this username")
+	identityFrom := fs.String("identity-from", "", "Use the identity cookie of a browser: firefox, chrome, chromium, brave or auto")
+	cookiesFile := fs.String("cookies-file", "", "Use the identity cookie in a cookies.txt file")
+	since := fs.Duration("since", 7*24*time.Hour, "How far back to read the feed")
+	match := fs.String("match", "", "Only show stories whose title, artist or fan contain one of these comma separated keywords")
+	export := fs.String("export", "", "Write the stories to this file instead, as CSV when it ends in .csv and JSON otherwise")
+	watch := fs.Duration("watch", 0, "Keep running and check the feed this often, e.g. 1h, reporting new stories only")
+	webhookURL := fs.String("webhook-url", "", "POST a \"feed\" event to this URL for every new story")
+	webhookTemplate := fs.String("webhook-template", "", "Go template file used to render webhook payloads (default: JSON)")
+	fs.Parse(args)
+
+	creds, err := resolveCredentials(*username, *cookiesFile, *identityFrom)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if creds.identity == "" {
+		log.Fatalf("No identity cookie found. Run `bcdl login` first")
+	}
+
+	var keywords []string
+
+	for _, keyword := range strings.Split(*match, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+
+	var webhook *internal.Webhook
+
+	if *webhookURL != "" {
+		if webhook, err = newWebhook(*webhookURL, *webhookTemplate); err != nil {
+			log.Fatalf("Invalid webhook: %v", err)
+		}
+	}
+
+	user := internal.NewUserWithCookies(*username, creds.identity, creds.cookies)
+	seen := map[string]bool{}
+	from := time.Now().Add(-*since)
+
+	for {
+		stories, err := internal.ReadFeed(user, from)
+
+		if errors.Is(err, internal.ErrNotSignedIn) {
+			log.Fatalf("%v. Run `bcdl login` to sign in again", err)
+		}
+
+		if err != nil && *watch == 0 {
+			log.Fatalf("%v", err)
+		}
+
+		if err != nil {
+			log.Printf("Could not check the feed: %v", err)
+		}
+
+		var fresh []internal.FeedStory
+
+		for _, story := range internal.MatchFeed(stories, keywords) {
+			if !seen[story.Key()] {
+				seen[story.Key()] = true
+				fresh = append(fresh, story)
+			}
+		}
+
+		reportFeed(fresh, *export, webhook)
+
+		if *watch == 0 {
+			return
+		}
+
+		time.Sleep(*watch)
+		// Only look at what was posted since the last check, with some overlap for late stories
+		from = time.Now().Add(-2 * *watch)
+	}
+}
+
+// reportFeed prints, exports and sends the new stories.
+func reportFeed(stories []internal.FeedStory, export string, webhook *internal.Webhook) {
+	if export != "" {
+		file, err := os.Create(export)
+
+		if err != nil {
+			log.Fatalf("Could not create %s: %v", export, err)
+		}
+
+		format := strings.TrimPrefix(strings.ToLower(filepath.Ext(export)), ".")
+
+		if err = internal.WriteFeed(file, stories, format); err == nil {
+			err = file.Close()
+		}
+
+		if err != nil {
+			log.Fatalf("Could not write %s: %v", export, err)
+		}
+	}
+
+	for _, story := range stories {
+		if export == "" {
+			fmt.Println(feedLine(story))
+		}
+
+		if webhook == nil {
+			continue
+		}
+
+		if err := webhook.Send(internal.FeedEvent(story)); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// feedLine describes a story in one line.
+func feedLine(story internal.FeedStory) string {
+	line := fmt.Sprintf("%s  %s - %s  %s", story.Date.Format(time.DateOnly), story.Artist, story.Title, story.URL)
+
+	if story.Kind == internal.FeedPurchase {
+		line = fmt.Sprintf("%s  (bought by %s)", line, story.Fan)
+	} else {
+		line = fmt.Sprintf("%s  (new release)", line)
+	}
+
+	return line
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// runGet asks a running bcdl daemon to download an item of a collection next.
+func runGet(args []string) {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	daemon := fs.String("daemon", os.Getenv("BCDL_DAEMON"), "Address the daemon serves --metrics on, e.g. localhost:9090 [$BCDL_DAEMON]")
+	profile := fs.String("profile", os.Getenv("BCDL_PROFILE"), "Profile whose collection has the item, if the daemon runs more than one [$BCDL_PROFILE]")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: bcdl get [flags] URL\n\nDownloads the item at URL ahead of the rest of the queue of a running bcdl daemon.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *daemon == "" {
+		log.Fatalf("Pass the address of the daemon with --daemon")
+	}
+
+	address := *daemon
+
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	resp, err := http.PostForm(strings.TrimSuffix(address, "/")+"/get", url.Values{"profile": {*profile}, "url": {fs.Arg(0)}})
+
+	if err != nil {
+		log.Fatalf("Could not reach the daemon: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		log.Fatalf("The daemon didn't take the item: %s", strings.TrimSpace(string(body)))
+	}
+
+	fmt.Println("Asked the daemon to download it next, see its log for how it went")
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"log"
+	"slices"
+	"sync"
+)
+
+// How many of the latest downloads are looked at, how many of them have to fail to halve
+// the number of jobs at once, and how many in a row have to succeed to add one again.
+const (
+	adaptiveWindow   = 10
+	adaptiveFailures = 3
+	adaptiveRecovery = 10
+)
+
+// WithAdaptiveConcurrency lowers the number of albums downloaded at the same time when
+// downloads start to fail or time out, e.g. because Bandcamp takes long to prepare heavy
+// formats, and raises it again up to WithConcurrency once they succeed.
+func WithAdaptiveConcurrency() func(*Downloader) {
+	return func(d *Downloader) {
+		d.adaptive = true
+	}
+}
+
+// concurrencyLimit is how many workers may run a job at the same time.
+type concurrencyLimit struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	max     int
+	running int
+	// recent are the outcomes since the limit last changed, successes are true
+	recent []bool
+}
+
+// newConcurrencyLimit lets up to max workers run jobs at once, to begin with.
+func newConcurrencyLimit(max int) *concurrencyLimit {
+	c := &concurrencyLimit{limit: max, max: max}
+	c.cond = sync.NewCond(&c.mu)
+
+	return c
+}
+
+// acquire waits until the worker may run a job.
+func (c *concurrencyLimit) acquire() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.running >= c.limit {
+		c.cond.Wait()
+	}
+
+	c.running++
+}
+
+// release lets another worker run a job.
+func (c *concurrencyLimit) release() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.running--
+	c.cond.Signal()
+}
+
+// record adjusts the limit to the outcome of a download.
+func (c *concurrencyLimit) record(ok bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recent = append(c.recent, ok)
+
+	if len(c.recent) > adaptiveWindow {
+		c.recent = c.recent[1:]
+	}
+
+	failures := 0
+
+	for _, ok := range c.recent {
+		if !ok {
+			failures++
+		}
+	}
+
+	switch {
+	case failures >= adaptiveFailures && c.limit > 1:
+		c.limit = max(c.limit/2, 1)
+		c.recent = nil
+		log.Printf("%d of the last downloads failed, downloading %d at a time", failures, c.limit)
+	case c.limit < c.max && len(c.recent) >= adaptiveRecovery && !slices.Contains(c.recent[len(c.recent)-adaptiveRecovery:], false):
+		c.limit++
+		c.recent = nil
+		c.cond.Broadcast()
+		log.Printf("Downloads are going well again, downloading %d at a time", c.limit)
+	}
+}
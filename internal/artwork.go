@@ -0,0 +1,418 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArtworkSize is the image format id Bandcamp uses in its image URLs.
+type ArtworkSize int
+
+// The artwork sizes Bandcamp serves. ArtworkOriginal is the full resolution upload.
+const (
+	ArtworkOriginal ArtworkSize = 0
+	Artwork1200     ArtworkSize = 10
+	Artwork700      ArtworkSize = 16
+	Artwork350      ArtworkSize = 2
+)
+
+// ArtworkFormat is the image format artwork is saved in.
+type ArtworkFormat string
+
+const (
+	ArtworkJPG ArtworkFormat = "jpg"
+	ArtworkPNG ArtworkFormat = "png"
+)
+
+// ArtworkOptions controls which images are saved alongside each album.
+type ArtworkOptions struct {
+	Size   ArtworkSize
+	Format ArtworkFormat
+	// ArtistImage also saves the artist's profile image.
+	ArtistImage bool
+	// Cover saves the artwork as cover.jpg in the album's directory, where music players
+	// look for it: the extracted album, or the directory of the archive when the path
+	// template gives every album its own. Other albums keep their artwork under their name.
+	Cover bool
+	// Cache is a directory the images are kept in once fetched, by item id, so an album
+	// downloaded again in another format doesn't fetch its artwork again. Empty fetches
+	// them every time. Images unused for 30 days are removed, and the least recently used
+	// ones once the cache grows past 512 MB.
+	Cache string
+}
+
+// DefaultArtworkOptions saves the original cover as a jpg without the artist image.
+func DefaultArtworkOptions() ArtworkOptions {
+	return ArtworkOptions{Size: ArtworkOriginal, Format: ArtworkJPG}
+}
+
+// DefaultArtworkCache returns where bcdl keeps the artwork it fetched unless the config
+// says otherwise, see ArtworkOptions.Cache.
+func DefaultArtworkCache() (string, error) {
+	dir, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", fmt.Errorf("Could not find the cache directory: %w", err)
+	}
+
+	return filepath.Join(dir, "bcdl", "artwork"), nil
+}
+
+// ParseArtworkSize converts "original" or a pixel size such as "1200" into an ArtworkSize.
+func ParseArtworkSize(s string) (ArtworkSize, error) {
+	switch s {
+	case "original", "":
+		return ArtworkOriginal, nil
+	case "1200":
+		return Artwork1200, nil
+	case "700":
+		return Artwork700, nil
+	case "350":
+		return Artwork350, nil
+	}
+
+	return 0, fmt.Errorf("Unknown artwork size %q, expected one of original, 1200, 700, 350", s)
+}
+
+// ParseArtworkFormat validates an artwork format name.
+func ParseArtworkFormat(s string) (ArtworkFormat, error) {
+	switch ArtworkFormat(s) {
+	case ArtworkJPG, ArtworkPNG:
+		return ArtworkFormat(s), nil
+	}
+
+	return "", fmt.Errorf("Unknown artwork format %q, expected jpg or png", s)
+}
+
+var artClient = &http.Client{Transport: newTransport(), Timeout: time.Minute}
+
+// Art ids on the collection page look like https://f4.bcbits.com/img/a1234567890_9.jpg
+var artIDPattern = regexp.MustCompile(`/img/a?(\d+)_\d+\.\w+`)
+
+// Artist images are linked from the band page with the band-photo class
+var bandPhotoPattern = regexp.MustCompile(`<img[^>]+class="band-photo"[^>]+src="([^"]+)"`)
+
+// parseArtID pulls the image id out of a bcbits image URL.
+func parseArtID(src string) string {
+	match := artIDPattern.FindStringSubmatch(src)
+
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// artworkURL builds the bcbits URL for an image. Album art ids are prefixed with "a",
+// artist images are not.
+func artworkURL(prefix, id string, size ArtworkSize) string {
+	return fmt.Sprintf("https://f4.bcbits.com/img/%s%s_%d.jpg", prefix, id, size)
+}
+
+// artworkCache is a directory fetched images are kept in, see ArtworkOptions.Cache.
+type artworkCache string
+
+// How long cached artwork is kept since it was last used, and how much is kept at most
+const (
+	artworkCacheAge  = 30 * 24 * time.Hour
+	artworkCacheSize = 512 << 20
+)
+
+// path returns where the image with the key is kept, whatever its format.
+func (c artworkCache) path(key string) string {
+	return filepath.Join(string(c), key)
+}
+
+// has reports whether the image with the key was fetched before. Images without a key
+// aren't cached.
+func (c artworkCache) has(key string) bool {
+	if c == "" || key == "" {
+		return false
+	}
+
+	_, err := os.Stat(c.path(key))
+
+	return err == nil
+}
+
+// fetch returns the image fetched under key before from the cache, or fetches it from the
+// URL src returns when it isn't cached or can't be read.
+func (c artworkCache) fetch(key string, src func() (string, error)) ([]byte, error) {
+	if c.has(key) {
+		data, err := os.ReadFile(c.path(key))
+
+		if err == nil {
+			// Marks it as used, see prune
+			now := time.Now()
+			os.Chtimes(c.path(key), now, now)
+
+			return data, nil
+		}
+
+		log.Printf("Could not read cached artwork, fetching it again: %v", err)
+	}
+
+	link, err := src()
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := artClient.Get(link)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch artwork: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not fetch artwork %s: %s", link, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch artwork: %w", err)
+	}
+
+	// The image is saved either way, the next album only has to fetch it again
+	if c != "" && key != "" {
+		if err := c.store(c.path(key), data); err != nil {
+			log.Printf("Could not cache artwork: %v", err)
+		}
+	}
+
+	return data, nil
+}
+
+// prune removes the images that weren't used for artworkCacheAge, then the least recently
+// used ones until the cache fits into artworkCacheSize.
+func (c artworkCache) prune() error {
+	entries, err := os.ReadDir(string(c))
+
+	if c == "" || errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not read the artwork cache: %w", err)
+	}
+
+	type cachedImage struct {
+		path string
+		size int64
+		used time.Time
+	}
+
+	var images []cachedImage
+	var total int64
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(string(c), entry.Name())
+
+		if time.Since(info.ModTime()) > artworkCacheAge {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Could not remove cached artwork: %v", err)
+			}
+
+			continue
+		}
+
+		images = append(images, cachedImage{path: path, size: info.Size(), used: info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].used.Before(images[j].used) })
+
+	for _, image := range images {
+		if total <= artworkCacheSize {
+			break
+		}
+
+		if err := os.Remove(image.path); err != nil {
+			log.Printf("Could not remove cached artwork: %v", err)
+			continue
+		}
+
+		total -= image.size
+	}
+
+	return nil
+}
+
+// store writes the image to path through a temporary file, so concurrent downloads of the
+// same album never read half an image.
+func (c artworkCache) store(path string, image []byte) error {
+	if err := os.MkdirAll(string(c), 0o777); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(string(c), ".bcdl-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(image); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveImage saves the image fetched through the cache under key, from the URL src returns,
+// as name in the requested format. Bandcamp mostly serves jpgs, but originals are whatever
+// was uploaded, so images are converted locally when their format doesn't match.
+func saveImage(storage Storage, cache artworkCache, key string, src func() (string, error), name string, format ArtworkFormat) error {
+	data, err := cache.fetch(key, src)
+
+	if err != nil {
+		return err
+	}
+
+	var fetched ArtworkFormat
+
+	switch kind := http.DetectContentType(data); kind {
+	case "image/jpeg":
+		fetched = ArtworkJPG
+	case "image/png":
+		fetched = ArtworkPNG
+	default:
+		return fmt.Errorf("Unsupported artwork of type %s", kind)
+	}
+
+	if fetched == format {
+		return storage.Save(name, bytes.NewReader(data), int64(len(data)))
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return fmt.Errorf("Could not decode artwork: %w", err)
+	}
+
+	var buf bytes.Buffer
+
+	if format == ArtworkPNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not convert artwork: %w", err)
+	}
+
+	return storage.Save(name, &buf, int64(buf.Len()))
+}
+
+// artistImageURL finds the artist image on the band page the album belongs to.
+func artistImageURL(albumURL url.URL, size ArtworkSize) (string, error) {
+	bandURL := url.URL{Scheme: albumURL.Scheme, Host: albumURL.Host}
+
+	resp, err := artClient.Get(bandURL.String())
+
+	if err != nil {
+		return "", fmt.Errorf("Could not load band page: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	page, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return "", err
+	}
+
+	match := bandPhotoPattern.FindSubmatch(page)
+
+	if match == nil {
+		return "", fmt.Errorf("No artist image on %s", bandURL.String())
+	}
+
+	id := parseArtID(string(match[1]))
+
+	if id == "" {
+		return "", fmt.Errorf("Unrecognized artist image %s", match[1])
+	}
+
+	return artworkURL("", id, size), nil
+}
+
+// artworkStems returns the names, without an extension, of the artwork and the artist image
+// of the download saved as name. albumDir is the directory of the album, if it has one.
+func artworkStems(name, albumDir string, opts ArtworkOptions) (cover, artist string) {
+	if opts.Cover && albumDir != "" {
+		return path.Join(albumDir, "cover"), path.Join(albumDir, "artist")
+	}
+
+	stem := strings.TrimSuffix(name, path.Ext(name))
+
+	return stem, stem + ".artist"
+}
+
+// saveArtwork saves the cover, and optionally the artist image, for the entry using
+// the stems as the file names without an extension.
+func saveArtwork(storage Storage, entry CollectionEntry, stem, artistStem string, opts ArtworkOptions) error {
+	if entry.artID == "" {
+		return fmt.Errorf("No artwork found for %s", entry.title)
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = ArtworkJPG
+	}
+
+	cache := artworkCache(opts.Cache)
+	// The same cover in the same size, whatever the format of the download
+	var key, artistKey string
+
+	if entry.id != "" {
+		key = fmt.Sprintf("%s_%d", entry.id, opts.Size)
+	}
+
+	if entry.bandID != "" {
+		artistKey = fmt.Sprintf("band%s_%d", entry.bandID, opts.Size)
+	}
+
+	cover := func() (string, error) { return artworkURL("a", entry.artID, opts.Size), nil }
+	err := saveImage(storage, cache, key, cover, stem+"."+string(format), format)
+
+	if err != nil || !opts.ArtistImage || entry.itemURL.Host == "" {
+		return err
+	}
+
+	// The band page is only read for artist images that aren't cached yet
+	artist := func() (string, error) { return artistImageURL(entry.itemURL, opts.Size) }
+
+	return saveImage(storage, cache, artistKey, artist, artistStem+"."+string(format), format)
+}
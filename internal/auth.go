@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// ErrNotSignedIn is returned when Bandcamp doesn't recognize the identity cookie.
+var ErrNotSignedIn = errors.New("Identity cookie invalid or expired")
+
+// Fan is the Bandcamp account a browser is signed into.
+type Fan struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+// identitiesPageData is the part of the #pagedata blob every Bandcamp page carries about
+// the visitor. Fan is only set when the visitor is signed in.
+type identitiesPageData struct {
+	Identities struct {
+		Fan *Fan `json:"fan"`
+	} `json:"identities"`
+}
+
+// signedInFan returns the fan in the page data, or ErrNotSignedIn when there is none.
+func (data identitiesPageData) signedInFan() (Fan, error) {
+	if data.Identities.Fan == nil || data.Identities.Fan.Username == "" {
+		return Fan{}, ErrNotSignedIn
+	}
+
+	return *data.Identities.Fan, nil
+}
+
+// SignedInFan opens the Bandcamp home page with the context's cookies and returns the
+// account Bandcamp recognizes. It is much cheaper than loading a collection.
+func (bcCtx AuthorizedBandcampContext) SignedInFan() (Fan, error) {
+	page, err := bcCtx.ctx.NewPage()
+
+	if err != nil {
+		return Fan{}, fmt.Errorf("Could not create page: %w", err)
+	}
+
+	defer page.Close()
+
+	_, err = gotoPage(page, bcUrl.String(), playwright.PageGotoOptions{
+		WaitUntil: playwright.WaitUntilStateDomcontentloaded,
+	})
+
+	if err != nil {
+		return Fan{}, fmt.Errorf("Could not open %s: %w", bcUrl.String(), err)
+	}
+
+	var data identitiesPageData
+
+	if err = readPageData(page, &data); err != nil {
+		return Fan{}, err
+	}
+
+	return data.signedInFan()
+}
+
+// checkSignedInAs makes sure the identity cookie belongs to username, so a mixed up cookie
+// doesn't download from, or record history for, the wrong account.
+func checkSignedInAs(fan Fan, username string) error {
+	if username != "" && !strings.EqualFold(fan.Username, username) {
+		return fmt.Errorf("The identity cookie signs in as %s, not %s", fan.Username, username)
+	}
+
+	return nil
+}
+
+// CheckBrowser starts Playwright and Chromium, returning the browser version.
+func CheckBrowser() (string, error) {
+	pw, err := playwright.Run(playwrightOptions())
+
+	if err != nil {
+		return "", fmt.Errorf("Could not start playwright: %w", err)
+	}
+
+	defer pw.Stop()
+
+	browser, err := pw.Chromium.Launch()
+
+	if err != nil {
+		return "", fmt.Errorf("Could not launch Chromium: %w", err)
+	}
+
+	defer browser.Close()
+
+	return browser.Version(), nil
+}
+
+// VerifyIdentity returns the account the user's identity cookie signs into. When the
+// user has a username it must match the account.
+func VerifyIdentity(user *User) (Fan, error) {
+	pw, err := playwright.Run(playwrightOptions())
+
+	if err != nil {
+		return Fan{}, fmt.Errorf("Could not start playwright: %w", err)
+	}
+
+	defer pw.Stop()
+
+	browser, err := pw.Chromium.Launch(playwright.BrowserTypeLaunchOptions{Proxy: browserProxy()})
+
+	if err != nil {
+		return Fan{}, fmt.Errorf("Could not launch Chromium: %w", err)
+	}
+
+	defer browser.Close()
+
+	context, err := NewAuthorizedBandcampContext(browser, user.identity, user.cookies...)
+
+	if err != nil {
+		return Fan{}, fmt.Errorf("Could not create context: %w", err)
+	}
+
+	fan, err := context.SignedInFan()
+
+	if err != nil {
+		return Fan{}, err
+	}
+
+	return fan, checkSignedInAs(fan, user.username)
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// How long a job that timed out waits before it is tried again the first time, and at most
+// once the wait doubled with every attempt.
+const (
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 10 * time.Minute
+)
+
+// errJobTimeout is returned when a job took longer than WithTimeout allows.
+var errJobTimeout = errors.New("timed out")
+
+// errPrepareTimeout is returned when Bandcamp didn't prepare a download in time.
+var errPrepareTimeout = errors.New("Download was not prepared in time")
+
+// WithRetries tries jobs that timed out, while Bandcamp prepared them or altogether, up to
+// n more times during the run. Each retry waits longer than the one before, so Bandcamp
+// isn't asked for an album it is still struggling with right away, see retryDelay.
+// Meanwhile the workers go on with other albums.
+func WithRetries(n int) func(*Downloader) {
+	return func(d *Downloader) {
+		d.retries = n
+	}
+}
+
+// retryDelay returns how long to wait before retry number attempt: twice as long as before
+// the one before, starting at retryBaseDelay, up to retryMaxDelay. It is cut by up to half
+// at random, so albums that timed out together aren't retried together.
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+
+	if attempt < 10 {
+		delay = min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryLater puts the job that failed with err back into the queue to be tried again after
+// a while, if it timed out and has retries left. It reports whether it did.
+func (job downloadJob) retryLater(jobs *jobQueue, err error) bool {
+	if !errors.Is(err, errJobTimeout) && !errors.Is(err, errPrepareTimeout) {
+		return false
+	}
+
+	if job.attempt >= job.retries {
+		return false
+	}
+
+	job.attempt++
+	// The attempt that timed out may have saved the file just as it was given up on, see onDisk
+	job.retry = true
+	delay := retryDelay(job.attempt)
+
+	log.Printf("%s %v, trying again in %s", job.Entry.title, err, delay.Round(time.Second))
+	jobs.pushAfter(job, delay)
+
+	return true
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{"first retry", 1, 30 * time.Second},
+		{"second retry", 2, time.Minute},
+		{"fifth retry", 5, 8 * time.Minute},
+		{"capped", 6, 10 * time.Minute},
+		{"far beyond the cap", 100, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The jitter is random, so look at enough draws to leave the range it may fall in
+			for range 100 {
+				if got := retryDelay(tt.attempt); got < tt.want/2 || got > tt.want {
+					t.Fatalf("retryDelay(%d) = %v, want between %v and %v", tt.attempt, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package internal
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"net/url"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/playwright-community/playwright-go"
 )
@@ -19,6 +25,39 @@ import (
 type AuthorizedBandcampContext struct {
 	ctx      playwright.BrowserContext
 	identity string
+	waits    PageWaits
+}
+
+// PageWaits holds the tunables for how long pages wait on Bandcamp and how
+// aggressively the collection page is scrolled.
+//
+// Slow connections will want longer waits, fast connections can shorten them.
+type PageWaits struct {
+	// FilterTimeout bounds how long to wait for search results after filtering the collection.
+	FilterTimeout time.Duration
+	// WaitUntil is the load state every navigation waits for before continuing.
+	WaitUntil *playwright.WaitUntilState
+	// ScrollDelta is how far the mouse wheel moves for each scroll of the collection page.
+	ScrollDelta float64
+	// ItemsPerScroll approximates how many albums Bandcamp loads for each scroll, for
+	// collection pages that don't say so themselves.
+	ItemsPerScroll int
+}
+
+// DefaultPageWaits returns the waits that work well on a typical connection.
+//
+// Defaults:
+//   - FilterTimeout: 10 seconds
+//   - WaitUntil: networkidle
+//   - ScrollDelta: 10,000 pixels
+//   - ItemsPerScroll: 20
+func DefaultPageWaits() PageWaits {
+	return PageWaits{
+		FilterTimeout:  10 * time.Second,
+		WaitUntil:      playwright.WaitUntilStateNetworkidle,
+		ScrollDelta:    10_000,
+		ItemsPerScroll: 20,
+	}
 }
 
 var bcUrl = url.URL{
@@ -34,37 +73,134 @@ var bcUrl = url.URL{
 //
 // Playwright has some issues running into captcha challenges during the login procedure, so this
 // method is the most full proof, if a bit annoying.
-func NewAuthorizedBandcampContext(browser playwright.Browser, identity string) (AuthorizedBandcampContext, error) {
-	// Cookie to handle login
-	// Would be great to get rid of this and do a login flow to get the value
-	cookie := playwright.Cookie{
-		Name:     "identity",
-		Value:    identity,
-		Domain:   bcUrl.Host,
-		Path:     "/",
-		Secure:   true,
-		HttpOnly: true,
-		Expires:  float64(time.Now().Add(180 * 24 * time.Hour).Unix()),
-	}
-
+//
+// Any extra cookies, e.g. from ReadCookiesFile, are loaded as well. The identity may be empty
+// when the extra cookies already contain it.
+func NewAuthorizedBandcampContext(browser playwright.Browser, identity string, extra ...playwright.OptionalCookie) (AuthorizedBandcampContext, error) {
 	var cookies []playwright.OptionalCookie
 
-	cookies = append(cookies, cookie.ToOptionalCookie())
+	if identity != "" {
+		// Cookie to handle login
+		cookie := playwright.Cookie{
+			Name:     "identity",
+			Value:    identity,
+			Domain:   bcUrl.Host,
+			Path:     "/",
+			Secure:   true,
+			HttpOnly: true,
+			Expires:  float64(time.Now().Add(180 * 24 * time.Hour).Unix()),
+		}
+
+		cookies = append(cookies, cookie.ToOptionalCookie())
+	} else {
+		identity = cookieValue(extra, "identity")
+	}
+
+	cookies = append(cookies, extra...)
 	oss := playwright.OptionalStorageState{
 		Cookies: cookies,
 	}
 
 	// Set up the storage state and context
-	ctx, err := browser.NewContext(playwright.BrowserNewContextOptions{
-		UserAgent:    playwright.String("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/84.0.4147.135 Safari/537.36"),
-		StorageState: &oss,
-	})
+	contextOpts := browserContextOptions()
+	contextOpts.StorageState = &oss
+
+	ctx, err := browser.NewContext(contextOpts)
 
 	if err != nil {
 		return AuthorizedBandcampContext{}, err
 	}
 
-	return AuthorizedBandcampContext{ctx: ctx, identity: identity}, nil
+	ctx.OnResponse(pauseOnRateLimit)
+
+	return AuthorizedBandcampContext{ctx: ctx, identity: identity, waits: DefaultPageWaits()}, nil
+}
+
+// WatchIdentity calls fn whenever Bandcamp rotates the identity cookie through a Set-Cookie
+// header, with the new value and when it expires. The expiry is zero for session cookies.
+//
+// fn is called from Playwright's event loop and must not block.
+func (bcCtx AuthorizedBandcampContext) WatchIdentity(fn func(identity string, expires time.Time)) {
+	var mu sync.Mutex
+	current := bcCtx.identity
+
+	bcCtx.ctx.OnResponse(func(resp playwright.Response) {
+		u, err := url.Parse(resp.URL())
+
+		if err != nil || (u.Hostname() != bcUrl.Host && !strings.HasSuffix(u.Hostname(), "."+bcUrl.Host)) {
+			return
+		}
+
+		header, err := resp.HeaderValue("set-cookie")
+
+		if err != nil || !strings.Contains(header, "identity=") {
+			return
+		}
+
+		// Multiple Set-Cookie headers are joined with new lines
+		parsed := (&http.Response{Header: http.Header{"Set-Cookie": strings.Split(header, "\n")}}).Cookies()
+
+		for _, cookie := range parsed {
+			if cookie.Name != "identity" || cookie.Value == "" {
+				continue
+			}
+
+			expires := cookie.Expires
+			if cookie.MaxAge > 0 {
+				expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+			}
+
+			mu.Lock()
+			changed := cookie.Value != current
+			current = cookie.Value
+			mu.Unlock()
+
+			if changed {
+				fn(cookie.Value, expires)
+			}
+		}
+	})
+}
+
+// Cookies returns the cookies the browser sends to link, with the identity as the browser
+// last got it.
+func (bcCtx AuthorizedBandcampContext) Cookies(link string) ([]*http.Cookie, error) {
+	cookies, err := bcCtx.ctx.Cookies(link)
+
+	if err != nil {
+		return nil, err
+	}
+
+	converted := make([]*http.Cookie, len(cookies))
+
+	for i, cookie := range cookies {
+		converted[i] = &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+	}
+
+	return converted, nil
+}
+
+// ParseWaitUntil validates the load state navigations wait for: load, domcontentloaded,
+// networkidle or commit.
+func ParseWaitUntil(s string) (*playwright.WaitUntilState, error) {
+	switch s {
+	case "load":
+		return playwright.WaitUntilStateLoad, nil
+	case "domcontentloaded":
+		return playwright.WaitUntilStateDomcontentloaded, nil
+	case "networkidle":
+		return playwright.WaitUntilStateNetworkidle, nil
+	case "commit":
+		return playwright.WaitUntilStateCommit, nil
+	}
+
+	return nil, fmt.Errorf("Unknown load state %q, expected load, domcontentloaded, networkidle or commit", s)
+}
+
+// WithPageWaits returns a copy of the context whose pages use the provided waits.
+func (bcCtx AuthorizedBandcampContext) WithPageWaits(waits PageWaits) AuthorizedBandcampContext {
+	bcCtx.waits = waits
+	return bcCtx
 }
 
 // NewCollectionPage creates a Page Object that represents the user's collection of albums,
@@ -78,7 +214,7 @@ func (bcCtx AuthorizedBandcampContext) NewCollectionPage(username string) (Colle
 		return CollectionPage{}, err
 	}
 
-	return newCollectionPage(page, username), nil
+	return newCollectionPage(page, username, bcCtx.waits), nil
 }
 
 // NewCollectionEntryPage creates a Page Object that represents an individual entry, i.e. an album, in the user's collection.
@@ -89,7 +225,7 @@ func (bcCtx AuthorizedBandcampContext) NewCollectionEntryPage(entry CollectionEn
 		return CollectionEntryPage{}, err
 	}
 
-	return newCollectionEntryPage(page, entry), nil
+	return newCollectionEntryPage(page, entry, bcCtx.waits), nil
 
 }
 
@@ -98,20 +234,41 @@ type CollectionPage struct {
 	page     playwright.Page
 	url      url.URL
 	username string
+	waits    PageWaits
+	// items is what the page data and the API answers to scrolling said about the items
+	// of the collection, by CollectionEntry id
+	items map[string]collectionItem
 }
 
-// CollectionEntry, i.e. an album.
+// CollectionEntry, i.e. an album. Its fields are read through the getters below so entries
+// handed out by GetCollection can't be changed behind the Downloader's back.
 type CollectionEntry struct {
-	url   url.URL
-	title string
+	url url.URL
+	// id is Bandcamp's id of the item, prefixed with its type since albums and tracks are
+	// numbered separately, e.g. "a1234567". Empty if the page didn't have it.
+	id        string
+	title     string
+	artist    string
+	itemURL   url.URL
+	artID     string
+	bandID    string
+	purchased time.Time
+	// gift is set for items someone else bought for the fan, gifter is who, if known
+	gift   bool
+	gifter string
+	// price is what the item sells for in currency, if the API said
+	price    float64
+	currency string
 }
 
 // NewCollectionPage creates a Page Object that represents the user's collection of albums.
-func newCollectionPage(page playwright.Page, username string) CollectionPage {
+func newCollectionPage(page playwright.Page, username string, waits PageWaits) CollectionPage {
 	cp := CollectionPage{
 		username: username,
 		page:     page,
 		url:      *bcUrl.JoinPath(username),
+		waits:    waits,
+		items:    map[string]collectionItem{},
 	}
 
 	return cp
@@ -119,8 +276,8 @@ func newCollectionPage(page playwright.Page, username string) CollectionPage {
 
 // Goto executes the Playwright Goto method to the collection URL.
 func (cp CollectionPage) Goto() (playwright.Response, error) {
-	return cp.page.Goto(cp.url.String(), playwright.PageGotoOptions{
-		WaitUntil: playwright.WaitUntilStateNetworkidle,
+	return gotoPage(cp.page, cp.url.String(), playwright.PageGotoOptions{
+		WaitUntil: cp.waits.WaitUntil,
 	})
 }
 
@@ -144,7 +301,7 @@ func (cp CollectionPage) filter(filter string) error {
 	}
 
 	// Don't wait too long for the results ot return.
-	timeout := 10_000.0
+	timeout := float64(cp.waits.FilterTimeout.Milliseconds())
 	return cp.page.Locator("div#collection-search.searched").WaitFor(playwright.LocatorWaitForOptions{Timeout: &timeout})
 }
 
@@ -152,8 +309,9 @@ func (cp CollectionPage) filter(filter string) error {
 // It will automatically handle scrolling the page a number of times to ensure
 // all of them are loaded onto the screen.
 //
-// This is calculated by finding the Show More button which has a count of albums,
-// dividing by 20 to approximate the number of times the page must be scrolled.
+// How often is worked out by ScrollTimes from AlbumCount, neither of which depend on the
+// display language. If fewer items than AlbumCount loaded after that, the page is
+// scrolled on for as long as more keep coming in.
 //
 // A collection can contain non-album items like subscriptions to labels. These entries
 // are malformed and skipped. The resulting entry set will only contain entries that
@@ -165,57 +323,45 @@ func (cp CollectionPage) GetCollection(filter string) ([]CollectionEntry, error)
 		return []CollectionEntry{}, fmt.Errorf("Failed to filter albums %w", err)
 	}
 
-	moreToShow, err := cp.page.Locator("div#collection-items > div.expand-container").IsHidden()
+	// Get the count of how many albums there are to grab. How much to scroll and whether
+	// everything loaded goes by it alone, not by whether the button below is shown
+	albumCount, err := cp.AlbumCount()
 
-	var albumCount int = 0
-
-	// Bandcamp keeps the button but hides the parent container. Only go through the process of
-	// clicking the button if the parent container is visible
-	if moreToShow {
-		loc := cp.page.Locator("div#collection-items > div.expand-container > button.show-more")
-		albums, err := loc.TextContent()
-
-		if err != nil {
-			log.Printf("No more to load. Continuing... %v", err)
-		}
-
-		// Get the count of how many more albums there are to grab
-		var re = regexp.MustCompile(`\b\d+\b`)
-		converted, err := strconv.Atoi(re.FindString(albums))
+	if err != nil {
+		log.Printf("Could not determine collection size. Continuing... %v", err)
+		albumCount = 0
+	}
 
-		if err == nil {
-			albumCount = converted
-		}
+	// Bandcamp keeps the button but hides its parent container once everything is shown
+	more := cp.page.Locator("div#collection-items > div.expand-container > button.show-more")
 
-		err = loc.Click()
-		if err != nil {
+	if visible, _ := more.IsVisible(); visible && (albumCount == 0 || albumCount > cp.loadedCount()) {
+		if err := more.Click(); err != nil {
 			return []CollectionEntry{}, fmt.Errorf("Could not click button to load more albums: %w", err)
 		}
 	}
 
-	// BC seems to load in increments of 20 at the default window size for Playwright.
-	// Thus we need to scroll a number of times to get every album
-	scrollTimes := int(math.Ceil(float64(albumCount) / 20.0))
+	scrolls := cp.ScrollTimes(albumCount)
 
-	if err != nil {
-		log.Printf("Nothing more to show %v", err)
+	for i := 0; i < scrolls; i++ {
+		cp.scroll()
 	}
 
-	// Expect a REST request made against this endpoint every time we scroll
-	respUrl := bcUrl.JoinPath("api", "fancollection", "1", "collection_items")
-	// Perform scrolling and wait for the API to return the results
-	for i := 0; i < scrollTimes; i++ {
-		err := cp.page.Mouse().Wheel(0, 10_000)
+	// The search results only count what matched, so this only checks the whole collection
+	if filter == "" {
+		loaded := cp.loadedCount()
 
-		if err != nil {
-			log.Printf("Error when scrolling. Continuing...")
-			continue
-		}
+		for loaded < albumCount {
+			cp.scroll()
+
+			more := cp.loadedCount()
 
-		_, err = cp.page.ExpectResponse(respUrl.String(), func() error { return nil })
+			if more <= loaded {
+				log.Printf("Only %d of the %d items of the collection loaded, the rest are left out", more, albumCount)
+				break
+			}
 
-		if err != nil {
-			log.Printf("Error waiting for response to scroll. Continuing...")
+			loaded = more
 		}
 	}
 
@@ -228,7 +374,114 @@ func (cp CollectionPage) GetCollection(filter string) ([]CollectionEntry, error)
 		entries, _ = cp.page.Locator("div#collection-search-items li.collection-item-container").All()
 	}
 
-	collectionEntries := make([]CollectionEntry, 0, cap(entries))
+	return parseCollectionEntries(entries, cp.collectionItems()), nil
+}
+
+// ScrollTimes returns how often the collection page has to be scrolled to load count
+// items. Each scroll loads as many as the page data says Bandcamp sends at a time, or
+// PageWaits.ItemsPerScroll if it doesn't.
+func (cp CollectionPage) ScrollTimes(count int) int {
+	perScroll := cp.waits.ItemsPerScroll
+
+	if data, err := cp.pageData(); err == nil && data.CollectionData.BatchSize > 0 {
+		perScroll = data.CollectionData.BatchSize
+	}
+
+	return scrollTimes(count, perScroll)
+}
+
+// scrollTimes returns how many scrolls of perScroll items it takes to load count items.
+func scrollTimes(count, perScroll int) int {
+	if count <= 0 {
+		return 0
+	}
+
+	if perScroll <= 0 {
+		perScroll = DefaultPageWaits().ItemsPerScroll
+	}
+
+	return int(math.Ceil(float64(count) / float64(perScroll)))
+}
+
+// scroll scrolls the collection page down and waits for the items it loads.
+func (cp CollectionPage) scroll() {
+	// Expect a REST request made against this endpoint every time we scroll
+	respUrl := bcUrl.JoinPath("api", "fancollection", "1", "collection_items")
+
+	if err := cp.page.Mouse().Wheel(0, cp.waits.ScrollDelta); err != nil {
+		log.Printf("Error when scrolling. Continuing...")
+		return
+	}
+
+	resp, err := cp.page.ExpectResponse(respUrl.String(), func() error { return nil })
+
+	if err != nil {
+		log.Printf("Error waiting for response to scroll. Continuing...")
+		return
+	}
+
+	var page collectionItemsResponse
+
+	if resp.JSON(&page) == nil {
+		cp.remember(page.Items...)
+	}
+}
+
+// remember keeps what Bandcamp said about items for parseCollectionEntries.
+func (cp CollectionPage) remember(items ...collectionItem) {
+	for _, item := range items {
+		if id := item.id(); id != "" {
+			cp.items[id] = item
+		}
+	}
+}
+
+// collectionItems returns what is known about the items on the page: the ones loaded by
+// scrolling and the ones the page data came with.
+func (cp CollectionPage) collectionItems() map[string]collectionItem {
+	if data, err := cp.pageData(); err == nil {
+		for _, items := range []map[string]collectionItem{data.ItemCache.Collection, data.ItemCache.Hidden} {
+			for _, item := range items {
+				cp.remember(item)
+			}
+		}
+	}
+
+	return cp.items
+}
+
+// loadedCount returns how many items of the collection are on the page.
+func (cp CollectionPage) loadedCount() int {
+	count, err := cp.page.Locator(".collection-item-container").Count()
+
+	if err != nil {
+		return 0
+	}
+
+	return count
+}
+
+// RecentEntries reloads the collection and returns the items shown before it is scrolled,
+// which are the most recent purchases.
+func (cp CollectionPage) RecentEntries() ([]CollectionEntry, error) {
+	if _, err := cp.Goto(); err != nil {
+		return nil, fmt.Errorf("Could not reload the collection: %w", err)
+	}
+
+	entries, err := cp.page.Locator(".collection-item-container").All()
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the collection: %w", err)
+	}
+
+	return parseCollectionEntries(entries, cp.collectionItems()), nil
+}
+
+// parseCollectionEntries reads the items of the collection grid, skipping the ones that
+// can't be downloaded. items has what Bandcamp's data says about them by id, which is
+// preferred over the text of the grid since that is translated.
+func parseCollectionEntries(entries []playwright.Locator, items map[string]collectionItem) []CollectionEntry {
+	collectionEntries := make([]CollectionEntry, 0, len(entries))
 
 	for _, entry := range entries {
 		title, err := entry.Locator("div.collection-title-details > a > div.collection-item-title").InnerText()
@@ -252,32 +505,437 @@ func (cp CollectionPage) GetCollection(filter string) ([]CollectionEntry, error)
 			title: title,
 		}
 
+		if itemID := optionalAttribute(entry, "data-itemid"); itemID != "" {
+			ce.id = optionalAttribute(entry, "data-tralbumtype") + itemID
+		}
+
+		item, known := items[ce.id]
+
+		// The artist tells apart albums with the same title, but isn't needed to download them
+		if known && item.Artist != "" {
+			ce.artist = item.Artist
+		} else {
+			ce.artist = parseArtist(optionalText(entry.Locator(".collection-item-artist")))
+		}
+
+		// Art and the album page are only needed for artwork, so don't drop entries without them
+		ce.artID = parseArtID(optionalAttribute(entry.Locator("img.collection-item-art"), "src"))
+
+		if itemURL, err := url.Parse(optionalAttribute(entry.Locator("a.item-link"), "href")); err == nil {
+			ce.itemURL = *itemURL
+		}
+
+		// Used to spot many items bought in one go, like a discography bundle
+		ce.bandID = optionalAttribute(entry, "data-bandid")
+		ce.purchased = parseCollectionToken(optionalAttribute(entry, "data-token"))
+
+		if known {
+			ce.gift, ce.gifter = item.gift()
+		} else {
+			ce.gift, ce.gifter = parseGift(optionalText(entry.Locator(".collection-item-gift-info")))
+		}
+
 		collectionEntries = append(collectionEntries, ce)
 
 	}
 
-	return collectionEntries, nil
+	return collectionEntries
+}
+
+// ID returns Bandcamp's id of the item, e.g. "a1234567", or "" if the collection page didn't
+// have it. Albums and tracks are numbered separately, so the id starts with the item type.
+func (ce CollectionEntry) ID() string {
+	return ce.id
+}
+
+// Title returns the title of the album.
+func (ce CollectionEntry) Title() string {
+	return ce.title
+}
+
+// Artist returns the name of the artist, or "" if the collection page didn't have it.
+func (ce CollectionEntry) Artist() string {
+	return ce.artist
+}
+
+// URL returns the address of the download page of the item.
+func (ce CollectionEntry) URL() string {
+	return ce.url.String()
+}
+
+// ItemURL returns the address of the album's page on Bandcamp, or "" if it isn't known.
+func (ce CollectionEntry) ItemURL() string {
+	return ce.itemURL.String()
+}
+
+// Purchased returns when the item was added to the collection, or the zero time if it isn't known.
+func (ce CollectionEntry) Purchased() time.Time {
+	return ce.purchased
+}
+
+// Gift reports whether the item was a gift rather than bought by the fan.
+func (ce CollectionEntry) Gift() bool {
+	return ce.gift
+}
+
+// Price returns what the item sells for and the currency, or 0 and "" if that isn't known.
+// Only the API has it, not the collection page, and it can differ from what the fan paid.
+func (ce CollectionEntry) Price() (float64, string) {
+	return ce.price, ce.currency
+}
+
+// Gifter returns the name of who gave the item as a gift, or "" if it wasn't one or the
+// collection doesn't say.
+func (ce CollectionEntry) Gifter() string {
+	return ce.gifter
+}
+
+// key identifies the entry within the collection: its id, or the title if the id isn't known.
+func (ce CollectionEntry) key() string {
+	if ce.id != "" {
+		return ce.id
+	}
+
+	return ce.title
+}
+
+// parseCollectionToken extracts the time an item was added to the collection from its
+// paging token, which looks like "1609459200:1234567890:a::".
+func parseCollectionToken(token string) time.Time {
+	seconds, _, _ := strings.Cut(token, ":")
+	unix, err := strconv.ParseInt(seconds, 10, 64)
+
+	if err != nil || unix <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(unix, 0)
+}
+
+// optionalAttribute returns the attribute of the first matching element, or the empty
+// string if it is missing. It does not wait around for elements that never appear.
+func optionalAttribute(loc playwright.Locator, name string) string {
+	value, err := loc.First().GetAttribute(name, playwright.LocatorGetAttributeOptions{
+		Timeout: playwright.Float(1_000),
+	})
+
+	if err != nil {
+		return ""
+	}
+
+	return value
+}
+
+// optionalText is like optionalAttribute, returning the text of the first element or "".
+func optionalText(loc playwright.Locator) string {
+	text, err := loc.First().InnerText(playwright.LocatorInnerTextOptions{
+		Timeout: playwright.Float(1_000),
+	})
+
+	if err != nil {
+		return ""
+	}
+
+	return text
+}
+
+// parseArtist strips the "by" the collection grid puts in front of artist names, for items
+// the data of the collection page doesn't cover.
+func parseArtist(text string) string {
+	text = strings.TrimSpace(text)
+
+	if artist, ok := strings.CutPrefix(text, "by "); ok {
+		return strings.TrimSpace(artist)
+	}
+
+	return text
+}
+
+// parseGift reads the note gifts carry on the collection page, e.g. "gift from Alex", for
+// items the data of the collection page doesn't cover. Items without one weren't gifts.
+func parseGift(text string) (bool, string) {
+	text = strings.TrimSpace(text)
+
+	if text == "" {
+		return false, ""
+	}
+
+	lower := strings.ToLower(text)
+
+	if i := strings.Index(lower, "gift from "); i >= 0 {
+		return true, strings.TrimSpace(text[i+len("gift from "):])
+	}
+
+	return true, ""
+}
+
+// collectionPageData is the subset of the #pagedata blob Bandcamp embeds on the collection page.
+type collectionPageData struct {
+	CollectionData struct {
+		ItemCount int `json:"item_count"`
+		BatchSize int `json:"batch_size"`
+	} `json:"collection_data"`
+	FanData struct {
+		FanID    int64  `json:"fan_id"`
+		Username string `json:"username"`
+	} `json:"fan_data"`
+	// ItemCache holds the items the page starts out with, by type and id
+	ItemCache struct {
+		Collection map[string]collectionItem `json:"collection"`
+		Hidden     map[string]collectionItem `json:"hidden"`
+	} `json:"item_cache"`
+}
+
+// pageData parses the JSON blob of the collection page.
+func (cp CollectionPage) pageData() (collectionPageData, error) {
+	var data collectionPageData
+	err := readPageData(cp.page, &data)
+
+	return data, err
+}
+
+// readPageData parses the JSON blob Bandcamp stores in the data-blob attribute of div#pagedata into v.
+func readPageData(page playwright.Page, v any) error {
+	blob, err := page.Locator("div#pagedata").GetAttribute("data-blob")
+
+	if err != nil {
+		return fmt.Errorf("Could not read page data: %w", err)
+	}
+
+	if err = json.Unmarshal([]byte(blob), v); err != nil {
+		return fmt.Errorf("Could not parse page data: %w", err)
+	}
+
+	return nil
+}
+
+// FanID returns the numeric Bandcamp id of the fan who owns the collection.
+func (cp CollectionPage) FanID() (int64, error) {
+	data, err := cp.pageData()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if data.FanData.FanID == 0 {
+		return 0, fmt.Errorf("No fan id found for %s", cp.username)
+	}
+
+	return data.FanData.FanID, nil
+}
+
+// AlbumCount returns the number of items in the collection without relying on any
+// localized text, like the label of the show more button.
+//
+// The count is read from the page data first. If that is unavailable, the counter
+// on the collection tab is used, which only ever contains digits.
+func (cp CollectionPage) AlbumCount() (int, error) {
+	data, err := cp.pageData()
+
+	if err == nil && data.CollectionData.ItemCount > 0 {
+		return data.CollectionData.ItemCount, nil
+	}
+
+	count, err := cp.page.Locator(`li[data-tab="collection"] span.count`).First().TextContent()
+
+	if err != nil {
+		return 0, fmt.Errorf("Could not find the collection count: %w", err)
+	}
+
+	return parseCount(count)
+}
+
+// parseCount reads the digits out of a counter, ignoring separators like in "1,234".
+func parseCount(text string) (int, error) {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, text)
+
+	if digits == "" {
+		return 0, fmt.Errorf("No count in %q", text)
+	}
+
+	return strconv.Atoi(digits)
 }
 
 // CollectionEntryPage represents a specific album.
 type CollectionEntryPage struct {
 	page  playwright.Page
 	entry CollectionEntry
+	waits PageWaits
 }
 
-func newCollectionEntryPage(page playwright.Page, entry CollectionEntry) CollectionEntryPage {
+func newCollectionEntryPage(page playwright.Page, entry CollectionEntry, waits PageWaits) CollectionEntryPage {
 
 	return CollectionEntryPage{
 		page:  page,
 		entry: entry,
+		waits: waits,
 	}
 }
 
 // Goto navigates to the page for the Collection Entry
 func (cep CollectionEntryPage) Goto() (playwright.Response, error) {
-	return cep.page.Goto(cep.entry.url.String(), playwright.PageGotoOptions{
-		WaitUntil: playwright.WaitUntilStateNetworkidle,
+	return gotoPage(cep.page, cep.entry.url.String(), playwright.PageGotoOptions{
+		WaitUntil: cep.waits.WaitUntil,
+	})
+}
+
+// ItemInfo is what the download page says about the item.
+type ItemInfo struct {
+	Artist   string
+	Title    string
+	Released time.Time
+	// Sizes are the sizes in bytes of the prepared downloads, for the formats Bandcamp lists them
+	Sizes map[FileType]int64
+}
+
+// downloadPageData is the subset of the #pagedata blob Bandcamp embeds on the download page.
+type downloadPageData struct {
+	DigitalItems []struct {
+		Artist      string `json:"artist"`
+		Title       string `json:"title"`
+		ReleaseDate string `json:"release_date"`
+		Downloads   map[FileType]struct {
+			SizeMB string `json:"size_mb"`
+			// URL starts the download of the format, see fetchDownload
+			URL string `json:"url"`
+		} `json:"downloads"`
+	} `json:"digital_items"`
+}
+
+// ItemInfo reads the artist, title, release date and download sizes of the item from the
+// download page.
+func (cep CollectionEntryPage) ItemInfo() (ItemInfo, error) {
+	var data downloadPageData
+
+	if err := readPageData(cep.page, &data); err != nil {
+		return ItemInfo{}, err
+	}
+
+	return data.itemInfo(cep.entry.title)
+}
+
+// itemInfo reads the ItemInfo of the item titled title out of the page data.
+func (data downloadPageData) itemInfo(title string) (ItemInfo, error) {
+	if len(data.DigitalItems) == 0 {
+		return ItemInfo{}, fmt.Errorf("No item information on the download page of %s", title)
+	}
+
+	item := data.DigitalItems[0]
+	info := ItemInfo{Artist: item.Artist, Title: item.Title}
+
+	// Dates look like "01 Jan 2020 00:00:00 GMT"
+	if released, err := time.Parse("02 Jan 2006 15:04:05 MST", item.ReleaseDate); err == nil {
+		info.Released = released
+	}
+
+	info.Sizes = make(map[FileType]int64, len(item.Downloads))
+
+	for ft, download := range item.Downloads {
+		if size, ok := parseDownloadSize(download.SizeMB); ok {
+			info.Sizes[ft] = size
+		}
+	}
+
+	return info, nil
+}
+
+// regionLocked reports whether the item is listed without any formats to download, which
+// is how Bandcamp serves items it won't hand out in the visitor's region. ok is false if
+// the page data doesn't list the item at all.
+func (data downloadPageData) regionLocked() (locked, ok bool) {
+	if len(data.DigitalItems) == 0 {
+		return false, false
+	}
+
+	return len(data.DigitalItems[0].Downloads) == 0, true
+}
+
+// parseDownloadSize converts the sizes on the download page, like "91.6MB" or "1.2GB", to bytes.
+func parseDownloadSize(s string) (int64, bool) {
+	units := []struct {
+		suffix string
+		bytes  float64
+	}{{"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}}
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+
+			if err != nil {
+				return 0, false
+			}
+
+			return int64(n * unit.bytes), true
+		}
+	}
+
+	return 0, false
+}
+
+// ErrRegionLocked is returned for items Bandcamp refuses to serve in the current region.
+// Retrying from the same location won't help.
+var ErrRegionLocked = errors.New("Item is not available in your region")
+
+// regionLockPattern matches the message Bandcamp shows instead of the download options
+// when an item can't be downloaded from the visitor's country. The message is translated,
+// so it is only checked when the page data is missing, see downloadPageData.regionLocked.
+var regionLockPattern = regexp.MustCompile(`(?i)not (?:currently )?available(?: for download)? in your (?:country|region|location)`)
+
+// RegionLocked reports whether the page says the item can't be downloaded from this region.
+func (cep CollectionEntryPage) RegionLocked() bool {
+	var data downloadPageData
+
+	if readPageData(cep.page, &data) == nil {
+		if locked, ok := data.regionLocked(); ok {
+			return locked
+		}
+	}
+
+	text, err := cep.page.Locator("body").InnerText(playwright.LocatorInnerTextOptions{
+		Timeout: playwright.Float(1_000),
 	})
+
+	return err == nil && regionLockPattern.MatchString(text)
+}
+
+// overlaySelectors match the close or accept buttons of the dialogs Bandcamp sometimes shows
+// on top of the album page, such as cookie consent for EU visitors or the currency and
+// language prompts. Any of them can cover the format selector and the download link.
+// Bandcamp translates the button labels, so the buttons are matched by their markup alone.
+var overlaySelectors = []string{
+	`#cookie-control-dialog button.accept-all`,
+	`#cookie-control-dialog [data-action="accept"]`,
+	`#cookie-control-dialog button.g-button`,
+	`.cookie-control button[type="submit"]`,
+	`.gdpr-dialog button.accept`,
+	`.gdpr-dialog button[type="submit"]`,
+	`#currency-dialog .close`,
+	`#language-dialog .close`,
+	`.ui-dialog .ui-dialog-titlebar-close`,
+}
+
+// DismissOverlays closes any visible consent, currency or language dialogs.
+// Pages without overlays are left untouched, and a dialog that cannot be closed
+// is ignored since the following steps will report the real failure.
+func (cep CollectionEntryPage) DismissOverlays() {
+	for _, selector := range overlaySelectors {
+		button := cep.page.Locator(selector).First()
+
+		if visible, err := button.IsVisible(); err != nil || !visible {
+			continue
+		}
+
+		if err := button.Click(playwright.LocatorClickOptions{Timeout: playwright.Float(2_000)}); err != nil {
+			log.Printf("Could not dismiss overlay %s: %v", selector, err)
+		}
+	}
 }
 
 // SelectFileType selects the specified file type and waits for it to be ready to download.
@@ -306,6 +964,63 @@ func (cep CollectionEntryPage) SelectFileType(ft FileType) error {
 	return nil
 }
 
+// WaitForPrepared blocks until Bandcamp has finished preparing the archive for
+// the selected file type and the download link is visible.
+//
+// timeoutMs controls how long to wait for the preparation to finish.
+func (cep CollectionEntryPage) WaitForPrepared(timeoutMs float64) error {
+	err := cep.page.Locator(`.download-button + a`).WaitFor(playwright.LocatorWaitForOptions{
+		State:   playwright.WaitForSelectorStateVisible,
+		Timeout: &timeoutMs,
+	})
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPrepareTimeout, err)
+	}
+
+	return nil
+}
+
+// DownloadLink returns where the download link of a prepared download points, so it can be
+// fetched without the browser.
+func (cep CollectionEntryPage) DownloadLink() (string, error) {
+	href, err := cep.page.Locator(`.download-button + a`).GetAttribute("href")
+
+	if err != nil || href == "" {
+		return "", fmt.Errorf("Could not read the download link: %w", err)
+	}
+
+	link, err := url.Parse(cep.page.URL())
+
+	if err != nil {
+		return href, nil
+	}
+
+	ref, err := link.Parse(href)
+
+	if err != nil {
+		return "", fmt.Errorf("Invalid download link %q: %w", href, err)
+	}
+
+	return ref.String(), nil
+}
+
+// StartDownload clicks the download link and returns the browser download without saving it.
+// timeoutMs controls how long to wait for the download to start.
+func (cep CollectionEntryPage) StartDownload(timeoutMs float64) (playwright.Download, error) {
+	dl, err := cep.page.ExpectDownload(func() error {
+		return cep.page.Locator(`.download-button + a`).Click()
+	}, playwright.PageExpectDownloadOptions{
+		Timeout: &timeoutMs,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not start download: %w", err)
+	}
+
+	return dl, nil
+}
+
 // DownloadFile starts a browser download and saves it to the specified outputDir.
 // timeoutMs controls how long to wait for the download to Prepare NOT how long to
 // wait for the download to complete!
@@ -313,14 +1028,10 @@ func (cep CollectionEntryPage) SelectFileType(ft FileType) error {
 // Depending on the file type, it can take longer for the download to hit the Prepared
 // state
 func (cep CollectionEntryPage) DownloadFile(outputDir string, timeoutMs float64) error {
-	dl, err := cep.page.ExpectDownload(func() error {
-		return cep.page.Locator(`.download-button + a`).Click()
-	}, playwright.PageExpectDownloadOptions{
-		Timeout: &timeoutMs,
-	})
+	dl, err := cep.StartDownload(timeoutMs)
 
 	if err != nil {
-		return fmt.Errorf("Could not start download: %w", err)
+		return err
 	}
 
 	// Download the file and save using the browser suggested name
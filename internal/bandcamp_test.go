@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestScrollTimes(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		perScroll int
+		want      int
+	}{
+		{"empty collection", 0, 20, 0},
+		{"negative count", -5, 20, 0},
+		{"less than one scroll", 7, 20, 1},
+		{"exact multiple", 40, 20, 2},
+		{"not a multiple", 41, 20, 3},
+		{"page data batch size", 100, 45, 3},
+		{"default per scroll", 41, 0, 3},
+		{"negative per scroll", 20, -1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scrollTimes(tt.count, tt.perScroll); got != tt.want {
+				t.Errorf("scrollTimes(%d, %d) = %d, want %d", tt.count, tt.perScroll, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    int
+		wantErr bool
+	}{
+		{"plain", "42", 42, false},
+		{"thousands separator", "1,234", 1234, false},
+		{"dot separator", "12.345", 12345, false},
+		{"surrounding whitespace", "\n  2,048 \n", 2048, false},
+		{"missing attribute", "", 0, true},
+		{"garbage", "n/a", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCount(tt.text)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCount(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("parseCount(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadPageDataRegionLocked(t *testing.T) {
+	tests := []struct {
+		name       string
+		blob       string
+		wantLocked bool
+		wantOK     bool
+	}{
+		{"no items", `{}`, false, false},
+		{"empty items", `{"digital_items": []}`, false, false},
+		{"formats listed", `{"digital_items": [{"downloads": {"flac": {"size_mb": "91.6MB", "url": "https://x"}}}]}`, false, true},
+		{"no downloads", `{"digital_items": [{"title": "Album"}]}`, true, true},
+		{"empty downloads", `{"digital_items": [{"downloads": {}}]}`, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data downloadPageData
+
+			if err := json.Unmarshal([]byte(tt.blob), &data); err != nil {
+				t.Fatalf("json.Unmarshal(%q) = %v", tt.blob, err)
+			}
+
+			if locked, ok := data.regionLocked(); locked != tt.wantLocked || ok != tt.wantOK {
+				t.Errorf("regionLocked(%q) = %v, %v, want %v, %v", tt.blob, locked, ok, tt.wantLocked, tt.wantOK)
+			}
+		})
+	}
+}
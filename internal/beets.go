@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BeetsOptions hands extracted albums to beets, which tags them and moves them into its
+// own library.
+type BeetsOptions struct {
+	// Command is the beets executable, "beet" when empty
+	Command string
+	// Args are passed to `beet import` before the album directories. Runs have no one to
+	// answer beets' questions, so the default is -q, skipping albums without a good match.
+	Args []string
+	// Queue, if set, is a file the album directories are appended to, one per line, instead
+	// of importing them right away, e.g. for `beet import $(cat queue.txt)` later on
+	Queue string
+}
+
+// DefaultBeetsOptions returns the options of an unattended `beet import -q`.
+func DefaultBeetsOptions() BeetsOptions {
+	return BeetsOptions{Command: "beet", Args: []string{"-q"}}
+}
+
+// beetsImport collects the albums extracted during a run for ImportIntoBeets.
+type beetsImport struct {
+	mu   sync.Mutex
+	dirs []string
+}
+
+// add remembers the album extracted to albumDir in the library at dir.
+func (b *beetsImport) add(dir, albumDir string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dirs = append(b.dirs, filepath.Join(dir, filepath.FromSlash(albumDir)))
+}
+
+// take returns the albums collected so far and starts over.
+func (b *beetsImport) take() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dirs := b.dirs
+	b.dirs = nil
+
+	return dirs
+}
+
+// ImportIntoBeets runs `beet import` on the album directories, or appends them to the queue
+// file when opts has one.
+func ImportIntoBeets(dirs []string, opts BeetsOptions) error {
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	if opts.Queue != "" {
+		file, err := os.OpenFile(opts.Queue, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+
+		if err != nil {
+			return fmt.Errorf("Could not open the beets queue: %w", err)
+		}
+
+		if _, err = file.WriteString(strings.Join(dirs, "\n") + "\n"); err != nil {
+			file.Close()
+			return fmt.Errorf("Could not write the beets queue: %w", err)
+		}
+
+		return file.Close()
+	}
+
+	command := opts.Command
+
+	if command == "" {
+		command = "beet"
+	}
+
+	args := append(append([]string{"import"}, opts.Args...), dirs...)
+
+	var output bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("beet import failed: %w: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// importIntoBeets imports what the run extracted since the last call, logging what went wrong.
+func (d *Downloader) importIntoBeets(imports *beetsImport) {
+	if imports == nil {
+		return
+	}
+
+	dirs := imports.take()
+
+	if len(dirs) == 0 {
+		return
+	}
+
+	if err := ImportIntoBeets(dirs, *d.extract.Beets); err != nil {
+		log.Printf("Could not import %d albums into beets: %v", len(dirs), err)
+		return
+	}
+
+	if d.extract.Beets.Queue != "" {
+		log.Printf("Queued %d albums for beets in %s", len(dirs), d.extract.Beets.Queue)
+	} else {
+		log.Printf("Imported %d albums into beets", len(dirs))
+	}
+}
@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CookieBrowsers are the browsers ImportIdentity can read cookies from.
+var CookieBrowsers = []string{"firefox", "chrome", "chromium", "brave"}
+
+// ImportIdentity reads Bandcamp's identity cookie from a browser installed on this machine,
+// so it doesn't have to be copied out of the developer tools by hand. browser is one of
+// CookieBrowsers, or "auto" to try each of them.
+//
+// The cookie databases are SQLite files, which are read with the sqlite3 command line tool.
+// Chrome based browsers encrypt their cookies with a key kept in the OS keychain.
+func ImportIdentity(browser string) (string, error) {
+	browsers := []string{browser}
+
+	if browser == "auto" {
+		browsers = CookieBrowsers
+	} else if !isCookieBrowser(browser) {
+		return "", fmt.Errorf("Unsupported browser %q, expected one of %s or auto", browser, strings.Join(CookieBrowsers, ", "))
+	}
+
+	var errs []error
+
+	for _, b := range browsers {
+		for _, db := range cookieDatabases(b) {
+			identity, err := readIdentityCookie(b, db)
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", db, err))
+				continue
+			}
+
+			if identity != "" {
+				return identity, nil
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return "", fmt.Errorf("Could not import the identity cookie from %s: %w", browser, errors.Join(errs...))
+	}
+
+	return "", fmt.Errorf("No Bandcamp identity cookie found in %s. Sign into Bandcamp with it first", browser)
+}
+
+func isCookieBrowser(browser string) bool {
+	for _, b := range CookieBrowsers {
+		if b == browser {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cookieDatabases finds the cookie database of every profile of the browser.
+func cookieDatabases(browser string) []string {
+	var patterns []string
+
+	if browser == "firefox" {
+		for _, dir := range firefoxProfileDirs() {
+			patterns = append(patterns, filepath.Join(dir, "*", "cookies.sqlite"))
+		}
+	} else if dir := chromiumDataDir(browser); dir != "" {
+		// Newer versions moved the database into a Network folder
+		patterns = append(patterns, filepath.Join(dir, "*", "Network", "Cookies"), filepath.Join(dir, "*", "Cookies"))
+	}
+
+	var dbs []string
+
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		dbs = append(dbs, matches...)
+	}
+
+	return dbs
+}
+
+// readIdentityCookie returns the most recently used identity cookie in the database,
+// or the empty string if there isn't one.
+func readIdentityCookie(browser, db string) (string, error) {
+	if browser == "firefox" {
+		rows, err := querySQLite(db, `SELECT value FROM moz_cookies
+			WHERE name = 'identity' AND host LIKE '%bandcamp.com' ORDER BY lastAccessed DESC LIMIT 1`)
+
+		if err != nil || len(rows) == 0 {
+			return "", err
+		}
+
+		return rows[0][0], nil
+	}
+
+	rows, err := querySQLite(db, `SELECT host_key, value, hex(encrypted_value) FROM cookies
+		WHERE name = 'identity' AND host_key LIKE '%bandcamp.com' ORDER BY last_access_utc DESC LIMIT 1`)
+
+	if err != nil || len(rows) == 0 {
+		return "", err
+	}
+
+	if len(rows[0]) < 3 {
+		return "", fmt.Errorf("Unexpected cookie row")
+	}
+
+	host, value, encrypted := rows[0][0], rows[0][1], rows[0][2]
+
+	if value != "" {
+		return value, nil
+	}
+
+	raw, err := hex.DecodeString(encrypted)
+
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := decryptChromiumCookie(browser, db, raw)
+
+	if err != nil {
+		return "", fmt.Errorf("Could not decrypt cookie: %w", err)
+	}
+
+	// Since version 24 of the database, values are prefixed with a hash of the host
+	if hash := sha256.Sum256([]byte(host)); bytes.HasPrefix(plain, hash[:]) {
+		plain = plain[len(hash):]
+	}
+
+	return string(plain), nil
+}
+
+// querySQLite runs query against a copy of the database, since browsers keep their
+// cookie databases locked while running. Columns are separated by tabs.
+func querySQLite(db, query string) ([][]string, error) {
+	sqlite, err := exec.LookPath("sqlite3")
+
+	if err != nil {
+		return nil, fmt.Errorf("The sqlite3 command is needed to read browser cookies: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "bcdl-cookies-*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	cp := filepath.Join(dir, "cookies.sqlite")
+
+	// The write ahead log holds changes that haven't made it into the database yet
+	for _, suffix := range []string{"", "-wal"} {
+		if err := copyFile(db+suffix, cp+suffix); err != nil && (suffix == "" || !errors.Is(err, os.ErrNotExist)) {
+			return nil, err
+		}
+	}
+
+	out, err := exec.Command(sqlite, "-readonly", "-separator", "\t", cp, query).Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not query cookies: %w", err)
+	}
+
+	var rows [][]string
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			rows = append(rows, strings.Split(line, "\t"))
+		}
+	}
+
+	return rows, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(dst)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// pbkdf2SHA1 derives a key the way Chrome does for its cookie encryption.
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var key []byte
+
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(nil)
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
+
+// decryptCBC decrypts a "v10" or "v11" value as written by Chrome on Linux and macOS.
+func decryptCBC(password []byte, iterations int, value []byte) ([]byte, error) {
+	key := pbkdf2SHA1(password, []byte("saltysalt"), iterations, 16)
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := value[3:]
+
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("Encrypted value has an invalid length")
+	}
+
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, bytes.Repeat([]byte(" "), aes.BlockSize)).CryptBlocks(plain, ciphertext)
+
+	// Remove the PKCS#7 padding, which also tells us whether the key was right
+	pad := int(plain[len(plain)-1])
+
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("Wrong decryption key")
+	}
+
+	return plain[:len(plain)-pad], nil
+}
@@ -0,0 +1,61 @@
+//go:build darwin
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+func firefoxProfileDirs() []string {
+	home, _ := os.UserHomeDir()
+
+	return []string{filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles")}
+}
+
+func chromiumDataDir(browser string) string {
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return ""
+	}
+
+	support := filepath.Join(home, "Library", "Application Support")
+
+	switch browser {
+	case "chrome":
+		return filepath.Join(support, "Google", "Chrome")
+	case "chromium":
+		return filepath.Join(support, "Chromium")
+	case "brave":
+		return filepath.Join(support, "BraveSoftware", "Brave-Browser")
+	}
+
+	return ""
+}
+
+// safeStorageNames are the keychain items holding each browser's cookie password.
+var safeStorageNames = map[string]string{
+	"chrome":   "Chrome Safe Storage",
+	"chromium": "Chromium Safe Storage",
+	"brave":    "Brave Safe Storage",
+}
+
+// decryptChromiumCookie decrypts a cookie value with the password from the login keychain.
+// macOS will ask for permission to read it the first time.
+func decryptChromiumCookie(browser, db string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte("v10")) {
+		return nil, fmt.Errorf("Unknown encryption scheme")
+	}
+
+	out, err := exec.Command("security", "find-generic-password", "-w", "-s", safeStorageNames[browser]).Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s from the keychain: %w", safeStorageNames[browser], err)
+	}
+
+	return decryptCBC(bytes.TrimSpace(out), 1003, value)
+}
@@ -0,0 +1,69 @@
+//go:build !darwin && !windows
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+func firefoxProfileDirs() []string {
+	home, _ := os.UserHomeDir()
+
+	return []string{
+		filepath.Join(home, ".mozilla", "firefox"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+	}
+}
+
+func chromiumDataDir(browser string) string {
+	config, err := os.UserConfigDir()
+
+	if err != nil {
+		return ""
+	}
+
+	switch browser {
+	case "chrome":
+		return filepath.Join(config, "google-chrome")
+	case "chromium":
+		return filepath.Join(config, "chromium")
+	case "brave":
+		return filepath.Join(config, "BraveSoftware", "Brave-Browser")
+	}
+
+	return ""
+}
+
+// decryptChromiumCookie decrypts a cookie value. "v10" values use a fixed password,
+// "v11" values use a password kept in the desktop keyring.
+func decryptChromiumCookie(browser, db string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte("v10")) && !bytes.HasPrefix(value, []byte("v11")) {
+		return nil, fmt.Errorf("Unknown encryption scheme")
+	}
+
+	passwords := [][]byte{[]byte("peanuts"), {}}
+
+	if bytes.HasPrefix(value, []byte("v11")) {
+		out, err := exec.Command("secret-tool", "lookup", "application", browser).Output()
+
+		if err == nil {
+			passwords = append([][]byte{bytes.TrimSpace(out)}, passwords...)
+		}
+	}
+
+	var err error
+
+	for _, password := range passwords {
+		var plain []byte
+
+		if plain, err = decryptCBC(password, 1, value); err == nil {
+			return plain, nil
+		}
+	}
+
+	return nil, err
+}
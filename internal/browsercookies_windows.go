@@ -0,0 +1,137 @@
+//go:build windows
+
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+func firefoxProfileDirs() []string {
+	return []string{filepath.Join(os.Getenv("APPDATA"), "Mozilla", "Firefox", "Profiles")}
+}
+
+func chromiumDataDir(browser string) string {
+	local := os.Getenv("LOCALAPPDATA")
+
+	switch browser {
+	case "chrome":
+		return filepath.Join(local, "Google", "Chrome", "User Data")
+	case "chromium":
+		return filepath.Join(local, "Chromium", "User Data")
+	case "brave":
+		return filepath.Join(local, "BraveSoftware", "Brave-Browser", "User Data")
+	}
+
+	return ""
+}
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+// unprotect decrypts data that was encrypted with DPAPI for the current user.
+func unprotect(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Nothing to decrypt")
+	}
+
+	in := dataBlob{size: uint32(len(data)), data: &data[0]}
+	var out dataBlob
+
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out)))
+
+	if r == 0 {
+		return nil, fmt.Errorf("CryptUnprotectData failed: %w", err)
+	}
+
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.data)))
+
+	return append([]byte(nil), unsafe.Slice(out.data, out.size)...), nil
+}
+
+// masterKey reads the AES key Chrome keeps, protected with DPAPI, in the Local State
+// file at the top of the user data directory.
+func masterKey(db string) ([]byte, error) {
+	for dir := filepath.Dir(db); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		contents, err := os.ReadFile(filepath.Join(dir, "Local State"))
+
+		if err != nil {
+			continue
+		}
+
+		var state struct {
+			OSCrypt struct {
+				EncryptedKey string `json:"encrypted_key"`
+			} `json:"os_crypt"`
+		}
+
+		if err := json.Unmarshal(contents, &state); err != nil {
+			return nil, err
+		}
+
+		key, err := base64.StdEncoding.DecodeString(state.OSCrypt.EncryptedKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return unprotect(bytes.TrimPrefix(key, []byte("DPAPI")))
+	}
+
+	return nil, fmt.Errorf("Could not find Local State")
+}
+
+// decryptChromiumCookie decrypts a cookie value. "v10" values are AES-GCM encrypted with
+// the master key, older values are protected by DPAPI directly. The app-bound "v20"
+// encryption of recent Chrome releases can't be read by other programs.
+func decryptChromiumCookie(browser, db string, value []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(value, []byte("v20")):
+		return nil, fmt.Errorf("%s uses app-bound encryption, use `bcdl login` instead", browser)
+	case !bytes.HasPrefix(value, []byte("v10")):
+		return unprotect(value)
+	}
+
+	key, err := masterKey(db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) < 3+gcm.NonceSize() {
+		return nil, fmt.Errorf("Encrypted value is too short")
+	}
+
+	nonce := value[3 : 3+gcm.NonceSize()]
+
+	return gcm.Open(nil, nonce, value[3+gcm.NonceSize():], nil)
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bundle is a group of items from one artist that were added to the collection at the
+// same time, such as a full digital discography purchase.
+type Bundle struct {
+	BandID    string
+	Purchased time.Time
+	Titles    []string
+	// keys identify the items of the bundle, see CollectionEntry.key
+	keys []string
+}
+
+// BundleOptions controls how bundles are detected and downloaded.
+type BundleOptions struct {
+	// MinSize is the number of items from one artist that must arrive together to count as a bundle.
+	MinSize int
+	// Window is how close together the purchase times must be.
+	Window time.Duration
+	// Delay is the minimum time between starting two downloads from the same bundle,
+	// so a single purchase doesn't look like an anomalous mass download.
+	Delay time.Duration
+}
+
+// DefaultBundleOptions treats 3 or more items from one artist bought within 10 minutes
+// as a bundle and spaces their downloads 30 seconds apart.
+func DefaultBundleOptions() BundleOptions {
+	return BundleOptions{MinSize: 3, Window: 10 * time.Minute, Delay: 30 * time.Second}
+}
+
+// detectBundles groups the entries into bundles. Entries without a known artist or
+// purchase time are never part of a bundle.
+func detectBundles(entries []CollectionEntry, opts BundleOptions) []Bundle {
+	byBand := make(map[string][]CollectionEntry)
+
+	for _, entry := range entries {
+		if entry.bandID != "" && !entry.purchased.IsZero() {
+			byBand[entry.bandID] = append(byBand[entry.bandID], entry)
+		}
+	}
+
+	var bundles []Bundle
+
+	for band, items := range byBand {
+		sort.Slice(items, func(i, j int) bool { return items[i].purchased.Before(items[j].purchased) })
+
+		start := 0
+		for i := 1; i <= len(items); i++ {
+			// Close off the current group once the next item is too far away
+			if i < len(items) && items[i].purchased.Sub(items[i-1].purchased) <= opts.Window {
+				continue
+			}
+
+			if i-start >= opts.MinSize {
+				bundle := Bundle{BandID: band, Purchased: items[start].purchased}
+
+				for _, item := range items[start:i] {
+					bundle.Titles = append(bundle.Titles, item.title)
+					bundle.keys = append(bundle.keys, item.key())
+				}
+
+				bundles = append(bundles, bundle)
+			}
+
+			start = i
+		}
+	}
+
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].Purchased.Before(bundles[j].Purchased) })
+
+	return bundles
+}
+
+// groupBundles reorders entries so every bundle's items are next to each other, at the
+// position of the bundle's first item. It returns the bundle each item belongs to, by key.
+func groupBundles(entries []CollectionEntry, bundles []Bundle) ([]CollectionEntry, map[string]int) {
+	member := make(map[string]int)
+
+	for i, bundle := range bundles {
+		for _, key := range bundle.keys {
+			member[key] = i
+		}
+	}
+
+	grouped := make([]CollectionEntry, 0, len(entries))
+	placed := make(map[int]bool)
+
+	for _, entry := range entries {
+		b, ok := member[entry.key()]
+
+		if !ok {
+			grouped = append(grouped, entry)
+			continue
+		}
+
+		if placed[b] {
+			continue
+		}
+
+		placed[b] = true
+
+		for _, other := range entries {
+			if i, ok := member[other.key()]; ok && i == b {
+				grouped = append(grouped, other)
+			}
+		}
+	}
+
+	return grouped, member
+}
+
+// bundleLimiter spaces out the downloads of items belonging to the same bundle.
+type bundleLimiter struct {
+	mu    sync.Mutex
+	delay time.Duration
+	next  map[int]time.Time
+}
+
+func newBundleLimiter(delay time.Duration) *bundleLimiter {
+	return &bundleLimiter{delay: delay, next: make(map[int]time.Time)}
+}
+
+// wait blocks until the bundle may start another download.
+func (l *bundleLimiter) wait(bundle int) {
+	l.mu.Lock()
+	now := time.Now()
+	start := now
+	if l.next[bundle].After(now) {
+		start = l.next[bundle]
+	}
+	l.next[bundle] = start.Add(l.delay)
+	l.mu.Unlock()
+
+	time.Sleep(start.Sub(now))
+}
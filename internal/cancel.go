@@ -0,0 +1,21 @@
+package internal
+
+import "context"
+
+// closeOnCancel closes the page once ctx is done, which makes the Playwright calls waiting
+// on it return right away. stop ends the watch, it doesn't close the page.
+func closeOnCancel(ctx context.Context, page interface{ Close() error }) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			page.Close()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"errors"
+	"time"
+)
+
+// Checkpoints are the milestones of a run progress is reported at, see WithCheckpoints.
+// Either can be left at zero.
+type Checkpoints struct {
+	// Every reports progress whenever this many more items finished
+	Every int
+	// Percent reports progress whenever this many more percent of the items finished
+	Percent int
+}
+
+// Checkpoint is the progress of a run at one of its Checkpoints.
+type Checkpoint struct {
+	// Done items finished one way or another, out of Total so far. Total grows when
+	// WithWatch finds new purchases
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// Succeeded and Failed are what Done is made up of, the rest were cancelled or deferred
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Bytes were downloaded so far
+	Bytes          int64   `json:"bytes"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// Percent returns how much of the run is done.
+func (c Checkpoint) Percent() int {
+	if c.Total == 0 {
+		return 100
+	}
+
+	return c.Done * 100 / c.Total
+}
+
+// WithCheckpoints reports the progress of long runs at the milestones of checkpoints,
+// through DownloadOpts.OnCheckpoint and to the webhook as "checkpoint" events, so
+// unattended backfills can be followed without watching them.
+func WithCheckpoints(checkpoints Checkpoints) func(*Downloader) {
+	return func(d *Downloader) {
+		d.checkpoints = checkpoints
+	}
+}
+
+// checkpointTracker counts the finished items of a run to tell when a checkpoint is reached.
+type checkpointTracker struct {
+	checkpoints Checkpoints
+	start       time.Time
+	progress    Checkpoint
+	// percent is the last percentage reported
+	percent int
+}
+
+// newCheckpointTracker starts tracking a run of total items.
+func newCheckpointTracker(checkpoints Checkpoints, total int) *checkpointTracker {
+	return &checkpointTracker{checkpoints: checkpoints, start: time.Now(), progress: Checkpoint{Total: total}}
+}
+
+// add counts n more items that are still to be done.
+func (t *checkpointTracker) add(n int) {
+	t.progress.Total += n
+}
+
+// finish counts the job as done and returns the progress if that reached a checkpoint.
+func (t *checkpointTracker) finish(job downloadJob) (Checkpoint, bool) {
+	t.progress.Done++
+
+	if job.Success {
+		t.progress.Succeeded++
+
+		if !job.saved.existing {
+			t.progress.Bytes += job.saved.bytes
+		}
+	} else if !errors.Is(job.err, ErrCancelled) && !errors.Is(job.err, ErrInsufficientSpace) {
+		t.progress.Failed++
+	}
+
+	reached := t.checkpoints.Every > 0 && t.progress.Done%t.checkpoints.Every == 0
+
+	if p := t.checkpoints.Percent; p > 0 {
+		if percent := t.progress.Percent() / p * p; percent > t.percent {
+			t.percent = percent
+			reached = true
+		}
+	}
+
+	t.progress.ElapsedSeconds = time.Since(t.start).Seconds()
+
+	return t.progress, reached
+}
+
+// checkpoint reports that the run reached a checkpoint.
+func (d *Downloader) checkpoint(run RunInfo, progress Checkpoint, opts DownloadOpts) {
+	if opts.OnCheckpoint != nil {
+		opts.OnCheckpoint(progress)
+	}
+
+	if d.sender == nil {
+		return
+	}
+
+	// Sent right away even when events are batched, it sums them up anyway
+	event := ItemEvent{Event: "checkpoint", Title: "Checkpoint", Time: time.Now()}
+	d.sender.send(WebhookData{Item: event, Run: run, Checkpoint: &progress})
+}
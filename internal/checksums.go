@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// checksumManifest is the name of the manifest in the library, in the format sha256sum -c reads.
+const checksumManifest = "SHA256SUMS"
+
+// manifestMu serializes updates of the manifest by the workers of one process. The library
+// lock does the same across processes for shared libraries.
+var manifestMu sync.Mutex
+
+// WithChecksums computes the SHA-256 of every download, records it in the history and adds
+// it to the SHA256SUMS manifest of the library, so the collection can be verified later
+// with `sha256sum -c SHA256SUMS`.
+//
+// The manifest is only kept for libraries on this machine, and archives deleted after
+// extracting are left out of it.
+func WithChecksums() func(*Downloader) {
+	return func(d *Downloader) {
+		d.checksums = true
+	}
+}
+
+// checksum computes the SHA-256 of the download saved as name. It reads the file in the
+// library when it is on this machine and the browser's copy otherwise. dl may be nil for
+// files that were already in the library.
+func (lib *library) checksum(dl fetchedFile, name string) (string, error) {
+	var path string
+
+	if local, ok := lib.storage.(*LocalStorage); ok {
+		path = lib.localPath(local, name)
+	} else if dl != nil {
+		var err error
+
+		if path, err = dl.Path(); err != nil {
+			return "", err
+		}
+	} else {
+		return "", errors.New("The file isn't on this machine")
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// addChecksum records the checksum of name in the manifest of the library, replacing an
+// earlier one of the same file. Libraries in other storages don't have a manifest.
+func (lib *library) addChecksum(name, sum string) error {
+	local, ok := lib.storage.(*LocalStorage)
+
+	if !ok {
+		return nil
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	return lib.withLock(func() error {
+		dir := local.Dir
+
+		// The manifest lists the library, not what is staged
+		if lib.incoming != "" {
+			dir = lib.dir
+		}
+
+		path := filepath.Join(dir, checksumManifest)
+		lines, err := readManifest(path)
+
+		if err != nil {
+			return err
+		}
+
+		kept := lines[:0]
+
+		for _, line := range lines {
+			if _, file, _ := strings.Cut(line, "  "); file != name {
+				kept = append(kept, line)
+			}
+		}
+
+		kept = append(kept, sum+"  "+name)
+
+		tmp := path + ".tmp"
+
+		if err := os.WriteFile(tmp, []byte(strings.Join(kept, "\n")+"\n"), 0o666); err != nil {
+			return fmt.Errorf("Could not write %s: %w", checksumManifest, err)
+		}
+
+		return os.Rename(tmp, path)
+	})
+}
+
+// readManifest returns the lines of the manifest at path. A missing manifest is empty.
+func readManifest(path string) ([]string, error) {
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %w", checksumManifest, err)
+	}
+
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// recordChecksum computes the checksum of the download saved as name and adds it to the
+// manifest unless the file is about to be deleted. Failures are only logged, the album
+// itself downloaded fine.
+func (job downloadJob) recordChecksum(dl fetchedFile, name string, deleted bool) string {
+	sum, err := job.library.checksum(dl, name)
+
+	if err != nil {
+		log.Printf("Could not compute the checksum of %s: %v", job.Entry.title, err)
+		return ""
+	}
+
+	if !deleted {
+		if err := job.library.addChecksum(name, sum); err != nil {
+			log.Printf("Could not add %s to %s: %v", job.Entry.title, checksumManifest, err)
+		}
+	}
+
+	return sum
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultCleanAge is how old leftovers have to be before Clean removes them. Anything
+// younger may still belong to a running instance.
+const DefaultCleanAge = 7 * 24 * time.Hour
+
+// CleanReport lists what Clean removed, or would have removed in a dry run.
+type CleanReport struct {
+	Files []string
+	Bytes int64
+}
+
+// WithCleanup cleans the libraries of a run, see Clean, before it starts and in watch mode
+// whenever the queue ran dry after downloading, so long-lived instances don't pile up
+// leftovers.
+func WithCleanup(maxAge time.Duration) func(*Downloader) {
+	return func(d *Downloader) {
+		d.cleanAge = maxAge
+	}
+}
+
+// Clean removes what crashed or interrupted runs left behind in the library at dir once
+// it is older than maxAge: partially written files, temporary copies of the state in
+// .bcdl and staged uploads to an rclone remote that never finished. Uploads staged for
+// SFTP in the temporary directory of the system are removed too. Partial transfers are
+// kept while the queue of the last run still lists them, since resuming it continues them.
+//
+// With dryRun nothing is removed.
+func Clean(dir string, maxAge time.Duration, dryRun bool) (CleanReport, error) {
+	var report CleanReport
+	cutoff := time.Now().Add(-maxAge)
+	bcdlDir := filepath.Join(dir, ".bcdl")
+	staging := filepath.Join(bcdlDir, "staging")
+	resumable := resumablePartials(dir)
+
+	remove := func(path string, info fs.FileInfo) error {
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if !dryRun {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("Could not remove %s: %w", path, err)
+			}
+		}
+
+		report.Files = append(report.Files, path)
+		report.Bytes += info.Size()
+
+		return nil
+	}
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		name := entry.Name()
+		stale := strings.HasPrefix(name, ".bcdl-") || strings.HasSuffix(name, ".part") ||
+			(strings.HasPrefix(path, bcdlDir+string(filepath.Separator)) && strings.HasSuffix(name, ".tmp")) ||
+			strings.HasPrefix(path, staging+string(filepath.Separator))
+
+		if !stale || resumable[name] {
+			return nil
+		}
+
+		info, err := entry.Info()
+
+		if err != nil {
+			return nil
+		}
+
+		return remove(path, info)
+	})
+
+	if err != nil {
+		return report, err
+	}
+
+	uploads, _ := filepath.Glob(filepath.Join(os.TempDir(), "bcdl-sftp-*"))
+
+	for _, path := range uploads {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			if err := remove(path, info); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// cleanLibraries runs Clean on every directory of the libraries, logging what it removed
+// and what went wrong.
+func (d *Downloader) cleanLibraries(libs []*library) {
+	done := map[string]bool{}
+
+	for _, lib := range libs {
+		if done[lib.dir] {
+			continue
+		}
+
+		done[lib.dir] = true
+
+		report, err := Clean(lib.dir, d.cleanAge, false)
+
+		if err != nil {
+			log.Printf("Could not clean up %s: %v", lib.dir, err)
+		}
+
+		if len(report.Files) > 0 {
+			log.Printf("Removed %d leftover files from %s, freeing %s", len(report.Files), lib.dir, FormatSize(report.Bytes))
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// collectionSummary is what the last run learned about an account's collection. It lets
+// the TUI give estimates before a browser has been started.
+type collectionSummary struct {
+	Username  string    `json:"username"`
+	Items     int       `json:"items"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// saveCollectionSummary records the size of the user's collection in the state directory.
+func saveCollectionSummary(stateDir string, user *User, items int) error {
+	contents, err := json.Marshal(collectionSummary{Username: user.username, Items: items, UpdatedAt: time.Now()})
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(stateDir, "collection.json"), contents, 0o600); err != nil {
+		return fmt.Errorf("Could not save collection summary: %w", err)
+	}
+
+	return nil
+}
+
+// CachedCollectionSize returns how many items the user's collection had the last time
+// it was downloaded into dir. ok is false if the size isn't known.
+func CachedCollectionSize(dir, username string) (items int, ok bool) {
+	summary, _, ok := loadCollectionSummary(dir, username)
+
+	return summary.Items, ok
+}
+
+// loadCollectionSummary finds the user's collection summary in dir, along with the
+// state directory it was kept in.
+func loadCollectionSummary(dir, username string) (collectionSummary, string, bool) {
+	bcdlDir := filepath.Join(dir, ".bcdl")
+
+	for _, stateDir := range []string{
+		filepath.Join(bcdlDir, "accounts", username),
+		bcdlDir,
+	} {
+		contents, err := os.ReadFile(filepath.Join(stateDir, "collection.json"))
+
+		if err != nil {
+			continue
+		}
+
+		var summary collectionSummary
+
+		if json.Unmarshal(contents, &summary) == nil && summary.Username == username {
+			return summary, stateDir, true
+		}
+	}
+
+	return collectionSummary{}, "", false
+}
+
+// LibraryStatus compares the last known size of a collection with what has already been
+// downloaded from it.
+type LibraryStatus struct {
+	Items      int
+	Downloaded int
+	// CheckedAt is when the collection was last listed
+	CheckedAt time.Time
+}
+
+// Pending returns how many items have not been downloaded yet.
+func (s LibraryStatus) Pending() int {
+	return max(s.Items-s.Downloaded, 0)
+}
+
+// CachedLibraryStatus reports how much of the user's collection was already downloaded
+// into dir in the file type, without starting a browser. ok is false if the size of the
+// collection isn't known.
+func CachedLibraryStatus(dir, username string, ft FileType) (status LibraryStatus, ok bool) {
+	summary, stateDir, ok := loadCollectionSummary(dir, username)
+
+	if !ok {
+		return status, false
+	}
+
+	status.Items = summary.Items
+	status.CheckedAt = summary.UpdatedAt
+
+	history, err := LoadHistory(filepath.Join(stateDir, "history.jsonl"))
+
+	if err != nil {
+		return status, true
+	}
+
+	entries, err := history.List()
+
+	if err != nil {
+		return status, true
+	}
+
+	user := &User{username: username}
+	titles := map[string]bool{}
+
+	for _, entry := range entries {
+		if entry.FileType == ft && entry.ownedBy(user) {
+			titles[entry.albumKey()] = true
+		}
+	}
+
+	status.Downloaded = min(len(titles), status.Items)
+
+	return status, true
+}
+
+// FormatSize renders a byte count with a human readable unit, e.g. "1.2 GB".
+func FormatSize(bytes int64) string {
+	const unit = 1000
+
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "kMGTPE"[exp])
+}
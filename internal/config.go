@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Profile holds the settings of a run that can be kept in the config file.
+// Zero values mean "not set".
+type Profile struct {
+	Username    string            `toml:"username,omitempty"`
+	Directory   string            `toml:"directory,omitempty"`
+	FileType    string            `toml:"filetype,omitempty"`
+	Concurrency int               `toml:"concurrency,omitzero"`
+	Timeout     time.Duration     `toml:"timeout,omitzero"`
+	Filter      string            `toml:"filter,omitempty"`
+	Targets     map[string]string `toml:"targets,omitempty"`
+	Hooks       Hooks             `toml:"hooks,omitempty"`
+	MediaServer MediaServer       `toml:"media_server,omitempty"`
+	// Watch, OnlyBetween and CookiesFile give every profile `bcdl daemon` runs its own
+	// schedule and account
+	Watch       time.Duration `toml:"watch,omitzero"`
+	OnlyBetween string        `toml:"only_between,omitempty"`
+	CookiesFile string        `toml:"cookies_file,omitempty"`
+	// Extract, PathTemplate and WebhookURL are the layout and notifications picked during
+	// the setup of the first run
+	Extract      bool   `toml:"extract,omitempty"`
+	PathTemplate string `toml:"path_template,omitempty"`
+	WebhookURL   string `toml:"webhook_url,omitempty"`
+	// Proxy is the proxy every connection goes through, see SetProxy
+	Proxy string `toml:"proxy,omitempty"`
+	// MinFree is kept free in every directory, e.g. "20GB". Targets can keep their own
+	// amount free as DIR:SIZE
+	MinFree string `toml:"min_free,omitempty"`
+	// DedupStore is where downloads are hardlinked by checksum, see WithDedupStore
+	DedupStore string `toml:"dedup_store,omitempty"`
+	// ArtworkCache is where fetched artwork is kept, see ArtworkOptions.Cache
+	ArtworkCache string `toml:"artwork_cache,omitempty"`
+	// UserAgent, Headers and Locale are how bcdl presents itself, see ClientOptions
+	UserAgent string            `toml:"user_agent,omitempty"`
+	Headers   map[string]string `toml:"headers,omitempty"`
+	Locale    string            `toml:"locale,omitempty"`
+	// FilterTimeout, WaitUntil, ScrollDelta and ItemsPerScroll tune how long pages of
+	// Bandcamp are waited for, see PageWaits
+	FilterTimeout  time.Duration `toml:"filter_timeout,omitzero"`
+	WaitUntil      string        `toml:"wait_until,omitempty"`
+	ScrollDelta    float64       `toml:"scroll_delta,omitzero"`
+	ItemsPerScroll int           `toml:"items_per_scroll,omitzero"`
+}
+
+// Config is the contents of the config file. Settings at the top level apply to every
+// run, named profiles override them:
+//
+//	username = "me"
+//	filetype = "mp3-320"
+//
+//	[profile.flac-nas]
+//	directory = "/mnt/nas/music"
+//	filetype = "flac"
+//	timeout = "10m"
+//
+//	[profile.flac-nas.hooks]
+//	on_run_start = "mount /mnt/nas"
+//
+// `bcdl daemon` runs several profiles at once, each with its own account and schedule:
+//
+//	[profile.sam]
+//	directory = "/mnt/nas/sam"
+//	cookies_file = "/etc/bcdl/sam.txt"
+//	watch = "30m"
+//	only_between = "01:00-07:00"
+type Config struct {
+	Profile
+	Profiles map[string]Profile `toml:"profile,omitempty"`
+}
+
+// DefaultConfigPath returns where the config file lives, e.g. ~/.config/bcdl/config.toml.
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", fmt.Errorf("Could not find the config directory: %w", err)
+	}
+
+	return filepath.Join(dir, "bcdl", "config.toml"), nil
+}
+
+// LoadConfig reads the config file at path. A missing file results in an empty config.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	meta, err := toml.DecodeFile(path, &config)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil
+	}
+
+	if err != nil {
+		return Config{}, fmt.Errorf("Could not read config %s: %w", path, err)
+	}
+
+	// Typos would otherwise be silently ignored
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		var keys []string
+
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+
+		return Config{}, fmt.Errorf("Unknown settings in %s: %s", path, strings.Join(keys, ", "))
+	}
+
+	return config, nil
+}
+
+// SaveConfig writes config to path, creating its directory.
+func SaveConfig(path string, config Config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("Could not create the config directory: %w", err)
+	}
+
+	var contents bytes.Buffer
+
+	if err := toml.NewEncoder(&contents).Encode(config); err != nil {
+		return fmt.Errorf("Could not encode config: %w", err)
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, contents.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("Could not save config: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Could not save config: %w", err)
+	}
+
+	return nil
+}
+
+// Resolve returns the settings for the named profile layered over the top level settings.
+// An empty name returns the top level settings.
+func (c Config) Resolve(name string) (Profile, error) {
+	if name == "" {
+		return c.Profile, nil
+	}
+
+	p, ok := c.Profiles[name]
+
+	if !ok {
+		var names []string
+
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+
+		sort.Strings(names)
+
+		return Profile{}, fmt.Errorf("Unknown profile %q, the config has: %s", name, strings.Join(names, ", "))
+	}
+
+	return c.Profile.Merge(p), nil
+}
+
+// Merge returns p with every setting that is set in other replaced by other's value.
+func (p Profile) Merge(other Profile) Profile {
+	if other.Username != "" {
+		p.Username = other.Username
+	}
+
+	if other.Directory != "" {
+		p.Directory = other.Directory
+	}
+
+	if other.FileType != "" {
+		p.FileType = other.FileType
+	}
+
+	if other.Concurrency != 0 {
+		p.Concurrency = other.Concurrency
+	}
+
+	if other.Timeout != 0 {
+		p.Timeout = other.Timeout
+	}
+
+	if other.Filter != "" {
+		p.Filter = other.Filter
+	}
+
+	if len(other.Targets) > 0 {
+		p.Targets = other.Targets
+	}
+
+	p.Hooks = p.Hooks.Merge(other.Hooks)
+
+	if other.MediaServer.URL != "" {
+		p.MediaServer = other.MediaServer
+	}
+
+	if other.Watch != 0 {
+		p.Watch = other.Watch
+	}
+
+	if other.OnlyBetween != "" {
+		p.OnlyBetween = other.OnlyBetween
+	}
+
+	if other.CookiesFile != "" {
+		p.CookiesFile = other.CookiesFile
+	}
+
+	if other.Extract {
+		p.Extract = true
+	}
+
+	if other.PathTemplate != "" {
+		p.PathTemplate = other.PathTemplate
+	}
+
+	if other.WebhookURL != "" {
+		p.WebhookURL = other.WebhookURL
+	}
+
+	if other.Proxy != "" {
+		p.Proxy = other.Proxy
+	}
+
+	if other.MinFree != "" {
+		p.MinFree = other.MinFree
+	}
+
+	if other.DedupStore != "" {
+		p.DedupStore = other.DedupStore
+	}
+
+	if other.ArtworkCache != "" {
+		p.ArtworkCache = other.ArtworkCache
+	}
+
+	if other.UserAgent != "" {
+		p.UserAgent = other.UserAgent
+	}
+
+	if len(other.Headers) > 0 {
+		p.Headers = other.Headers
+	}
+
+	if other.Locale != "" {
+		p.Locale = other.Locale
+	}
+
+	if other.FilterTimeout != 0 {
+		p.FilterTimeout = other.FilterTimeout
+	}
+
+	if other.WaitUntil != "" {
+		p.WaitUntil = other.WaitUntil
+	}
+
+	if other.ScrollDelta != 0 {
+		p.ScrollDelta = other.ScrollDelta
+	}
+
+	if other.ItemsPerScroll != 0 {
+		p.ItemsPerScroll = other.ItemsPerScroll
+	}
+
+	return p
+}
+
+// PageWaits returns the default page waits with those the profile sets.
+func (p Profile) PageWaits() (PageWaits, error) {
+	waits := DefaultPageWaits()
+
+	if p.FilterTimeout > 0 {
+		waits.FilterTimeout = p.FilterTimeout
+	}
+
+	if p.WaitUntil != "" {
+		state, err := ParseWaitUntil(p.WaitUntil)
+
+		if err != nil {
+			return waits, err
+		}
+
+		waits.WaitUntil = state
+	}
+
+	if p.ScrollDelta > 0 {
+		waits.ScrollDelta = p.ScrollDelta
+	}
+
+	if p.ItemsPerScroll > 0 {
+		waits.ItemsPerScroll = p.ItemsPerScroll
+	}
+
+	return waits, nil
+}
+
+// FormatTargets parses the profile's targets, ordered by file type.
+func (p Profile) FormatTargets() ([]FormatTarget, error) {
+	var targets []FormatTarget
+
+	for format, dir := range p.Targets {
+		ft, err := ParseFileType(format)
+
+		if err != nil {
+			return nil, err
+		}
+
+		dir, minFree := ParseTargetDir(dir)
+		targets = append(targets, FormatTarget{FileType: ft, Dir: dir, MinFree: minFree})
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].FileType < targets[j].FileType })
+
+	return targets, nil
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// The commands a running process takes on its standard input, see ReadControl.
+const controlGet = "get"
+
+// writeControl sends the command with its argument to a process reading w with ReadControl.
+func writeControl(w io.Writer, command, arg string) error {
+	if strings.ContainsAny(arg, "\r\n") {
+		return fmt.Errorf("Invalid argument %q", arg)
+	}
+
+	if _, err := fmt.Fprintf(w, "%s %s\n", command, arg); err != nil {
+		return fmt.Errorf("Could not reach the profile: %w", err)
+	}
+
+	return nil
+}
+
+// ReadControl carries out the commands a supervising Daemon sends over r, one per line,
+// until r is closed. "get URL" puts the item at URL in front of the run of d, see Request.
+func ReadControl(r io.Reader, d *Downloader) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+
+		switch command {
+		case "":
+		case controlGet:
+			if err := d.Request(arg); err != nil {
+				log.Printf("Could not download %s next: %v", arg, err)
+			} else {
+				log.Printf("Downloading %s next", arg)
+			}
+		default:
+			log.Printf("Unknown command %q", command)
+		}
+	}
+}
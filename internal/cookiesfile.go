@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in files written by curl and most browser extensions.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// ReadCookiesFile parses a Netscape cookies.txt file, as exported for other downloaders,
+// and returns the cookies set for bandcamp.com.
+func ReadCookiesFile(path string) ([]playwright.OptionalCookie, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not open cookies file: %w", err)
+	}
+
+	defer file.Close()
+
+	var cookies []playwright.OptionalCookie
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		text = strings.TrimPrefix(text, httpOnlyPrefix)
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// domain, include subdomains, path, secure, expiry, name, value
+		fields := strings.Split(text, "\t")
+
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s:%d is not in the Netscape cookie format", path, line)
+		}
+
+		domain := fields[0]
+
+		if host := strings.TrimPrefix(domain, "."); host != bcUrl.Host && !strings.HasSuffix(host, "."+bcUrl.Host) {
+			continue
+		}
+
+		expires, err := strconv.ParseFloat(fields[4], 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d has an invalid expiry: %w", path, line, err)
+		}
+
+		cookie := playwright.OptionalCookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Domain:   playwright.String(domain),
+			Path:     playwright.String(fields[2]),
+			Secure:   playwright.Bool(strings.EqualFold(fields[3], "TRUE")),
+			HttpOnly: playwright.Bool(httpOnly),
+		}
+
+		// 0 marks a session cookie
+		if expires > 0 {
+			cookie.Expires = playwright.Float(expires)
+		}
+
+		cookies = append(cookies, cookie)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read cookies file: %w", err)
+	}
+
+	if len(cookies) == 0 {
+		return nil, fmt.Errorf("%s has no bandcamp.com cookies", path)
+	}
+
+	return cookies, nil
+}
+
+// cookieValue returns the value of the named cookie, or the empty string.
+func cookieValue(cookies []playwright.OptionalCookie, name string) string {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+
+	return ""
+}
+
+// IdentityFromCookies returns the value of the identity cookie, or the empty string.
+func IdentityFromCookies(cookies []playwright.OptionalCookie) string {
+	return cookieValue(cookies, "identity")
+}
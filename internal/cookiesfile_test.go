@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadCookiesFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{
+			"bandcamp cookies",
+			"# Netscape HTTP Cookie File\n.bandcamp.com\tTRUE\t/\tTRUE\t1900000000\tidentity\tabc\n",
+			"identity=abc secure=true httponly=false expires=true",
+			false,
+		},
+		{
+			"http only and session cookies",
+			"#HttpOnly_.bandcamp.com\tTRUE\t/\tFALSE\t0\tsession\txyz\r\n",
+			"session=xyz secure=false httponly=true expires=false",
+			false,
+		},
+		{
+			"subdomains and other sites",
+			"example.com\tFALSE\t/\tFALSE\t0\tother\t1\nartist.bandcamp.com\tFALSE\t/\tFALSE\t0\tfan\t2\n",
+			"fan=2 secure=false httponly=false expires=false",
+			false,
+		},
+		{
+			"lookalike domain",
+			"notbandcamp.com\tFALSE\t/\tFALSE\t0\tidentity\tabc\n",
+			"",
+			true,
+		},
+		{"too few fields", ".bandcamp.com\tTRUE\t/\tTRUE\tidentity\tabc\n", "", true},
+		{"invalid expiry", ".bandcamp.com\tTRUE\t/\tTRUE\tsoon\tidentity\tabc\n", "", true},
+		{"empty", "# Netscape HTTP Cookie File\n\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cookies.txt")
+
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cookies, err := ReadCookiesFile(path)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadCookiesFile(%q) error = %v, want error %v", tt.content, err, tt.wantErr)
+			}
+
+			var got []string
+
+			for _, c := range cookies {
+				got = append(got, fmt.Sprintf("%s=%s secure=%v httponly=%v expires=%v", c.Name, c.Value, *c.Secure, *c.HttpOnly, c.Expires != nil))
+			}
+
+			if strings.Join(got, "\n") != tt.want {
+				t.Errorf("ReadCookiesFile(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
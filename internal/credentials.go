@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keyringService identifies bcdl's secrets in the OS keyring.
+const keyringService = "bcdl"
+
+// errNoKeyring is returned by the keyring helpers when no keyring is available.
+var errNoKeyring = errors.New("No system keyring available")
+
+// identityAccount returns the keyring account the identity of the Bandcamp user is kept
+// under, so every account of a household keeps its own. Identities saved without a
+// username, e.g. by older versions, are kept as "identity".
+func identityAccount(username string) string {
+	if username == "" {
+		return "identity"
+	}
+
+	return "identity:" + username
+}
+
+// identityPath is where the identity of the user is kept when the OS keyring isn't available.
+func identityPath(username string) (string, error) {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", fmt.Errorf("Could not find the config directory: %w", err)
+	}
+
+	name := "identity"
+
+	if username != "" {
+		name = "identity-" + filepath.Base(username)
+	}
+
+	return filepath.Join(dir, "bcdl", name), nil
+}
+
+// SaveIdentity stores the identity cookie of the Bandcamp user so later runs don't need to
+// ask for it, keeping it out of shell history. The macOS Keychain, Windows Credential
+// Manager or libsecret is used when available, otherwise a file only readable by the
+// current user. The username may be empty when it isn't known yet.
+//
+// It returns a description of where the identity was stored.
+func SaveIdentity(username, identity string) (string, error) {
+	path, err := identityPath(username)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := keyringSet(keyringService, identityAccount(username), identity); err == nil {
+		// Don't leave an older copy behind to be picked up instead
+		os.Remove(path)
+		return "the system keyring", nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("Could not create config directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, []byte(identity+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("Could not save identity: %w", err)
+	}
+
+	return path, nil
+}
+
+// LoadIdentity returns the stored identity cookie of the Bandcamp user, or the empty string
+// if there isn't one.
+//
+// An identity saved without a username is taken over by the first user that has none of
+// its own, so it ends up with a single account rather than signing in all of them.
+func LoadIdentity(username string) (string, error) {
+	identity, err := loadIdentity(username)
+
+	if err != nil || identity != "" || username == "" {
+		return identity, err
+	}
+
+	if identity, err = loadIdentity(""); err != nil || identity == "" {
+		return identity, err
+	}
+
+	if _, err := SaveIdentity(username, identity); err != nil {
+		return "", err
+	}
+
+	deleteIdentity("")
+	log.Printf("The saved identity is %s's from now on, run `bcdl login --username NAME` for the other accounts", username)
+
+	return identity, nil
+}
+
+// loadIdentity reads the identity stored under the username.
+func loadIdentity(username string) (string, error) {
+	if identity, err := keyringGet(keyringService, identityAccount(username)); err == nil && identity != "" {
+		return identity, nil
+	}
+
+	path, err := identityPath(username)
+
+	if err != nil {
+		return "", err
+	}
+
+	contents, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Could not read identity: %w", err)
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// deleteIdentity removes the identity stored under the username, wherever it is.
+func deleteIdentity(username string) {
+	keyringDelete(keyringService, identityAccount(username))
+
+	if path, err := identityPath(username); err == nil {
+		os.Remove(path)
+	}
+}
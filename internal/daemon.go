@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a profile waits to be restarted after its process exited, doubling every time it
+// exits again soon after starting, and how long it has to keep running to start over.
+const (
+	daemonBackoff    = 10 * time.Second
+	daemonMaxBackoff = 30 * time.Minute
+	daemonStable     = 10 * time.Minute
+)
+
+// DaemonProfile is a profile of the config file Daemon keeps running, with the arguments
+// its bcdl process is started with.
+type DaemonProfile struct {
+	Name string
+	Args []string
+}
+
+// ErrProfileNotRunning is returned when an item is requested from a profile whose process
+// isn't running, e.g. while it waits to be restarted.
+var ErrProfileNotRunning = errors.New("The profile isn't running")
+
+// daemonState is how a profile is doing, for the metrics.
+type daemonState struct {
+	up       bool
+	started  time.Time
+	restarts int
+	// control is the standard input of the running process, see ReadControl
+	control io.Writer
+}
+
+// Daemon runs a bcdl process per profile, so one service covers every account of a
+// household, each with its own schedule, queue and directory. Processes that exit are
+// restarted with backoff, and their output is prefixed with the name of the profile.
+type Daemon struct {
+	executable string
+	profiles   []DaemonProfile
+
+	mu     sync.Mutex
+	states map[string]*daemonState
+}
+
+// NewDaemon creates a Daemon that runs the profiles with the bcdl executable.
+func NewDaemon(executable string, profiles []DaemonProfile) *Daemon {
+	states := map[string]*daemonState{}
+
+	for _, p := range profiles {
+		states[p.Name] = &daemonState{}
+	}
+
+	return &Daemon{executable: executable, profiles: profiles, states: states}
+}
+
+// Run starts every profile and keeps them running until ctx is done, which interrupts
+// them so they can finish what they are saving.
+func (d *Daemon) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, p := range d.profiles {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			d.supervise(ctx, p)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// supervise runs the profile until ctx is done, restarting it whenever it exits.
+func (d *Daemon) supervise(ctx context.Context, p DaemonProfile) {
+	wait := daemonBackoff
+
+	for {
+		start := time.Now()
+		err := d.runProfile(ctx, p)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(start) > daemonStable {
+			wait = daemonBackoff
+		}
+
+		if err != nil {
+			log.Printf("Profile %s stopped: %v, restarting in %v", p.Name, err, wait)
+		} else {
+			log.Printf("Profile %s finished, restarting in %v", p.Name, wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		wait = min(wait*2, daemonMaxBackoff)
+
+		d.update(p.Name, func(s *daemonState) { s.restarts++ })
+	}
+}
+
+// runProfile runs the bcdl process of the profile until it exits.
+func (d *Daemon) runProfile(ctx context.Context, p DaemonProfile) error {
+	output, w := io.Pipe()
+	defer w.Close()
+
+	cmd := exec.CommandContext(ctx, d.executable, p.Args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	control, err := cmd.StdinPipe()
+
+	if err != nil {
+		return fmt.Errorf("Could not start bcdl: %w", err)
+	}
+
+	// Interrupted processes stop after the downloads in progress, killed ones don't
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+
+		return nil
+	}
+	cmd.WaitDelay = time.Minute
+
+	go prefixLines(output, p.Name)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Could not start bcdl: %w", err)
+	}
+
+	d.update(p.Name, func(s *daemonState) {
+		s.up = true
+		s.started = time.Now()
+		s.control = control
+	})
+
+	err = cmd.Wait()
+
+	d.update(p.Name, func(s *daemonState) {
+		s.up = false
+		s.control = nil
+	})
+
+	return err
+}
+
+// prefixLines copies the lines of r to stderr with the name of the profile in front.
+func prefixLines(r io.Reader, name string) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fmt.Fprintf(os.Stderr, "[%s] %s\n", name, scanner.Text())
+	}
+
+	// Keep the process from blocking on a line too long to scan
+	io.Copy(io.Discard, r)
+}
+
+// update changes the state of the named profile.
+func (d *Daemon) update(name string, change func(*daemonState)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	change(d.states[name])
+}
+
+// ServeHTTP serves the state of every profile in the Prometheus text format.
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var names []string
+
+	for name := range d.states {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	metric := func(name, kind, help string, value func(*daemonState) int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+
+		for _, profile := range names {
+			fmt.Fprintf(&b, "%s{profile=%q} %d\n", name, profile, value(d.states[profile]))
+		}
+	}
+
+	metric("bcdl_profile_up", "gauge", "Whether the bcdl process of the profile is running.", func(s *daemonState) int64 {
+		if s.up {
+			return 1
+		}
+
+		return 0
+	})
+	metric("bcdl_profile_restarts_total", "counter", "How often the profile was restarted.", func(s *daemonState) int64 {
+		return int64(s.restarts)
+	})
+	metric("bcdl_profile_started_seconds", "gauge", "When the bcdl process of the profile last started, as a Unix time.", func(s *daemonState) int64 {
+		if s.started.IsZero() {
+			return 0
+		}
+
+		return s.started.Unix()
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.WriteString(w, b.String())
+}
+
+// Request asks the running process of the named profile to download the item at link
+// ahead of the rest of its queue, see Downloader.Request. Whether it could is in the log of
+// the profile. The name may be left out when the daemon runs a single profile.
+func (d *Daemon) Request(name, link string) error {
+	if name == "" && len(d.profiles) == 1 {
+		name = d.profiles[0].Name
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.states[name]
+
+	if !ok {
+		return fmt.Errorf("Unknown profile %q", name)
+	}
+
+	if state.control == nil {
+		return fmt.Errorf("%w: %s", ErrProfileNotRunning, name)
+	}
+
+	return writeControl(state.control, controlGet, link)
+}
+
+// serveGet takes requests for items at /get, a POST with the url of the item and the
+// profile whose collection has it, see Request.
+func (d *Daemon) serveGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Expected a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link := r.FormValue("url")
+
+	if link == "" {
+		http.Error(w, "Expected the url of an item", http.StatusBadRequest)
+		return
+	}
+
+	err := d.Request(r.FormValue("profile"), link)
+
+	switch {
+	case errors.Is(err, ErrProfileNotRunning):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// Serve serves the metrics of the daemon at /metrics and takes requests for items at /get
+// on addr until ctx is done.
+func (d *Daemon) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", d)
+	mux.HandleFunc("/get", d.serveGet)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Could not serve metrics: %w", err)
+	}
+
+	return nil
+}
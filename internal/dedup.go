@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// WithDedupStore keeps a single copy on disk of downloads that are the same byte for byte,
+// e.g. an album in several layouts or owned by several accounts. Every download is
+// hardlinked into the store at dir under its SHA-256, and a download whose checksum is
+// already there is replaced with a hardlink to that copy. The store has to be on the same
+// file system as the libraries, and only libraries on this machine are deduplicated.
+//
+// Linked files are the same file, so editing one, e.g. retagging it, changes all of them.
+func WithDedupStore(dir string) func(*Downloader) {
+	return func(d *Downloader) {
+		d.dedupStore = dir
+	}
+}
+
+// dedupe adds the file saved as name with the checksum sum to the store, or replaces it
+// with a hardlink to the copy in the store if there already is one. It reports whether
+// the file was replaced.
+func (lib *library) dedupe(store, name, sum string) (bool, error) {
+	local, ok := lib.storage.(*LocalStorage)
+
+	if !ok || sum == "" {
+		return false, nil
+	}
+
+	file := lib.localPath(local, name)
+	object := filepath.Join(store, sum[:2], sum)
+
+	if err := os.MkdirAll(filepath.Dir(object), 0o777); err != nil {
+		return false, fmt.Errorf("Could not create dedup store: %w", err)
+	}
+
+	err := os.Link(file, object)
+
+	if err == nil {
+		return false, nil
+	}
+
+	if !errors.Is(err, fs.ErrExist) {
+		return false, fmt.Errorf("Could not add %s to the dedup store: %w", name, err)
+	}
+
+	fileInfo, err := os.Stat(file)
+
+	if err != nil {
+		return false, err
+	}
+
+	objectInfo, err := os.Stat(object)
+
+	if err != nil {
+		return false, err
+	}
+
+	if os.SameFile(fileInfo, objectInfo) {
+		return false, nil
+	}
+
+	// Linked next to the file and renamed over it, so the file never goes missing
+	tmp := file + ".bcdl-link"
+	os.Remove(tmp)
+
+	if err := os.Link(object, tmp); err != nil {
+		return false, fmt.Errorf("Could not link %s to the dedup store: %w", name, err)
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("Could not link %s to the dedup store: %w", name, err)
+	}
+
+	return true, nil
+}
+
+// deduplicate runs dedupe for the job, computing the checksum if it wasn't yet.
+func (job downloadJob) deduplicate(dl fetchedFile, name, sum string) {
+	if sum == "" {
+		var err error
+
+		if sum, err = job.library.checksum(dl, name); err != nil {
+			log.Printf("Could not compute the checksum of %s: %v", job.Entry.title, err)
+			return
+		}
+	}
+
+	linked, err := job.library.dedupe(job.dedupStore, name, sum)
+
+	if err != nil {
+		log.Println(err)
+	} else if linked {
+		log.Printf("%s is the same as an earlier download, linked it instead of keeping a copy", job.Entry.title)
+	}
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrInsufficientSpace is returned for downloads that don't fit into the free space left
+// in their directory. They are deferred rather than failed: nothing is recorded, so the
+// next run with room downloads them.
+var ErrInsufficientSpace = errors.New("Not enough free space")
+
+// spaceHeadroom is left free on top of every download, for the history and the filesystem itself
+const spaceHeadroom = 100_000_000
+
+// spaceReservations tracks how much of a directory's free space the downloads that are
+// running are about to take, so downloads side by side don't all count the same space.
+type spaceReservations struct {
+	mu       sync.Mutex
+	reserved int64
+	// keep is left free for other uses of the disk, see FormatTarget
+	keep int64
+}
+
+// WithMinFree keeps bytes free in every directory downloads go to, deferring the
+// downloads that would take more. Targets can keep their own amount free instead.
+func WithMinFree(bytes int64) func(*Downloader) {
+	return func(d *Downloader) {
+		d.minFree = bytes
+	}
+}
+
+// ParseSize parses sizes like 500MB, 20 GB or 1.5TB, in the decimal units of FormatSize.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	number := strings.TrimRightFunc(s, func(r rune) bool { return unicode.IsLetter(r) || r == ' ' })
+	unit := strings.ToUpper(strings.TrimSpace(s[len(number):]))
+
+	value, err := strconv.ParseFloat(number, 64)
+
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("Invalid size %q, expected e.g. 500MB or 20GB", s)
+	}
+
+	multiplier := map[string]float64{"": 1, "B": 1, "KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12, "PB": 1e15}[unit]
+
+	if multiplier == 0 {
+		return 0, fmt.Errorf("Invalid size %q, expected e.g. 500MB or 20GB", s)
+	}
+
+	return int64(value * multiplier), nil
+}
+
+// ParseTargetDir splits the size to keep free off the directory of a target, given as
+// DIR:SIZE, e.g. /home/me/phone-sync:20GB. Directories without one keep nothing free.
+func ParseTargetDir(value string) (string, int64) {
+	i := strings.LastIndex(value, ":")
+
+	if i < 0 {
+		return value, 0
+	}
+
+	// Anything else after a colon is part of the directory, e.g. of C:\Music
+	if size, err := ParseSize(value[i+1:]); err == nil {
+		return value[:i], size
+	}
+
+	return value, 0
+}
+
+// available returns how much of the free bytes of a directory are left for downloads.
+func (r *spaceReservations) available(free uint64) int64 {
+	return int64(free) - r.reserved - max(spaceHeadroom, r.keep)
+}
+
+// reserveSpace claims size bytes in the library for a download and returns the function
+// that releases them again. Storages other than the local disk, and platforms where free
+// space can't be checked, always have room.
+func (lib *library) reserveSpace(size int64) (func(), error) {
+	local, ok := lib.storage.(*LocalStorage)
+
+	if !ok || lib.space == nil {
+		return func() {}, nil
+	}
+
+	free, err := FreeSpace(local.Dir)
+
+	if err != nil {
+		return func() {}, nil
+	}
+
+	lib.space.mu.Lock()
+	defer lib.space.mu.Unlock()
+
+	available := lib.space.available(free)
+
+	if size > available {
+		return nil, fmt.Errorf("%w in %s: the download needs %s, %s are available", ErrInsufficientSpace, local.Dir, FormatSize(size), FormatSize(max(available, 0)))
+	}
+
+	lib.space.reserved += size
+
+	return func() {
+		lib.space.mu.Lock()
+		lib.space.reserved -= size
+		lib.space.mu.Unlock()
+	}, nil
+}
+
+// checkRoom returns ErrInsufficientSpace once the library has no room left, so the jobs
+// for it are deferred without opening their download pages.
+func (lib *library) checkRoom() error {
+	local, ok := lib.storage.(*LocalStorage)
+
+	if !ok || lib.space == nil {
+		return nil
+	}
+
+	free, err := FreeSpace(local.Dir)
+
+	if err != nil {
+		return nil
+	}
+
+	lib.space.mu.Lock()
+	defer lib.space.mu.Unlock()
+
+	if lib.space.available(free) <= 0 {
+		return fmt.Errorf("%w in %s, keeping %s free", ErrInsufficientSpace, local.Dir, FormatSize(max(spaceHeadroom, lib.space.keep)))
+	}
+
+	return nil
+}
+
+// warnLowSpace logs a warning when the estimated size of the downloads into a library is
+// more than the free space left in it.
+func (lib *library) warnLowSpace(estimate int64) {
+	local, ok := lib.storage.(*LocalStorage)
+
+	if !ok {
+		return
+	}
+
+	if free, err := FreeSpace(local.Dir); err == nil && estimate > int64(free) {
+		log.Printf("The downloads into %s need about %s but only %s are free. Albums that don't fit are deferred to a later run", local.Dir, FormatSize(estimate), FormatSize(int64(free)))
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package internal
+
+import "syscall"
+
+// FreeSpace returns how many bytes are available to the current user on the file system
+// holding path.
+func FreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package internal
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = kernel32.NewProc("GetDiskFreeSpaceExW")
+
+// FreeSpace returns how many bytes are available to the current user on the volume
+// holding path.
+func FreeSpace(path string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+
+	if r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&available)), 0, 0); r == 0 {
+		return 0, err
+	}
+
+	return available, nil
+}
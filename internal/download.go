@@ -2,9 +2,16 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/playwright-community/playwright-go"
@@ -14,6 +21,8 @@ import (
 type User struct {
 	identity string
 	username string
+	fanID    int64
+	cookies  []playwright.OptionalCookie
 }
 
 // Downloader represents all the options needed to successfully download the collection
@@ -24,7 +33,103 @@ type Downloader struct {
 	context  context.Context
 	timeout  time.Duration
 	headless bool
+	// endpoint is the remote browser set by WithRemoteBrowser
+	endpoint string
+	engine   DownloadEngine
+	// segments is set by WithSegments
+	segments int
 	filetype FileType
+	waits    PageWaits
+	shared   bool
+	gate     *pauseGate
+	webhook  *Webhook
+	batching *WebhookBatching
+	batcher  *webhookBatcher
+	sender   *webhookSender
+	artwork  *ArtworkOptions
+	extract  *AutoExtractOptions
+	names    FilenamePolicy
+	hooks    Hooks
+	template PathTemplate
+	existing ExistingPolicy
+	window   *TimeWindow
+	// checksums is set by WithChecksums
+	checksums bool
+	// dedupStore is set by WithDedupStore
+	dedupStore string
+	// limits are set by WithTransferTimeout and WithStallTimeout
+	limits transferLimits
+	// retries is set by WithRetries
+	retries int
+	// retryPasses is set by WithRetryPasses
+	retryPasses int
+	// checkpoints is set by WithCheckpoints
+	checkpoints Checkpoints
+	// keepAlive is set by WithKeepAlive
+	keepAlive   time.Duration
+	mediaServer *MediaServer
+	history     HistoryStore
+	storage     Storage
+	// cleanAge is set by WithCleanup
+	cleanAge time.Duration
+	// labels is set by WithLabelFilter
+	labels *LabelFilter
+	// upgrades is set by WithUpgrades
+	upgrades []UpgradeRule
+	// staged and incoming are set by WithStaging
+	staged   bool
+	incoming string
+	bundles  *BundleOptions
+	targets  []FormatTarget
+	// minFree is set by WithMinFree
+	minFree int64
+	// postWorkers is set by WithPostWorkers
+	postWorkers int
+	// adaptive is set by WithAdaptiveConcurrency
+	adaptive bool
+	timings  *timings
+	dryRun   bool
+	retry    bool
+	// resume is set by WithResume
+	resume bool
+	watch  time.Duration
+	// albums downloaded at the same time
+	concurrency int
+
+	mu  sync.Mutex
+	run *activeRun
+}
+
+// activeRun is the queue of the Download currently in progress and where its results go.
+// enqueue queues the jobs of an entry of its collection that isn't part of the run yet,
+// reporting whether there was anything to download.
+type activeRun struct {
+	queue      *jobQueue
+	results    chan<- downloadJob
+	collection []CollectionEntry
+	enqueue    func(CollectionEntry) bool
+}
+
+// find returns the entry of the collection whose album or track page, or download page,
+// is at link.
+func (r *activeRun) find(link string) (CollectionEntry, bool) {
+	u, err := url.Parse(link)
+
+	if err != nil {
+		return CollectionEntry{}, false
+	}
+
+	same := func(page url.URL) bool {
+		return page.Host != "" && strings.EqualFold(page.Host, u.Host) && strings.TrimSuffix(page.Path, "/") == strings.TrimSuffix(u.Path, "/")
+	}
+
+	for _, entry := range r.collection {
+		if same(entry.itemURL) || same(entry.url) {
+			return entry, true
+		}
+	}
+
+	return CollectionEntry{}, false
 }
 
 // NewUser creates a User from the provided username and identity parameters.
@@ -34,13 +139,23 @@ func NewUser(username, identity string) *User {
 	return u
 }
 
+// NewUserWithCookies creates a User that signs in with a full set of Bandcamp cookies,
+// e.g. from ReadCookiesFile. The identity is taken from the cookies when it is empty.
+func NewUserWithCookies(username, identity string, cookies []playwright.OptionalCookie) *User {
+	if identity == "" {
+		identity = cookieValue(cookies, "identity")
+	}
+
+	return &User{username: username, identity: identity, cookies: cookies}
+}
+
 // NewDownloader creates a new Download object using the specified options.
 func NewDownloader(user *User, dirPath string, options ...func(*Downloader)) (*Downloader, error) {
 	if dirPath == "" {
 		return nil, fmt.Errorf("Directory path cannot be empty")
 	}
 
-	dl := &Downloader{user: user, dirPath: dirPath}
+	dl := &Downloader{user: user, dirPath: dirPath, context: context.Background(), waits: DefaultPageWaits(), gate: newPauseGate(), concurrency: 3, timeout: 4 * time.Minute}
 
 	for _, f := range options {
 		f(dl)
@@ -48,20 +163,33 @@ func NewDownloader(user *User, dirPath string, options ...func(*Downloader)) (*D
 	return dl, nil
 }
 
-// WithContext sets the context for the downloader.
+// WithContext sets the context of the downloads. Once it is done, Download stops reading
+// the collection, reports what is queued or running through OnCancel and returns the
+// error of the context.
 func WithContext(ctx context.Context) func(*Downloader) {
 	return func(d *Downloader) {
 		d.context = ctx
 	}
 }
 
-// WithTimeout sets the starting timeout for each job.
+// WithTimeout sets the starting timeout for each job. It bounds how long Bandcamp may
+// take to prepare an album and how long the download may take to start, not the transfer
+// itself, see WithTransferTimeout and WithStallTimeout.
 func WithTimeout(timeout time.Duration) func(*Downloader) {
 	return func(d *Downloader) {
 		d.timeout = timeout
 	}
 }
 
+// WithConcurrency sets how many albums are downloaded at the same time. Values below 1 are ignored.
+func WithConcurrency(n int) func(*Downloader) {
+	return func(d *Downloader) {
+		if n > 0 {
+			d.concurrency = n
+		}
+	}
+}
+
 // WithHeadless sets whether or not to use a Headless browser.
 // Very useful for debugging.
 func WithHeadless() func(*Downloader) {
@@ -77,221 +205,1894 @@ func WithFiletype(filetype FileType) func(*Downloader) {
 	}
 }
 
-// DefaultDownloader creates a Downloader with sensible defaults.
-//
-// Defaults:
-//   - context: Background
-//   - timeout: 3 minutes
-//   - filetype: MP3_320
-func DefaultDownloader(user *User, dirPath string) (*Downloader, error) {
-	return NewDownloader(user, dirPath,
-		WithContext(context.Background()),
-		WithTimeout(3*time.Minute),
-		WithFiletype(MP3_320),
-	)
+// WithPageWaits sets the page load, filter and scroll tunables used while
+// browsing Bandcamp.
+func WithPageWaits(waits PageWaits) func(*Downloader) {
+	return func(d *Downloader) {
+		d.waits = waits
+	}
 }
 
-// downloadJob is used for processing a download request
-type downloadJob struct {
-	Entry       CollectionEntry
-	err         error
-	Success     bool
-	DownloadDir string
-	filetype    FileType
-	timeoutMs   float64
+// WithSharedLibrary allows several bcdl instances, each for a different account, to
+// download into the same directory. State is kept per account and files are written
+// under a cooperative lock. Items another account already downloaded in the same format
+// are recorded for this one too instead of being downloaded again, see OnShared.
+func WithSharedLibrary() func(*Downloader) {
+	return func(d *Downloader) {
+		d.shared = true
+	}
 }
 
-// failed marks the job as failed and sets the error
-func (j *downloadJob) failed(err error) {
-	j.Success = false
-	j.err = err
+// WithWebhook posts an event to the webhook for every album that finishes.
+func WithWebhook(webhook *Webhook) func(*Downloader) {
+	return func(d *Downloader) {
+		d.webhook = webhook
+	}
 }
 
-// succeeded marks the job as successful
-func (j *downloadJob) succeeded() {
-	j.Success = true
-	j.err = nil
+// notify sends the event to the webhook in the background, if one is configured. Failures
+// are only logged so a flaky endpoint never stops the downloads.
+func (d *Downloader) notify(run RunInfo, event ItemEvent) {
+	if d.sender == nil {
+		return
+	}
+
+	event.Time = time.Now()
+
+	if d.batcher != nil {
+		d.batcher.add(event)
+		return
+	}
+
+	d.sender.send(WebhookData{Item: event, Run: run})
 }
 
-// workers will pull jobs off of the jobs channel and send the results to the results channel.
-// TODO: Add in exponential backoff for retries. Helpful for longer downloads
-func worker(id int, jobs <-chan downloadJob, results chan<- downloadJob, browserCtx AuthorizedBandcampContext) {
-	for job := range jobs {
-		// TODO: Set this to use the job timeoutMs
-		jobCtx, cancel := context.WithTimeout(context.Background(), time.Minute*4)
-		jobErr := make(chan error, 1)
-		go func() {
-			jobErr <- processJob(job, browserCtx)
-			cancel()
-		}()
+// notifySkipped sends the items a run found downloaded already as a single digest, rather
+// than a message for every item of the collection. Digests are split up as
+// WebhookBatching.MaxItems asks.
+func (d *Downloader) notifySkipped(run RunInfo, events []ItemEvent) {
+	if d.sender == nil {
+		return
+	}
 
-		select {
-		case <-jobCtx.Done():
-			job.failed(fmt.Errorf("%s timed out", job.Entry.title))
-			results <- job
-		case err := <-jobErr:
-			if err != nil {
-				job.failed(err)
-				results <- job
-			} else {
-				job.succeeded()
-				results <- job
-			}
-		}
+	now := time.Now()
+
+	for i := range events {
+		events[i].Time = now
+	}
+
+	size := len(events)
+
+	if d.batching != nil && d.batching.MaxItems > 0 {
+		size = d.batching.MaxItems
+	}
+
+	for len(events) > 0 {
+		n := min(size, len(events))
+		d.sender.send(digest(run, events[:n]))
+		events = events[n:]
 	}
 }
 
-// processJob does the heavy lifting of going to the URL for an album and managing the download process.
-func processJob(job downloadJob, browserCtx AuthorizedBandcampContext) error {
-	page, err := browserCtx.NewCollectionEntryPage(job.Entry)
+// itemEvent builds the webhook event for an item.
+func itemEvent(event string, item Item) ItemEvent {
+	e := ItemEvent{
+		Event:           event,
+		ID:              item.ID,
+		Title:           item.Title,
+		Artist:          item.Artist,
+		URL:             item.URL,
+		FileType:        item.FileType,
+		Path:            item.Path,
+		Bytes:           item.Bytes,
+		DurationSeconds: item.Duration.Seconds(),
+		Gifter:          item.Gifter,
+	}
 
-	if err != nil {
-		return fmt.Errorf("Could not create page: %w", err)
+	if item.Err != nil {
+		e.Error = item.Err.Error()
 	}
 
-	defer page.Close()
+	return e
+}
 
-	_, err = page.Goto()
+// jobEvent builds the webhook event for a finished job.
+func jobEvent(job downloadJob) ItemEvent {
+	event := itemEvent("success", job.item())
 
-	if err != nil {
-		return fmt.Errorf("Could not goto %s: %w", job.Entry.url.String(), err)
+	if !job.Success {
+		event.Event = "failure"
 	}
 
-	// Download the specific format
-	err = page.SelectFileType(job.filetype)
+	if job.saved.existing {
+		event.Event = "skip"
+	}
 
-	if err != nil {
-		return fmt.Errorf("Could not select file type %s: %w", job.filetype, err)
+	if errors.Is(job.err, ErrCancelled) {
+		event.Event = "cancel"
 	}
 
-	// Download the page
-	var timeout float64 = job.timeoutMs
+	if errors.Is(job.err, ErrRegionLocked) {
+		event.Event = "region-locked"
+	}
 
-	err = page.DownloadFile(job.DownloadDir, timeout)
+	return event
+}
 
-	if err != nil {
-		return fmt.Errorf("Could not download file: %w", err)
+// WithArtwork saves the album artwork next to each download using the provided options.
+func WithArtwork(opts ArtworkOptions) func(*Downloader) {
+	return func(d *Downloader) {
+		d.artwork = &opts
 	}
+}
 
-	return nil
+// AutoExtractOptions controls how archives are unpacked by WithAutoExtract.
+type AutoExtractOptions struct {
+	ExtractOptions
+	// DeleteArchive removes the zip once it was extracted
+	DeleteArchive bool
+	// RunPlaylist, if set, is the name of an M3U8 playlist in the library that lists every
+	// album extracted during the run, e.g. "New downloads.m3u8"
+	RunPlaylist string
+	// Beets, if set, imports the albums extracted during the run into beets once it is done
+	Beets *BeetsOptions
 }
 
-type fileFunc func(name string)
+// WithAutoExtract unpacks every archive into an Artist/Album directory of the library right
+// after it was downloaded, the same way ExtractLibrary does. A failed extraction is logged
+// and leaves the archive in place. It needs a library on this machine, see WithStorage.
+func WithAutoExtract(opts AutoExtractOptions) func(*Downloader) {
+	return func(d *Downloader) {
+		d.extract = &opts
+	}
+}
 
-// DownloadOpts provides a list of callbacks and a Filter value to track
-// the status of the download process.
-type DownloadOpts struct {
-	OnStart   fileFunc
-	OnSuccess fileFunc
-	OnFailure fileFunc
-	Filter    string
+// WithFilenamePolicy sets how downloaded file names are normalized.
+func WithFilenamePolicy(policy FilenamePolicy) func(*Downloader) {
+	return func(d *Downloader) {
+		d.names = policy
+	}
 }
 
-// Download is the workhorse responsible for saving all of the albums in the collection
-// to a directory on local the machine.
+// WithPathTemplate saves downloads under a path built from the template instead of the
+// name the browser suggests. Archives extracted afterwards end up next to them.
+func WithPathTemplate(template PathTemplate) func(*Downloader) {
+	return func(d *Downloader) {
+		d.template = template
+	}
+}
+
+// WithTimeWindow only starts downloads while the local time is inside of the window,
+// e.g. overnight on a metered connection. The queue waits while outside of it.
+func WithTimeWindow(window TimeWindow) func(*Downloader) {
+	return func(d *Downloader) {
+		d.window = &window
+	}
+}
+
+// WithHistoryStore replaces the history file in the .bcdl directory with another store,
+// such as the embedding application's own database.
+func WithHistoryStore(store HistoryStore) func(*Downloader) {
+	return func(d *Downloader) {
+		d.history = store
+	}
+}
+
+// WithStorage delivers downloads to the storage instead of saving them into the
+// output directory. The output directory is still used for bcdl's own state.
+func WithStorage(storage Storage) func(*Downloader) {
+	return func(d *Downloader) {
+		d.storage = storage
+	}
+}
+
+// FormatTarget sends one file type to its own directory.
+type FormatTarget struct {
+	FileType FileType
+	Dir      string
+	// MinFree is kept free in Dir, downloads that would take more are deferred. Zero
+	// keeps what WithMinFree sets
+	MinFree int64
+}
+
+// WithFormatTargets downloads every album once per target, e.g. FLAC into an archive
+// and MP3 320 into a folder synced to a phone, in a single run. Each target keeps its
+// own history, so a format is only skipped where it has already been delivered.
 //
-// In addition to the zip files, the method creates a hidden .bcdl folder to track
-// files to make the tool more useful.
-func (d *Downloader) Download(opts DownloadOpts) error {
-	outDir := d.dirPath
-	bcdlDir := filepath.Join(outDir, ".bcdl")
+// The targets replace the Downloader's own directory and file type.
+func WithFormatTargets(targets ...FormatTarget) func(*Downloader) {
+	return func(d *Downloader) {
+		d.targets = targets
+	}
+}
 
-	// Downloads will go here
-	if err := os.Mkdir(outDir, 0o777); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("Could not create output dir %v", err)
+// WithTimings records how long each phase of every download takes. The results are
+// available from Timings once Download returns.
+func WithTimings() func(*Downloader) {
+	return func(d *Downloader) {
+		d.timings = newTimings()
 	}
+}
 
-	// Track download history to avoid repeats
-	if err := os.Mkdir(bcdlDir, 0o777); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("Could not create output dir %v", err)
+// Timings returns the percentiles of every phase recorded with WithTimings,
+// or nil if timings are not enabled.
+func (d *Downloader) Timings() TimingReport {
+	return d.timings.report()
+}
+
+// WithDryRun finds what would be downloaded without downloading anything or touching the
+// library. Items already in the history are reported through OnSkip and the rest through
+// OnPlanned. Plan does the same and returns what it found.
+func WithDryRun() func(*Downloader) {
+	return func(d *Downloader) {
+		d.dryRun = true
+	}
+}
+
+// WithRetryFailed only downloads the items that failed in earlier runs. Failures are
+// kept in the .bcdl directory until the item downloads successfully.
+func WithRetryFailed() func(*Downloader) {
+	return func(d *Downloader) {
+		d.retry = true
 	}
+}
 
-	// Create an append only file
-	// TODO: Add history tracking so we repeatedly run and skip downloads
-	// file, err := os.OpenFile(filepath.Join(wd, "out", ".bcdl", "downloaded"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+// WithWatch keeps Download running after the collection was downloaded and checks for new
+// purchases every interval. New purchases go to the front of the queue, so they show up
+// within minutes even while a large backfill is still in progress.
+func WithWatch(interval time.Duration) func(*Downloader) {
+	return func(d *Downloader) {
+		d.watch = interval
+	}
+}
 
-	// Install browsers & run
-	err := playwright.Install()
-	if err != nil {
-		return fmt.Errorf("Could not install playwright: %v", err)
+// WithBundles detects items bought together from one artist, such as discography deals.
+// Their downloads are grouped together, reported through OnBundle and spaced out.
+func WithBundles(opts BundleOptions) func(*Downloader) {
+	return func(d *Downloader) {
+		d.bundles = &opts
 	}
-	pw, err := playwright.Run()
-	if err != nil {
-		return fmt.Errorf("could not start playwright: %v", err)
+}
+
+// Pause stops the Downloader from starting any new jobs. Downloads that are
+// already in flight are allowed to finish.
+func (d *Downloader) Pause() {
+	d.gate.pause()
+}
+
+// Resume lets a paused Downloader start jobs again.
+func (d *Downloader) Resume() {
+	d.gate.resume()
+}
+
+// Paused reports whether the Downloader is currently paused.
+func (d *Downloader) Paused() bool {
+	return d.gate.isPaused()
+}
+
+// current returns the run in progress or ErrNotQueued if nothing is downloading.
+func (d *Downloader) current() (*activeRun, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.run == nil {
+		return nil, ErrNotQueued
 	}
-	browser, err := pw.Chromium.Launch(playwright.BrowserTypeLaunchOptions{
-		Headless: playwright.Bool(d.headless),
-	})
+
+	return d.run, nil
+}
+
+// setRun records the run in progress. Passing nil clears it.
+func (d *Downloader) setRun(run *activeRun) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.run = run
+}
+
+// Prioritize moves the queued album with the key, see Item.Key, to the front of the queue so
+// it is the next one downloaded. It returns ErrNotQueued if the album has already started or
+// is not part of the run.
+func (d *Downloader) Prioritize(key string) error {
+	run, err := d.current()
 
 	if err != nil {
-		return fmt.Errorf("could not launch browser: %v", err)
+		return err
 	}
 
-	context, err := NewAuthorizedBandcampContext(browser, d.user.identity)
+	return run.queue.prioritize(key)
+}
+
+// Request puts the item of the collection at link, its album or track page, in front of
+// the run in progress, so an item someone asked for doesn't wait for a long backfill.
+// Items that are queued already move to the front. It returns ErrNotQueued if the item
+// already started, was downloaded before or nothing is downloading, and
+// ErrNotInCollection if the collection of the run doesn't have it.
+func (d *Downloader) Request(link string) error {
+	run, err := d.current()
 
 	if err != nil {
-		return fmt.Errorf("could not create context: %v", err)
+		return err
 	}
 
-	page, err := context.NewCollectionPage(d.user.username)
+	entry, ok := run.find(link)
 
-	if err != nil {
-		return fmt.Errorf("could not create page: %v", err)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotInCollection, link)
 	}
 
-	// Go to the users collection
-	if _, err = page.Goto(); err != nil {
-		return fmt.Errorf("could not goto: %v", err)
+	if err := run.queue.prioritize(entry.key()); err == nil {
+		return nil
 	}
 
-	// Get all entries in the collection
-	entries, err := page.GetCollection(opts.Filter)
+	if !run.enqueue(entry) {
+		return ErrNotQueued
+	}
+
+	return nil
+}
+
+// Cancel removes the queued album with the key, see Item.Key, from the run. It is reported
+// through OnCancel. Albums that have already started cannot be cancelled and return
+// ErrNotQueued.
+func (d *Downloader) Cancel(key string) error {
+	run, err := d.current()
 
 	if err != nil {
-		return fmt.Errorf("Could not get your collection. Check that you have the correct identity cookie value")
+		return err
 	}
 
-	// Set up jobs
-	jobs := make(chan downloadJob, len(entries))
-	results := make(chan downloadJob, len(entries))
+	job, err := run.queue.remove(key)
 
-	// Limit jobs to 3. This seems to be the sweet spot
-	for w := 0; w < 3; w++ {
-		go worker(w, jobs, results, context)
+	if err != nil {
+		return err
 	}
 
-	// Get the album name and every download link
-	for _, entry := range entries {
-		opts.OnStart(entry.title)
-		// Enqueue those jobs
-		jobs <- downloadJob{
-			Entry:       entry,
-			DownloadDir: outDir,
-			filetype:    d.filetype,
+	job.failed(ErrCancelled)
+	run.results <- job
 
-			// TODO: Make configurable!
-			timeoutMs: 240_000,
-		}
+	return nil
+}
+
+// DefaultDownloader creates a Downloader with sensible defaults.
+//
+// Defaults:
+//   - context: Background
+//   - timeout: 4 minutes
+//   - stall timeout: 2 minutes
+//   - retries: 2
+//   - retry passes: 1
+//   - keep alive: 15 minutes
+//   - concurrency: 3
+//   - filetype: MP3_320
+func DefaultDownloader(user *User, dirPath string) (*Downloader, error) {
+	return NewDownloader(user, dirPath,
+		WithContext(context.Background()),
+		WithTimeout(4*time.Minute),
+		WithStallTimeout(DefaultStallTimeout),
+		WithRetries(2),
+		WithRetryPasses(1),
+		WithKeepAlive(DefaultKeepAlive),
+		WithFiletype(MP3_320),
+	)
+}
+
+// downloadJob is used for processing a download request
+type downloadJob struct {
+	Entry    CollectionEntry
+	bundle   int
+	limiter  *bundleLimiter
+	err      error
+	Success  bool
+	library  *library
+	history  HistoryStore
+	failures *failedJobs
+	saved    savedFile
+	// duration is how long the job took, from leaving the queue to being saved
+	duration  time.Duration
+	timings   *timings
+	artwork   *ArtworkOptions
+	extract   *AutoExtractOptions
+	checksums bool
+	// dedupStore is set by WithDedupStore
+	dedupStore string
+	limits     transferLimits
+	// retry is set when the album failed to download before
+	retry     bool
+	engine    DownloadEngine
+	segments  int
+	filetype  FileType
+	timeoutMs float64
+	// post is set by WithPostWorkers
+	post *postPool
+	// transferring is set by the worker to hear when the transfer started, see transferStarted
+	transferring func()
+	// attempt counts the retries of the job so far, up to retries, see retryLater. It
+	// isn't handed out by the queue before notBefore
+	attempt   int
+	retries   int
+	notBefore time.Time
+	// journal keeps track of the job in case the run has to be resumed
+	journal *runJournal
+}
+
+// failed marks the job as failed and sets the error
+func (j *downloadJob) failed(err error) {
+	j.Success = false
+	j.err = err
+}
+
+// succeeded marks the job as successful
+func (j *downloadJob) succeeded() {
+	j.Success = true
+	j.err = nil
+}
+
+// item describes the job for callbacks and events.
+func (j downloadJob) item() Item {
+	item := entryItem(j.Entry, j.filetype)
+	item.Dir = j.library.dir
+	item.Path = j.saved.name
+	item.Bytes = j.saved.bytes
+	item.Duration = j.duration
+	item.Existing = j.saved.existing
+	item.Err = j.err
+
+	if j.saved.artist != "" {
+		item.Artist = j.saved.artist
 	}
 
-	for i := 0; i < len(entries); i++ {
-		job := <-results
-		if job.Success {
-			opts.OnSuccess(job.Entry.title)
+	return item
+}
+
+// workers will pull jobs off of the job queue and send the results to the results channel.
+// Without a browser session jobs are downloaded over HTTP as user, see EngineHTTP. Once
+// ctx is done the jobs they run or pick up are reported as cancelled.
+func worker(ctx context.Context, id int, jobs *jobQueue, results chan<- downloadJob, session *browserSession, user *User, opts DownloadOpts, sleep *sleepWatcher, keeper *sessionKeeper, limit *concurrencyLimit, gates []*pauseGate) {
+	for {
+		// Leave jobs in the queue while paused so they can still be reordered or cancelled
+		for _, gate := range gates {
+			gate.wait()
+		}
+
+		limit.acquire()
+		job, ok := jobs.pop()
+
+		if !ok {
+			limit.release()
+			return
+		}
+
+		// A gate may have closed while the worker waited for a job, wait for it with the job
+		// back in the queue
+		if anyPaused(gates) {
+			limit.release()
+			jobs.pushFront(job)
+			continue
+		}
+
+		if ctx.Err() != nil {
+			limit.release()
+			job.failed(ErrCancelled)
+			results <- job
+			continue
+		}
+
+		// Don't open the download pages of a target that is full
+		if err := job.library.checkRoom(); err != nil {
+			limit.release()
+			job.failed(err)
+			results <- job
+			continue
+		}
+
+		job.journal.update(job, jobRunning)
+
+		if job.limiter != nil {
+			job.limiter.wait(job.bundle)
+		}
+
+		var browserCtx AuthorizedBandcampContext
+		gen := 0
+
+		if session != nil {
+			browserCtx, gen = session.current()
+		}
+
+		start := time.Now()
+		// The job timeout bounds opening the download page, choosing the format and waiting
+		// for Bandcamp to prepare it, and stops once the transfer begins. The transfer itself
+		// is bounded by WithTransferTimeout and WithStallTimeout
+		jobCtx, cancel := context.WithCancelCause(ctx)
+		startTimer := time.AfterFunc(time.Duration(job.timeoutMs)*time.Millisecond, func() { cancel(errJobTimeout) })
+		job.transferring = func() { startTimer.Stop() }
+
+		// Timing out closes the page and cancels the requests of the job, which is waited
+		// for so a retry never runs alongside the attempt it replaces
+		var out jobOutcome
+
+		if session != nil {
+			out.saved, out.err = processJob(jobCtx, job, browserCtx, opts)
 		} else {
-			opts.OnFailure(job.Entry.title)
+			out.saved, out.err = processHTTPJob(jobCtx, job, user, opts)
 		}
-	}
 
-	close(jobs)
-	close(results)
+		startTimer.Stop()
+		timedOut := out.err != nil && errors.Is(context.Cause(jobCtx), errJobTimeout)
+		cancel(nil)
 
-	if err = browser.Close(); err != nil {
-		return fmt.Errorf("could not close browser: %v", err)
-	}
-	if err = pw.Stop(); err != nil {
-		return fmt.Errorf("could not stop Playwright: %v", err)
+		limit.release()
+
+		if timedOut {
+			if ctx.Err() != nil {
+				job.duration = time.Since(start)
+				job.failed(ErrCancelled)
+				results <- job
+				continue
+			}
+
+			if session.lost(gen) || sleep.interrupted(start) || keeper.interrupted() {
+				jobs.pushFront(job)
+				continue
+			}
+
+			if job.retryLater(jobs, errJobTimeout) {
+				continue
+			}
+
+			job.duration = time.Since(start)
+			job.failed(fmt.Errorf("%s %w", job.Entry.title, errJobTimeout))
+			results <- job
+			continue
+		}
+
+		if out.err != nil && ctx.Err() != nil {
+			job.duration = time.Since(start)
+			job.failed(ErrCancelled)
+			results <- job
+			continue
+		}
+
+		// Interrupted by the remote browser going away, the machine sleeping or the
+		// session being signed out, try again once it is back
+		if out.err != nil && (session.lost(gen) || sleep.interrupted(start) || keeper.interrupted()) {
+			jobs.pushFront(job)
+			continue
+		}
+
+		if out.err != nil && job.retryLater(jobs, out.err) {
+			continue
+		}
+
+		if out.err == nil && out.saved.post != nil {
+			job.post.submit(job, start, out.saved.post)
+			continue
+		}
+
+		job.duration = time.Since(start)
+		job.saved = out.saved
+
+		if out.err != nil {
+			job.failed(out.err)
+		} else {
+			job.succeeded()
+		}
+
+		results <- job
 	}
+}
 
-	return nil
+// savedFile describes a finished download.
+type savedFile struct {
+	// name is where the download was saved, relative to the library
+	name   string
+	artist string
+	bytes  int64
+	// existing is set when the file was already on disk and nothing was downloaded
+	existing bool
+	sha256   string
+	// post is the rest of the job, set when it is left to the post-processing workers
+	post func() (savedFile, error)
+}
+
+// jobOutcome is what processJob hands back to its worker.
+type jobOutcome struct {
+	saved savedFile
+	err   error
+}
+
+// processJob does the heavy lifting of going to the URL for an album and managing the download process.
+// It returns what was saved, which has the artist filled in as soon as the page was read.
+// The page is closed once ctx is done, which stops it.
+func processJob(ctx context.Context, job downloadJob, browserCtx AuthorizedBandcampContext, opts DownloadOpts) (savedFile, error) {
+	var saved savedFile
+
+	page, err := browserCtx.NewCollectionEntryPage(job.Entry)
+
+	if err != nil {
+		return saved, fmt.Errorf("Could not create page: %w", err)
+	}
+
+	defer page.Close()
+	defer closeOnCancel(ctx, page)()
+
+	start := time.Now()
+	_, err = page.Goto()
+
+	if err != nil {
+		return saved, fmt.Errorf("Could not goto %s: %w", job.Entry.url.String(), err)
+	}
+
+	job.timings.since(PhaseNavigate, start)
+	start = time.Now()
+
+	page.DismissOverlays()
+
+	data := PathData{Album: job.Entry.title, Artist: job.Entry.artist, Purchased: job.Entry.purchased, FileType: job.filetype, Gift: job.Entry.gift, Gifter: job.Entry.gifter}
+
+	var sizes map[FileType]int64
+
+	if info, err := page.ItemInfo(); err == nil {
+		data.Artist = info.Artist
+		data.Released = info.Released
+		saved.artist = info.Artist
+		sizes = info.Sizes
+	} else if job.library.template != "" {
+		log.Printf("Could not read the details of %s for its path: %v", job.Entry.title, err)
+	}
+
+	if saved, ok := job.onDisk(data, sizes, saved); ok {
+		return saved, nil
+	}
+
+	// Rather fail now than with a full disk halfway through the transfer
+	if size, ok := sizes[job.filetype]; ok {
+		release, err := job.library.reserveSpace(size)
+
+		if err != nil {
+			return saved, err
+		}
+
+		defer release()
+	}
+
+	// Download the specific format. Overlays can show up after the page loads,
+	// so dismiss them again before giving up.
+	err = page.SelectFileType(job.filetype)
+
+	if err != nil {
+		page.DismissOverlays()
+		err = page.SelectFileType(job.filetype)
+	}
+
+	if err != nil {
+		// The format selector is missing entirely on region locked items
+		if page.RegionLocked() {
+			return saved, ErrRegionLocked
+		}
+
+		return saved, fmt.Errorf("Could not select file type %s: %w", job.filetype, err)
+	}
+
+	job.timings.since(PhaseSelect, start)
+
+	var timeout float64 = job.timeoutMs
+
+	// Bandcamp builds the archive on their end before the link becomes usable
+	opts.OnPrepareStart.call(job.item())
+	start = time.Now()
+	err = page.WaitForPrepared(timeout)
+
+	if err != nil {
+		if page.RegionLocked() {
+			return saved, ErrRegionLocked
+		}
+
+		return saved, fmt.Errorf("Could not prepare download: %w", err)
+	}
+
+	job.timings.since(PhasePrepare, start)
+	opts.OnPrepareDone.call(job.item())
+
+	// Preparing can take minutes, long enough for a dialog to appear over the link
+	page.DismissOverlays()
+
+	if job.engine == EngineHybrid {
+		link, err := page.DownloadLink()
+
+		if err != nil {
+			return saved, err
+		}
+
+		cookies, err := browserCtx.Cookies(link)
+
+		if err != nil {
+			return saved, fmt.Errorf("Could not read the cookies of the browser: %w", err)
+		}
+
+		return job.transfer(ctx, link, cookies, data, saved, opts)
+	}
+
+	// Download the page
+	start = time.Now()
+	dl, err := page.StartDownload(timeout)
+
+	if err != nil {
+		return saved, err
+	}
+
+	job.transferStarted()
+
+	// Wait for the transfer separately so it isn't counted as saving, and can be given up on
+	if err := job.waitTransfer(dl); err != nil {
+		return saved, fmt.Errorf("Could not download file: %w", err)
+	}
+
+	job.timings.since(PhaseTransfer, start)
+
+	start = time.Now()
+	name, err := job.library.save(dl, data)
+
+	if err != nil {
+		return saved, fmt.Errorf("Could not download file: %w", err)
+	}
+
+	job.timings.since(PhaseSave, start)
+
+	return job.afterSave(dl, name, saved), nil
+}
+
+// onDisk looks for a copy of the download that is already in the library and doesn't have
+// to be downloaded again.
+func (job downloadJob) onDisk(data PathData, sizes map[FileType]int64, saved savedFile) (savedFile, bool) {
+	if data.Artist == "" {
+		return saved, false
+	}
+
+	var name string
+	var ok bool
+
+	// Don't wait for Bandcamp to prepare something that is already on disk
+	if job.library.existing == ExistingSkip {
+		name, ok = job.library.existingFile(data)
+	}
+
+	// A timed out attempt may have saved the whole file just as it was given up on
+	if !ok && job.retry {
+		name, ok = job.library.completeFile(data, sizes[job.filetype])
+	}
+
+	if !ok {
+		return saved, false
+	}
+
+	saved.name = name
+	saved.existing = true
+
+	if job.checksums {
+		saved.sha256 = job.recordChecksum(nil, name, false)
+	}
+
+	return saved, true
+}
+
+// finish records what was saved as name and hands it to what comes after downloading:
+// checksums, extracting and artwork. Those failing doesn't fail the download.
+func (job downloadJob) finish(dl fetchedFile, name string, saved savedFile) savedFile {
+	saved.name = name
+
+	// Playwright keeps its copy of the download until the browser closes. A remote browser
+	// keeps it on its own machine, so go by the saved file instead
+	if path, err := dl.Path(); err == nil {
+		if info, err := os.Stat(path); err == nil {
+			saved.bytes = info.Size()
+		}
+	} else if local, ok := job.library.storage.(*LocalStorage); ok {
+		if info, err := os.Stat(local.Path(name)); err == nil {
+			saved.bytes = info.Size()
+		}
+	}
+
+	archive := strings.EqualFold(path.Ext(name), ".zip")
+	deleted := archive && job.extract != nil && job.extract.DeleteArchive
+
+	if job.checksums {
+		saved.sha256 = job.recordChecksum(dl, name, deleted)
+	}
+
+	// An archive about to be deleted isn't worth keeping in the store
+	if job.dedupStore != "" && !deleted {
+		job.deduplicate(dl, name, saved.sha256)
+	}
+
+	// The album downloaded fine even if it can't be extracted
+	var albumDir string
+
+	if job.library.template.albumDirectories() {
+		albumDir = path.Dir(name)
+	}
+
+	if job.extract != nil && strings.EqualFold(path.Ext(name), ".zip") {
+		var err error
+
+		if albumDir, err = job.library.extractArchive(name, job.Entry.title, *job.extract); err != nil {
+			log.Printf("Could not extract %s: %v", job.Entry.title, err)
+		} else {
+			if job.library.playlist != nil {
+				if err := job.library.playlist.add(job.library.workDir(), job.library.dir, albumDir); err != nil {
+					log.Printf("Could not add %s to the playlist: %v", job.Entry.title, err)
+				}
+			}
+
+			if job.library.beets != nil {
+				job.library.beets.add(job.library.dir, albumDir)
+			}
+		}
+	}
+
+	// Missing artwork shouldn't fail it either. Extracting first lets the cover go into the album directory
+	if job.artwork != nil {
+		stem, artistStem := artworkStems(name, albumDir, *job.artwork)
+
+		if err := saveArtwork(job.library.storage, job.Entry, stem, artistStem, *job.artwork); err != nil {
+			log.Printf("Could not save artwork for %s: %v", job.Entry.title, err)
+		}
+	}
+
+	return saved
+}
+
+// Item describes the album a callback is about. What isn't known yet is left empty, e.g.
+// the artist before the album's page was read and Path before it was saved.
+type Item struct {
+	// ID is Bandcamp's id of the item, see CollectionEntry.ID
+	ID       string
+	Title    string
+	Artist   string
+	URL      string
+	FileType FileType
+	// Dir is the library the item is downloaded into and Path where the download was
+	// saved, relative to it
+	Dir   string
+	Path  string
+	Bytes int64
+	// Duration is how long the download took, including the wait for Bandcamp to prepare it
+	Duration time.Duration
+	// Existing is set when the file was already in the library, so nothing was downloaded
+	Existing bool
+	// Gifter is who gave the item as a gift, see CollectionEntry.Gifter
+	Gifter string
+	Err    error
+}
+
+// Key identifies the item within a run, e.g. for Prioritize: the id Bandcamp gave it, or
+// its title for items without one.
+func (i Item) Key() string {
+	if i.ID != "" {
+		return i.ID
+	}
+
+	return i.Title
+}
+
+// entryItem describes a collection entry that is about to be downloaded as ft.
+func entryItem(entry CollectionEntry, ft FileType) Item {
+	return Item{ID: entry.id, Title: entry.title, Artist: entry.artist, URL: entry.url.String(), FileType: ft, Gifter: entry.gifter}
+}
+
+type itemFunc func(item Item)
+
+// call invokes the callback if one was provided.
+func (f itemFunc) call(item Item) {
+	if f != nil {
+		f(item)
+	}
+}
+
+// DownloadOpts provides a list of callbacks and a Filter value to track
+// the status of the download process.
+//
+// OnPrepareStart and OnPrepareDone bracket the time Bandcamp spends building
+// the archive, which can take minutes for lossless formats. They are called
+// from the worker goroutines.
+//
+// OnBundle is called for every group of items bought together when bundle
+// detection is enabled with WithBundles.
+//
+// OnRegionLocked is called instead of OnFailure for items Bandcamp won't serve in the
+// current region. Without it they are reported as failures.
+//
+// OnDeferred is called instead of OnFailure for items that don't fit into the space
+// left in their directory, see WithMinFree. Without it they are reported as failures.
+//
+// OnShared is called instead of downloading items another account of a shared library
+// already downloaded, with the name of that account. They are recorded in the history
+// of this one too. Without it they are reported through OnSkip.
+//
+// OnPlanned is called instead of downloading when WithDryRun is set.
+//
+// OnEstimate is called once it is known what will be downloaded, with a rough estimate
+// of the total size in bytes.
+//
+// OnIdentityRefresh is called when Bandcamp hands out a new identity cookie during
+// the run, so it can be saved for the next one.
+//
+// OnProgress is called about once a second while a file is transferred by the http or
+// hybrid engine, with the bytes received so far and the size of the file, or -1 when it
+// isn't known. The browser doesn't report progress.
+//
+// OnCheckpoint is called with the progress of the run at its checkpoints, see
+// WithCheckpoints.
+//
+// OnSignedOut is called when Bandcamp signs the session out during the run, see
+// WithKeepAlive. The remaining downloads wait until it is accepted again.
+type DownloadOpts struct {
+	OnBundle          func(Bundle)
+	OnStart           itemFunc
+	OnSkip            itemFunc
+	OnPrepareStart    itemFunc
+	OnPrepareDone     itemFunc
+	OnSuccess         itemFunc
+	OnFailure         itemFunc
+	OnCancel          itemFunc
+	OnRegionLocked    itemFunc
+	OnDeferred        itemFunc
+	OnShared          func(item Item, account string)
+	OnPlanned         itemFunc
+	OnEstimate        func(bytes int64)
+	OnIdentityRefresh func(identity string, expires time.Time)
+	OnProgress        func(item Item, done, total int64)
+	OnCheckpoint      func(Checkpoint)
+	OnSignedOut       func(err error)
+	Filter            string
+}
+
+// historyKey returns what the history is searched for to find the entry in the file type.
+func historyKey(entry CollectionEntry, ft FileType) HistoryEntry {
+	return HistoryEntry{ItemID: entry.id, Title: entry.title, FileType: ft}
+}
+
+// failedKey returns what the failed downloads are searched for to find the entry in the file type.
+func (d *Downloader) failedKey(entry CollectionEntry, ft FileType) FailedJob {
+	return FailedJob{Username: d.user.username, ItemID: entry.id, Title: entry.title, FileType: ft}
+}
+
+// identityExpiryWarning is how close to expiring a refreshed identity has to be to warn about it.
+const identityExpiryWarning = 14 * 24 * time.Hour
+
+// openTarget prepares dir to receive downloads and loads its history.
+func (d *Downloader) openTarget(dir string) (*library, HistoryStore, error) {
+	// Track download history to avoid repeats
+	lib := newLibrary(dir, d.user, d.shared)
+
+	// Downloads will go here, a dry run leaves the file system alone
+	if !d.dryRun {
+		if err := os.Mkdir(dir, 0o777); err != nil && !os.IsExist(err) {
+			return nil, nil, fmt.Errorf("Could not create output dir %v", err)
+		}
+
+		if err := lib.create(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	lib.filenames = d.names
+	lib.template = d.template
+	lib.existing = d.existing
+
+	if d.storage != nil {
+		lib.storage = d.storage
+	}
+
+	// Record of everything downloaded so repeated runs skip them
+	if d.history != nil {
+		return lib, d.history, nil
+	}
+
+	history, err := LoadHistory(filepath.Join(lib.stateDir, "history.jsonl"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lib, history, nil
+}
+
+// formatTargets returns where every format goes, which is the file type and directory of
+// the Downloader unless WithFormatTargets was used.
+func (d *Downloader) formatTargets() []FormatTarget {
+	if len(d.targets) == 0 {
+		return []FormatTarget{{FileType: d.filetype, Dir: d.dirPath, MinFree: d.minFree}}
+	}
+
+	targets := slices.Clone(d.targets)
+
+	for i := range targets {
+		if targets[i].MinFree == 0 {
+			targets[i].MinFree = d.minFree
+		}
+	}
+
+	return targets
+}
+
+// Download is the workhorse responsible for saving all of the albums in the collection
+// to a directory on local the machine.
+//
+// In addition to the zip files, the method creates a hidden .bcdl folder to track
+// files to make the tool more useful. Albums the user already downloaded in the same
+// file type are skipped and reported through OnSkip.
+//
+// The run start and end hooks, see WithHooks, run before and after everything else.
+func (d *Downloader) Download(opts DownloadOpts) error {
+	if err := d.runHook(HookRunStart, nil); err != nil {
+		return err
+	}
+
+	err := d.download(opts)
+
+	if hookErr := d.runHook(HookRunEnd, err); hookErr != nil {
+		log.Println(hookErr)
+	}
+
+	return err
+}
+
+// download does the work of Download.
+func (d *Downloader) download(opts DownloadOpts) error {
+	targets := d.formatTargets()
+
+	run := RunInfo{
+		Username:  d.user.username,
+		Directory: targets[0].Dir,
+		FileType:  targets[0].FileType,
+		StartedAt: time.Now(),
+	}
+
+	if d.webhook != nil && !d.dryRun {
+		d.sender = newWebhookSender(d.webhook)
+
+		defer func() {
+			d.sender.close()
+			d.sender = nil
+		}()
+	}
+
+	if d.webhook != nil && d.batching != nil && !d.dryRun {
+		d.batcher = newWebhookBatcher(d.webhook, *d.batching, run)
+
+		defer func() {
+			d.batcher.close()
+			d.batcher = nil
+		}()
+	}
+
+	if d.artwork != nil && !d.dryRun {
+		if err := artworkCache(d.artwork.Cache).prune(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	libs := make([]*library, len(targets))
+	histories := make([]HistoryStore, len(targets))
+	failures := make([]*failedJobs, len(targets))
+	// Targets in the same directory share their list of failures and extracted albums
+	failuresByDir := map[string]*failedJobs{}
+	extractedByDir := map[string]*extractedAlbums{}
+	spaceByDir := map[string]*spaceReservations{}
+	playlistByDir := map[string]*runPlaylist{}
+	// Imported in one go, so beets can tell apart albums of the same run
+	var imports *beetsImport
+
+	if d.extract != nil && d.extract.Beets != nil {
+		imports = &beetsImport{}
+	}
+
+	for i, target := range targets {
+		var err error
+
+		if libs[i], histories[i], err = d.openTarget(target.Dir); err != nil {
+			return err
+		}
+
+		if d.staged {
+			if d.incoming != "" && target.Dir != targets[0].Dir {
+				return errors.New("An incoming directory can only be used with one output directory")
+			}
+
+			if err = d.stage(libs[i]); err != nil {
+				return err
+			}
+		}
+
+		if spaceByDir[target.Dir] == nil {
+			spaceByDir[target.Dir] = &spaceReservations{}
+		}
+
+		libs[i].space = spaceByDir[target.Dir]
+		libs[i].space.keep = max(libs[i].space.keep, target.MinFree)
+
+		if failures[i] = failuresByDir[libs[i].stateDir]; failures[i] == nil {
+			if failures[i], err = loadFailedJobs(libs[i].stateDir); err != nil {
+				return err
+			}
+
+			failuresByDir[libs[i].stateDir] = failures[i]
+		}
+
+		if d.extract == nil {
+			continue
+		}
+
+		if _, local := libs[i].storage.(*LocalStorage); !local {
+			return errors.New("Archives can only be extracted into a library on this machine")
+		}
+
+		if libs[i].extracted = extractedByDir[target.Dir]; libs[i].extracted == nil {
+			if libs[i].extracted, err = loadExtractedAlbums(target.Dir); err != nil {
+				return err
+			}
+
+			extractedByDir[target.Dir] = libs[i].extracted
+		}
+
+		if d.extract.RunPlaylist != "" {
+			if libs[i].playlist = playlistByDir[target.Dir]; libs[i].playlist == nil {
+				libs[i].playlist = &runPlaylist{path: filepath.Join(target.Dir, d.extract.RunPlaylist)}
+				playlistByDir[target.Dir] = libs[i].playlist
+			}
+		}
+
+		libs[i].beets = imports
+	}
+
+	if d.cleanAge > 0 && !d.dryRun {
+		d.cleanLibraries(libs)
+	}
+
+	if err := d.context.Err(); err != nil {
+		return err
+	}
+
+	var collection []CollectionEntry
+	var pw *playwright.Playwright
+	var session *browserSession
+	var err error
+
+	switch {
+	case d.resume:
+		if collection, err = d.resumeCollection(libs[0].stateDir); err != nil || d.dryRun {
+			break
+		}
+
+		if d.engine != EngineHTTP {
+			pw, session, err = d.openBrowser(opts)
+		} else if err = d.checkSession(nil); err != nil {
+			err = d.signInFailed(err)
+		}
+	// A dry run only reads the collection, which the API does in seconds without a browser
+	case d.dryRun || d.engine == EngineHTTP:
+		collection, err = d.listCollection(opts.Filter)
+	default:
+		if pw, session, err = d.openBrowser(opts); err == nil {
+			if collection, err = d.browseCollection(session, opts.Filter); err != nil {
+				session.close()
+				pw.Stop()
+			}
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Remembered so the TUI can estimate library sizes on the next run
+	if opts.Filter == "" && !d.dryRun && !d.resume {
+		if err := saveCollectionSummary(libs[0].stateDir, d.user, len(collection)); err != nil {
+			log.Println(err)
+		}
+	}
+
+	var notes *ItemNotes
+
+	if d.labels != nil {
+		if notes, err = LoadItemNotes(libs[0].dir); err != nil {
+			return err
+		}
+
+		collection = d.labels.Filter(collection, notes)
+	}
+
+	// What every target has in other formats, to decide on upgrades
+	var formats []*downloadedFormats
+
+	if len(d.upgrades) > 0 {
+		formats = make([]*downloadedFormats, len(targets))
+
+		for i := range targets {
+			if formats[i], err = newDownloadedFormats(histories[i], d.user); err != nil {
+				return err
+			}
+		}
+	}
+
+	// What the other accounts of a shared library downloaded, which isn't downloaded again
+	copies := make([]*sharedCopies, len(targets))
+
+	if d.shared && d.history == nil {
+		for i := range targets {
+			if copies[i], err = loadSharedCopies(libs[i], d.user); err != nil {
+				return err
+			}
+		}
+	}
+
+	entries := make([]CollectionEntry, 0, len(collection))
+	// The targets each entry still has to be delivered to
+	pending := make(map[string][]int)
+	// Sent as one digest once everything was checked
+	var skipped []ItemEvent
+
+	for _, entry := range collection {
+		for i, target := range targets {
+			if d.retry && !failures[i].contains(d.failedKey(entry, target.FileType)) {
+				continue
+			}
+
+			downloaded, err := histories[i].Contains(d.user, historyKey(entry, target.FileType))
+
+			if err != nil {
+				return fmt.Errorf("Could not check history: %w", err)
+			}
+
+			if downloaded {
+				// Downloaded by a run that didn't know about the failure, e.g. into another target
+				if !d.dryRun {
+					if err := failures[i].resolve(d.failedKey(entry, target.FileType)); err != nil {
+						log.Println(err)
+					}
+				}
+
+				opts.OnSkip.call(entryItem(entry, target.FileType))
+				skipped = append(skipped, itemEvent("skip", entryItem(entry, target.FileType)))
+				continue
+			}
+
+			if d.recordShared(copies[i], histories[i], entry, target.FileType, opts) {
+				continue
+			}
+
+			if formats != nil {
+				download, upgraded := d.upgrade(formats[i], entry, target.FileType)
+
+				if !download {
+					opts.OnSkip.call(entryItem(entry, target.FileType))
+					skipped = append(skipped, itemEvent("skip", entryItem(entry, target.FileType)))
+					continue
+				}
+
+				if upgraded != nil {
+					log.Printf("Upgrading %s from %s, downloaded %s, to %s", entry.title, upgraded.FileType, upgraded.DownloadedAt.Format(time.DateOnly), target.FileType)
+				}
+			}
+
+			pending[entry.key()] = append(pending[entry.key()], i)
+		}
+
+		if len(pending[entry.key()]) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	d.notifySkipped(run, skipped)
+
+	var limiter *bundleLimiter
+	member := map[string]int{}
+
+	if d.bundles != nil {
+		bundles := detectBundles(entries, *d.bundles)
+		entries, member = groupBundles(entries, bundles)
+		limiter = newBundleLimiter(d.bundles.Delay)
+
+		for _, bundle := range bundles {
+			if opts.OnBundle != nil {
+				opts.OnBundle(bundle)
+			}
+		}
+	}
+
+	// Sizes are only known once each download page was opened, so go by typical album sizes
+	var estimate int64
+	estimates := map[*spaceReservations]int64{}
+
+	for _, entry := range entries {
+		for _, i := range pending[entry.key()] {
+			estimate += targets[i].FileType.TypicalAlbumSize()
+			estimates[libs[i].space] += targets[i].FileType.TypicalAlbumSize()
+		}
+	}
+
+	if opts.OnEstimate != nil {
+		opts.OnEstimate(estimate)
+	}
+
+	for i := range libs {
+		if size, ok := estimates[libs[i].space]; ok {
+			libs[i].warnLowSpace(size)
+			delete(estimates, libs[i].space)
+		}
+	}
+
+	if d.dryRun {
+		for _, entry := range entries {
+			for _, i := range pending[entry.key()] {
+				opts.OnPlanned.call(entryItem(entry, targets[i].FileType))
+			}
+		}
+
+		return nil
+	}
+
+	jobCount := 0
+	for _, entry := range entries {
+		jobCount += len(pending[entry.key()])
+	}
+
+	// Set up jobs
+	jobs := newJobQueue()
+	results := make(chan downloadJob, jobCount)
+
+	// Kept until the run went through it all, so a crashed run can be resumed
+	journal, err := createJournal(libs[0].stateDir, d.user)
+
+	if err != nil {
+		log.Printf("%v, the run can't be resumed", err)
+	}
+
+	var post *postPool
+
+	if d.postWorkers > 0 {
+		post = newPostPool(d.postWorkers, results)
+		defer post.close()
+	}
+
+	sleepDone := make(chan struct{})
+	defer close(sleepDone)
+
+	sleep := watchSleep(func() error { return d.checkSession(session) }, sleepDone)
+	gates := []*pauseGate{d.gate, sleep.gate}
+
+	if session != nil {
+		gates = append(gates, session.gate)
+	}
+
+	keeper := keepSessionAlive(d.keepAlive, func() error { return d.checkSession(session) }, func(err error) {
+		if opts.OnSignedOut != nil {
+			opts.OnSignedOut(err)
+		}
+
+		if hookErr := d.runHook(HookAuthFailure, err); hookErr != nil {
+			log.Println(hookErr)
+		}
+
+		d.notify(run, ItemEvent{Event: "signed-out", Title: "Signed out", Error: err.Error()})
+	}, sleepDone)
+
+	if keeper != nil {
+		gates = append(gates, keeper.gate)
+	}
+
+	if d.window != nil {
+		windowGate := newPauseGate()
+		done := make(chan struct{})
+
+		// Close the gate before any worker starts so nothing slips through outside of the window
+		if !d.window.Contains(time.Now()) {
+			windowGate.pause()
+			log.Printf("Outside of the transfer window %s, waiting for it to open", d.window)
+		}
+
+		defer close(done)
+
+		go enforceWindow(*d.window, windowGate, done)
+		gates = append(gates, windowGate)
+	}
+
+	var limit *concurrencyLimit
+
+	if d.adaptive {
+		limit = newConcurrencyLimit(d.concurrency)
+	}
+
+	// 3 jobs at a time seems to be the sweet spot, see WithConcurrency
+	for w := 0; w < d.concurrency; w++ {
+		go worker(d.context, w, jobs, results, session, d.user, opts, sleep, keeper, limit, gates)
+	}
+
+	newJob := func(entry CollectionEntry, i int) downloadJob {
+		return downloadJob{
+			Entry:      entry,
+			library:    libs[i],
+			history:    histories[i],
+			failures:   failures[i],
+			timings:    d.timings,
+			artwork:    d.artwork,
+			extract:    d.extract,
+			checksums:  d.checksums,
+			dedupStore: d.dedupStore,
+			limits:     d.limits,
+			engine:     d.engine,
+			segments:   d.segments,
+			post:       post,
+			retry:      failures[i].contains(d.failedKey(entry, targets[i].FileType)),
+			filetype:   targets[i].FileType,
+			timeoutMs:  float64(d.timeout.Milliseconds()),
+			retries:    d.retries,
+			journal:    journal,
+		}
+	}
+
+	// Get the album name and every download link
+	for _, entry := range entries {
+		for _, i := range pending[entry.key()] {
+			opts.OnStart.call(entryItem(entry, targets[i].FileType))
+			// Enqueue those jobs
+			bundle, bundled := member[entry.key()]
+			job := newJob(entry, i)
+
+			if bundled {
+				job.bundle = bundle
+				job.limiter = limiter
+			}
+
+			journal.plan(job)
+			jobs.push(job)
+		}
+	}
+
+	// Jobs for new purchases found while watching and for items asked for with Request,
+	// which the loop below has to wait for too until it is finished
+	found := make(chan int)
+	finished := make(chan struct{})
+	var plannedMu sync.Mutex
+	planned := make(map[string]bool, len(entries))
+
+	for _, entry := range entries {
+		planned[entry.key()] = true
+	}
+
+	// Counted before they are queued so their results can't arrive first
+	enqueue := func(entry CollectionEntry) bool {
+		plannedMu.Lock()
+		defer plannedMu.Unlock()
+
+		if planned[entry.key()] {
+			return false
+		}
+
+		var queued []downloadJob
+
+		for i, target := range targets {
+			if downloaded, err := histories[i].Contains(d.user, historyKey(entry, target.FileType)); err == nil && !downloaded && !d.recordShared(copies[i], histories[i], entry, target.FileType, opts) {
+				queued = append(queued, newJob(entry, i))
+			}
+		}
+
+		if len(queued) == 0 {
+			return false
+		}
+
+		select {
+		case found <- len(queued):
+		case <-finished:
+			return false
+		}
+
+		planned[entry.key()] = true
+
+		for _, job := range queued {
+			opts.OnStart.call(job.item())
+			journal.plan(job)
+			jobs.pushFront(job)
+		}
+
+		return true
+	}
+
+	d.setRun(&activeRun{queue: jobs, results: results, collection: collection, enqueue: enqueue})
+
+	if d.watch > 0 {
+		seen := make(map[string]bool, len(collection))
+
+		for _, entry := range collection {
+			seen[entry.key()] = true
+		}
+
+		recent := func() ([]CollectionEntry, error) {
+			return recentCollection(d.context, d.user)
+		}
+
+		if session != nil {
+			recent = func() ([]CollectionEntry, error) {
+				page, err := session.collectionPage(d.user.username)
+
+				if err != nil {
+					return nil, err
+				}
+
+				return page.RecentEntries()
+			}
+		}
+
+		if notes != nil {
+			unfiltered := recent
+			recent = func() ([]CollectionEntry, error) {
+				entries, err := unfiltered()
+
+				return d.labels.Filter(entries, notes), err
+			}
+		}
+
+		go watchPurchases(d.context, recent, d.watch, opts.Filter, seen, func(entry CollectionEntry) { enqueue(entry) })
+	}
+
+	outstanding := jobCount
+	progress := newCheckpointTracker(d.checkpoints, jobCount)
+	// Albums downloaded since the media server last scanned
+	fresh := 0
+
+	done := d.context.Done()
+
+	// Albums that failed, held back for the next pass, see WithRetryPasses
+	var held []downloadJob
+	pass := 0
+
+	for outstanding > 0 || len(held) > 0 || (d.watch > 0 && d.context.Err() == nil) {
+		var job downloadJob
+
+		// While watching, scan whenever the queue ran dry rather than once at the end
+		if outstanding == 0 && fresh > 0 {
+			d.promoteStaged(libs)
+			d.importIntoBeets(imports)
+			d.refreshMediaServer()
+
+			if d.cleanAge > 0 {
+				d.cleanLibraries(libs)
+			}
+
+			fresh = 0
+		}
+
+		// Once everything else was tried, the albums that failed go again
+		if outstanding == 0 && len(held) > 0 && d.context.Err() == nil {
+			pass++
+			log.Printf("Trying the %d albums that failed again, pass %d of %d", len(held), pass, d.retryPasses)
+
+			for _, job := range held {
+				job = job.nextPass()
+				journal.update(job, jobQueued)
+				jobs.push(job)
+			}
+
+			outstanding += len(held)
+			held = nil
+		}
+
+		select {
+		case <-done:
+			// Nothing else starts, the running jobs stop and report themselves cancelled
+			done = nil
+			cancelled := jobs.drain()
+			// Those waiting for the next pass are reported as they failed
+			failed := held
+			outstanding += len(held)
+			held = nil
+
+			go func() {
+				for _, job := range cancelled {
+					job.failed(ErrCancelled)
+					results <- job
+				}
+
+				for _, job := range failed {
+					results <- job
+				}
+			}()
+
+			continue
+		case n := <-found:
+			outstanding += n
+			progress.add(n)
+			continue
+		case job = <-results:
+			outstanding--
+		}
+
+		// Only counted once it failed for good
+		if !job.Success && pass < d.retryPasses && d.context.Err() == nil && job.passable() {
+			held = append(held, job)
+			continue
+		}
+
+		if checkpoint, ok := progress.finish(job); ok {
+			d.checkpoint(run, checkpoint, opts)
+		}
+
+		d.notify(run, jobEvent(job))
+
+		// Items that can't be downloaded say nothing about how the downloads are going
+		if job.Success {
+			limit.record(true)
+		} else if !errors.Is(job.err, ErrCancelled) && !errors.Is(job.err, ErrInsufficientSpace) && !errors.Is(job.err, ErrRegionLocked) {
+			limit.record(false)
+		}
+
+		if job.Success {
+			err := job.history.Add(HistoryEntry{
+				FanID:        d.user.fanID,
+				Username:     d.user.username,
+				ItemID:       job.Entry.id,
+				Title:        job.Entry.title,
+				Artist:       job.item().Artist,
+				URL:          job.Entry.url.String(),
+				FileType:     job.filetype,
+				File:         job.saved.name,
+				SHA256:       job.saved.sha256,
+				Gifter:       job.Entry.gifter,
+				DownloadedAt: time.Now(),
+			})
+
+			if err != nil {
+				log.Printf("Could not record %s in history: %v", job.Entry.title, err)
+			}
+
+			if err := job.failures.resolve(d.failedKey(job.Entry, job.filetype)); err != nil {
+				log.Println(err)
+			}
+
+			if !job.saved.existing {
+				fresh++
+			}
+
+			journal.update(job, jobDone)
+			opts.OnSuccess.call(job.item())
+			continue
+		}
+
+		if errors.Is(job.err, ErrCancelled) {
+			journal.update(job, jobQueued)
+			opts.OnCancel.call(job.item())
+			continue
+		}
+
+		// Not a failure, the next run with room downloads it
+		if errors.Is(job.err, ErrInsufficientSpace) && opts.OnDeferred != nil {
+			journal.update(job, jobQueued)
+			opts.OnDeferred.call(job.item())
+			continue
+		}
+
+		journal.update(job, jobFailed)
+
+		failed := d.failedKey(job.Entry, job.filetype)
+		failed.URL = job.Entry.url.String()
+		failed.Error = job.err.Error()
+		failed.FailedAt = time.Now()
+
+		if err := job.failures.record(failed); err != nil {
+			log.Println(err)
+		}
+
+		if errors.Is(job.err, ErrRegionLocked) && opts.OnRegionLocked != nil {
+			opts.OnRegionLocked.call(job.item())
+		} else {
+			opts.OnFailure.call(job.item())
+		}
+	}
+
+	close(finished)
+	d.setRun(nil)
+	jobs.close()
+	close(results)
+
+	if d.context.Err() != nil {
+		journal.close()
+	} else {
+		journal.finish()
+	}
+
+	if fresh > 0 {
+		d.promoteStaged(libs)
+		d.importIntoBeets(imports)
+		d.refreshMediaServer()
+	}
+
+	if session == nil {
+		return d.context.Err()
+	}
+
+	if err = session.close(); err != nil {
+		return fmt.Errorf("could not close browser: %v", err)
+	}
+	if err = pw.Stop(); err != nil {
+		return fmt.Errorf("could not stop Playwright: %v", err)
+	}
+
+	return d.context.Err()
+}
+
+// openBrowser starts Playwright and the browser the downloads run in, and checks that the
+// identity cookie signs into the user's account.
+func (d *Downloader) openBrowser(opts DownloadOpts) (*playwright.Playwright, *browserSession, error) {
+	// Install browsers & run. A remote browser brings its own
+	runOpts := playwrightOptions()
+	runOpts.SkipInstallBrowsers = d.endpoint != ""
+
+	err := playwright.Install(runOpts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not install playwright: %v", err)
+	}
+	pw, err := playwright.Run(runOpts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not start playwright: %v", err)
+	}
+
+	connect := func() (playwright.Browser, error) {
+		if d.endpoint != "" {
+			browser, err := pw.Chromium.Connect(d.endpoint)
+
+			if err != nil {
+				return nil, fmt.Errorf("could not connect to browser at %s: %v", d.endpoint, err)
+			}
+
+			return browser, nil
+		}
+
+		browser, err := pw.Chromium.Launch(playwright.BrowserTypeLaunchOptions{
+			Headless: playwright.Bool(d.headless),
+			Proxy:    browserProxy(),
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("could not launch browser: %v", err)
+		}
+
+		return browser, nil
+	}
+
+	// Run again after reconnecting, with the identity as refreshed so far
+	setup := func(browser playwright.Browser) (AuthorizedBandcampContext, error) {
+		d.mu.Lock()
+		identity := d.user.identity
+		d.mu.Unlock()
+
+		context, err := NewAuthorizedBandcampContext(browser, identity, d.user.cookies...)
+
+		if err != nil {
+			return context, fmt.Errorf("could not create context: %v", err)
+		}
+
+		context = context.WithPageWaits(d.waits)
+
+		context.WatchIdentity(func(identity string, expires time.Time) {
+			d.mu.Lock()
+			d.user.identity = identity
+			d.mu.Unlock()
+
+			if !expires.IsZero() && time.Until(expires) < identityExpiryWarning {
+				log.Printf("Your Bandcamp session expires on %s. Run `bcdl login` again before then", expires.Format(time.DateOnly))
+			}
+
+			if opts.OnIdentityRefresh != nil {
+				opts.OnIdentityRefresh(identity, expires)
+			}
+		})
+
+		return context, nil
+	}
+
+	session, err := openBrowserSession(d.endpoint != "", connect, setup)
+
+	if err != nil {
+		pw.Stop()
+		return nil, nil, err
+	}
+
+	// Fail before loading the collection rather than after every album times out
+	if err = d.checkSession(session); err != nil {
+		session.close()
+		pw.Stop()
+		return nil, nil, d.signInFailed(err)
+	}
+
+	return pw, session, nil
+}
+
+// checkSession checks that Bandcamp still accepts the session of the run, in the browser
+// or over HTTP when there is none.
+func (d *Downloader) checkSession(session *browserSession) error {
+	var fan Fan
+	var err error
+
+	if session == nil {
+		fan, err = fetchSignedInFan(d.user)
+	} else {
+		context, _ := session.current()
+		fan, err = context.SignedInFan()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return checkSignedInAs(fan, d.user.username)
+}
+
+// browseCollection reads the user's collection by scrolling through the collection page.
+func (d *Downloader) browseCollection(session *browserSession, filter string) ([]CollectionEntry, error) {
+	page, err := session.collectionPage(d.user.username)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create page: %v", err)
+	}
+
+	// Loading and scrolling the page stops once the run is cancelled
+	defer closeOnCancel(d.context, page)()
+
+	// Go to the users collection
+	if _, err = page.Goto(); err != nil {
+		if d.context.Err() != nil {
+			return nil, d.context.Err()
+		}
+
+		return nil, fmt.Errorf("could not goto: %v", err)
+	}
+
+	// History is tracked per account so a shared library does not mix up purchases
+	if fanID, err := page.FanID(); err == nil {
+		d.user.fanID = fanID
+	} else {
+		log.Printf("Could not determine fan id, history will be matched by username: %v", err)
+	}
+
+	// Get all entries in the collection
+	collection, err := page.GetCollection(filter)
+
+	if d.context.Err() != nil {
+		return nil, d.context.Err()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not get your collection. Check that you have the correct identity cookie value")
+	}
+
+	return collection, nil
+}
+
+// listCollection reads the user's collection over the API, see ListCollection.
+func (d *Downloader) listCollection(filter string) ([]CollectionEntry, error) {
+	fan, err := fetchSignedInFan(d.user)
+
+	if err == nil {
+		err = checkSignedInAs(fan, d.user.username)
+	}
+
+	if err != nil {
+		return nil, d.signInFailed(err)
+	}
+
+	// History is tracked per account so a shared library does not mix up purchases
+	d.user.fanID = fan.ID
+
+	return fetchCollection(d.context, d.user, fan.ID, filter)
+}
+
+// signInFailed runs the auth failure hook for err, returning the error the run stops with.
+func (d *Downloader) signInFailed(err error) error {
+	err = fmt.Errorf("Could not sign into Bandcamp: %w", err)
+
+	if hookErr := d.runHook(HookAuthFailure, err); hookErr != nil {
+		log.Println(hookErr)
+	}
+
+	return err
 }
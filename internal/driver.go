@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// ErrDriverMismatch is returned when the installed Playwright driver isn't the version
+// this build of bcdl talks to. Starting it fails or misbehaves in confusing ways.
+var ErrDriverMismatch = errors.New("Playwright driver version mismatch")
+
+// DriverStatus describes the Playwright driver bcdl will start.
+type DriverStatus struct {
+	// Expected is the driver version the playwright-go module was built for
+	Expected string
+	// Installed is what the driver reports, empty if it isn't installed
+	Installed string
+	Dir       string
+	// Owned is set when the driver lives in bcdl's own directory, see ReinstallDriver
+	Owned bool
+}
+
+// playwrightDir returns the directory ReinstallDriver installs the driver and browsers into.
+func playwrightDir() (string, error) {
+	dir, err := os.UserCacheDir()
+
+	if err != nil {
+		return "", fmt.Errorf("Could not find the cache directory: %w", err)
+	}
+
+	return filepath.Join(dir, "bcdl", "playwright"), nil
+}
+
+// playwrightOptions returns the options every driver is installed and started with. Once
+// ReinstallDriver put a driver into bcdl's own directory it is used instead of the one
+// shared with other Playwright installs, which upgrades elsewhere can't break.
+func playwrightOptions() *playwright.RunOptions {
+	opts := &playwright.RunOptions{Browsers: []string{"chromium"}, Verbose: true}
+
+	if dir, err := playwrightDir(); err == nil && dirExists(filepath.Join(dir, "ms-playwright-go")) {
+		useOwnedDir(opts, dir)
+	}
+
+	return opts
+}
+
+// useOwnedDir points opts and the browser downloads of the driver at dir.
+func useOwnedDir(opts *playwright.RunOptions, dir string) {
+	opts.DriverDirectory = dir
+	// The driver reads this for where browsers are installed and launched from
+	os.Setenv("PLAYWRIGHT_BROWSERS_PATH", filepath.Join(dir, "browsers"))
+}
+
+// CheckDriver compares the installed Playwright driver with the version bcdl expects.
+// It fails with ErrDriverMismatch when they differ or the driver is missing.
+func CheckDriver() (DriverStatus, error) {
+	opts := playwrightOptions()
+	driver, err := playwright.NewDriver(opts)
+
+	if err != nil {
+		return DriverStatus{}, err
+	}
+
+	status := DriverStatus{Expected: driver.Version, Dir: driver.DriverDirectory, Owned: opts.DriverDirectory != ""}
+
+	if _, err := os.Stat(driver.DriverBinaryLocation); err != nil {
+		return status, fmt.Errorf("%w: %s isn't installed in %s", ErrDriverMismatch, driver.Version, driver.DriverDirectory)
+	}
+
+	output, err := exec.Command(driver.DriverBinaryLocation, "--version").Output()
+
+	if err != nil {
+		return status, fmt.Errorf("%w: the driver in %s doesn't start: %v", ErrDriverMismatch, driver.DriverDirectory, err)
+	}
+
+	// The driver prints "Version 1.41.2"
+	status.Installed = string(bytes.TrimSpace(bytes.TrimPrefix(bytes.TrimSpace(output), []byte("Version"))))
+
+	if status.Installed != status.Expected {
+		return status, fmt.Errorf("%w: %s is installed but bcdl needs %s", ErrDriverMismatch, status.Installed, status.Expected)
+	}
+
+	return status, nil
+}
+
+// ReinstallDriver removes the driver and browsers from bcdl's own directory and installs
+// the versions bcdl expects there. Later runs use them instead of the shared install.
+func ReinstallDriver() (DriverStatus, error) {
+	dir, err := playwrightDir()
+
+	if err != nil {
+		return DriverStatus{}, err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return DriverStatus{}, fmt.Errorf("Could not remove the old driver: %w", err)
+	}
+
+	opts := &playwright.RunOptions{Browsers: []string{"chromium"}, Verbose: true}
+	useOwnedDir(opts, dir)
+
+	if err := playwright.Install(opts); err != nil {
+		return DriverStatus{}, fmt.Errorf("Could not install playwright: %w", err)
+	}
+
+	return CheckDriver()
+}
+
+// InstallDriver installs the driver and Chromium where runs start them from, unless they
+// are already there. Processes that share the install should call it once up front, so
+// they don't race to download it.
+func InstallDriver() error {
+	if err := playwright.Install(playwrightOptions()); err != nil {
+		return fmt.Errorf("Could not install playwright: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExistingPolicy controls what happens when a download would be saved over a file that
+// is already in the library, e.g. after the .bcdl directory was moved or rebuilt.
+type ExistingPolicy string
+
+const (
+	// ExistingOverwrite replaces the file. It is the default.
+	ExistingOverwrite ExistingPolicy = "overwrite"
+	// ExistingSkip keeps the file and records the album as downloaded. Albums whose file
+	// name can be predicted from their page are not downloaded at all.
+	ExistingSkip ExistingPolicy = "skip"
+	// ExistingRename keeps the file and saves the download next to it with a number appended.
+	ExistingRename ExistingPolicy = "rename"
+)
+
+// ParseExistingPolicy validates the name of a policy for existing files.
+func ParseExistingPolicy(s string) (ExistingPolicy, error) {
+	switch ExistingPolicy(s) {
+	case ExistingOverwrite, ExistingSkip, ExistingRename:
+		return ExistingPolicy(s), nil
+	}
+
+	return "", fmt.Errorf("Unknown policy for existing files %q, expected skip, overwrite or rename", s)
+}
+
+// WithExistingFiles sets what happens to files that are already in the library.
+func WithExistingFiles(policy ExistingPolicy) func(*Downloader) {
+	return func(d *Downloader) {
+		d.existing = policy
+	}
+}
+
+// Extensions of single track downloads, albums are always zip archives
+var trackExtensions = map[FileType]string{
+	MP3_VO:        ".mp3",
+	MP3_320:       ".mp3",
+	FLAC:          ".flac",
+	AAC_HI:        ".m4a",
+	VORBIS:        ".ogg",
+	ALAC:          ".m4a",
+	WAV:           ".wav",
+	AIFF_LOSSLESS: ".aiff",
+}
+
+// existingFile looks for the file a download of data would be saved as, before anything
+// is downloaded. Without a path template Bandcamp names downloads "Artist - Title".
+func (lib *library) existingFile(data PathData) (string, bool) {
+	stem := lib.filenames.Apply(data.Artist + " - " + data.Album)
+
+	if lib.template != "" {
+		stem = filepath.ToSlash(lib.filenames.ApplyPath(lib.template.Render(data)))
+	}
+
+	for _, ext := range []string{".zip", trackExtensions[data.FileType]} {
+		if exists, err := lib.exists(stem + ext); err == nil && exists {
+			return stem + ext, true
+		}
+	}
+
+	return "", false
+}
+
+// Sizes on the download page are rounded and may count megabytes of 1024 KiB
+const sizeTolerance = 0.05
+
+// completeFile looks for a finished copy of the download from an earlier attempt, like
+// existingFile. It has to be on this machine, be as large as the download page says and,
+// for archives, read back without errors.
+func (lib *library) completeFile(data PathData, size int64) (string, bool) {
+	local, ok := lib.storage.(*LocalStorage)
+
+	if !ok || size <= 0 {
+		return "", false
+	}
+
+	name, ok := lib.existingFile(data)
+
+	if !ok {
+		return "", false
+	}
+
+	file := lib.localPath(local, name)
+	info, err := os.Stat(file)
+
+	if err != nil || math.Abs(float64(info.Size()-size)) > float64(size)*sizeTolerance {
+		return "", false
+	}
+
+	if strings.EqualFold(path.Ext(name), ".zip") && VerifyArchive(file) != nil {
+		return "", false
+	}
+
+	return name, true
+}
+
+// freeName returns name, or name with a number appended if it is already taken.
+func (lib *library) freeName(name string) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for n := 1; ; n++ {
+		if exists, err := lib.exists(name); err != nil || !exists {
+			return name
+		}
+
+		name = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiscLayout controls how multi-disc releases are laid out when extracted.
+type DiscLayout string
+
+const (
+	// DiscFlat extracts every track into the album directory as-is.
+	DiscFlat DiscLayout = "flat"
+	// DiscSubfolders puts each disc's tracks into a "Disc N" subfolder.
+	DiscSubfolders DiscLayout = "subfolders"
+	// DiscCombined renumbers tracks sequentially across discs with zero padding.
+	DiscCombined DiscLayout = "combined"
+)
+
+// ParseDiscLayout validates a disc layout name.
+func ParseDiscLayout(s string) (DiscLayout, error) {
+	switch DiscLayout(s) {
+	case DiscFlat, DiscSubfolders, DiscCombined:
+		return DiscLayout(s), nil
+	}
+
+	return "", fmt.Errorf("Unknown disc layout %q, expected flat, subfolders or combined", s)
+}
+
+// ExtractOptions controls how downloaded archives are unpacked.
+type ExtractOptions struct {
+	DiscLayout DiscLayout
+	Filenames  FilenamePolicy
+	// Playlists writes an M3U8 playlist of every album into its directory
+	Playlists bool
+}
+
+var audioExtensions = map[string]bool{
+	".mp3": true, ".flac": true, ".m4a": true, ".ogg": true, ".wav": true, ".aiff": true, ".aif": true,
+}
+
+// Disc numbers in names look like "Disc 2", "CD2" or the "2-01" style track numbers box sets use
+var (
+	discNamePattern  = regexp.MustCompile(`(?i)\b(?:disc|disk|cd)\s*(\d+)\b`)
+	discTrackPattern = regexp.MustCompile(`(?:^| - )(\d{1,2})-(\d{1,3}) `)
+	// Bandcamp names tracks "Artist - Album - 01 Title.ext"
+	trackNumberPattern = regexp.MustCompile(`^(.* - )?(?:\d{1,2}-)?(\d{1,3}) (.*)$`)
+)
+
+// track is an audio file inside of an archive along with where it sits in the release.
+type track struct {
+	file  *zip.File
+	disc  int
+	track int
+}
+
+// discFromName finds the disc number in a track's file name, or 0 if there isn't one.
+func discFromName(name string) int {
+	if m := discNamePattern.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+
+	if m := discTrackPattern.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+
+	return 0
+}
+
+// readTrack works out the disc and track number, preferring tags over the file name.
+func readTrack(f *zip.File) track {
+	t := track{file: f}
+	base := path.Base(f.Name)
+
+	if m := trackNumberPattern.FindStringSubmatch(strings.TrimSuffix(base, path.Ext(base))); m != nil {
+		t.track, _ = strconv.Atoi(m[2])
+	}
+
+	if rc, err := f.Open(); err == nil {
+		t.disc = readDiscTag(rc, strings.ToLower(path.Ext(base)))
+		rc.Close()
+	}
+
+	if t.disc == 0 {
+		t.disc = discFromName(base)
+	}
+
+	return t
+}
+
+// isMultiDisc reports whether the tracks span more than one disc.
+func isMultiDisc(tracks []track) bool {
+	discs := make(map[int]bool)
+
+	for _, t := range tracks {
+		discs[max(t.disc, 1)] = true
+	}
+
+	return len(discs) > 1
+}
+
+// destinations maps every file in the archive to its path relative to the album directory.
+func destinations(files []*zip.File, layout DiscLayout) map[*zip.File]string {
+	dests := make(map[*zip.File]string, len(files))
+	var tracks []track
+
+	for _, f := range files {
+		dests[f] = filepath.FromSlash(f.Name)
+
+		if audioExtensions[strings.ToLower(path.Ext(f.Name))] && layout != DiscFlat {
+			tracks = append(tracks, readTrack(f))
+		}
+	}
+
+	if !isMultiDisc(tracks) {
+		return dests
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if tracks[i].disc != tracks[j].disc {
+			return tracks[i].disc < tracks[j].disc
+		}
+		return tracks[i].track < tracks[j].track
+	})
+
+	width := max(2, len(strconv.Itoa(len(tracks))))
+
+	for i, t := range tracks {
+		name := path.Base(t.file.Name)
+
+		switch layout {
+		case DiscSubfolders:
+			dests[t.file] = filepath.Join(fmt.Sprintf("Disc %d", max(t.disc, 1)), name)
+		case DiscCombined:
+			ext := path.Ext(name)
+			m := trackNumberPattern.FindStringSubmatch(strings.TrimSuffix(name, ext))
+
+			if m != nil {
+				dests[t.file] = fmt.Sprintf("%s%0*d %s%s", m[1], width, i+1, m[3], ext)
+			} else {
+				// Without a track number to replace, tracks of the same name on two discs would collide
+				dests[t.file] = fmt.Sprintf("%d-%s", max(t.disc, 1), name)
+			}
+		}
+	}
+
+	return dests
+}
+
+// Extract unpacks the archive at zipPath into destDir, laying out multi-disc releases
+// according to the options.
+func Extract(zipPath, destDir string, opts ExtractOptions) error {
+	archive, err := zip.OpenReader(zipPath)
+
+	if err != nil {
+		return fmt.Errorf("Could not open archive: %w", err)
+	}
+
+	defer archive.Close()
+
+	layout := opts.DiscLayout
+	if layout == "" {
+		layout = DiscFlat
+	}
+
+	// Absolute, so entries are checked against the same form of the path whatever destDir
+	// looks like, e.g. "."
+	root, err := filepath.Abs(destDir)
+
+	if err != nil {
+		return fmt.Errorf("Could not extract archive: %w", err)
+	}
+
+	prefix := root
+
+	if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+		prefix += string(os.PathSeparator)
+	}
+
+	var files []*zip.File
+
+	for _, f := range archive.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+
+	for f, dest := range destinations(files, layout) {
+		target := filepath.Join(root, opts.Filenames.ApplyPath(dest))
+
+		// Refuse entries that try to escape the album directory
+		if !strings.HasPrefix(target, prefix) {
+			return fmt.Errorf("Archive entry %s is outside of the destination", f.Name)
+		}
+
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
+	}
+
+	if opts.Playlists {
+		return writeAlbumPlaylist(destDir)
+	}
+
+	return nil
+}
+
+// extractFile writes a single archive entry to target.
+func extractFile(f *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return fmt.Errorf("Could not create directory: %w", err)
+	}
+
+	rc, err := f.Open()
+
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %w", f.Name, err)
+	}
+
+	defer rc.Close()
+
+	out, err := os.Create(target)
+
+	if err != nil {
+		return fmt.Errorf("Could not create %s: %w", target, err)
+	}
+
+	if _, err = io.Copy(out, rc); err != nil {
+		out.Close()
+		return fmt.Errorf("Could not extract %s: %w", f.Name, err)
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want int
+	}{
+		{"disc", "Artist - Album (Disc 2) - 01 Intro.flac", 2},
+		{"cd without a space", "Artist - Album CD3 - 01 Intro.flac", 3},
+		{"disk", "disk 1 - 04 Song.mp3", 1},
+		{"box set track number", "Artist - Album - 2-07 Song.flac", 2},
+		{"box set track number first", "1-12 Song.flac", 1},
+		{"single disc", "Artist - Album - 01 Intro.flac", 0},
+		{"disco in the title", "Artist - Album - 03 Discotheque.flac", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := discFromName(tt.file); got != tt.want {
+				t.Errorf("discFromName(%q) = %d, want %d", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	names := []string{
+		"A - B - 1-01 One.flac",
+		"A - B - 1-02 Two.flac",
+		"A - B - 2-01 Three.flac",
+		"Disc 2 Bonus.flac",
+		"Disc 1 Bonus.flac",
+		"cover.jpg",
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+
+	for _, name := range names {
+		if _, err := w.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		layout DiscLayout
+		want   map[string]string
+	}{
+		{"flat", DiscFlat, map[string]string{
+			"A - B - 1-01 One.flac":   "A - B - 1-01 One.flac",
+			"A - B - 2-01 Three.flac": "A - B - 2-01 Three.flac",
+			"Disc 2 Bonus.flac":       "Disc 2 Bonus.flac",
+			"cover.jpg":               "cover.jpg",
+		}},
+		{"subfolders", DiscSubfolders, map[string]string{
+			"A - B - 1-01 One.flac":   filepath.Join("Disc 1", "A - B - 1-01 One.flac"),
+			"A - B - 2-01 Three.flac": filepath.Join("Disc 2", "A - B - 2-01 Three.flac"),
+			"Disc 2 Bonus.flac":       filepath.Join("Disc 2", "Disc 2 Bonus.flac"),
+			"cover.jpg":               "cover.jpg",
+		}},
+		// Tracks without a number come first on their disc
+		{"combined", DiscCombined, map[string]string{
+			"A - B - 1-01 One.flac":   "A - B - 02 One.flac",
+			"A - B - 1-02 Two.flac":   "A - B - 03 Two.flac",
+			"A - B - 2-01 Three.flac": "A - B - 05 Three.flac",
+			"Disc 1 Bonus.flac":       "1-Disc 1 Bonus.flac",
+			"Disc 2 Bonus.flac":       "2-Disc 2 Bonus.flac",
+			"cover.jpg":               "cover.jpg",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dests := destinations(r.File, tt.layout)
+
+			for _, f := range r.File {
+				if want, ok := tt.want[f.Name]; ok && dests[f] != want {
+					t.Errorf("destinations(%s)[%q] = %q, want %q", tt.layout, f.Name, dests[f], want)
+				}
+			}
+		})
+	}
+}
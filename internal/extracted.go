@@ -0,0 +1,305 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// extractedAlbums remembers where each archive of a library was unpacked, so extracting
+// again skips them. It is kept as extracted.json in the .bcdl directory.
+type extractedAlbums struct {
+	mu   sync.Mutex
+	path string
+	// Albums maps the archive name to the album directory, both relative to the library
+	Albums map[string]string
+}
+
+// loadExtractedAlbums reads the record of extracted albums of the library at dir.
+func loadExtractedAlbums(dir string) (*extractedAlbums, error) {
+	e := &extractedAlbums{path: filepath.Join(dir, ".bcdl", "extracted.json"), Albums: map[string]string{}}
+
+	contents, err := os.ReadFile(e.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read extracted albums: %w", err)
+	}
+
+	if err = json.Unmarshal(contents, &e.Albums); err != nil {
+		return nil, fmt.Errorf("Could not parse extracted albums: %w", err)
+	}
+
+	return e, nil
+}
+
+// reload reads the record again to pick up albums extracted by other processes.
+func (e *extractedAlbums) reload() error {
+	fresh, err := loadExtractedAlbums(filepath.Dir(filepath.Dir(e.path)))
+
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Albums = fresh.Albums
+
+	return nil
+}
+
+// lookup returns where the archive was extracted to, if it was.
+func (e *extractedAlbums) lookup(archive string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dir, ok := e.Albums[archive]
+
+	return dir, ok
+}
+
+// claimed reports whether another archive was extracted into albumDir.
+func (e *extractedAlbums) claimed(albumDir, archive string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for other, dir := range e.Albums {
+		if dir == albumDir && other != archive {
+			return true
+		}
+	}
+
+	return false
+}
+
+// add records where the archive was extracted to.
+func (e *extractedAlbums) add(archive, albumDir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Albums[archive] = albumDir
+
+	contents, err := json.MarshalIndent(e.Albums, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(e.path), 0o777); err != nil {
+		return err
+	}
+
+	tmp := e.path + ".tmp"
+
+	if err = os.WriteFile(tmp, contents, 0o600); err != nil {
+		return fmt.Errorf("Could not save extracted albums: %w", err)
+	}
+
+	return os.Rename(tmp, e.path)
+}
+
+// ExtractedAlbum describes one archive handled by ExtractLibrary.
+type ExtractedAlbum struct {
+	// Archive and Dir are relative to the library
+	Archive string
+	Dir     string
+	// Skipped is set when the archive had already been extracted
+	Skipped bool
+}
+
+// ExtractLibrary unpacks every zip archive in the library at dir into an Artist/Album
+// directory inside of it, calling fn for each one. Archives extracted by an earlier call
+// are skipped, and an album whose directory is taken by another archive, e.g. the same
+// album in two formats, gets a numbered directory instead.
+//
+// Album titles come from the download history where it knows the archive and from the
+// archive name otherwise.
+func ExtractLibrary(dir string, opts ExtractOptions, fn func(album ExtractedAlbum, err error)) error {
+	record, err := loadExtractedAlbums(dir)
+
+	if err != nil {
+		return err
+	}
+
+	titles := historyTitles(dir)
+
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && entry.Name() == ".bcdl" {
+			return filepath.SkipDir
+		}
+
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".zip") {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, path)
+
+		if err != nil {
+			return err
+		}
+
+		name = filepath.ToSlash(name)
+
+		if albumDir, ok := record.lookup(name); ok && dirExists(filepath.Join(dir, albumDir)) {
+			fn(ExtractedAlbum{Archive: name, Dir: albumDir, Skipped: true}, nil)
+			return nil
+		}
+
+		albumDir, err := extractAlbum(dir, name, titles[name], opts, record)
+		fn(ExtractedAlbum{Archive: name, Dir: albumDir}, err)
+
+		return nil
+	})
+}
+
+// extractAlbum unpacks the archive called name into its album directory inside of the
+// library at dir and records it, returning the album directory.
+func extractAlbum(dir, name, title string, opts ExtractOptions, record *extractedAlbums) (string, error) {
+	artist, album := albumFromArchive(name)
+
+	if title != "" {
+		album = title
+	}
+
+	base := filepath.Join(safeDirName(opts.Filenames.Apply(artist)), safeDirName(opts.Filenames.Apply(album)))
+
+	// Archives laid out by a path template already sit where their album belongs
+	if strings.Contains(name, "/") {
+		base = filepath.FromSlash(strings.TrimSuffix(name, path.Ext(name)))
+	}
+	albumDir := base
+
+	// Directories that exist without being recorded belong to something else
+	taken := func(albumDir string) bool {
+		return record.claimed(filepath.ToSlash(albumDir), name) || dirExists(filepath.Join(dir, albumDir))
+	}
+
+	// Extracting again, e.g. after the archive was downloaded again, reuses the directory
+	if previous, ok := record.lookup(name); ok {
+		albumDir = filepath.FromSlash(previous)
+	} else {
+		for n := 2; taken(albumDir); n++ {
+			albumDir = fmt.Sprintf("%s (%d)", base, n)
+		}
+	}
+
+	if err := Extract(filepath.Join(dir, filepath.FromSlash(name)), filepath.Join(dir, albumDir), opts); err != nil {
+		return albumDir, err
+	}
+
+	return albumDir, record.add(name, filepath.ToSlash(albumDir))
+}
+
+// extractArchive unpacks a freshly downloaded archive of the library into its album
+// directory, deleting the archive afterwards if asked to. It returns the slash separated
+// album directory.
+func (lib *library) extractArchive(name, title string, opts AutoExtractOptions) (string, error) {
+	var albumDir string
+
+	err := lib.withLock(func() error {
+		// Another account sharing the library may have extracted it since the run started
+		if lib.lock != nil {
+			if err := lib.extracted.reload(); err != nil {
+				return err
+			}
+
+			if previous, ok := lib.extracted.lookup(name); ok && dirExists(filepath.Join(lib.workDir(), previous)) {
+				albumDir = previous
+				return nil
+			}
+		}
+
+		dir, err := extractAlbum(lib.workDir(), name, title, opts.ExtractOptions, lib.extracted)
+
+		if err != nil {
+			return err
+		}
+
+		albumDir = filepath.ToSlash(dir)
+
+		if opts.DeleteArchive {
+			return os.Remove(filepath.Join(lib.workDir(), filepath.FromSlash(name)))
+		}
+
+		return nil
+	})
+
+	return albumDir, err
+}
+
+// albumFromArchive splits Bandcamp's "Artist - Album.zip" archive names.
+func albumFromArchive(name string) (artist, album string) {
+	stem := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+
+	if artist, album, ok := strings.Cut(stem, " - "); ok {
+		return artist, album
+	}
+
+	return "Unknown Artist", stem
+}
+
+// historyTitles maps the archives of the library at dir to the album titles the history
+// recorded for them.
+func historyTitles(dir string) map[string]string {
+	titles := map[string]string{}
+
+	for _, stateDir := range libraryStateDirs(dir) {
+		history, err := LoadHistory(filepath.Join(stateDir, "history.jsonl"))
+
+		if err != nil {
+			continue
+		}
+
+		entries, _ := history.List()
+
+		for _, entry := range entries {
+			if entry.File != "" {
+				titles[entry.File] = entry.Title
+			}
+		}
+	}
+
+	return titles
+}
+
+// safeDirName replaces the characters that can't be part of a directory name on any of
+// the platforms bcdl runs on.
+func safeDirName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	// Windows drops trailing dots and spaces, which would merge different albums
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+
+	if name == "" {
+		return "_"
+	}
+
+	return name
+}
+
+// dirExists reports whether there is a directory at path.
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && info.IsDir()
+}
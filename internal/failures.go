@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FailedJob is a download that did not succeed, kept in the .bcdl directory so a later
+// run with WithRetryFailed can try it again.
+type FailedJob struct {
+	Username string    `json:"username"`
+	ItemID   string    `json:"item_id,omitempty"`
+	Title    string    `json:"title"`
+	URL      string    `json:"url"`
+	FileType FileType  `json:"filetype"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// failedJobs is the list of failed downloads of a state directory. It is rewritten after
+// every change so failures survive the process exiting or crashing.
+type failedJobs struct {
+	mu   sync.Mutex
+	path string
+	jobs []FailedJob
+}
+
+// loadFailedJobs reads the failed downloads in stateDir. A missing file results in an empty list.
+func loadFailedJobs(stateDir string) (*failedJobs, error) {
+	f := &failedJobs{path: filepath.Join(stateDir, "failed.json")}
+
+	contents, err := os.ReadFile(f.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read failed downloads: %w", err)
+	}
+
+	if err = json.Unmarshal(contents, &f.jobs); err != nil {
+		return nil, fmt.Errorf("Could not parse failed downloads: %w", err)
+	}
+
+	return f, nil
+}
+
+// contains reports whether the album of job failed to download for its user in its file type.
+func (f *failedJobs) contains(job FailedJob) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.index(job) >= 0
+}
+
+// record adds the failure, replacing an earlier one of the same album.
+func (f *failedJobs) record(job FailedJob) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if i := f.index(job); i >= 0 {
+		f.jobs[i] = job
+	} else {
+		f.jobs = append(f.jobs, job)
+	}
+
+	return f.save()
+}
+
+// resolve forgets an earlier failure of the album of job once it has been downloaded.
+func (f *failedJobs) resolve(job FailedJob) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := f.index(job)
+
+	if i < 0 {
+		return nil
+	}
+
+	f.jobs = append(f.jobs[:i], f.jobs[i+1:]...)
+
+	return f.save()
+}
+
+// index returns the position of the album of job in the list or -1. The caller must hold mu.
+func (f *failedJobs) index(job FailedJob) int {
+	for i, failed := range f.jobs {
+		if failed.Username == job.Username && failed.FileType == job.FileType && sameAlbum(failed.ItemID, failed.Title, job.ItemID, job.Title) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// save writes the list through a temporary file so a crash can't leave it half written.
+// The caller must hold mu.
+func (f *failedJobs) save() error {
+	if len(f.jobs) == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("Could not remove failed downloads: %w", err)
+		}
+
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(f.jobs, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+
+	if err = os.WriteFile(tmp, contents, 0o600); err != nil {
+		return fmt.Errorf("Could not save failed downloads: %w", err)
+	}
+
+	if err = os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("Could not save failed downloads: %w", err)
+	}
+
+	return nil
+}
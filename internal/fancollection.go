@@ -0,0 +1,323 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// collectionItemsURL is the API the collection page calls while it is scrolled.
+var collectionItemsURL = bcUrl.JoinPath("api", "fancollection", "1", "collection_items")
+
+// collectionPageSize is how many items are asked for per request. The collection page asks
+// for 20, but the API hands out a lot more at once.
+const collectionPageSize = 500
+
+// pageDataPattern finds the #pagedata blob in a page's HTML.
+var pageDataPattern = regexp.MustCompile(`<div id="pagedata" data-blob="([^"]*)"`)
+
+var apiClient = &http.Client{Transport: newTransport(), Timeout: time.Minute}
+
+// collectionItemsResponse is a page of the fancollection API.
+type collectionItemsResponse struct {
+	Items []collectionItem `json:"items"`
+	// RedownloadURLs maps "p" + sale_item_id to the item's download page
+	RedownloadURLs map[string]string `json:"redownload_urls"`
+	MoreAvailable  bool              `json:"more_available"`
+	LastToken      string            `json:"last_token"`
+	Error          bool              `json:"error"`
+	ErrorMessage   string            `json:"error_message"`
+}
+
+// collectionItem is an item of the fancollection API, which holds what the collection page
+// shows of it.
+type collectionItem struct {
+	TralbumType string `json:"tralbum_type"`
+	TralbumID   int64  `json:"tralbum_id"`
+	Title       string `json:"item_title"`
+	Artist      string `json:"band_name"`
+	BandID      int64  `json:"band_id"`
+	ItemURL     string `json:"item_url"`
+	ArtID       int64  `json:"item_art_id"`
+	SaleItemID  int64  `json:"sale_item_id"`
+	SaleType    string `json:"sale_item_type"`
+	Token       string `json:"token"`
+	// Gifts have an id and usually the name of who sent them
+	GiftID         int64  `json:"gift_id"`
+	GiftSenderName string `json:"gift_sender_name"`
+	// What the item sells for, which isn't necessarily what the fan paid
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+}
+
+// ListCollection reads the user's collection with plain HTTP requests instead of a browser,
+// which takes seconds rather than minutes of scrolling. Entries are in purchase order like
+// on the collection page and only the ones matching filter are returned.
+func ListCollection(user *User, filter string) ([]CollectionEntry, error) {
+	fan, err := fetchSignedInFan(user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkSignedInAs(fan, user.username); err != nil {
+		return nil, err
+	}
+
+	user.fanID = fan.ID
+
+	if user.username == "" {
+		user.username = fan.Username
+	}
+
+	return fetchCollection(context.Background(), user, fan.ID, filter)
+}
+
+// apiRequest sends req with the user's cookies, failing unless Bandcamp answers with 200 OK.
+// When Bandcamp rate limits it, every request pauses for as long as it asks and req is
+// sent again.
+func apiRequest(user *User, req *http.Request) ([]byte, error) {
+	setClientHeaders(req)
+
+	for _, cookie := range userCookies(user) {
+		req.AddCookie(cookie)
+	}
+
+	var resp *http.Response
+
+	for attempt := 1; ; attempt++ {
+		bandcampLimiter.wait()
+
+		var err error
+
+		if resp, err = apiClient.Do(req); err != nil {
+			return nil, fmt.Errorf("Could not reach Bandcamp: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt == rateLimitAttempts {
+			break
+		}
+
+		resp.Body.Close()
+		bandcampLimiter.pause(retryAfter(resp.Header.Get("Retry-After")))
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("Could not send %s again: %w", req.URL, err)
+			}
+		}
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the response from %s: %w", req.URL, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered with %s", req.URL, resp.Status)
+	}
+
+	return body, nil
+}
+
+// userCookies returns the identity and the other cookies of the user.
+func userCookies(user *User) []*http.Cookie {
+	var cookies []*http.Cookie
+
+	if user.identity != "" {
+		cookies = append(cookies, &http.Cookie{Name: "identity", Value: user.identity})
+	}
+
+	for _, cookie := range user.cookies {
+		if cookie.Name != "identity" || user.identity == "" {
+			cookies = append(cookies, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+
+	return cookies
+}
+
+// fetchSignedInFan is SignedInFan without a browser.
+func fetchSignedInFan(user *User) (Fan, error) {
+	req, err := http.NewRequest(http.MethodGet, bcUrl.String(), nil)
+
+	if err != nil {
+		return Fan{}, err
+	}
+
+	body, err := apiRequest(user, req)
+
+	if err != nil {
+		return Fan{}, err
+	}
+
+	match := pageDataPattern.FindSubmatch(body)
+
+	if match == nil {
+		return Fan{}, fmt.Errorf("Could not read page data: none in %s", bcUrl.String())
+	}
+
+	var data identitiesPageData
+
+	if err = json.Unmarshal([]byte(html.UnescapeString(string(match[1]))), &data); err != nil {
+		return Fan{}, fmt.Errorf("Could not parse page data: %w", err)
+	}
+
+	return data.signedInFan()
+}
+
+// fetchCollection pages through the fancollection API, newest purchases first.
+func fetchCollection(ctx context.Context, user *User, fanID int64, filter string) ([]CollectionEntry, error) {
+	var entries []CollectionEntry
+	// Tokens are "<purchase time>:<sale item id>:<type>::", so this starts with the newest
+	token := fmt.Sprintf("%d::a::", time.Now().Add(24*time.Hour).Unix())
+
+	for {
+		page, err := fetchCollectionItems(ctx, user, fanID, token)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			entry, ok := item.entry(page.RedownloadURLs)
+
+			if ok && matchesFilter(entry, filter) {
+				entries = append(entries, entry)
+			}
+		}
+
+		if !page.MoreAvailable || page.LastToken == "" || page.LastToken == token {
+			return entries, nil
+		}
+
+		token = page.LastToken
+	}
+}
+
+// recentCollection returns the newest items of the collection, like RecentEntries does with
+// the collection page.
+func recentCollection(ctx context.Context, user *User) ([]CollectionEntry, error) {
+	page, err := fetchCollectionItems(ctx, user, user.fanID, fmt.Sprintf("%d::a::", time.Now().Add(24*time.Hour).Unix()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CollectionEntry
+
+	for _, item := range page.Items {
+		if entry, ok := item.entry(page.RedownloadURLs); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// fetchCollectionItems requests the page of the collection older than token.
+func fetchCollectionItems(ctx context.Context, user *User, fanID int64, token string) (collectionItemsResponse, error) {
+	var page collectionItemsResponse
+
+	payload, err := json.Marshal(map[string]any{"fan_id": fanID, "older_than_token": token, "count": collectionPageSize})
+
+	if err != nil {
+		return page, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, collectionItemsURL.String(), bytes.NewReader(payload))
+
+	if err != nil {
+		return page, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	body, err := apiRequest(user, req)
+
+	if err != nil {
+		return page, fmt.Errorf("Could not get your collection: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &page); err != nil {
+		return page, fmt.Errorf("Could not parse your collection: %w", err)
+	}
+
+	if page.Error {
+		return page, fmt.Errorf("Could not get your collection: %s", page.ErrorMessage)
+	}
+
+	return page, nil
+}
+
+// id returns the id CollectionEntry.ID has for the item, or "" if the API left it out.
+func (item collectionItem) id() string {
+	if item.TralbumID == 0 {
+		return ""
+	}
+
+	return item.TralbumType + strconv.FormatInt(item.TralbumID, 10)
+}
+
+// gift reports whether the item was a gift and who sent it, if the API says.
+func (item collectionItem) gift() (bool, string) {
+	return item.GiftID != 0 || item.GiftSenderName != "", item.GiftSenderName
+}
+
+// entry converts the item into what parseCollectionEntries reads off the collection page.
+// Items without a download page, e.g. subscriptions, are skipped like there.
+func (item collectionItem) entry(redownloadURLs map[string]string) (CollectionEntry, bool) {
+	saleType := item.SaleType
+
+	if saleType == "" {
+		saleType = "p"
+	}
+
+	href := redownloadURLs[saleType+strconv.FormatInt(item.SaleItemID, 10)]
+
+	if href == "" || item.Title == "" {
+		return CollectionEntry{}, false
+	}
+
+	u, err := url.Parse(href)
+
+	if err != nil {
+		return CollectionEntry{}, false
+	}
+
+	entry := CollectionEntry{
+		url:       *u,
+		id:        item.id(),
+		title:     item.Title,
+		artist:    item.Artist,
+		purchased: parseCollectionToken(item.Token),
+		price:     item.Price,
+		currency:  item.Currency,
+	}
+
+	entry.gift, entry.gifter = item.gift()
+
+	if item.ArtID != 0 {
+		entry.artID = strconv.FormatInt(item.ArtID, 10)
+	}
+
+	if item.BandID != 0 {
+		entry.bandID = strconv.FormatInt(item.BandID, 10)
+	}
+
+	if itemURL, err := url.Parse(item.ItemURL); err == nil {
+		entry.itemURL = *itemURL
+	}
+
+	return entry, true
+}
@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedURL is the API the feed page loads stories from.
+var feedURL = bcUrl.JoinPath("fan_dash_feed_updates")
+
+// The kinds of feed stories.
+const (
+	// FeedNewRelease is a release by an artist or label the fan follows
+	FeedNewRelease = "new-release"
+	// FeedPurchase is something a fan the user follows bought
+	FeedPurchase = "purchase"
+)
+
+// feedStoryTypes maps Bandcamp's story types to the kinds above.
+var feedStoryTypes = map[string]string{
+	"nr": FeedNewRelease,
+	"np": FeedPurchase,
+	"p":  FeedPurchase,
+}
+
+// FeedStory is an entry of the fan feed.
+type FeedStory struct {
+	// Kind is FeedNewRelease or FeedPurchase
+	Kind   string    `json:"kind"`
+	Title  string    `json:"title"`
+	Artist string    `json:"artist"`
+	URL    string    `json:"url"`
+	Date   time.Time `json:"date"`
+	// Fan is who bought the item, only set for purchases
+	Fan string `json:"fan,omitempty"`
+	// Matches are the keywords of MatchFeed found in the story
+	Matches []string `json:"matches,omitempty"`
+}
+
+// Key identifies the story, so a feed that is checked repeatedly reports it once.
+func (s FeedStory) Key() string {
+	return s.Kind + " " + s.Fan + " " + s.URL
+}
+
+// feedResponse is a page of the feed API.
+type feedResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Stories struct {
+		Entries []struct {
+			StoryType string `json:"story_type"`
+			StoryDate string `json:"story_date"`
+			FanID     int64  `json:"fan_id"`
+			Title     string `json:"item_title"`
+			ItemURL   string `json:"item_url"`
+			BandName  string `json:"band_name"`
+		} `json:"entries"`
+		OldestStoryDate int64 `json:"oldest_story_date"`
+		FanInfo         map[string]struct {
+			Name     string `json:"name"`
+			Username string `json:"username"`
+		} `json:"fan_info"`
+	} `json:"stories"`
+}
+
+// ReadFeed returns the stories of the user's feed back to since, newest first. Like
+// ListCollection it only needs the identity cookie, not a browser.
+func ReadFeed(user *User, since time.Time) ([]FeedStory, error) {
+	fan, err := fetchSignedInFan(user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkSignedInAs(fan, user.username); err != nil {
+		return nil, err
+	}
+
+	var stories []FeedStory
+	// Stories posted in the same second as the last one of a page can show up on the next
+	seen := map[string]bool{}
+	olderThan := time.Now().Unix()
+
+	for {
+		page, err := fetchFeedPage(user, fan.ID, olderThan)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range page.Stories.Entries {
+			story := FeedStory{
+				Kind:   feedStoryTypes[entry.StoryType],
+				Title:  entry.Title,
+				Artist: entry.BandName,
+				URL:    entry.ItemURL,
+			}
+
+			// Dates look like "08 Jan 2024 19:22:15 GMT"
+			if date, err := time.Parse("02 Jan 2006 15:04:05 MST", entry.StoryDate); err == nil {
+				story.Date = date
+			}
+
+			if story.Kind == "" || story.Date.Before(since) {
+				continue
+			}
+
+			if story.Kind == FeedPurchase {
+				info := page.Stories.FanInfo[strconv.FormatInt(entry.FanID, 10)]
+				story.Fan = info.Username
+			}
+
+			if seen[story.Key()] {
+				continue
+			}
+
+			seen[story.Key()] = true
+
+			stories = append(stories, story)
+		}
+
+		oldest := page.Stories.OldestStoryDate
+
+		if len(page.Stories.Entries) == 0 || oldest <= 0 || oldest >= olderThan || time.Unix(oldest, 0).Before(since) {
+			return stories, nil
+		}
+
+		olderThan = oldest
+	}
+}
+
+// fetchFeedPage requests the stories older than the unix time olderThan.
+func fetchFeedPage(user *User, fanID int64, olderThan int64) (feedResponse, error) {
+	var page feedResponse
+
+	form := url.Values{
+		"fan_id":     {strconv.FormatInt(fanID, 10)},
+		"older_than": {strconv.FormatInt(olderThan, 10)},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, feedURL.String(), strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return page, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := apiRequest(user, req)
+
+	if err != nil {
+		return page, fmt.Errorf("Could not read your feed: %w", err)
+	}
+
+	if err = json.Unmarshal(body, &page); err != nil {
+		return page, fmt.Errorf("Could not parse your feed: %w", err)
+	}
+
+	if !page.OK {
+		return page, fmt.Errorf("Could not read your feed: %s", page.Error)
+	}
+
+	return page, nil
+}
+
+// MatchFeed returns the stories whose title, artist or fan contain one of the keywords,
+// ignoring case, with the keywords they matched. Without keywords every story matches.
+func MatchFeed(stories []FeedStory, keywords []string) []FeedStory {
+	if len(keywords) == 0 {
+		return stories
+	}
+
+	var matched []FeedStory
+
+	for _, story := range stories {
+		text := strings.ToLower(strings.Join([]string{story.Title, story.Artist, story.Fan}, "\n"))
+		story.Matches = nil
+
+		for _, keyword := range keywords {
+			if keyword != "" && strings.Contains(text, strings.ToLower(keyword)) {
+				story.Matches = append(story.Matches, keyword)
+			}
+		}
+
+		if len(story.Matches) > 0 {
+			matched = append(matched, story)
+		}
+	}
+
+	return matched
+}
+
+// WriteFeed writes the stories as JSON or, when format is "csv", as a spreadsheet.
+func WriteFeed(w io.Writer, stories []FeedStory, format string) error {
+	if format != "csv" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(stories)
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"kind", "date", "artist", "title", "url", "fan", "matches"})
+
+	for _, story := range stories {
+		out.Write([]string{story.Kind, story.Date.Format(time.RFC3339), story.Artist, story.Title, story.URL, story.Fan, strings.Join(story.Matches, ";")})
+	}
+
+	out.Flush()
+
+	return out.Error()
+}
+
+// FeedEvent describes a story for a webhook, as a "feed" event.
+func FeedEvent(story FeedStory) WebhookData {
+	return WebhookData{Item: ItemEvent{
+		Event:  "feed",
+		Title:  story.Title,
+		Artist: story.Artist,
+		URL:    story.URL,
+		Time:   story.Date,
+	}}
+}
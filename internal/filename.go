@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// UnicodeForm is the normalization form applied to file names.
+type UnicodeForm string
+
+const (
+	// FormNone leaves names exactly as Bandcamp provides them.
+	FormNone UnicodeForm = ""
+	// FormNFC composes characters, which is what Linux and Windows tools expect.
+	FormNFC UnicodeForm = "nfc"
+	// FormNFD decomposes characters, which is what macOS filesystems historically store.
+	FormNFD UnicodeForm = "nfd"
+)
+
+// ParseUnicodeForm validates a normalization form name.
+func ParseUnicodeForm(s string) (UnicodeForm, error) {
+	switch UnicodeForm(strings.ToLower(s)) {
+	case FormNone, "none":
+		return FormNone, nil
+	case FormNFC:
+		return FormNFC, nil
+	case FormNFD:
+		return FormNFD, nil
+	}
+
+	return "", fmt.Errorf("Unknown unicode form %q, expected nfc, nfd or none", s)
+}
+
+// FilenamePolicy controls how album and track names are turned into file names.
+//
+// Albums with accented or Japanese titles can end up as two different looking folders
+// when a library is synced between macOS and Linux, because each side stores a
+// different normalization form. Picking one form for everything avoids that.
+type FilenamePolicy struct {
+	Form UnicodeForm
+	// ASCII transliterates names to plain ASCII, replacing anything that has no
+	// sensible equivalent with an underscore.
+	ASCII bool
+	// Sanitize replaces what Windows and SMB shares can't store in a name: the characters
+	// <>:"/\|?*, control characters and emoji. Trailing dots and spaces are dropped and
+	// reserved names like CON get an underscore appended.
+	Sanitize bool
+	// Replacement is what sanitized characters are replaced with, "_" when empty.
+	// Replacements overrides it for single characters, e.g. ":" with " -".
+	Replacement  string
+	Replacements map[rune]string
+}
+
+// Characters Windows doesn't allow in file names
+const reservedChars = `<>:"/\|?*`
+
+// Emoji, the Extended_Pictographic property of Unicode 15 plus the regional indicators of
+// flags. Other characters outside of the basic multilingual plane, like the CJK extensions,
+// are kept.
+var extendedPictographic = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00a9, 0x00a9, 1}, {0x00ae, 0x00ae, 1}, {0x203c, 0x203c, 1}, {0x2049, 0x2049, 1},
+		{0x2122, 0x2122, 1}, {0x2139, 0x2139, 1}, {0x2194, 0x2199, 1}, {0x21a9, 0x21aa, 1},
+		{0x231a, 0x231b, 1}, {0x2328, 0x2328, 1}, {0x2388, 0x2388, 1}, {0x23cf, 0x23cf, 1},
+		{0x23e9, 0x23f3, 1}, {0x23f8, 0x23fa, 1}, {0x24c2, 0x24c2, 1}, {0x25aa, 0x25ab, 1},
+		{0x25b6, 0x25b6, 1}, {0x25c0, 0x25c0, 1}, {0x25fb, 0x25fe, 1}, {0x2600, 0x2605, 1},
+		{0x2607, 0x2612, 1}, {0x2614, 0x2685, 1}, {0x2690, 0x2705, 1}, {0x2708, 0x2712, 1},
+		{0x2714, 0x2714, 1}, {0x2716, 0x2716, 1}, {0x271d, 0x271d, 1}, {0x2721, 0x2721, 1},
+		{0x2728, 0x2728, 1}, {0x2733, 0x2734, 1}, {0x2744, 0x2744, 1}, {0x2747, 0x2747, 1},
+		{0x274c, 0x274c, 1}, {0x274e, 0x274e, 1}, {0x2753, 0x2755, 1}, {0x2757, 0x2757, 1},
+		{0x2763, 0x2767, 1}, {0x2795, 0x2797, 1}, {0x27a1, 0x27a1, 1}, {0x27b0, 0x27b0, 1},
+		{0x27bf, 0x27bf, 1}, {0x2934, 0x2935, 1}, {0x2b05, 0x2b07, 1}, {0x2b1b, 0x2b1c, 1},
+		{0x2b50, 0x2b50, 1}, {0x2b55, 0x2b55, 1}, {0x3030, 0x3030, 1}, {0x303d, 0x303d, 1},
+		{0x3297, 0x3297, 1}, {0x3299, 0x3299, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1f000, 0x1f0ff, 1}, {0x1f10d, 0x1f10f, 1}, {0x1f12f, 0x1f12f, 1}, {0x1f16c, 0x1f171, 1},
+		{0x1f17e, 0x1f17f, 1}, {0x1f18e, 0x1f18e, 1}, {0x1f191, 0x1f19a, 1}, {0x1f1ad, 0x1f1ff, 1},
+		{0x1f201, 0x1f20f, 1}, {0x1f21a, 0x1f21a, 1}, {0x1f22f, 0x1f22f, 1}, {0x1f232, 0x1f23a, 1},
+		{0x1f23c, 0x1f23f, 1}, {0x1f249, 0x1f3fa, 1}, {0x1f400, 0x1f53d, 1}, {0x1f546, 0x1f64f, 1},
+		{0x1f680, 0x1f6ff, 1}, {0x1f774, 0x1f77f, 1}, {0x1f7d5, 0x1f7ff, 1}, {0x1f80c, 0x1f80f, 1},
+		{0x1f848, 0x1f84f, 1}, {0x1f85a, 0x1f85f, 1}, {0x1f888, 0x1f88f, 1}, {0x1f8ae, 0x1f8ff, 1},
+		{0x1f90c, 0x1f93a, 1}, {0x1f93c, 0x1f945, 1}, {0x1f947, 0x1faff, 1}, {0x1fc00, 0x1fffd, 1},
+	},
+	LatinOffset: 2,
+}
+
+// What follows an emoji within its sequence: the zero width joiner, variation selectors,
+// the keycap, skin tones, the second regional indicator of a flag and tags
+var emojiComponent = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x200d, 0x200d, 1}, {0x20e3, 0x20e3, 1}, {0xfe0e, 0xfe0f, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1f1e6, 0x1f1ff, 1}, {0x1f3fb, 0x1f3ff, 1}, {0xe0020, 0xe007f, 1},
+	},
+}
+
+// Names Windows reserves for devices, with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitize makes the name safe to store on Windows and SMB shares.
+func (p FilenamePolicy) sanitize(name string) string {
+	if name == "" {
+		return name
+	}
+
+	replacement := p.Replacement
+
+	if replacement == "" {
+		replacement = "_"
+	}
+
+	var s strings.Builder
+	// Whether the last rune was part of an emoji, whose sequence is replaced as a whole, and
+	// whether it joins the next one
+	inEmoji, joined := false, false
+
+	for _, r := range name {
+		if inEmoji && (unicode.Is(emojiComponent, r) || joined && unicode.Is(extendedPictographic, r)) {
+			joined = r == '\u200d'
+			continue
+		}
+
+		inEmoji, joined = false, false
+
+		if text, ok := p.Replacements[r]; ok {
+			s.WriteString(text)
+			continue
+		}
+
+		if unicode.Is(extendedPictographic, r) {
+			s.WriteString(replacement)
+			inEmoji = true
+			continue
+		}
+
+		if strings.ContainsRune(reservedChars, r) || r < ' ' {
+			s.WriteString(replacement)
+			continue
+		}
+
+		s.WriteRune(r)
+	}
+
+	name = strings.TrimRight(s.String(), ". ")
+
+	stem, _, _ := strings.Cut(name, ".")
+
+	if reservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = stem + "_" + strings.TrimPrefix(name, stem)
+	}
+
+	if name == "" {
+		return replacement
+	}
+
+	return name
+}
+
+// Letters that do not decompose into an ASCII base letter and a combining mark
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'ø': "o", 'Ø': "O", 'œ': "oe", 'Œ': "OE",
+	'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "Th", 'ł': "l", 'Ł': "L",
+	'ı': "i", '‘': "'", '’': "'", '“': "\"", '”': "\"", '–': "-", '—': "-", '…': "...",
+}
+
+// transliterate reduces the name to ASCII.
+func transliterate(name string) string {
+	var s strings.Builder
+
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case r < unicode.MaxASCII:
+			s.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Drop the accents split off by NFD
+		case transliterations[r] != "":
+			s.WriteString(transliterations[r])
+		default:
+			s.WriteRune('_')
+		}
+	}
+
+	return s.String()
+}
+
+// Apply returns the name with the policy applied.
+func (p FilenamePolicy) Apply(name string) string {
+	switch {
+	case p.ASCII:
+		name = transliterate(name)
+	case p.Form == FormNFC:
+		name = norm.NFC.String(name)
+	case p.Form == FormNFD:
+		name = norm.NFD.String(name)
+	}
+
+	if p.Sanitize {
+		name = p.sanitize(name)
+	}
+
+	return name
+}
+
+// ApplyPath applies the policy to every element of a relative path.
+func (p FilenamePolicy) ApplyPath(path string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+
+	for i, part := range parts {
+		parts[i] = p.Apply(part)
+	}
+
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
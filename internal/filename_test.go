@@ -0,0 +1,57 @@
+package internal
+
+import "testing"
+
+func TestFilenamePolicySanitize(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy FilenamePolicy
+		in     string
+		want   string
+	}{
+		{"plain", FilenamePolicy{}, "Album (2020)", "Album (2020)"},
+		{"reserved characters", FilenamePolicy{}, `a<b>c:d"e/f\g|h?i*j`, "a_b_c_d_e_f_g_h_i_j"},
+		{"control characters", FilenamePolicy{}, "a\tb\x00c", "a_b_c"},
+		{"trailing dots and spaces", FilenamePolicy{}, "Album... ", "Album"},
+		{"only dots", FilenamePolicy{}, "...", "_"},
+		{"reserved name", FilenamePolicy{}, "con", "con_"},
+		{"reserved name with an extension", FilenamePolicy{}, "NUL.txt", "NUL_.txt"},
+		{"reserved name inside of a word", FilenamePolicy{}, "Console", "Console"},
+		{"emoji", FilenamePolicy{}, "Love \U0001F496", "Love _"},
+		{"emoji with a skin tone", FilenamePolicy{}, "\U0001F44D\U0001F3FD yes", "_ yes"},
+		{"joined emoji", FilenamePolicy{}, "\U0001F468\u200d\U0001F469\u200d\U0001F467", "_"},
+		{"flag", FilenamePolicy{}, "\U0001F1EF\U0001F1F5 tour", "_ tour"},
+		{"cjk extension kept", FilenamePolicy{}, "\U00020000", "\U00020000"},
+		{"replacement", FilenamePolicy{Replacement: "-"}, "a?b", "a-b"},
+		{"replacements", FilenamePolicy{Replacements: map[rune]string{':': " -"}}, "Vol: 2", "Vol - 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.sanitize(tt.in); got != tt.want {
+				t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ascii", "Album", "Album"},
+		{"accents", "Sigur Rós – Ágætis byrjun", "Sigur Ros - Agaetis byrjun"},
+		{"letters without a base", "Straße Łódź", "Strasse Lodz"},
+		{"no equivalent", "東京", "__"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transliterate(tt.in); got != tt.want {
+				t.Errorf("transliterate(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
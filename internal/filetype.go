@@ -1,5 +1,11 @@
 package internal
 
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
 type FileType string
 
 // All of the file types Bandcamp supports.
@@ -16,3 +22,136 @@ const (
 )
 
 var AllFileTypes = []FileType{MP3_320, MP3_VO, FLAC, AAC_HI, VORBIS, ALAC, WAV, AIFF_LOSSLESS}
+
+var fileTypeDescriptions = map[FileType]string{
+	MP3_VO:        "MP3 ~245kbps variable bitrate. Lossy, plays everywhere, quickest to download",
+	MP3_320:       "MP3 320kbps constant bitrate. Lossy, plays everywhere",
+	FLAC:          "FLAC. Lossless, the usual pick for archiving. Not supported by Apple Music",
+	AAC_HI:        "AAC 256kbps. Lossy, a good fit for Apple devices",
+	VORBIS:        "Ogg Vorbis. Lossy and open, not supported by Apple devices",
+	ALAC:          "Apple Lossless. Lossless, for Apple Music and iTunes",
+	WAV:           "WAV. Lossless and uncompressed, limited tag support",
+	AIFF_LOSSLESS: "AIFF. Lossless and uncompressed, keeps tags, common in DJ software",
+}
+
+// fileTypeLabels are the short names used in file names.
+var fileTypeLabels = map[FileType]string{
+	MP3_VO:        "MP3 V0",
+	MP3_320:       "MP3 320",
+	FLAC:          "FLAC",
+	AAC_HI:        "AAC",
+	VORBIS:        "Ogg Vorbis",
+	ALAC:          "ALAC",
+	WAV:           "WAV",
+	AIFF_LOSSLESS: "AIFF",
+}
+
+// typicalAlbumMB is roughly how large a 45 minute album is in each format.
+var typicalAlbumMB = map[FileType]int64{
+	MP3_VO:        85,
+	MP3_320:       105,
+	FLAC:          300,
+	AAC_HI:        90,
+	VORBIS:        70,
+	ALAC:          310,
+	WAV:           475,
+	AIFF_LOSSLESS: 475,
+}
+
+// fileTypeAliases maps the names people commonly use for a format, with anything other
+// than letters and digits removed, to the format.
+var fileTypeAliases = map[string]FileType{
+	"mp3":          MP3_VO,
+	"v0":           MP3_VO,
+	"mp3v0":        MP3_VO,
+	"mp3vo":        MP3_VO,
+	"320":          MP3_320,
+	"mp3320":       MP3_320,
+	"flac":         FLAC,
+	"aac":          AAC_HI,
+	"aachi":        AAC_HI,
+	"m4a":          AAC_HI,
+	"ogg":          VORBIS,
+	"vorbis":       VORBIS,
+	"oggvorbis":    VORBIS,
+	"alac":         ALAC,
+	"wav":          WAV,
+	"wave":         WAV,
+	"aif":          AIFF_LOSSLESS,
+	"aiff":         AIFF_LOSSLESS,
+	"aifflossless": AIFF_LOSSLESS,
+}
+
+// Label returns a short name for the file type, e.g. "MP3 320", suitable for file names.
+func (ft FileType) Label() string {
+	if label, ok := fileTypeLabels[ft]; ok {
+		return label
+	}
+
+	return string(ft)
+}
+
+// Description explains the file type in a few words.
+func (ft FileType) Description() string {
+	return fileTypeDescriptions[ft]
+}
+
+// Lossless reports whether the file type keeps the audio as it was uploaded.
+func (ft FileType) Lossless() bool {
+	switch ft {
+	case FLAC, ALAC, WAV, AIFF_LOSSLESS:
+		return true
+	}
+
+	return false
+}
+
+// TypicalAlbumSize estimates the size in bytes of an average album in the file type.
+// Bandcamp does not publish sizes up front so this is only a rule of thumb.
+func (ft FileType) TypicalAlbumSize() int64 {
+	return typicalAlbumMB[ft] * 1_000_000
+}
+
+// ParseFileType finds the file type for s. Matching ignores case and punctuation, and
+// accepts common aliases such as "v0", "320", "ogg" or "aiff".
+func ParseFileType(s string) (FileType, error) {
+	key := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+
+	if ft, ok := fileTypeAliases[key]; ok {
+		return ft, nil
+	}
+
+	var valid strings.Builder
+
+	for _, ft := range AllFileTypes {
+		fmt.Fprintf(&valid, "\n  %-14s %s", ft, ft.Description())
+	}
+
+	return "", fmt.Errorf("Unknown file type %q, expected one of:%s", s, valid.String())
+}
+
+// FileTypeFlag is a flag.Value that accepts any spelling ParseFileType understands.
+type FileTypeFlag FileType
+
+// String returns the selected file type.
+func (f *FileTypeFlag) String() string {
+	return string(*f)
+}
+
+// Set parses the flag value.
+func (f *FileTypeFlag) Set(s string) error {
+	ft, err := ParseFileType(s)
+
+	if err != nil {
+		return err
+	}
+
+	*f = FileTypeFlag(ft)
+
+	return nil
+}
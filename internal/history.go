@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HistoryEntry records a single successful download.
+//
+// The owning account is stored alongside the album so several Bandcamp accounts
+// can share one library directory without masking each other's purchases.
+type HistoryEntry struct {
+	FanID    int64    `json:"fan_id,omitempty"`
+	Username string   `json:"username"`
+	ItemID   string   `json:"item_id,omitempty"`
+	Title    string   `json:"title"`
+	Artist   string   `json:"artist,omitempty"`
+	URL      string   `json:"url"`
+	FileType FileType `json:"filetype"`
+	// File is the name the download was saved under in the library and SHA256 its checksum,
+	// see WithChecksums. Gifter is who gave the item as a gift.
+	File         string    `json:"file,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Gifter       string    `json:"gifter,omitempty"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// ownedBy reports whether the entry belongs to the user.
+// The fan id is preferred when both sides know it since usernames can be changed.
+func (e HistoryEntry) ownedBy(user *User) bool {
+	if e.FanID != 0 && user.fanID != 0 {
+		return e.FanID == user.fanID
+	}
+
+	return e.Username == user.username
+}
+
+// sameItem reports whether both entries record the same album, file type and account.
+func (e HistoryEntry) sameItem(other HistoryEntry) bool {
+	return sameAlbum(e.ItemID, e.Title, other.ItemID, other.Title) && e.FileType == other.FileType &&
+		other.ownedBy(&User{username: e.Username, fanID: e.FanID})
+}
+
+// albumKey identifies the album of the entry: its item id, or the title for entries
+// recorded before ids were.
+func (e HistoryEntry) albumKey() string {
+	if e.ItemID != "" {
+		return e.ItemID
+	}
+
+	return e.Title
+}
+
+// sameAlbum reports whether two records are of the same album.
+// The item id is preferred when both sides know it since titles can change and repeat.
+func sameAlbum(id, title, otherID, otherTitle string) bool {
+	if id != "" && otherID != "" {
+		return id == otherID
+	}
+
+	return title == otherTitle
+}
+
+// HistoryStore keeps track of what has been downloaded so repeated runs can skip it.
+//
+// Applications embedding the Downloader can provide their own implementation, e.g.
+// backed by their own database, through WithHistoryStore.
+type HistoryStore interface {
+	// List returns every entry in the order they were added.
+	List() ([]HistoryEntry, error)
+	// Contains reports whether the user has already downloaded the album of entry in its
+	// file type. Only the ItemID, Title and FileType of entry are set.
+	Contains(user *User, entry HistoryEntry) (bool, error)
+	// Add records a download.
+	Add(entry HistoryEntry) error
+	// Delete removes every record of the entry's album and file type for its account.
+	Delete(entry HistoryEntry) error
+}
+
+// MemoryHistory is a HistoryStore that only lives as long as the process.
+type MemoryHistory struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+}
+
+// NewMemoryHistory creates an empty in-memory history.
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{}
+}
+
+// List returns a copy of every entry.
+func (h *MemoryHistory) List() ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]HistoryEntry(nil), h.entries...), nil
+}
+
+// Contains reports whether the user has already downloaded the album of entry in its file type.
+func (h *MemoryHistory) Contains(user *User, entry HistoryEntry) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, e := range h.entries {
+		if sameAlbum(e.ItemID, e.Title, entry.ItemID, entry.Title) && e.FileType == entry.FileType && e.ownedBy(user) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Add records a download.
+func (h *MemoryHistory) Add(entry HistoryEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, entry)
+
+	return nil
+}
+
+// Delete removes every record of the entry's album and file type for its account.
+func (h *MemoryHistory) Delete(entry HistoryEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	kept := h.entries[:0]
+
+	for _, e := range h.entries {
+		if !entry.sameItem(e) {
+			kept = append(kept, e)
+		}
+	}
+
+	h.entries = kept
+
+	return nil
+}
+
+// FileHistory is an append only log of downloads, stored as JSON lines in the .bcdl directory.
+// It is the default HistoryStore.
+type FileHistory struct {
+	memory MemoryHistory
+	path   string
+}
+
+// LoadHistory reads the history file at path. A missing file results in an empty history.
+func LoadHistory(path string) (*FileHistory, error) {
+	h := &FileHistory{path: path}
+
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not open history: %w", err)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		var entry HistoryEntry
+
+		// Skip lines that were only partially written
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		h.memory.entries = append(h.memory.entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read history: %w", err)
+	}
+
+	return h, nil
+}
+
+// List returns every entry in the file.
+func (h *FileHistory) List() ([]HistoryEntry, error) {
+	return h.memory.List()
+}
+
+// Contains reports whether the user has already downloaded the album of entry in its file type.
+func (h *FileHistory) Contains(user *User, entry HistoryEntry) (bool, error) {
+	return h.memory.Contains(user, entry)
+}
+
+// Add appends the entry to the history file.
+func (h *FileHistory) Add(entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	h.memory.mu.Lock()
+	defer h.memory.mu.Unlock()
+
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return fmt.Errorf("Could not open history: %w", err)
+	}
+
+	defer file.Close()
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Could not write history: %w", err)
+	}
+
+	h.memory.entries = append(h.memory.entries, entry)
+
+	return nil
+}
+
+// Delete removes the matching entries and rewrites the history file.
+func (h *FileHistory) Delete(entry HistoryEntry) error {
+	if err := h.memory.Delete(entry); err != nil {
+		return err
+	}
+
+	h.memory.mu.Lock()
+	defer h.memory.mu.Unlock()
+
+	return writeHistoryFile(h.path, h.memory.entries)
+}
+
+// writeHistoryFile replaces the history file with entries. The new contents are written
+// to a temporary file first so a crash never leaves a truncated history behind.
+func writeHistoryFile(path string, entries []HistoryEntry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".history-*")
+
+	if err != nil {
+		return fmt.Errorf("Could not rewrite history: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := errors.Join(writer.Flush(), tmp.Close()); err != nil {
+		return fmt.Errorf("Could not rewrite history: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// SQLHistory is a HistoryStore backed by a SQL database, such as SQLite.
+//
+// bcdl does not ship a database driver. The embedding application opens the
+// database with whichever driver it already uses and hands over the *sql.DB.
+// Queries use ? placeholders.
+type SQLHistory struct {
+	db *sql.DB
+}
+
+// NewSQLHistory creates the history table if needed and returns a store using it.
+// Tables created before item ids, artists, files, checksums and gifters were recorded get
+// the columns added.
+func NewSQLHistory(db *sql.DB) (*SQLHistory, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bcdl_history (
+		fan_id INTEGER NOT NULL DEFAULT 0,
+		username TEXT NOT NULL,
+		item_id TEXT NOT NULL DEFAULT '',
+		title TEXT NOT NULL,
+		artist TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL,
+		filetype TEXT NOT NULL,
+		file TEXT NOT NULL DEFAULT '',
+		sha256 TEXT NOT NULL DEFAULT '',
+		gifter TEXT NOT NULL DEFAULT '',
+		downloaded_at TEXT NOT NULL
+	)`)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not create history table: %w", err)
+	}
+
+	for _, column := range []string{"item_id", "artist", "file", "sha256", "gifter"} {
+		if _, err := db.Exec(`SELECT ` + column + ` FROM bcdl_history LIMIT 1`); err == nil {
+			continue
+		}
+
+		if _, err := db.Exec(`ALTER TABLE bcdl_history ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+			return nil, fmt.Errorf("Could not add %s to history table: %w", column, err)
+		}
+	}
+
+	return &SQLHistory{db: db}, nil
+}
+
+// List returns every entry in the order they were added.
+func (h *SQLHistory) List() ([]HistoryEntry, error) {
+	rows, err := h.db.Query(`SELECT fan_id, username, item_id, title, artist, url, filetype, file, sha256, gifter, downloaded_at FROM bcdl_history ORDER BY downloaded_at`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var entries []HistoryEntry
+
+	for rows.Next() {
+		var entry HistoryEntry
+		var downloadedAt string
+
+		if err := rows.Scan(&entry.FanID, &entry.Username, &entry.ItemID, &entry.Title, &entry.Artist, &entry.URL, &entry.FileType, &entry.File, &entry.SHA256, &entry.Gifter, &downloadedAt); err != nil {
+			return nil, err
+		}
+
+		entry.DownloadedAt, _ = time.Parse(time.RFC3339, downloadedAt)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Contains reports whether the user has already downloaded the album of entry in its file type.
+func (h *SQLHistory) Contains(user *User, entry HistoryEntry) (bool, error) {
+	var count int
+
+	err := h.db.QueryRow(`SELECT COUNT(*) FROM bcdl_history
+		WHERE ((item_id != '' AND ? != '' AND item_id = ?) OR ((item_id = '' OR ? = '') AND title = ?))
+		AND filetype = ?
+		AND ((fan_id != 0 AND ? != 0 AND fan_id = ?) OR ((fan_id = 0 OR ? = 0) AND username = ?))`,
+		entry.ItemID, entry.ItemID, entry.ItemID, entry.Title, string(entry.FileType),
+		user.fanID, user.fanID, user.fanID, user.username,
+	).Scan(&count)
+
+	return count > 0, err
+}
+
+// Add records a download.
+func (h *SQLHistory) Add(entry HistoryEntry) error {
+	_, err := h.db.Exec(`INSERT INTO bcdl_history (fan_id, username, item_id, title, artist, url, filetype, file, sha256, gifter, downloaded_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.FanID, entry.Username, entry.ItemID, entry.Title, entry.Artist, entry.URL, string(entry.FileType), entry.File, entry.SHA256, entry.Gifter, entry.DownloadedAt.Format(time.RFC3339),
+	)
+
+	return err
+}
+
+// Delete removes every record of the entry's album and file type for its account.
+func (h *SQLHistory) Delete(entry HistoryEntry) error {
+	_, err := h.db.Exec(`DELETE FROM bcdl_history
+		WHERE ((item_id != '' AND ? != '' AND item_id = ?) OR ((item_id = '' OR ? = '') AND title = ?))
+		AND filetype = ?
+		AND ((fan_id != 0 AND ? != 0 AND fan_id = ?) OR ((fan_id = 0 OR ? = 0) AND username = ?))`,
+		entry.ItemID, entry.ItemID, entry.ItemID, entry.Title, string(entry.FileType),
+		entry.FanID, entry.FanID, entry.FanID, entry.Username,
+	)
+
+	return err
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Hooks are shell commands run at points of a run's lifecycle, e.g. to mount an archive
+// drive before downloading, start a media server scan afterwards or get alerted when the
+// identity cookie stops working. Empty commands are skipped.
+//
+// Commands get the details of the run through environment variables: BCDL_EVENT,
+// BCDL_USERNAME, BCDL_DIRECTORY, BCDL_FILETYPE, and BCDL_ERROR when something failed.
+type Hooks struct {
+	// RunStart runs before anything else. The run is aborted when it fails.
+	RunStart string `toml:"on_run_start"`
+	// RunEnd runs when the run finished, whether it succeeded or not
+	RunEnd string `toml:"on_run_end"`
+	// AuthFailure runs when Bandcamp doesn't accept the identity cookie
+	AuthFailure string `toml:"on_auth_failure"`
+}
+
+// The events hooks run for, as passed in BCDL_EVENT.
+const (
+	HookRunStart    = "run-start"
+	HookRunEnd      = "run-end"
+	HookAuthFailure = "auth-failure"
+)
+
+// WithHooks runs the hook commands during Download.
+func WithHooks(hooks Hooks) func(*Downloader) {
+	return func(d *Downloader) {
+		d.hooks = hooks
+	}
+}
+
+// Merge returns h with every hook that is set in other replaced by other's command.
+func (h Hooks) Merge(other Hooks) Hooks {
+	if other.RunStart != "" {
+		h.RunStart = other.RunStart
+	}
+
+	if other.RunEnd != "" {
+		h.RunEnd = other.RunEnd
+	}
+
+	if other.AuthFailure != "" {
+		h.AuthFailure = other.AuthFailure
+	}
+
+	return h
+}
+
+// command returns the hook for the event.
+func (h Hooks) command(event string) string {
+	switch event {
+	case HookRunStart:
+		return h.RunStart
+	case HookRunEnd:
+		return h.RunEnd
+	case HookAuthFailure:
+		return h.AuthFailure
+	}
+
+	return ""
+}
+
+// runHook runs the hook for the event, if there is one, and waits for it to finish.
+// runErr is what went wrong in the run, if anything.
+func (d *Downloader) runHook(event string, runErr error) error {
+	command := d.hooks.command(event)
+
+	if command == "" {
+		return nil
+	}
+
+	target := d.formatTargets()[0]
+
+	env := append(os.Environ(),
+		"BCDL_EVENT="+event,
+		"BCDL_USERNAME="+d.user.username,
+		"BCDL_DIRECTORY="+target.Dir,
+		"BCDL_FILETYPE="+string(target.FileType),
+	)
+
+	if runErr != nil {
+		env = append(env, "BCDL_ERROR="+runErr.Error())
+	}
+
+	shell, flag := "sh", "-c"
+
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	cmd := exec.Command(shell, flag, command)
+	cmd.Env = env
+
+	// Output is only shown when something goes wrong so it doesn't garble the dashboard
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("The %s hook failed: %w: %s", event, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
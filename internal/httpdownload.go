@@ -0,0 +1,616 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DownloadEngine is how albums are downloaded.
+type DownloadEngine string
+
+const (
+	// EngineBrowser clicks through every download page in Chromium, like a person would
+	EngineBrowser DownloadEngine = "browser"
+	// EngineHTTP reads the download links off the download pages and fetches them with
+	// plain HTTP requests, which is a lot faster and lighter than a browser but depends
+	// more on how Bandcamp builds its pages
+	EngineHTTP DownloadEngine = "http"
+	// EngineHybrid has the browser prepare every download like EngineBrowser, then
+	// transfers the file over HTTP like EngineHTTP, with progress and resuming
+	EngineHybrid DownloadEngine = "hybrid"
+)
+
+// ParseDownloadEngine parses the name of a DownloadEngine.
+func ParseDownloadEngine(s string) (DownloadEngine, error) {
+	switch engine := DownloadEngine(strings.ToLower(s)); engine {
+	case EngineBrowser, EngineHTTP, EngineHybrid:
+		return engine, nil
+	}
+
+	return "", fmt.Errorf("Unknown download engine %q, use browser, http or hybrid", s)
+}
+
+// WithEngine picks how albums are downloaded. With EngineHTTP no browser is started at all,
+// the collection is read over the API like ListCollection does.
+func WithEngine(engine DownloadEngine) func(*Downloader) {
+	return func(d *Downloader) {
+		d.engine = engine
+	}
+}
+
+// How often the preparation of a download is checked on.
+const prepareInterval = 2 * time.Second
+
+// downloadClient transfers files, which can take a long time, so only the wait for the
+// server to answer is bounded.
+var downloadClient = &http.Client{Transport: &http.Transport{
+	Proxy:                 proxyFor,
+	ResponseHeaderTimeout: 5 * time.Minute,
+}}
+
+// fetchDownloadPage reads the page data of the entry's download page, and whether the
+// item can't be downloaded from this region. The message on the page only decides that
+// when the page data doesn't.
+func fetchDownloadPage(ctx context.Context, user *User, entry CollectionEntry) (downloadPageData, bool, error) {
+	var data downloadPageData
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.url.String(), nil)
+
+	if err != nil {
+		return data, false, err
+	}
+
+	body, err := apiRequest(user, req)
+
+	if err != nil {
+		return data, false, fmt.Errorf("Could not goto %s: %w", entry.url.String(), err)
+	}
+
+	locked := regionLockPattern.Match(body)
+	match := pageDataPattern.FindSubmatch(body)
+
+	if match == nil {
+		return data, locked, fmt.Errorf("No page data on the download page of %s", entry.title)
+	}
+
+	if err = json.Unmarshal([]byte(html.UnescapeString(string(match[1]))), &data); err != nil {
+		return data, locked, fmt.Errorf("Could not parse page data: %w", err)
+	}
+
+	if dataLocked, ok := data.regionLocked(); ok {
+		locked = dataLocked
+	}
+
+	return data, locked, nil
+}
+
+// statDownloadURL returns where the state of the download at link can be checked: the
+// same address under /statdownload/ instead of /download/.
+func statDownloadURL(link string) (string, error) {
+	u, err := url.Parse(link)
+
+	if err != nil || !strings.Contains(u.Path, "/download/") {
+		return "", fmt.Errorf("Unexpected download link %q", link)
+	}
+
+	u.Path = strings.Replace(u.Path, "/download/", "/statdownload/", 1)
+	query := u.Query()
+	query.Set(".vrs", "1")
+	query.Set(".rand", strconv.FormatInt(time.Now().UnixNano(), 10))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// statDownload is the answer of a /statdownload/ request.
+type statDownload struct {
+	Result      string `json:"result"`
+	DownloadURL string `json:"download_url"`
+	// RetryURL replaces the download link when it expired
+	RetryURL string `json:"retry_url"`
+}
+
+// waitForDownloadURL waits up to timeout for Bandcamp to prepare the download at link and
+// returns the address the file can be fetched from. When the state can't be read, the
+// link is tried as is, Bandcamp redirects it to the file once it is prepared.
+func waitForDownloadURL(ctx context.Context, user *User, link string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		statURL, err := statDownloadURL(link)
+
+		if err != nil {
+			return link, nil
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, statURL, nil)
+
+		if err != nil {
+			return "", err
+		}
+
+		body, err := apiRequest(user, req)
+
+		if err != nil {
+			return "", fmt.Errorf("Could not check on the download: %w", err)
+		}
+
+		// The answer may be wrapped in a JavaScript callback
+		var stat statDownload
+		start := bytes.Index(body, []byte(`{"`))
+
+		if start < 0 || json.NewDecoder(bytes.NewReader(body[start:])).Decode(&stat) != nil {
+			return link, nil
+		}
+
+		if stat.Result == "ok" {
+			if stat.DownloadURL != "" {
+				return stat.DownloadURL, nil
+			}
+
+			return link, nil
+		}
+
+		if stat.RetryURL != "" {
+			link = stat.RetryURL
+		}
+
+		if time.Now().After(deadline) {
+			return "", errPrepareTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(prepareInterval):
+		}
+	}
+}
+
+// httpDownload is a file fetched by fetchDownload, kept in a temporary file until it is
+// saved.
+type httpDownload struct {
+	name  string
+	path  string
+	moved bool
+}
+
+// SuggestedFilename is the name Bandcamp sent the file with.
+func (dl *httpDownload) SuggestedFilename() string {
+	return dl.name
+}
+
+// Path returns the temporary file, until SaveAs moved it.
+func (dl *httpDownload) Path() (string, error) {
+	if dl.moved {
+		return "", errors.New("The download was saved already")
+	}
+
+	return dl.path, nil
+}
+
+// SaveAs moves the temporary file to path, copying it when that is on another disk.
+func (dl *httpDownload) SaveAs(path string) error {
+	if err := os.Rename(dl.path, path); err == nil {
+		dl.moved = true
+		return nil
+	}
+
+	src, err := os.Open(dl.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	dst, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+
+	if err = errors.Join(err, dst.Close()); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the temporary file, unless it was moved into place.
+func (dl *httpDownload) Delete() {
+	if !dl.moved {
+		os.Remove(dl.path)
+	}
+}
+
+// How often a transfer is attempted, resuming where the last attempt stopped, and how
+// often progress is reported.
+const (
+	transferAttempts = 5
+	progressInterval = time.Second
+)
+
+// fetchDownload downloads the file at link into a temporary file in dir. The cookies are
+// only sent to Bandcamp, not its CDN. Files sent without a name are named stem plus the
+// extension of ft, or .zip for archives.
+//
+// Interrupted transfers are resumed with Range requests. With a key, what a failed transfer
+// received is kept in dir and resumed by the next call with the same key, see resume.
+// With more than one segment, large files are fetched in that many ranges at once, see
+// fetchSegmented. progress, if set, is called with the bytes received so far and the size
+// of the file, or -1 when it isn't known.
+func fetchDownload(ctx context.Context, link string, cookies []*http.Cookie, dir, key, stem string, ft FileType, segments int, limits transferLimits, progress func(done, total int64)) (*httpDownload, error) {
+	t := &transfer{ctx: ctx, link: link, cookies: cookies, total: -1, limits: limits, progress: progress}
+	var err error
+
+	if limits.timeout > 0 {
+		t.deadline = time.Now().Add(limits.timeout)
+	}
+
+	if key != "" {
+		err = t.resume(partialPath(dir, key))
+	} else {
+		t.file, err = os.CreateTemp(dir, ".bcdl-*")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	dl := &httpDownload{path: t.file.Name()}
+	// Segments leave gaps in the file when they fail, so only single streams are resumed
+	segmented := false
+
+	if segments > 1 && t.written == 0 {
+		segmented, err = t.fetchSegmented(stem, segments)
+	}
+
+	if !segmented && err == nil {
+		err = t.fetchStream(stem)
+	}
+
+	if err = errors.Join(err, t.file.Close()); err != nil {
+		if t.partial != "" && !segmented && t.written > 0 {
+			log.Printf("Keeping the %s of %s received so far to resume on the next run", FormatSize(t.written), stem)
+		} else {
+			t.forget()
+			os.Remove(dl.path)
+		}
+
+		return nil, err
+	}
+
+	t.forget()
+
+	dl.name = t.name
+
+	if dl.name == "" && t.zip {
+		dl.name = stem + ".zip"
+	} else if dl.name == "" {
+		dl.name = stem + trackExtensions[ft]
+	}
+
+	return dl, nil
+}
+
+// transfer is the state of fetchDownload between attempts.
+type transfer struct {
+	// ctx stops the transfer once it is done
+	ctx     context.Context
+	link    string
+	cookies []*http.Cookie
+	file    *os.File
+	total   int64
+	name    string
+	zip     bool
+	// partial is where the details of a resumable transfer are kept, see resume
+	partial string
+	// limits bound every request of the transfer, which has to be done by deadline if it is set
+	limits   transferLimits
+	deadline time.Time
+
+	// mu guards the progress, which segments add to at the same time
+	mu       sync.Mutex
+	written  int64
+	progress func(done, total int64)
+	reported time.Time
+}
+
+// fetchStream downloads the file in one stream, resuming where an attempt stopped.
+func (t *transfer) fetchStream(stem string) error {
+	for attempt := 1; ; attempt++ {
+		err := t.fetch()
+
+		if err == nil || errors.Is(err, errTransferTimeout) || t.ctx.Err() != nil || attempt == transferAttempts {
+			return err
+		}
+
+		log.Printf("Transfer of %s stopped at %s, resuming: %v", stem, FormatSize(t.written), err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// request requests the file, only the bytes in rng unless it is empty.
+func (t *transfer) request(ctx context.Context, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.link, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	setClientHeaders(req)
+
+	// The file itself comes from Bandcamp's CDN, which doesn't need to know who is asking
+	if host := req.URL.Hostname(); host == bcUrl.Host || strings.HasSuffix(host, "."+bcUrl.Host) {
+		for _, cookie := range t.cookies {
+			req.AddCookie(cookie)
+		}
+	}
+
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+
+	return downloadClient.Do(req)
+}
+
+// describe takes the name and type of the file from the headers of resp.
+func (t *transfer) describe(resp *http.Response) {
+	t.zip = strings.Contains(resp.Header.Get("Content-Type"), "zip")
+
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		t.name = path.Base(params["filename"])
+	}
+}
+
+// contentSize returns the size of the whole file from the Content-Range header of a
+// response to a Range request, which looks like "bytes 100-199/200".
+func contentSize(resp *http.Response) (int64, bool) {
+	_, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+
+	return n, err == nil
+}
+
+// fetch requests the rest of the file after what was written and appends it to the file.
+// A server that ignores the range sends all of it again, which starts the file over.
+func (t *transfer) fetch() error {
+	// All there, the last run stopped right before saving it
+	if t.written > 0 && t.written == t.total {
+		return nil
+	}
+
+	rng := ""
+
+	if t.written > 0 {
+		rng = fmt.Sprintf("bytes=%d-", t.written)
+	}
+
+	resp, stop, err := t.watchedRequest(rng)
+
+	if err != nil {
+		return err
+	}
+
+	defer stop()
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && t.written > 0:
+		size, ok := contentSize(resp)
+
+		// Resumed from an earlier run, but Bandcamp sends another file by now
+		if ok && t.total >= 0 && size != t.total {
+			err := fmt.Errorf("The file changed from %s to %s since the last attempt", FormatSize(t.total), FormatSize(size))
+			t.total = -1
+
+			return errors.Join(err, t.restart())
+		}
+
+		if ok {
+			t.total = size
+		}
+	case resp.StatusCode == http.StatusOK:
+		if err := t.restart(); err != nil {
+			return err
+		}
+
+		t.total = resp.ContentLength
+		t.describe(resp)
+	default:
+		return fmt.Errorf("%s answered with %s", resp.Request.URL.Host, resp.Status)
+	}
+
+	if err := t.remember(); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(t, resp.Body)
+
+	if err == nil && t.total >= 0 && t.written != t.total {
+		err = fmt.Errorf("Only got %s of %s", FormatSize(t.written), FormatSize(t.total))
+	}
+
+	return err
+}
+
+// Write appends p to the file.
+func (t *transfer) Write(p []byte) (int, error) {
+	n, err := t.file.Write(p)
+	t.advance(n)
+
+	return n, err
+}
+
+// advance adds n received bytes to the progress, reporting it now and then.
+func (t *transfer) advance(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.written += int64(n)
+
+	if t.progress != nil && (time.Since(t.reported) >= progressInterval || t.written == t.total) {
+		t.reported = time.Now()
+		t.progress(t.written, t.total)
+	}
+}
+
+// processHTTPJob is processJob for EngineHTTP.
+func processHTTPJob(ctx context.Context, job downloadJob, user *User, opts DownloadOpts) (savedFile, error) {
+	var saved savedFile
+
+	start := time.Now()
+	page, locked, err := fetchDownloadPage(ctx, user, job.Entry)
+
+	if err != nil {
+		return saved, err
+	}
+
+	job.timings.since(PhaseNavigate, start)
+	start = time.Now()
+
+	data := PathData{Album: job.Entry.title, Artist: job.Entry.artist, Purchased: job.Entry.purchased, FileType: job.filetype, Gift: job.Entry.gift, Gifter: job.Entry.gifter}
+
+	var sizes map[FileType]int64
+
+	if info, err := page.itemInfo(job.Entry.title); err == nil {
+		data.Artist = info.Artist
+		data.Released = info.Released
+		saved.artist = info.Artist
+		sizes = info.Sizes
+	} else if job.library.template != "" {
+		log.Printf("Could not read the details of %s for its path: %v", job.Entry.title, err)
+	}
+
+	if saved, ok := job.onDisk(data, sizes, saved); ok {
+		return saved, nil
+	}
+
+	// Rather fail now than with a full disk halfway through the transfer
+	if size, ok := sizes[job.filetype]; ok {
+		release, err := job.library.reserveSpace(size)
+
+		if err != nil {
+			return saved, err
+		}
+
+		defer release()
+	}
+
+	var link string
+
+	if len(page.DigitalItems) > 0 {
+		link = page.DigitalItems[0].Downloads[job.filetype].URL
+	}
+
+	if link == "" {
+		if locked {
+			return saved, ErrRegionLocked
+		}
+
+		return saved, fmt.Errorf("No %s download on the download page of %s", job.filetype, job.Entry.title)
+	}
+
+	job.timings.since(PhaseSelect, start)
+
+	// Bandcamp builds the archive on their end before the link becomes usable
+	opts.OnPrepareStart.call(job.item())
+	start = time.Now()
+	link, err = waitForDownloadURL(ctx, user, link, time.Duration(job.timeoutMs)*time.Millisecond)
+
+	if err != nil {
+		return saved, fmt.Errorf("Could not prepare download: %w", err)
+	}
+
+	job.timings.since(PhasePrepare, start)
+	opts.OnPrepareDone.call(job.item())
+
+	return job.transfer(ctx, link, userCookies(user), data, saved, opts)
+}
+
+// transfer downloads the prepared file at link with fetchDownload and saves it, for
+// EngineHTTP and EngineHybrid. It stops once ctx is done.
+func (job downloadJob) transfer(ctx context.Context, link string, cookies []*http.Cookie, data PathData, saved savedFile, opts DownloadOpts) (savedFile, error) {
+	// Next to the library, moving the file into place is a rename
+	dir := os.TempDir()
+
+	if _, ok := job.library.storage.(*LocalStorage); ok {
+		dir = job.library.workDir()
+	}
+
+	var progress func(done, total int64)
+
+	if opts.OnProgress != nil {
+		item := job.item()
+		item.Artist = data.Artist
+
+		progress = func(done, total int64) {
+			opts.OnProgress(item, done, total)
+		}
+	}
+
+	job.transferStarted()
+
+	start := time.Now()
+	key := job.Entry.key() + "-" + string(job.filetype)
+	dl, err := fetchDownload(ctx, link, cookies, dir, key, data.Artist+" - "+data.Album, job.filetype, job.segments, job.limits, progress)
+
+	if err != nil {
+		return saved, fmt.Errorf("Could not download file: %w", err)
+	}
+
+	job.timings.since(PhaseTransfer, start)
+
+	// Uploads are left to the post-processing workers too, which remove the file after
+	if job.post != nil {
+		transferred := saved
+		saved.post = func() (savedFile, error) {
+			defer dl.Delete()
+			return job.store(dl, data, transferred)
+		}
+
+		return saved, nil
+	}
+
+	defer dl.Delete()
+
+	return job.store(dl, data, saved)
+}
+
+// store saves the transferred file into the library and finishes the job.
+func (job downloadJob) store(dl fetchedFile, data PathData, saved savedFile) (savedFile, error) {
+	start := time.Now()
+	name, err := job.library.save(dl, data)
+
+	if err != nil {
+		return saved, fmt.Errorf("Could not download file: %w", err)
+	}
+
+	job.timings.since(PhaseSave, start)
+
+	return job.finish(dl, name, saved), nil
+}
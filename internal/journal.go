@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Statuses of the jobs in the queue a run keeps, see WithResume.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// ErrNothingToResume is returned by runs with WithResume when the last run finished.
+var ErrNothingToResume = errors.New("Nothing to resume, the last run finished")
+
+// WithResume downloads what the last run left when it crashed or was stopped, going by the
+// queue it kept in the .bcdl directory instead of reading the collection again. Albums that
+// were in flight start over, their transfers picking up where they stopped. Albums that
+// failed are tried again.
+func WithResume() func(*Downloader) {
+	return func(d *Downloader) {
+		d.resume = true
+	}
+}
+
+// queuedJob is a line of queue.jsonl in the .bcdl directory. The file starts with every job
+// the run planned, followed by a line for every change of their status, so it only grows
+// during the run and a crash loses at most the last line. Status lines leave out what the
+// planned line said about the item.
+type queuedJob struct {
+	FanID     int64     `json:"fan_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	ItemID    string    `json:"item_id,omitempty"`
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	ItemURL   string    `json:"item_url,omitempty"`
+	ArtID     string    `json:"art_id,omitempty"`
+	BandID    string    `json:"band_id,omitempty"`
+	Purchased time.Time `json:"purchased,omitempty"`
+	Gift      bool      `json:"gift,omitempty"`
+	Gifter    string    `json:"gifter,omitempty"`
+	Price     float64   `json:"price,omitempty"`
+	Currency  string    `json:"currency,omitempty"`
+	FileType  FileType  `json:"filetype"`
+	Status    string    `json:"status"`
+}
+
+// key identifies the job within the queue.
+func (q queuedJob) key() string {
+	return q.ItemID + "\x00" + q.Title + "\x00" + string(q.FileType)
+}
+
+// entry returns the collection entry the job was planned for.
+func (q queuedJob) entry() CollectionEntry {
+	entry := CollectionEntry{
+		id:        q.ItemID,
+		title:     q.Title,
+		artist:    q.Artist,
+		artID:     q.ArtID,
+		bandID:    q.BandID,
+		purchased: q.Purchased,
+		gift:      q.Gift,
+		gifter:    q.Gifter,
+		price:     q.Price,
+		currency:  q.Currency,
+	}
+
+	if u, err := url.Parse(q.URL); err == nil {
+		entry.url = *u
+	}
+
+	if u, err := url.Parse(q.ItemURL); err == nil {
+		entry.itemURL = *u
+	}
+
+	return entry
+}
+
+// runJournal writes the queue of a run to the .bcdl directory as it progresses.
+type runJournal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	user *User
+}
+
+// journalPath returns where the queue of the runs in stateDir is kept.
+func journalPath(stateDir string) string {
+	return filepath.Join(stateDir, "queue.jsonl")
+}
+
+// createJournal starts the queue of a new run of user in stateDir, replacing the last one.
+func createJournal(stateDir string, user *User) (*runJournal, error) {
+	path := journalPath(stateDir)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not save the queue: %w", err)
+	}
+
+	return &runJournal{path: path, file: file, user: user}, nil
+}
+
+// plan adds the job to the queue.
+func (j *runJournal) plan(job downloadJob) {
+	if j == nil {
+		return
+	}
+
+	entry := job.Entry
+
+	j.write(queuedJob{
+		FanID:     j.user.fanID,
+		Username:  j.user.username,
+		ItemID:    entry.id,
+		Title:     entry.title,
+		Artist:    entry.artist,
+		URL:       entry.url.String(),
+		ItemURL:   entry.itemURL.String(),
+		ArtID:     entry.artID,
+		BandID:    entry.bandID,
+		Purchased: entry.purchased,
+		Gift:      entry.gift,
+		Gifter:    entry.gifter,
+		Price:     entry.price,
+		Currency:  entry.currency,
+		FileType:  job.filetype,
+		Status:    jobQueued,
+	})
+}
+
+// update records the new status of the job.
+func (j *runJournal) update(job downloadJob, status string) {
+	if j == nil {
+		return
+	}
+
+	j.write(queuedJob{ItemID: job.Entry.id, Title: job.Entry.title, FileType: job.filetype, Status: status})
+}
+
+// write appends the line to the queue. A line that is missing only means the job is
+// downloaded again should the run be resumed, so errors are just logged.
+func (j *runJournal) write(line queuedJob) {
+	contents, err := json.Marshal(line)
+
+	if err != nil {
+		log.Printf("Could not save the queue: %v", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err = j.file.Write(append(contents, '\n')); err != nil {
+		log.Printf("Could not save the queue: %v", err)
+	}
+}
+
+// close keeps the queue for the next run to resume.
+func (j *runJournal) close() {
+	if j == nil {
+		return
+	}
+
+	if err := j.file.Close(); err != nil {
+		log.Printf("Could not save the queue: %v", err)
+	}
+}
+
+// finish removes the queue of a run that went through it all.
+func (j *runJournal) finish() {
+	if j == nil {
+		return
+	}
+
+	j.file.Close()
+
+	if err := os.Remove(j.path); err != nil {
+		log.Printf("Could not remove the queue: %v", err)
+	}
+}
+
+// resumableRun is what the last run left to download.
+type resumableRun struct {
+	fanID   int64
+	entries []CollectionEntry
+	// running and failed count the jobs that were in flight and that failed
+	running int
+	failed  int
+}
+
+// loadJournal reads the queue the last run of user in stateDir left, returning
+// ErrNothingToResume if there is none.
+func loadJournal(stateDir string, user *User) (resumableRun, error) {
+	var run resumableRun
+
+	planned, status, err := readJournal(stateDir)
+
+	if err != nil {
+		return run, err
+	}
+
+	for _, job := range planned {
+		if job.Username != user.username {
+			return run, fmt.Errorf("The last run was for %s, not %s", job.Username, user.username)
+		}
+
+		run.fanID = job.FanID
+	}
+
+	seen := map[string]bool{}
+
+	for _, job := range planned {
+		switch status[job.key()] {
+		case jobDone:
+			continue
+		case jobRunning:
+			run.running++
+		case jobFailed:
+			run.failed++
+		}
+
+		entry := job.entry()
+
+		if !seen[entry.key()] {
+			seen[entry.key()] = true
+			run.entries = append(run.entries, entry)
+		}
+	}
+
+	if len(run.entries) == 0 {
+		return run, ErrNothingToResume
+	}
+
+	return run, nil
+}
+
+// readJournal reads the queue the last run in stateDir left: the jobs it planned and the
+// last status of every job by key. It returns ErrNothingToResume if there is none.
+func readJournal(stateDir string) ([]queuedJob, map[string]string, error) {
+	file, err := os.Open(journalPath(stateDir))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNothingToResume
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read the queue: %w", err)
+	}
+
+	defer file.Close()
+
+	var planned []queuedJob
+	status := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+
+	for scanner.Scan() {
+		var line queuedJob
+
+		// The last line is cut short if the run crashed while writing it
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+
+		if line.Username != "" {
+			planned = append(planned, line)
+		}
+
+		status[line.key()] = line.Status
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Could not read the queue: %w", err)
+	}
+
+	return planned, status, nil
+}
+
+// resumablePartials returns the names of the partial transfers, see partialPath, of the
+// jobs the queues of the library at dir still list as unfinished, which WithResume picks
+// up again.
+func resumablePartials(dir string) map[string]bool {
+	names := map[string]bool{}
+
+	for _, stateDir := range libraryStateDirs(dir) {
+		planned, status, err := readJournal(stateDir)
+
+		if err != nil {
+			continue
+		}
+
+		for _, job := range planned {
+			if status[job.key()] == jobDone {
+				continue
+			}
+
+			name := filepath.Base(partialPath("", job.entry().key()+"-"+string(job.FileType)))
+			names[name] = true
+			names[name+".json"] = true
+		}
+	}
+
+	return names
+}
+
+// resumeCollection returns the entries the last run left to download.
+func (d *Downloader) resumeCollection(stateDir string) ([]CollectionEntry, error) {
+	run, err := loadJournal(stateDir, d.user)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if run.fanID != 0 {
+		d.user.fanID = run.fanID
+	}
+
+	log.Printf("Resuming the last run: %d albums left, %d of the downloads were in flight and %d failed", len(run.entries), run.running, run.failed)
+
+	return run.entries, nil
+}
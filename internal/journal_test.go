@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadJournal(t *testing.T) {
+	const (
+		planA = `{"fan_id":1,"username":"fan","item_id":"a1","title":"A","filetype":"flac","status":"queued"}`
+		planB = `{"fan_id":1,"username":"fan","item_id":"a2","title":"B","filetype":"flac","status":"queued"}`
+		doneA = `{"item_id":"a1","title":"A","filetype":"flac","status":"done"}`
+	)
+
+	tests := []struct {
+		name        string
+		lines       []string
+		wantTitles  string
+		wantRunning int
+		wantFailed  int
+		wantErr     error
+	}{
+		{"nothing done", []string{planA, planB}, "A,B", 0, 0, nil},
+		{"some done", []string{planA, planB, doneA}, "B", 0, 0, nil},
+		{"truncated planned line", []string{planA, planB[:40]}, "A", 0, 0, nil},
+		{"truncated status line", []string{planA, planB, doneA[:30]}, "A,B", 0, 0, nil},
+		{
+			"running and failed",
+			[]string{planA, planB, `{"item_id":"a1","title":"A","filetype":"flac","status":"running"}`, `{"item_id":"a2","title":"B","filetype":"flac","status":"failed"}`},
+			"A,B", 1, 1, nil,
+		},
+		{"all done", []string{planA, doneA}, "", 0, 0, ErrNothingToResume},
+		{"no queue", nil, "", 0, 0, ErrNothingToResume},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if tt.lines != nil {
+				if err := os.WriteFile(journalPath(dir), []byte(strings.Join(tt.lines, "\n")), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			run, err := loadJournal(dir, &User{username: "fan"})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("loadJournal() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var titles []string
+
+			for _, entry := range run.entries {
+				titles = append(titles, entry.title)
+			}
+
+			if got := strings.Join(titles, ","); got != tt.wantTitles || run.running != tt.wantRunning || run.failed != tt.wantFailed {
+				t.Errorf("loadJournal() = %q, %d running, %d failed, want %q, %d running, %d failed",
+					got, run.running, run.failed, tt.wantTitles, tt.wantRunning, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestLoadJournalOtherUser(t *testing.T) {
+	dir := t.TempDir()
+	line := `{"fan_id":1,"username":"fan","item_id":"a1","title":"A","filetype":"flac","status":"queued"}`
+
+	if err := os.WriteFile(journalPath(dir), []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadJournal(dir, &User{username: "someone-else"}); err == nil || errors.Is(err, ErrNothingToResume) {
+		t.Errorf("loadJournal() error = %v, want the run to belong to someone else", err)
+	}
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultKeepAlive is how often DefaultDownloader checks the session during a run.
+const DefaultKeepAlive = 15 * time.Minute
+
+// How often the session is checked while it is signed out, waiting for it to come back,
+// and at most when downloads fail.
+const (
+	signedOutInterval = 5 * time.Minute
+	failureCheckDelay = time.Minute
+)
+
+// WithKeepAlive checks every interval during a run that Bandcamp still accepts the session,
+// which also keeps it from going stale during runs of several hours, and right away when a
+// download fails. Once it is signed out, no new albums start and OnSignedOut is called and
+// the auth failure hook runs, instead of every remaining album failing. The albums that
+// failed meanwhile are queued again, and the run goes on if the session is accepted again.
+// Zero turns it off.
+func WithKeepAlive(interval time.Duration) func(*Downloader) {
+	return func(d *Downloader) {
+		d.keepAlive = interval
+	}
+}
+
+// sessionKeeper checks the session of a run every so often.
+type sessionKeeper struct {
+	mu sync.Mutex
+	// last is when the session was checked
+	last time.Time
+	// gate holds workers back while the session is signed out
+	gate   *pauseGate
+	verify func() error
+	// signedOut is called once the session was found signed out
+	signedOut func(err error)
+}
+
+// keepSessionAlive checks the session with verify every interval until done is closed.
+func keepSessionAlive(interval time.Duration, verify func() error, signedOut func(error), done <-chan struct{}) *sessionKeeper {
+	if interval <= 0 {
+		return nil
+	}
+
+	k := &sessionKeeper{last: time.Now(), gate: newPauseGate(), verify: verify, signedOut: signedOut}
+
+	go k.run(interval, done)
+
+	return k
+}
+
+// run checks the session every interval, and more often while it is signed out.
+func (k *sessionKeeper) run(interval time.Duration, done <-chan struct{}) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		if k.check() {
+			timer.Reset(interval)
+		} else {
+			timer.Reset(signedOutInterval)
+		}
+	}
+}
+
+// check verifies the session, pausing the workers when it was signed out and letting them
+// go on once it is back. It reports whether the session is fine.
+func (k *sessionKeeper) check() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.last = time.Now()
+	err := k.verify()
+
+	if err != nil && !errors.Is(err, ErrNotSignedIn) {
+		// Most likely the network, which the downloads notice themselves
+		log.Printf("Could not check the session: %v", err)
+		return true
+	}
+
+	switch {
+	case err != nil && !k.gate.isPaused():
+		k.gate.pause()
+		log.Printf("Bandcamp signed the session out, holding back the remaining downloads: %v", err)
+		k.signedOut(err)
+	case err == nil && k.gate.isPaused():
+		k.gate.resume()
+		log.Println("Signed in again, continuing the downloads")
+	}
+
+	return err == nil
+}
+
+// interrupted reports whether a job that just failed is down to the session being signed
+// out, so it is queued again rather than counted. Unless it was checked in the last minute,
+// the session is checked first.
+func (k *sessionKeeper) interrupted() bool {
+	if k == nil {
+		return false
+	}
+
+	k.mu.Lock()
+	recent := time.Since(k.last) < failureCheckDelay
+	k.mu.Unlock()
+
+	if !recent {
+		k.check()
+	}
+
+	return k.gate.isPaused()
+}
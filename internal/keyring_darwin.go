@@ -0,0 +1,46 @@
+//go:build darwin
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringSet stores secret in the login Keychain, replacing any previous value. The command
+// is sent to `security -i` on stdin so the secret never shows up in the process list.
+func keyringSet(service, account, secret string) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s \"%s\" -a \"%s\" -w \"%s\"\n", quote.Replace(service), quote.Replace(account), quote.Replace(secret)))
+
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	// Interactive mode exits fine when a command fails, so check that it was stored
+	if stored, err := keyringGet(service, account); err != nil || stored != secret {
+		return fmt.Errorf("Could not store the secret in the Keychain")
+	}
+
+	return nil
+}
+
+// keyringGet looks up a secret stored by keyringSet.
+func keyringGet(service, account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// keyringDelete removes a secret stored by keyringSet.
+func keyringDelete(service, account string) error {
+	return exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run()
+}
@@ -0,0 +1,52 @@
+//go:build !darwin && !windows
+
+package internal
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// keyringSet stores secret with libsecret's secret-tool, reading it from stdin so it
+// never shows up in the process list.
+func keyringSet(service, account, secret string) error {
+	tool, err := exec.LookPath("secret-tool")
+
+	if err != nil {
+		return errNoKeyring
+	}
+
+	cmd := exec.Command(tool, "store", "--label", service+" "+account, "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+
+	return cmd.Run()
+}
+
+// keyringGet looks up a secret stored by keyringSet.
+func keyringGet(service, account string) (string, error) {
+	tool, err := exec.LookPath("secret-tool")
+
+	if err != nil {
+		return "", errNoKeyring
+	}
+
+	out, err := exec.Command(tool, "lookup", "service", service, "account", account).Output()
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// keyringDelete removes a secret stored by keyringSet.
+func keyringDelete(service, account string) error {
+	tool, err := exec.LookPath("secret-tool")
+
+	if err != nil {
+		return errNoKeyring
+	}
+
+	return exec.Command(tool, "clear", "service", service, "account", account).Run()
+}
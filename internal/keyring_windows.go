@@ -0,0 +1,110 @@
+//go:build windows
+
+package internal
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredFree  = advapi32.NewProc("CredFree")
+	procCredDel   = advapi32.NewProc("CredDeleteW")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+// credential mirrors the Win32 CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keyringSet stores secret in the Windows Credential Manager.
+func keyringSet(service, account, secret string) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+
+	if err != nil {
+		return err
+	}
+
+	user, err := syscall.UTF16PtrFromString(account)
+
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(secret)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	if r, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return fmt.Errorf("CredWrite failed: %w", err)
+	}
+
+	return nil
+}
+
+// keyringGet looks up a secret stored by keyringSet.
+func keyringGet(service, account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+
+	if r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		return "", fmt.Errorf("CredRead failed: %w", err)
+	}
+
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// keyringDelete removes a secret stored by keyringSet.
+func keyringDelete(service, account string) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+
+	if err != nil {
+		return err
+	}
+
+	if r, _, err := procCredDel.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); r == 0 {
+		return fmt.Errorf("CredDelete failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ItemNote is what the user wrote down about an item of their collection, such as that
+// they own it on vinyl.
+type ItemNote struct {
+	// Item is how the note was attached: the title, "Artist - Title", the URL or the id
+	Item      string    `json:"item"`
+	Labels    []string  `json:"labels,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// HasLabel reports whether the item is labelled label, ignoring case.
+func (n ItemNote) HasLabel(label string) bool {
+	return slices.ContainsFunc(n.Labels, func(l string) bool { return strings.EqualFold(l, label) })
+}
+
+// matches reports whether the note was attached to the entry.
+func (n ItemNote) matches(entry CollectionEntry) bool {
+	item := strings.TrimSpace(n.Item)
+
+	return strings.EqualFold(item, entry.title) ||
+		strings.EqualFold(item, entry.artist+" - "+entry.title) ||
+		(entry.id != "" && item == entry.id) ||
+		item == entry.url.String() ||
+		item == entry.ItemURL()
+}
+
+// ItemNotes are the notes and labels of a library, kept in .bcdl/labels.json where they are
+// part of the state export. They describe items rather than files, so every account of a
+// shared library sees the same ones.
+type ItemNotes struct {
+	mu    sync.Mutex
+	path  string
+	notes []ItemNote
+}
+
+// LoadItemNotes reads the notes of the library at dir. A missing file results in no notes.
+func LoadItemNotes(dir string) (*ItemNotes, error) {
+	n := &ItemNotes{path: filepath.Join(dir, ".bcdl", "labels.json")}
+
+	contents, err := os.ReadFile(n.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return n, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read labels: %w", err)
+	}
+
+	if err = json.Unmarshal(contents, &n.notes); err != nil {
+		return nil, fmt.Errorf("Could not parse labels: %w", err)
+	}
+
+	return n, nil
+}
+
+// List returns every note, sorted by item.
+func (n *ItemNotes) List() []ItemNote {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	notes := slices.Clone(n.notes)
+	sort.Slice(notes, func(i, j int) bool { return strings.ToLower(notes[i].Item) < strings.ToLower(notes[j].Item) })
+
+	return notes
+}
+
+// Find returns the notes attached to the entry, merged into one. A nil ItemNotes has none.
+func (n *ItemNotes) Find(entry CollectionEntry) (ItemNote, bool) {
+	if n == nil {
+		return ItemNote{}, false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var found ItemNote
+	ok := false
+
+	for _, note := range n.notes {
+		if !note.matches(entry) {
+			continue
+		}
+
+		for _, label := range note.Labels {
+			if !found.HasLabel(label) {
+				found.Labels = append(found.Labels, label)
+			}
+		}
+
+		if note.Note != "" {
+			found.Note = strings.TrimSpace(found.Note + "\n" + note.Note)
+		}
+
+		found.Item = note.Item
+		ok = true
+	}
+
+	return found, ok
+}
+
+// Update adds and removes labels of item and replaces its note unless note is nil. Notes
+// left without labels and text are forgotten.
+func (n *ItemNotes) Update(item string, add, remove []string, note *string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	i := slices.IndexFunc(n.notes, func(existing ItemNote) bool { return strings.EqualFold(existing.Item, item) })
+
+	if i < 0 {
+		n.notes = append(n.notes, ItemNote{Item: item})
+		i = len(n.notes) - 1
+	}
+
+	updated := &n.notes[i]
+
+	for _, label := range add {
+		if label = strings.TrimSpace(label); label != "" && !updated.HasLabel(label) {
+			updated.Labels = append(updated.Labels, label)
+		}
+	}
+
+	updated.Labels = slices.DeleteFunc(updated.Labels, func(label string) bool {
+		return slices.ContainsFunc(remove, func(r string) bool { return strings.EqualFold(strings.TrimSpace(r), label) })
+	})
+
+	if note != nil {
+		updated.Note = *note
+	}
+
+	updated.UpdatedAt = time.Now()
+
+	if len(updated.Labels) == 0 && updated.Note == "" {
+		n.notes = slices.Delete(n.notes, i, i+1)
+	}
+
+	return n.save()
+}
+
+// save writes the notes through a temporary file. The caller must hold mu.
+func (n *ItemNotes) save() error {
+	if err := os.MkdirAll(filepath.Dir(n.path), 0o777); err != nil {
+		return fmt.Errorf("Could not create state dir: %w", err)
+	}
+
+	contents, err := json.MarshalIndent(n.notes, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := n.path + ".tmp"
+
+	if err = os.WriteFile(tmp, contents, 0o600); err != nil {
+		return fmt.Errorf("Could not save labels: %w", err)
+	}
+
+	if err = os.Rename(tmp, n.path); err != nil {
+		return fmt.Errorf("Could not save labels: %w", err)
+	}
+
+	return nil
+}
+
+// LabelFilter picks items by their labels. Items labelled with one of Exclude are left out,
+// and with Only set, so are items without one of those.
+type LabelFilter struct {
+	Only    []string
+	Exclude []string
+}
+
+// Matches reports whether an item with the note passes the filter.
+func (f LabelFilter) Matches(note ItemNote) bool {
+	if slices.ContainsFunc(f.Exclude, note.HasLabel) {
+		return false
+	}
+
+	return len(f.Only) == 0 || slices.ContainsFunc(f.Only, note.HasLabel)
+}
+
+// Filter returns the entries whose notes pass the filter.
+func (f LabelFilter) Filter(entries []CollectionEntry, notes *ItemNotes) []CollectionEntry {
+	if len(f.Only) == 0 && len(f.Exclude) == 0 {
+		return entries
+	}
+
+	var kept []CollectionEntry
+
+	for _, entry := range entries {
+		note, _ := notes.Find(entry)
+
+		if f.Matches(note) {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept
+}
+
+// WithLabelFilter only downloads the items that pass the filter, going by the labels in the
+// first directory of the run, e.g. to leave out everything labelled "vinyl-owned".
+func WithLabelFilter(filter LabelFilter) func(*Downloader) {
+	return func(d *Downloader) {
+		d.labels = &filter
+	}
+}
@@ -0,0 +1,387 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A lock that wasn't refreshed for this long is assumed to belong to a crashed instance.
+// Holders refresh it four times as often.
+const staleLockAge = 30 * time.Minute
+
+// How often a waiting instance checks if the lock has been released.
+const lockPollInterval = 250 * time.Millisecond
+
+// libraryLock is a cooperative lock shared by every bcdl instance writing into the
+// same library directory. It is a plain file so it works on network shares where
+// advisory locks are unreliable.
+type libraryLock struct {
+	path string
+}
+
+// heldLock is a library lock this instance holds. Its file names the owner and is touched
+// while it is held, so long saves don't lose it.
+type heldLock struct {
+	path  string
+	owner string
+	done  chan struct{}
+}
+
+// acquire blocks until the lock file could be created.
+func (l libraryLock) acquire() (*heldLock, error) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d:%x", host, os.Getpid(), rand.Uint64())
+
+	for {
+		file, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+
+		if err == nil {
+			_, err = file.WriteString(owner)
+
+			if err = errors.Join(err, file.Close()); err != nil {
+				os.Remove(l.path)
+				return nil, fmt.Errorf("Could not lock library: %w", err)
+			}
+
+			held := &heldLock{path: l.path, owner: owner, done: make(chan struct{})}
+			go held.refresh()
+
+			return held, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("Could not lock library: %w", err)
+		}
+
+		l.breakStale(owner)
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// breakStale takes a lock out of the way whose holder stopped refreshing it, most likely
+// because it crashed. Only the waiter that created the break file next to the lock may
+// break it, so no two waiters break it at once. The lock is renamed out of the way and, if
+// it turns out to be a new one after all, linked back, which unlike a rename never replaces
+// a lock someone took in the meantime.
+func (l libraryLock) breakStale(owner string) {
+	if info, err := os.Stat(l.path); err != nil || time.Since(info.ModTime()) <= staleLockAge {
+		return
+	}
+
+	breaker := l.path + ".break"
+	file, err := os.OpenFile(breaker, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		// A waiter that crashed while breaking the lock leaves its break file behind
+		if info, err := os.Stat(breaker); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(breaker)
+		}
+
+		return
+	}
+
+	file.Close()
+	defer os.Remove(breaker)
+
+	// Checked again now that no one else is breaking it
+	stale, err := os.ReadFile(l.path)
+
+	if err != nil {
+		return
+	}
+
+	if info, err := os.Stat(l.path); err != nil || time.Since(info.ModTime()) <= staleLockAge {
+		return
+	}
+
+	// Named like the temporary files the state directory is cleaned of
+	broken := fmt.Sprintf("%s.%x.tmp", l.path, sha256.Sum256([]byte(owner)))
+
+	if err := os.Rename(l.path, broken); err != nil {
+		return
+	}
+
+	defer os.Remove(broken)
+
+	// Released and taken again since it was read
+	if current, err := os.ReadFile(broken); err == nil && !bytes.Equal(current, stale) {
+		if err := os.Link(broken, l.path); err != nil {
+			log.Printf("Could not restore the library lock of %s: %v", current, err)
+		}
+
+		return
+	}
+
+	log.Printf("Broke the library lock of %s, which wasn't refreshed for %v", stale, staleLockAge)
+}
+
+// refresh touches the lock file until it is released.
+func (h *heldLock) refresh() {
+	ticker := time.NewTicker(staleLockAge / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			now := time.Now()
+
+			if err := os.Chtimes(h.path, now, now); err != nil {
+				log.Printf("Could not refresh the library lock: %v", err)
+			}
+		}
+	}
+}
+
+// release removes the lock file, as long as it is still this instance's.
+func (h *heldLock) release() error {
+	close(h.done)
+
+	owner, err := os.ReadFile(h.path)
+
+	if err != nil {
+		return fmt.Errorf("Could not release the library lock: %w", err)
+	}
+
+	if string(owner) != h.owner {
+		return fmt.Errorf("The library lock was taken over by %s", owner)
+	}
+
+	return os.Remove(h.path)
+}
+
+// library is the directory downloads are saved into, along with where the state
+// for the current account lives.
+//
+// In shared mode several instances for different accounts may write into the same
+// directory, so every write goes through the library lock and state is kept per account.
+type library struct {
+	dir       string
+	stateDir  string
+	lock      *libraryLock
+	filenames FilenamePolicy
+	template  PathTemplate
+	existing  ExistingPolicy
+	storage   Storage
+	// space is shared by the libraries of a run that are in the same directory
+	space *spaceReservations
+	// extracted is only loaded when archives are extracted after downloading
+	extracted *extractedAlbums
+	// playlist is only set when the albums extracted during the run are collected in one
+	playlist *runPlaylist
+	// beets is only set when the albums extracted during the run are imported into beets
+	beets *beetsImport
+	// incoming is only set when downloads are staged, see WithStaging
+	incoming string
+}
+
+// newLibrary returns the library at dir with the state directory of the user, without
+// creating anything on disk, see create.
+func newLibrary(dir string, user *User, shared bool) *library {
+	bcdlDir := filepath.Join(dir, ".bcdl")
+	lib := &library{dir: dir, stateDir: bcdlDir, storage: NewLocalStorage(dir)}
+
+	if shared {
+		lib.stateDir = filepath.Join(bcdlDir, "accounts", user.username)
+		lib.lock = &libraryLock{path: filepath.Join(bcdlDir, "library.lock")}
+	}
+
+	return lib
+}
+
+// create creates the state directory of the library.
+func (lib *library) create() error {
+	if err := os.MkdirAll(lib.stateDir, 0o777); err != nil {
+		return fmt.Errorf("Could not create state dir %v", err)
+	}
+
+	return nil
+}
+
+// libraryStateDirs returns the state directories of the library at dir: one for every
+// account sharing it and the one used when it isn't shared.
+func libraryStateDirs(dir string) []string {
+	stateDirs, _ := filepath.Glob(filepath.Join(dir, ".bcdl", "accounts", "*"))
+
+	return append(stateDirs, filepath.Join(dir, ".bcdl"))
+}
+
+// withLock runs fn while holding the library lock, if there is one.
+func (lib *library) withLock(fn func() error) error {
+	if lib.lock == nil {
+		return fn()
+	}
+
+	held, err := lib.lock.acquire()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := held.release(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	return fn()
+}
+
+// fetchedFile is a finished download, from the browser or over HTTP. playwright.Download
+// is one.
+type fetchedFile interface {
+	SuggestedFilename() string
+	// Path is where the download is kept until it is saved
+	Path() (string, error)
+	SaveAs(path string) error
+}
+
+// save delivers a finished download to the library's storage using the suggested name, or
+// the path template when there is one, and returns the name it was saved under.
+//
+// When the library is shared and another account already saved the same file, the
+// existing copy is kept. Otherwise files already there are handled by the library's
+// ExistingPolicy.
+func (lib *library) save(dl fetchedFile, data PathData) (string, error) {
+	name := lib.filenames.Apply(dl.SuggestedFilename())
+
+	if lib.template != "" {
+		name = filepath.ToSlash(lib.filenames.ApplyPath(lib.template.Render(data) + path.Ext(dl.SuggestedFilename())))
+	}
+
+	err := lib.withLock(func() error {
+		if lib.lock != nil || lib.existing == ExistingSkip {
+			if exists, err := lib.exists(name); err == nil && exists {
+				return nil
+			}
+		}
+
+		if lib.existing == ExistingRename {
+			name = lib.freeName(name)
+		}
+
+		// Let the download move itself when it stays on this machine
+		if local, ok := lib.storage.(*LocalStorage); ok {
+			if err := os.MkdirAll(filepath.Dir(local.Path(name)), 0o777); err != nil {
+				return fmt.Errorf("Could not create directory: %w", err)
+			}
+
+			if err := dl.SaveAs(local.Path(name)); err != nil {
+				return fmt.Errorf("Could not download file: %w", err)
+			}
+
+			return nil
+		}
+
+		path, err := dl.Path()
+
+		if err != nil {
+			return fmt.Errorf("Could not download file: %w", err)
+		}
+
+		file, err := os.Open(path)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		size := int64(-1)
+		if info, err := file.Stat(); err == nil {
+			size = info.Size()
+		}
+
+		return lib.storage.Save(name, file, size)
+	})
+
+	return name, err
+}
+
+// AccountReport summarizes what one account contributed to a shared library.
+type AccountReport struct {
+	Username string
+	Albums   int
+}
+
+// LibraryReport is the merged view of every account downloading into a library.
+type LibraryReport struct {
+	Accounts []AccountReport
+	// UniqueAlbums counts each album and file type once, regardless of how many accounts own it.
+	UniqueAlbums int
+	// SharedAlbums counts albums owned by more than one account.
+	SharedAlbums int
+}
+
+// SharedLibraryReport merges the history of every account that downloaded into dir.
+func SharedLibraryReport(dir string) (LibraryReport, error) {
+	var report LibraryReport
+
+	paths, err := filepath.Glob(filepath.Join(dir, ".bcdl", "accounts", "*", "history.jsonl"))
+
+	if err != nil {
+		return report, err
+	}
+
+	owners := make(map[string]map[string]bool)
+
+	for _, path := range paths {
+		history, err := LoadHistory(path)
+
+		if err != nil {
+			return report, err
+		}
+
+		entries, err := history.List()
+
+		if err != nil {
+			return report, err
+		}
+
+		account := AccountReport{Username: filepath.Base(filepath.Dir(path))}
+
+		for _, entry := range entries {
+			// Different items can share a title, e.g. self-titled albums
+			key := entry.ItemID
+
+			if key == "" {
+				key = entry.Title
+			}
+
+			key += "\x00" + string(entry.FileType)
+
+			if owners[key] == nil {
+				owners[key] = make(map[string]bool)
+			}
+
+			owners[key][account.Username] = true
+			account.Albums++
+		}
+
+		report.Accounts = append(report.Accounts, account)
+	}
+
+	sort.Slice(report.Accounts, func(i, j int) bool {
+		return report.Accounts[i].Username < report.Accounts[j].Username
+	})
+
+	report.UniqueAlbums = len(owners)
+
+	for _, accounts := range owners {
+		if len(accounts) > 1 {
+			report.SharedAlbums++
+		}
+	}
+
+	return report, nil
+}
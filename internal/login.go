@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// ErrLoginAborted is returned when the login window is closed before signing in.
+var ErrLoginAborted = errors.New("Login window was closed before signing in")
+
+// Login opens a visible browser window on Bandcamp's login page and waits for the user
+// to sign in, returning the value of the identity cookie Bandcamp sets afterwards.
+//
+// Signing in by hand sidesteps the captcha challenges an automated login runs into.
+func Login(timeout time.Duration) (string, error) {
+	err := playwright.Install(playwrightOptions())
+	if err != nil {
+		return "", fmt.Errorf("Could not install playwright: %w", err)
+	}
+
+	pw, err := playwright.Run(playwrightOptions())
+	if err != nil {
+		return "", fmt.Errorf("Could not start playwright: %w", err)
+	}
+
+	defer pw.Stop()
+
+	browser, err := pw.Chromium.Launch(playwright.BrowserTypeLaunchOptions{
+		Headless: playwright.Bool(false),
+		Proxy:    browserProxy(),
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("Could not launch browser: %w", err)
+	}
+
+	defer browser.Close()
+
+	ctx, err := browser.NewContext(browserContextOptions())
+
+	if err != nil {
+		return "", fmt.Errorf("Could not create browser context: %w", err)
+	}
+
+	page, err := ctx.NewPage()
+
+	if err != nil {
+		return "", fmt.Errorf("Could not create page: %w", err)
+	}
+
+	if _, err = page.Goto(bcUrl.JoinPath("login").String()); err != nil {
+		return "", fmt.Errorf("Could not open the login page: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		if page.IsClosed() {
+			return "", ErrLoginAborted
+		}
+
+		cookies, err := ctx.Cookies(bcUrl.String())
+
+		if err != nil {
+			return "", fmt.Errorf("Could not read cookies: %w", err)
+		}
+
+		for _, cookie := range cookies {
+			if cookie.Name == "identity" && cookie.Value != "" {
+				return cookie.Value, nil
+			}
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return "", fmt.Errorf("Did not sign in within %s", timeout)
+}
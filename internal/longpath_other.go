@@ -0,0 +1,8 @@
+//go:build !windows
+
+package internal
+
+// longPath returns path as is, only Windows limits the length of paths.
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Paths this long need the \\?\ prefix to get past MAX_PATH
+const maxPath = 248
+
+// longPath prefixes long absolute paths with \\?\ so Windows accepts them. Short
+// paths are returned as is.
+func longPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+
+	if err != nil {
+		return path
+	}
+
+	// Network shares use the \\?\UNC\server\share form
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+
+	return `\\?\` + abs
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MediaServerKind is the software of a media server that is told to rescan its library.
+type MediaServerKind string
+
+// The media servers whose library refresh APIs are supported.
+const (
+	Plex      MediaServerKind = "plex"
+	Jellyfin  MediaServerKind = "jellyfin"
+	Navidrome MediaServerKind = "navidrome"
+)
+
+// ParseMediaServerKind parses the name of a media server, ignoring case.
+func ParseMediaServerKind(s string) (MediaServerKind, error) {
+	switch kind := MediaServerKind(strings.ToLower(s)); kind {
+	case Plex, Jellyfin, Navidrome:
+		return kind, nil
+	}
+
+	return "", fmt.Errorf("Unknown media server %q, use plex, jellyfin or navidrome", s)
+}
+
+// MediaServer is a media server scanning the library, kept in the config file as:
+//
+//	[media_server]
+//	kind = "plex"
+//	url = "http://nas:32400"
+//	token = "..."
+//	library = "3"
+type MediaServer struct {
+	Kind MediaServerKind `toml:"kind"`
+	URL  string          `toml:"url"`
+	// Token is Plex's X-Plex-Token, a Jellyfin API key or the Navidrome password
+	Token string `toml:"token"`
+	// User is the Navidrome user to sign in as
+	User string `toml:"user"`
+	// Library is the id of the Plex library section to scan, all of them when empty
+	Library string `toml:"library"`
+}
+
+var mediaServerClient = &http.Client{Transport: newTransport(), Timeout: 30 * time.Second}
+
+// WithMediaServer asks the media server to rescan its library whenever a run downloaded
+// something, so new albums show up without waiting for its scheduled scan.
+func WithMediaServer(server MediaServer) func(*Downloader) {
+	return func(d *Downloader) {
+		d.mediaServer = &server
+	}
+}
+
+// Refresh starts a library scan on the server. Servers scan in the background, so it
+// returns as soon as the scan was accepted.
+func (m MediaServer) Refresh() error {
+	base, err := url.Parse(strings.TrimSuffix(m.URL, "/"))
+
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return fmt.Errorf("Invalid %s URL %q", m.Kind, m.URL)
+	}
+
+	var req *http.Request
+
+	switch m.Kind {
+	case Plex:
+		section := m.Library
+
+		if section == "" {
+			section = "all"
+		}
+
+		u := base.JoinPath("library", "sections", section, "refresh")
+		u.RawQuery = url.Values{"X-Plex-Token": {m.Token}}.Encode()
+		req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+	case Jellyfin:
+		req, err = http.NewRequest(http.MethodPost, base.JoinPath("Library", "Refresh").String(), nil)
+
+		if err == nil {
+			req.Header.Set("X-Emby-Token", m.Token)
+		}
+	case Navidrome:
+		req, err = http.NewRequest(http.MethodGet, m.subsonicURL(base, "startScan"), nil)
+	default:
+		return fmt.Errorf("Unknown media server %q, use plex, jellyfin or navidrome", m.Kind)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := mediaServerClient.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("Could not reach %s: %w", m.Kind, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered with %s", m.Kind, resp.Status)
+	}
+
+	if m.Kind == Navidrome {
+		return subsonicError(resp.Body)
+	}
+
+	return nil
+}
+
+// subsonicURL builds a Subsonic API call, which Navidrome implements, signed with a salted
+// token so the password isn't sent in the clear.
+func (m MediaServer) subsonicURL(base *url.URL, method string) string {
+	salt := make([]byte, 8)
+	rand.Read(salt)
+	s := hex.EncodeToString(salt)
+	sum := md5.Sum([]byte(m.Token + s))
+
+	u := base.JoinPath("rest", method)
+	u.RawQuery = url.Values{
+		"u": {m.User},
+		"t": {hex.EncodeToString(sum[:])},
+		"s": {s},
+		"v": {"1.16.1"},
+		"c": {"bcdl"},
+		"f": {"json"},
+	}.Encode()
+
+	return u.String()
+}
+
+// subsonicError returns the error in a Subsonic response, which reports them with 200 OK.
+func subsonicError(body io.Reader) error {
+	var resp struct {
+		Response struct {
+			Status string `json:"status"`
+			Error  struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"subsonic-response"`
+	}
+
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return fmt.Errorf("Could not parse the response of navidrome: %w", err)
+	}
+
+	if resp.Response.Status != "ok" {
+		return fmt.Errorf("navidrome did not start the scan: %s", resp.Response.Error.Message)
+	}
+
+	return nil
+}
+
+// refreshMediaServer asks the configured media server to scan, logging what went wrong.
+func (d *Downloader) refreshMediaServer() {
+	if d.mediaServer == nil {
+		return
+	}
+
+	if err := d.mediaServer.Refresh(); err != nil {
+		log.Printf("Could not refresh the %s library: %v", d.mediaServer.Kind, err)
+		return
+	}
+
+	log.Printf("Asked %s to scan the library", d.mediaServer.Kind)
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PathTemplate lays out downloads in the library instead of saving them under the name
+// the browser suggests, e.g. "{artist}/{album} ({year}) [{format}]". Slashes separate
+// directories and the extension of the download is added to the end.
+//
+// The placeholders are {artist}, {album}, {year}, {format}, {purchased}, the year the
+// item was bought, and for gifts {gift}, which is "Gifts", and {gifter}, who sent it. They
+// are empty for everything else, so "{gift}/{artist}/{album}" keeps gifts apart.
+// Parentheses and brackets left empty by unknown values are dropped.
+type PathTemplate string
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+	emptyGroupPattern  = regexp.MustCompile(`\(\s*\)|\[\s*\]`)
+	spacesPattern      = regexp.MustCompile(`\s{2,}`)
+)
+
+// pathPlaceholders are the names a PathTemplate can use.
+var pathPlaceholders = map[string]bool{"artist": true, "album": true, "year": true, "format": true, "purchased": true, "gift": true, "gifter": true}
+
+// ParsePathTemplate checks that the template only uses known placeholders and stays inside
+// of the library, so it can't be absolute or have "." or ".." directories.
+func ParsePathTemplate(s string) (PathTemplate, error) {
+	for _, m := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+		if !pathPlaceholders[m[1]] {
+			return "", fmt.Errorf("Unknown placeholder {%s} in path template, expected {artist}, {album}, {year}, {format}, {purchased}, {gift} or {gifter}", m[1])
+		}
+	}
+
+	if strings.Count(s, "{") != strings.Count(s, "}") {
+		return "", fmt.Errorf("Unbalanced braces in path template %q", s)
+	}
+
+	if strings.TrimSpace(s) == "" {
+		return "", fmt.Errorf("Path template is empty")
+	}
+
+	if strings.HasPrefix(s, "/") || strings.HasPrefix(s, `\`) || filepath.IsAbs(s) || filepath.VolumeName(s) != "" {
+		return "", fmt.Errorf("Path template %q must be relative to the directory", s)
+	}
+
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part = strings.TrimSpace(part); part == "." || part == ".." {
+			return "", fmt.Errorf("Path template %q can't have %s directories", s, part)
+		}
+	}
+
+	return PathTemplate(s), nil
+}
+
+// PathData is what a PathTemplate is filled in with. Empty values are left out.
+type PathData struct {
+	Artist    string
+	Album     string
+	Released  time.Time
+	Purchased time.Time
+	FileType  FileType
+	Gift      bool
+	Gifter    string
+}
+
+// Render fills in the template, returning a slash separated path without an extension.
+// Values can't introduce directories of their own since slashes in them are replaced, and
+// directories that come out as "." or ".." become "_".
+func (t PathTemplate) Render(data PathData) string {
+	values := map[string]string{
+		"artist":    data.Artist,
+		"album":     data.Album,
+		"year":      yearOf(data.Released),
+		"purchased": yearOf(data.Purchased),
+		"format":    data.FileType.Label(),
+		"gifter":    data.Gifter,
+	}
+
+	if data.Gift {
+		values["gift"] = "Gifts"
+	}
+
+	parts := strings.Split(string(t), "/")
+	kept := parts[:0]
+
+	for _, part := range parts {
+		part = placeholderPattern.ReplaceAllStringFunc(part, func(placeholder string) string {
+			return strings.NewReplacer("/", "_", `\`, "_").Replace(values[strings.Trim(placeholder, "{}")])
+		})
+
+		part = emptyGroupPattern.ReplaceAllString(part, "")
+		part = strings.TrimSpace(spacesPattern.ReplaceAllString(part, " "))
+
+		if part == "." || part == ".." {
+			part = "_"
+		}
+
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+
+	return strings.Join(kept, "/")
+}
+
+// albumDirectories reports whether the template puts every album into a directory of its
+// own, i.e. the album is part of the directory and not only of the file name.
+func (t PathTemplate) albumDirectories() bool {
+	i := strings.LastIndex(string(t), "/")
+
+	return i >= 0 && strings.Contains(string(t)[:i], "{album}")
+}
+
+// yearOf formats the year of t, or returns the empty string when t is unknown.
+func yearOf(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return strconv.Itoa(t.Year())
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{"placeholders", "{artist}/{album} ({year}) [{format}]", false},
+		{"dots in a name", "{artist}/...and {album}", false},
+		{"unknown placeholder", "{label}/{album}", true},
+		{"unbalanced braces", "{artist/{album}", true},
+		{"empty", "  ", true},
+		{"absolute", "/music/{artist}/{album}", true},
+		{"absolute with a backslash", `\music\{album}`, true},
+		{"parent directory", "../{artist}/{album}", true},
+		{"parent directory in between", "{artist}/ .. /{album}", true},
+		{"current directory", "./{album}", true},
+		{"parent directory with a backslash", `{artist}\..\{album}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePathTemplate(tt.template)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePathTemplate(%q) error = %v, want error %v", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPathTemplateRender(t *testing.T) {
+	released := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		template PathTemplate
+		data     PathData
+		want     string
+	}{
+		{"every value", "{artist}/{album} ({year}) [{format}]", PathData{Artist: "Artist", Album: "Album", Released: released, FileType: FLAC}, "Artist/Album (2019) [FLAC]"},
+		{"empty groups", "{artist}/{album} ({year}) [{gifter}]", PathData{Artist: "Artist", Album: "Album"}, "Artist/Album"},
+		{"empty directory", "{gift}/{artist}/{album}", PathData{Artist: "Artist", Album: "Album"}, "Artist/Album"},
+		{"gift", "{gift}/{gifter}/{album}", PathData{Album: "Album", Gift: true, Gifter: "Sam"}, "Gifts/Sam/Album"},
+		{"slashes", "{artist}/{album}", PathData{Artist: "AC/DC", Album: `Back\Black`}, "AC_DC/Back_Black"},
+		{"parent directory", "{artist}/{album}", PathData{Artist: "..", Album: "Album"}, "_/Album"},
+		{"current directory", "{artist}/{album}", PathData{Artist: ".", Album: ".."}, "_/_"},
+		{"parent directory through a slash", "{artist}/{album}", PathData{Artist: "Artist", Album: "../.."}, "Artist/.._.."},
+		{"dots in a name", "{artist}/{album}", PathData{Artist: "...", Album: "Album"}, ".../Album"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.template.Render(tt.data); got != tt.want {
+				t.Errorf("Render(%+v) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package internal
+
+import "sync"
+
+// pauseGate holds workers back from starting new jobs while paused.
+// Jobs that are already running are left to finish.
+type pauseGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func newPauseGate() *pauseGate {
+	g := &pauseGate{}
+	g.cond = sync.NewCond(&g.mu)
+
+	return g
+}
+
+// pause stops new jobs from starting.
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.paused = true
+}
+
+// resume lets waiting workers continue.
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.paused = false
+	g.cond.Broadcast()
+}
+
+// isPaused reports whether the gate is currently closed.
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.paused
+}
+
+// anyPaused reports whether any of the gates is closed.
+func anyPaused(gates []*pauseGate) bool {
+	for _, gate := range gates {
+		if gate.isPaused() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wait blocks until the gate is open.
+func (g *pauseGate) wait() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.paused {
+		g.cond.Wait()
+	}
+}
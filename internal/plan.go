@@ -0,0 +1,63 @@
+package internal
+
+import "context"
+
+// Plan is what a Download would do, see Downloader.Plan.
+type Plan struct {
+	// Items would be downloaded, once for every format target they are missing from
+	Items []Item
+	// Skipped are already in the history, or were downloaded by another account of a
+	// shared library
+	Skipped []Item
+	// Bytes is a rough estimate of the size of Items
+	Bytes int64
+}
+
+// Plan finds what Download would download with opts, after the filters and the history,
+// without downloading anything or touching the library, so it can be shown for
+// confirmation first. The callbacks of opts are called like in a dry run, see WithDryRun.
+// The collection is read through the API, without a browser, until ctx is done. Plan must
+// not run while the Downloader downloads.
+func (d *Downloader) Plan(ctx context.Context, opts DownloadOpts) (Plan, error) {
+	var plan Plan
+
+	dryRun, runCtx := d.dryRun, d.context
+	d.dryRun, d.context = true, ctx
+
+	defer func() {
+		d.dryRun, d.context = dryRun, runCtx
+	}()
+
+	onSkip, onShared, onPlanned, onEstimate := opts.OnSkip, opts.OnShared, opts.OnPlanned, opts.OnEstimate
+
+	opts.OnSkip = func(item Item) {
+		plan.Skipped = append(plan.Skipped, item)
+		onSkip.call(item)
+	}
+	opts.OnShared = func(item Item, account string) {
+		plan.Skipped = append(plan.Skipped, item)
+
+		if onShared != nil {
+			onShared(item, account)
+		} else {
+			onSkip.call(item)
+		}
+	}
+	opts.OnPlanned = func(item Item) {
+		plan.Items = append(plan.Items, item)
+		onPlanned.call(item)
+	}
+	opts.OnEstimate = func(bytes int64) {
+		plan.Bytes = bytes
+
+		if onEstimate != nil {
+			onEstimate(bytes)
+		}
+	}
+
+	if err := d.download(opts); err != nil {
+		return Plan{}, err
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// audioFiles returns the audio files in dir and its subdirectories, slash separated and
+// relative to dir, in the order they are played. Bandcamp names tracks with their number
+// so sorting by path puts them in order, disc folders included.
+func audioFiles(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !audioExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+
+	sort.Strings(files)
+
+	return files, err
+}
+
+// writeAlbumPlaylist writes an M3U8 playlist of the album extracted to dir into dir, named
+// after the directory.
+func writeAlbumPlaylist(dir string) error {
+	files, err := audioFiles(dir)
+
+	if err != nil {
+		return fmt.Errorf("Could not list the tracks of %s: %w", dir, err)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	contents := "#EXTM3U\n" + strings.Join(files, "\n") + "\n"
+	path := filepath.Join(dir, filepath.Base(dir)+".m3u8")
+
+	if err := os.WriteFile(path, []byte(contents), 0o666); err != nil {
+		return fmt.Errorf("Could not write playlist: %w", err)
+	}
+
+	return nil
+}
+
+// runPlaylist collects every album extracted into a library during a run in one playlist.
+// It is started over by every run.
+type runPlaylist struct {
+	mu      sync.Mutex
+	path    string
+	started bool
+}
+
+// add appends the tracks of the album extracted to albumDir, relative to the library at dir.
+// The tracks are listed in workDir, where the album is until staged downloads are moved into
+// dir.
+func (p *runPlaylist) add(workDir, dir, albumDir string) error {
+	files, err := audioFiles(filepath.Join(workDir, filepath.FromSlash(albumDir)))
+
+	if err != nil {
+		return fmt.Errorf("Could not list the tracks of %s: %w", albumDir, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+
+	if !p.started {
+		flags = os.O_TRUNC | os.O_CREATE | os.O_WRONLY
+	}
+
+	file, err := os.OpenFile(p.path, flags, 0o666)
+
+	if err != nil {
+		return fmt.Errorf("Could not open playlist: %w", err)
+	}
+
+	defer file.Close()
+
+	var s strings.Builder
+
+	if !p.started {
+		s.WriteString("#EXTM3U\n")
+	}
+
+	// Playlists in the library can point into it with relative paths
+	prefix := filepath.ToSlash(albumDir) + "/"
+
+	if rel, err := filepath.Rel(filepath.Dir(p.path), filepath.Join(dir, filepath.FromSlash(albumDir))); err == nil {
+		prefix = filepath.ToSlash(rel) + "/"
+	}
+
+	for _, f := range files {
+		s.WriteString(prefix + f + "\n")
+	}
+
+	if _, err := file.WriteString(s.String()); err != nil {
+		return fmt.Errorf("Could not write playlist: %w", err)
+	}
+
+	p.started = true
+
+	return nil
+}
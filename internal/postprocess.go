@@ -0,0 +1,79 @@
+package internal
+
+import "time"
+
+// WithPostWorkers runs what comes after a transfer in n workers of their own: extracting,
+// checksums and artwork, and with EngineHTTP and EngineHybrid saving the file, which
+// uploads it to remote storage. Transfers carry on while archives are extracted and the
+// other way around. Without it the download workers do it themselves.
+func WithPostWorkers(n int) func(*Downloader) {
+	return func(d *Downloader) {
+		d.postWorkers = n
+	}
+}
+
+// postTask is the rest of a job that was transferred, started at start.
+type postTask struct {
+	job   downloadJob
+	start time.Time
+	run   func() (savedFile, error)
+}
+
+// postPool hands the jobs whose transfer finished to the post-processing workers, which
+// send them on as results once they are done.
+type postPool struct {
+	tasks chan postTask
+}
+
+// newPostPool starts workers post-processing workers that send finished jobs to results.
+func newPostPool(workers int, results chan<- downloadJob) *postPool {
+	// Transfers only wait on post-processing once it fell this far behind
+	p := &postPool{tasks: make(chan postTask, 2*workers)}
+
+	for range workers {
+		go func() {
+			for task := range p.tasks {
+				job := task.job
+				saved, err := task.run()
+
+				job.duration = time.Since(task.start)
+				job.saved = saved
+
+				if err != nil {
+					job.failed(err)
+				} else {
+					job.succeeded()
+				}
+
+				results <- job
+			}
+		}()
+	}
+
+	return p
+}
+
+// submit queues the rest of the job.
+func (p *postPool) submit(job downloadJob, start time.Time, run func() (savedFile, error)) {
+	p.tasks <- postTask{job: job, start: start, run: run}
+}
+
+// close stops the workers once the queued jobs are done.
+func (p *postPool) close() {
+	close(p.tasks)
+}
+
+// afterSave finishes the job that was saved as name, right away or in the post-processing
+// workers when there are some.
+func (job downloadJob) afterSave(dl fetchedFile, name string, saved savedFile) savedFile {
+	if job.post == nil {
+		return job.finish(dl, name, saved)
+	}
+
+	transferred := saved
+	saved.post = func() (savedFile, error) {
+		return job.finish(dl, name, transferred), nil
+	}
+
+	return saved
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// proxyURL is the proxy set with SetProxy, nil to go by HTTPS_PROXY, HTTP_PROXY and
+// NO_PROXY like any other program.
+var proxyURL *url.URL
+
+// SetProxy sends every connection of bcdl through the proxy at location, in the browser
+// and over HTTP: http://host:port, https://host:port or socks5://host:port, with
+// user:password@ in front of the host for proxies that need a sign in. Chromium can't
+// sign into SOCKS5 proxies, those only work with --engine http.
+func SetProxy(location string) error {
+	u, err := url.Parse(location)
+
+	if err != nil {
+		return fmt.Errorf("Invalid proxy %q: %w", location, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("Invalid proxy %q, expected http://, https:// or socks5://", location)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("Invalid proxy %q, expected a host and port", location)
+	}
+
+	proxyURL = u
+
+	return nil
+}
+
+// proxyFor is the Proxy of every HTTP transport of bcdl.
+func proxyFor(req *http.Request) (*url.URL, error) {
+	if proxyURL != nil {
+		return proxyURL, nil
+	}
+
+	return http.ProxyFromEnvironment(req)
+}
+
+// newTransport returns a transport like http.DefaultTransport that goes through the proxy.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFor
+
+	return transport
+}
+
+// browserProxy returns the proxy Chromium is launched with, nil to connect directly.
+func browserProxy() *playwright.Proxy {
+	if proxyURL == nil {
+		return nil
+	}
+
+	proxy := &playwright.Proxy{Server: (&url.URL{Scheme: proxyURL.Scheme, Host: proxyURL.Host}).String()}
+
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		proxy.Username = playwright.String(proxyURL.User.Username())
+		proxy.Password = playwright.String(password)
+	}
+
+	return proxy
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrNotQueued is returned when a queue operation targets an album that is not waiting to be downloaded.
+var ErrNotQueued = errors.New("Album is not queued")
+
+// ErrNotInCollection is returned when an item is asked for that the collection doesn't have.
+var ErrNotInCollection = errors.New("Item is not in the collection")
+
+// ErrCancelled is the error recorded on jobs that were cancelled before they started.
+var ErrCancelled = errors.New("Download cancelled")
+
+// jobQueue holds the jobs that have not been picked up by a worker yet.
+// Unlike a channel it can be reordered and have jobs removed while the run is in progress.
+type jobQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   []downloadJob
+	closed bool
+}
+
+func newJobQueue() *jobQueue {
+	q := &jobQueue{}
+	q.cond = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// push adds the job to the back of the queue.
+func (q *jobQueue) push(job downloadJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.jobs = append(q.jobs, job)
+	q.cond.Signal()
+}
+
+// pushFront adds the job to the front of the queue, ahead of everything already waiting.
+func (q *jobQueue) pushFront(job downloadJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.jobs = append([]downloadJob{job}, q.jobs...)
+	q.cond.Signal()
+}
+
+// pushAfter adds the job to the back of the queue, where it waits for delay before it is
+// handed out.
+func (q *jobQueue) pushAfter(job downloadJob, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job.notBefore = time.Now().Add(delay)
+	q.jobs = append(q.jobs, job)
+
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		q.cond.Broadcast()
+	})
+}
+
+// pop removes the first job of the queue that is done waiting, blocking until one is
+// available. It returns false once the queue is closed and empty.
+func (q *jobQueue) pop() (downloadJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		now := time.Now()
+		i := slices.IndexFunc(q.jobs, func(job downloadJob) bool { return !job.notBefore.After(now) })
+
+		if i >= 0 {
+			job := q.jobs[i]
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+
+			return job, true
+		}
+
+		if len(q.jobs) == 0 && q.closed {
+			return downloadJob{}, false
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// drain takes every job out of the queue and returns them.
+func (q *jobQueue) drain() []downloadJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := q.jobs
+	q.jobs = nil
+
+	return jobs
+}
+
+// close wakes up every worker waiting on an empty queue so they can exit.
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// find returns the index of the first queued job for the album with the key or -1.
+func (q *jobQueue) find(key string) int {
+	for i, job := range q.jobs {
+		if job.Entry.key() == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// prioritize moves the album with the key to the front of the queue.
+func (q *jobQueue) prioritize(key string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.find(key)
+
+	if i < 0 {
+		return ErrNotQueued
+	}
+
+	// Asked for by name, so it doesn't wait for its retry either
+	job := q.jobs[i]
+	job.notBefore = time.Time{}
+	copy(q.jobs[1:i+1], q.jobs[:i])
+	q.jobs[0] = job
+	q.cond.Signal()
+
+	return nil
+}
+
+// remove takes the album with the key out of the queue and returns its job.
+func (q *jobQueue) remove(key string) (downloadJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.find(key)
+
+	if i < 0 {
+		return downloadJob{}, ErrNotQueued
+	}
+
+	job := q.jobs[i]
+	q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+
+	return job, nil
+}
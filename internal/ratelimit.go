@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// How long requests pause when Bandcamp rate limits them without saying for how long, the
+// longest pause bcdl accepts and how often a request is sent again before it fails.
+const (
+	defaultRetryAfter = time.Minute
+	maxRetryAfter     = 30 * time.Minute
+	rateLimitAttempts = 10
+)
+
+// RateLimit spaces out the page navigations and API calls to Bandcamp, so a long run
+// doesn't look like a flood of requests to it.
+type RateLimit struct {
+	// MinDelay is the least time between two requests
+	MinDelay time.Duration
+	// PerMinute is the most requests in a minute, 0 for no cap
+	PerMinute int
+}
+
+// interval is the time between two requests the limit allows.
+func (l RateLimit) interval() time.Duration {
+	interval := l.MinDelay
+
+	if l.PerMinute > 0 {
+		interval = max(interval, time.Minute/time.Duration(l.PerMinute))
+	}
+
+	return interval
+}
+
+// rateLimiter hands out the times requests may be sent at, one interval apart, and holds
+// all of them back while Bandcamp is rate limiting.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	paused   time.Time
+}
+
+// bandcampLimiter is shared by every worker, the limit is for the whole process.
+var bandcampLimiter rateLimiter
+
+// SetRateLimit limits the requests to Bandcamp from now on.
+func SetRateLimit(limit RateLimit) {
+	bandcampLimiter.mu.Lock()
+	defer bandcampLimiter.mu.Unlock()
+
+	bandcampLimiter.interval = limit.interval()
+}
+
+// wait blocks until the next request may be sent.
+func (l *rateLimiter) wait() {
+	for {
+		l.mu.Lock()
+
+		now := time.Now()
+
+		if l.paused.After(now) {
+			l.mu.Unlock()
+			time.Sleep(l.pausedFor(now))
+
+			continue
+		}
+
+		at := now
+
+		if l.next.After(now) {
+			at = l.next
+		}
+
+		l.next = at.Add(l.interval)
+
+		l.mu.Unlock()
+
+		time.Sleep(at.Sub(now))
+
+		// A pause that started meanwhile holds this request back too
+		if l.pausedFor(time.Now()) <= 0 {
+			return
+		}
+	}
+}
+
+// pausedFor returns how long requests are still held back at now.
+func (l *rateLimiter) pausedFor(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.paused.Sub(now)
+}
+
+// pause holds back every request for d, which Bandcamp asked for by rate limiting one.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if l.paused.Before(now) {
+		log.Printf("Bandcamp is rate limiting requests, pausing them for %v", d.Round(time.Second))
+	}
+
+	if until := now.Add(d); until.After(l.paused) {
+		l.paused = until
+	}
+}
+
+// pauseOnRateLimit pauses every request when Bandcamp rate limits one the browser sent,
+// e.g. from the scripts of a download page. It is called from Playwright's event loop.
+func pauseOnRateLimit(resp playwright.Response) {
+	if resp.Status() != http.StatusTooManyRequests {
+		return
+	}
+
+	u, err := url.Parse(resp.URL())
+
+	if err != nil || (u.Hostname() != bcUrl.Host && !strings.HasSuffix(u.Hostname(), "."+bcUrl.Host)) {
+		return
+	}
+
+	header, _ := resp.HeaderValue("retry-after")
+	bandcampLimiter.pause(retryAfter(header))
+}
+
+// gotoPage navigates page to link once the rate limit allows it. While Bandcamp rate
+// limits it, the page is loaded again after the pause it asked for.
+func gotoPage(page playwright.Page, link string, opts playwright.PageGotoOptions) (playwright.Response, error) {
+	for attempt := 1; ; attempt++ {
+		bandcampLimiter.wait()
+		resp, err := page.Goto(link, opts)
+
+		if err != nil || resp == nil || resp.Status() != http.StatusTooManyRequests || attempt == rateLimitAttempts {
+			return resp, err
+		}
+
+		header, _ := resp.HeaderValue("retry-after")
+		bandcampLimiter.pause(retryAfter(header))
+	}
+}
+
+// retryAfter reads how long to wait from the Retry-After header of a rate limited
+// response, given in seconds or as a date.
+func retryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	d := defaultRetryAfter
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		d = time.Until(at)
+	}
+
+	if d <= 0 {
+		// Bandcamp's clock says the wait is over, so only wait briefly
+		d = time.Second
+	}
+
+	return min(d, maxRetryAfter)
+}
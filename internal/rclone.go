@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RemoteReport is how a mirror of the library compares to the local copy.
+type RemoteReport struct {
+	Matched int
+	// Missing files were never copied to the mirror
+	Missing []string
+	// Differ are files whose checksum or size on the mirror doesn't match the local copy
+	Differ []string
+	// Failed files could not be read on either side
+	Failed []string
+}
+
+// OK reports whether every local file is on the mirror intact.
+func (r RemoteReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Differ) == 0 && len(r.Failed) == 0
+}
+
+// ParseRcloneRemote takes a mirror location such as "rclone:remote:/music" and returns
+// the rclone remote path, "remote:/music".
+func ParseRcloneRemote(location string) (string, error) {
+	remote, ok := strings.CutPrefix(location, "rclone:")
+
+	if !ok || !strings.Contains(remote, ":") {
+		return "", fmt.Errorf("Unsupported mirror %q, expected rclone:remote:/path", location)
+	}
+
+	return remote, nil
+}
+
+// VerifyRemote compares the library at dir with its mirror at the rclone remote without
+// changing either. Checksums are compared when both sides support a common hash, and
+// the mirror is otherwise only checked for size unless download is set, which streams
+// every file from the mirror to compare the contents.
+//
+// Requires the rclone command.
+func VerifyRemote(dir, remote string, download bool) (RemoteReport, error) {
+	var report RemoteReport
+
+	rclone, err := exec.LookPath("rclone")
+
+	if err != nil {
+		return report, fmt.Errorf("The rclone command is needed to verify a mirror: %w", err)
+	}
+
+	args := []string{"check", dir, remote, "--one-way", "--combined", "-", "--exclude", ".bcdl/**"}
+
+	if download {
+		args = append(args, "--download")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(rclone, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// rclone exits with an error when anything differs, which the report covers
+	runErr := cmd.Run()
+
+	scanner := bufio.NewScanner(&stdout)
+
+	for scanner.Scan() {
+		status, path, ok := strings.Cut(scanner.Text(), " ")
+
+		if !ok {
+			continue
+		}
+
+		switch status {
+		case "=":
+			report.Matched++
+		case "-":
+			report.Missing = append(report.Missing, path)
+		case "*":
+			report.Differ = append(report.Differ, path)
+		case "!":
+			report.Failed = append(report.Failed, path)
+		}
+	}
+
+	var exitErr *exec.ExitError
+
+	if runErr != nil && (!errors.As(runErr, &exitErr) || report.OK()) {
+		return report, fmt.Errorf("Could not check %s: %w: %s", remote, runErr, strings.TrimSpace(stderr.String()))
+	}
+
+	return report, nil
+}
+
+// rcloneRemotePattern matches the "remote:path" locations of rclone. Single letters are
+// left out, they are Windows drives.
+var rcloneRemotePattern = regexp.MustCompile(`^[\w][\w.@ -]+:`)
+
+// How often an upload is tried before giving up, and how long to wait after the first failure
+const (
+	rcloneAttempts = 5
+	rcloneBackoff  = 5 * time.Second
+)
+
+// IsRcloneRemote reports whether location is an rclone remote like "gdrive:music/bandcamp"
+// rather than a directory.
+func IsRcloneRemote(location string) bool {
+	return rcloneRemotePattern.MatchString(location) && !strings.Contains(location, "://")
+}
+
+// RcloneStorage moves downloads to any remote rclone is configured for, such as Google
+// Drive, OneDrive or a crypt remote:
+//
+//	gdrive:music/bandcamp
+//
+// Downloads are written to a local staging directory first and moved with `rclone moveto`,
+// which is retried with backoff.
+type RcloneStorage struct {
+	remote  string
+	staging string
+}
+
+// NewRcloneStorage creates an RcloneStorage for the remote, staging files in staging.
+func NewRcloneStorage(remote, staging string) (*RcloneStorage, error) {
+	// The mirror syntax of verify works too
+	if r, err := ParseRcloneRemote(remote); err == nil {
+		remote = r
+	}
+
+	if !IsRcloneRemote(remote) {
+		return nil, fmt.Errorf("Invalid rclone remote %q, expected remote:path", remote)
+	}
+
+	if _, err := exec.LookPath("rclone"); err != nil {
+		return nil, fmt.Errorf("The rclone command is needed to upload to %s: %w", remote, err)
+	}
+
+	if err := os.MkdirAll(staging, 0o777); err != nil {
+		return nil, fmt.Errorf("Could not create staging dir: %w", err)
+	}
+
+	return &RcloneStorage{remote: strings.TrimSuffix(remote, "/"), staging: staging}, nil
+}
+
+// target returns the rclone path of name.
+func (s *RcloneStorage) target(name string) string {
+	if strings.HasSuffix(s.remote, ":") {
+		return s.remote + name
+	}
+
+	return s.remote + "/" + name
+}
+
+// runRclone runs an rclone command, returning what it printed.
+func runRclone(args ...string) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.Command("rclone", args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	return output.String(), err
+}
+
+// Save copies r into the staging directory and moves it to the remote. The staged copy
+// is removed when every attempt failed.
+func (s *RcloneStorage) Save(name string, r io.Reader, size int64) error {
+	staged := filepath.Join(s.staging, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(staged), 0o777); err != nil {
+		return fmt.Errorf("Could not create directory: %w", err)
+	}
+
+	file, err := os.Create(staged)
+
+	if err != nil {
+		return fmt.Errorf("Could not stage %s: %w", name, err)
+	}
+
+	defer os.Remove(staged)
+
+	_, err = io.Copy(file, r)
+
+	if err = errors.Join(err, file.Close()); err != nil {
+		return fmt.Errorf("Could not stage %s: %w", name, err)
+	}
+
+	wait := rcloneBackoff
+
+	for attempt := 1; ; attempt++ {
+		output, err := runRclone("moveto", staged, s.target(name))
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt == rcloneAttempts {
+			return fmt.Errorf("Could not move %s to %s: %w: %s", name, s.remote, err, strings.TrimSpace(output))
+		}
+
+		log.Printf("Could not move %s to %s, retrying in %v: %v", name, s.remote, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// Exists lists the directory of name on the remote.
+func (s *RcloneStorage) Exists(name string) (bool, error) {
+	dir := path.Dir(name)
+	target := s.remote
+
+	if dir != "." {
+		target = s.target(dir)
+	}
+
+	output, err := runRclone("lsf", "--files-only", "--max-depth", "1", target)
+
+	// rclone exits with 3 for directories that don't exist
+	var exit *exec.ExitError
+
+	if errors.As(err, &exit) && exit.ExitCode() == 3 {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("Could not check %s: %w: %s", name, err, strings.TrimSpace(output))
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if line == path.Base(name) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RcloneStateDir returns the local directory that keeps the .bcdl state and staging
+// directory of a remote, e.g. ~/.config/bcdl/remotes/gdrive_music_bandcamp.
+func RcloneStateDir(remote string) (string, error) {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", fmt.Errorf("Could not find the config directory: %w", err)
+	}
+
+	name := regexp.MustCompile(`[^\w.-]+`).ReplaceAllString(strings.Trim(remote, ":/"), "_")
+
+	return filepath.Join(dir, "bcdl", "remotes", name), nil
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// maxReconnectBackoff caps how long to wait between attempts to reconnect to a remote browser.
+const maxReconnectBackoff = time.Minute
+
+// reconnectAttempts is how often a lost remote browser is tried before the jobs waiting on
+// it are allowed to fail.
+const reconnectAttempts = 10
+
+// WithRemoteBrowser runs the downloads in the browser listening on the websocket endpoint,
+// e.g. one started with `npx playwright@1.41.2 run-server` in a container, instead of
+// launching Chromium on this machine. Downloads are streamed back into the library.
+//
+// When the connection drops, new jobs wait until it is reconnected and the ones that were
+// running are queued again.
+func WithRemoteBrowser(endpoint string) func(*Downloader) {
+	return func(d *Downloader) {
+		d.endpoint = endpoint
+	}
+}
+
+// browserSession is the browser a run downloads in. A remote browser is reconnected to when
+// its connection drops, and the context is set up again.
+type browserSession struct {
+	mu      sync.Mutex
+	browser playwright.Browser
+	context AuthorizedBandcampContext
+	// gen counts the connections so jobs can tell whether theirs was lost
+	gen    int
+	closed bool
+	// gaveUp is set once reconnecting failed reconnectAttempts times
+	gaveUp bool
+	// gate holds workers back while reconnecting
+	gate *pauseGate
+
+	// page is the collection page of connection pageGen, see collectionPage
+	page    *CollectionPage
+	pageGen int
+
+	connect func() (playwright.Browser, error)
+	setup   func(playwright.Browser) (AuthorizedBandcampContext, error)
+}
+
+// openBrowserSession connects with connect and sets the browser up with setup. Remote
+// sessions are reconnected to the same way when the connection drops.
+func openBrowserSession(remote bool, connect func() (playwright.Browser, error), setup func(playwright.Browser) (AuthorizedBandcampContext, error)) (*browserSession, error) {
+	browser, err := connect()
+
+	if err != nil {
+		return nil, err
+	}
+
+	context, err := setup(browser)
+
+	if err != nil {
+		browser.Close()
+		return nil, err
+	}
+
+	s := &browserSession{browser: browser, context: context, gate: newPauseGate(), connect: connect, setup: setup}
+
+	if remote {
+		browser.OnDisconnected(s.disconnected)
+	}
+
+	return s, nil
+}
+
+// current returns the context jobs should use and the connection it belongs to.
+func (s *browserSession) current() (AuthorizedBandcampContext, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.context, s.gen
+}
+
+// lost reports whether a job that ran on connection gen was interrupted by the browser
+// going away, so it should be queued again rather than counted as failed.
+func (s *browserSession) lost(gen int) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.gaveUp {
+		return false
+	}
+
+	return gen != s.gen || !s.browser.IsConnected()
+}
+
+// collectionPage returns a page for the user's collection on the current connection.
+func (s *browserSession) collectionPage(username string) (CollectionPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.page != nil && s.pageGen == s.gen {
+		return *s.page, nil
+	}
+
+	page, err := s.context.NewCollectionPage(username)
+
+	if err != nil {
+		return CollectionPage{}, err
+	}
+
+	s.page = &page
+	s.pageGen = s.gen
+
+	return page, nil
+}
+
+// disconnected pauses the workers and starts reconnecting when the current browser went away.
+func (s *browserSession) disconnected(browser playwright.Browser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Closed by the end of the run, or a connection that was already replaced
+	if s.closed || browser != s.browser {
+		return
+	}
+
+	s.gen++
+	s.gate.pause()
+	log.Println("Lost the connection to the browser, reconnecting")
+
+	go s.reconnect()
+}
+
+// reconnect connects again with exponential backoff and lets the workers continue.
+func (s *browserSession) reconnect() {
+	// The workers continue either way, after giving up the jobs fail like any other error
+	defer s.gate.resume()
+
+	backoff := time.Second
+
+	for attempt := 1; ; attempt++ {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		browser, err := s.connect()
+
+		if err == nil {
+			var context AuthorizedBandcampContext
+
+			if context, err = s.setup(browser); err != nil {
+				browser.Close()
+			} else {
+				s.mu.Lock()
+				closed := s.closed
+
+				if !closed {
+					s.browser = browser
+					s.context = context
+					browser.OnDisconnected(s.disconnected)
+				}
+
+				s.mu.Unlock()
+
+				if closed {
+					browser.Close()
+				} else {
+					log.Println("Reconnected to the browser")
+				}
+
+				return
+			}
+		}
+
+		if attempt == reconnectAttempts {
+			s.mu.Lock()
+			s.gaveUp = true
+			s.mu.Unlock()
+
+			log.Printf("Could not reconnect to the browser, giving up: %v", err)
+			return
+		}
+
+		log.Printf("Could not reconnect to the browser, retrying in %s: %v", backoff, err)
+		time.Sleep(backoff)
+
+		if backoff = backoff * 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// close closes the browser without reconnecting to it.
+func (s *browserSession) close() error {
+	s.mu.Lock()
+	s.closed = true
+	browser := s.browser
+	s.mu.Unlock()
+
+	return browser.Close()
+}
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ArtistCount is how many items of an artist a report counted.
+type ArtistCount struct {
+	Artist string
+	Items  int
+}
+
+// Spend is what the items of a report sell for in one currency.
+type Spend struct {
+	Currency string
+	Amount   float64
+}
+
+// YearReport summarizes the purchases and downloads of a year.
+type YearReport struct {
+	Year      int
+	Username  string
+	Purchases int
+	Gifts     int
+	// Months counts the purchases of every month, January first
+	Months [12]int
+	// Artists are ordered by how many of their items were collected
+	Artists []ArtistCount
+	// Spend is estimated from the current prices of the items, Priced says of how many
+	Spend  []Spend
+	Priced int
+	// Downloads counts every file downloaded that year, by format in Formats, and Bytes is
+	// how much of that is still in the library
+	Downloads int
+	Formats   map[FileType]int
+	Bytes     int64
+}
+
+// NewYearReport summarizes the year from the user's collection and what the history of
+// the library at dir recorded. Items whose purchase date isn't known are left out.
+func NewYearReport(year int, user *User, collection []CollectionEntry, dir string) (YearReport, error) {
+	report := YearReport{Year: year, Username: user.username, Formats: map[FileType]int{}}
+	artists := map[string]int{}
+	spend := map[string]float64{}
+
+	for _, entry := range collection {
+		if entry.purchased.Year() != year {
+			continue
+		}
+
+		report.Purchases++
+		report.Months[entry.purchased.Month()-1]++
+		artists[entry.artist]++
+
+		if entry.gift {
+			report.Gifts++
+		} else if entry.price > 0 && entry.currency != "" {
+			spend[entry.currency] += entry.price
+			report.Priced++
+		}
+	}
+
+	for artist, items := range artists {
+		report.Artists = append(report.Artists, ArtistCount{Artist: artist, Items: items})
+	}
+
+	sort.Slice(report.Artists, func(i, j int) bool {
+		if report.Artists[i].Items != report.Artists[j].Items {
+			return report.Artists[i].Items > report.Artists[j].Items
+		}
+
+		return report.Artists[i].Artist < report.Artists[j].Artist
+	})
+
+	for currency, amount := range spend {
+		report.Spend = append(report.Spend, Spend{Currency: currency, Amount: amount})
+	}
+
+	sort.Slice(report.Spend, func(i, j int) bool { return report.Spend[i].Amount > report.Spend[j].Amount })
+
+	if dir == "" {
+		return report, nil
+	}
+
+	for _, stateDir := range libraryStateDirs(dir) {
+		history, err := LoadHistory(filepath.Join(stateDir, "history.jsonl"))
+
+		if err != nil {
+			return report, err
+		}
+
+		records, _ := history.List()
+
+		for _, record := range records {
+			if record.DownloadedAt.Year() != year || !record.ownedBy(user) {
+				continue
+			}
+
+			report.Downloads++
+			report.Formats[record.FileType]++
+
+			if record.File == "" {
+				continue
+			}
+
+			if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(record.File))); err == nil {
+				report.Bytes += info.Size()
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// TopArtists returns the n artists with the most items.
+func (r YearReport) TopArtists(n int) []ArtistCount {
+	return r.Artists[:min(n, len(r.Artists))]
+}
+
+// FormatCounts returns the formats downloaded in, most used first.
+func (r YearReport) FormatCounts() []string {
+	var formats []FileType
+
+	for ft := range r.Formats {
+		formats = append(formats, ft)
+	}
+
+	sort.Slice(formats, func(i, j int) bool { return r.Formats[formats[i]] > r.Formats[formats[j]] })
+
+	var counts []string
+
+	for _, ft := range formats {
+		counts = append(counts, fmt.Sprintf("%s: %d", ft.Label(), r.Formats[ft]))
+	}
+
+	return counts
+}
+
+// reportFuncs are the helpers the report templates use.
+var reportFuncs = map[string]any{
+	"month": func(i int) string { return time.Month(i + 1).String() },
+	"size":  FormatSize,
+	"join":  strings.Join,
+	"money": func(s Spend) string { return fmt.Sprintf("%.2f %s", s.Amount, s.Currency) },
+}
+
+// markdownReport is the YearReport as Markdown.
+const markdownReport = `# {{.Year}} on Bandcamp
+
+{{.Username}} collected **{{.Purchases}}** items from **{{len .Artists}}** artists{{if .Gifts}}, {{.Gifts}} of them gifts{{end}}.
+{{- if .Spend}}
+
+Estimated spend, at today's prices of {{.Priced}} items:{{range .Spend}} {{money .}}{{end}}
+{{- end}}
+
+## Top artists
+{{range .TopArtists 10}}
+- {{.Artist}}: {{.Items}}
+{{- end}}
+
+## Purchases by month
+{{range $i, $n := .Months}}
+- {{month $i}}: {{$n}}
+{{- end}}
+
+## Downloads
+
+{{.Downloads}} downloads{{with .FormatCounts}} ({{join . ", "}}){{end}}, {{size .Bytes}} of which are in the library.
+`
+
+// htmlReport is the YearReport as a stand-alone HTML page.
+const htmlReport = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Year}} on Bandcamp</title>
+<style>body { font-family: sans-serif; max-width: 40em; margin: 2em auto; } td { padding: 0 1em 0 0; }</style>
+</head>
+<body>
+<h1>{{.Year}} on Bandcamp</h1>
+<p>{{.Username}} collected <strong>{{.Purchases}}</strong> items from <strong>{{len .Artists}}</strong> artists{{if .Gifts}}, {{.Gifts}} of them gifts{{end}}.</p>
+{{- if .Spend}}
+<p>Estimated spend, at today's prices of {{.Priced}} items:{{range .Spend}} {{money .}}{{end}}</p>
+{{- end}}
+<h2>Top artists</h2>
+<table>
+{{- range .TopArtists 10}}
+<tr><td>{{.Artist}}</td><td>{{.Items}}</td></tr>
+{{- end}}
+</table>
+<h2>Purchases by month</h2>
+<table>
+{{- range $i, $n := .Months}}
+<tr><td>{{month $i}}</td><td>{{$n}}</td></tr>
+{{- end}}
+</table>
+<h2>Downloads</h2>
+<p>{{.Downloads}} downloads{{with .FormatCounts}} ({{join . ", "}}){{end}}, {{size .Bytes}} of which are in the library.</p>
+</body>
+</html>
+`
+
+// WriteHTML writes the report as an HTML page.
+func (r YearReport) WriteHTML(w io.Writer) error {
+	return htmltemplate.Must(htmltemplate.New("report").Funcs(reportFuncs).Parse(htmlReport)).Execute(w, r)
+}
+
+// WriteMarkdown writes the report as Markdown.
+func (r YearReport) WriteMarkdown(w io.Writer) error {
+	return template.Must(template.New("report").Funcs(reportFuncs).Parse(markdownReport)).Execute(w, r)
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// partialTransfer is what is known about a partially downloaded file, kept next to it in
+// a .json file so the next run can resume it.
+type partialTransfer struct {
+	Total int64  `json:"total"`
+	Name  string `json:"name,omitempty"`
+	Zip   bool   `json:"zip,omitempty"`
+}
+
+// partialPath returns where the transfer with the key is kept in dir until it finished.
+// Like every temporary file of bcdl its name starts with .bcdl-, so staging leaves it
+// alone and Clean removes it once it is old.
+func partialPath(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(dir, fmt.Sprintf(".bcdl-%x.part", sum[:8]))
+}
+
+// resume opens the partial file at path to append to it. The size of the file is where
+// the last run stopped, as long as its details were recorded. Otherwise it starts over.
+func (t *transfer) resume(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+
+	if err != nil {
+		return err
+	}
+
+	t.file = file
+	t.partial = path + ".json"
+
+	var partial partialTransfer
+	contents, err := os.ReadFile(t.partial)
+
+	if err == nil && json.Unmarshal(contents, &partial) == nil && partial.Total > 0 {
+		if info, err := file.Stat(); err == nil && info.Size() <= partial.Total {
+			t.written = info.Size()
+			t.total = partial.Total
+			t.name = partial.Name
+			t.zip = partial.Zip
+		}
+	}
+
+	if t.written == 0 {
+		return t.restart()
+	}
+
+	if _, err := file.Seek(t.written, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	return nil
+}
+
+// restart empties the file to download it from the start.
+func (t *transfer) restart() error {
+	if err := t.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	t.written = 0
+
+	return nil
+}
+
+// remember records the details of a resumable transfer, once they are known.
+func (t *transfer) remember() error {
+	if t.partial == "" || t.total <= 0 {
+		return nil
+	}
+
+	contents, err := json.Marshal(partialTransfer{Total: t.total, Name: t.name, Zip: t.zip})
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(t.partial, contents, 0o600); err != nil {
+		return fmt.Errorf("Could not record the transfer for resuming: %w", err)
+	}
+
+	return nil
+}
+
+// forget removes the details of a resumable transfer once it has nothing to resume.
+func (t *transfer) forget() {
+	if t.partial != "" {
+		os.Remove(t.partial)
+	}
+}
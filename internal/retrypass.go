@@ -0,0 +1,36 @@
+package internal
+
+import "errors"
+
+// WithRetryPasses goes over the albums that failed up to n more times once the rest of the
+// queue was downloaded, before they are reported as failed, since errors preparing a
+// download often go away a while later. Every pass gives them twice as long as the one
+// before and opens their pages anew. Albums that are region locked, don't fit or failed
+// because the session was signed out aren't tried again.
+func WithRetryPasses(n int) func(*Downloader) {
+	return func(d *Downloader) {
+		d.retryPasses = n
+	}
+}
+
+// passable reports whether the job that failed may be tried again in a later pass.
+func (job downloadJob) passable() bool {
+	for _, err := range []error{ErrCancelled, ErrInsufficientSpace, ErrRegionLocked, ErrNotSignedIn} {
+		if errors.Is(job.err, err) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nextPass returns the job that failed as it is tried in the next pass.
+func (job downloadJob) nextPass() downloadJob {
+	job.timeoutMs *= 2
+	job.attempt = 0
+	// The last attempt may have left part of the file, see onDisk
+	job.retry = true
+	job.err = nil
+
+	return job
+}
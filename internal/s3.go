@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage uploads downloads to a bucket of Amazon S3 or a compatible service, such as
+// Backblaze B2, Wasabi or MinIO:
+//
+//	s3://bucket/music
+//
+// Requests are signed with AWS Signature Version 4. Large objects are uploaded in parts, as
+// a single upload can hold at most 5 GB.
+type S3Storage struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// S3Credentials are the keys and location of the service an S3Storage talks to.
+type S3Credentials struct {
+	AccessKey string
+	SecretKey string
+	// Region defaults to us-east-1
+	Region string
+	// Endpoint is the address of the service, e.g. https://s3.us-west-004.backblazeb2.com.
+	// Amazon's endpoint for the region is used when it is empty.
+	Endpoint string
+}
+
+// NewS3Storage creates an S3Storage for a location like "s3://bucket/prefix".
+func NewS3Storage(location string, creds S3Credentials) (*S3Storage, error) {
+	u, err := url.Parse(location)
+
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 location %q, expected s3://bucket/path", location)
+	}
+
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, fmt.Errorf("S3 needs an access key and secret key")
+	}
+
+	if creds.Region == "" {
+		creds.Region = "us-east-1"
+	}
+
+	if creds.Endpoint == "" {
+		creds.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", creds.Region)
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(creds.Endpoint, "/"))
+
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q", creds.Endpoint)
+	}
+
+	return &S3Storage{
+		endpoint:  endpoint,
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    creds.Region,
+		accessKey: creds.AccessKey,
+		secretKey: creds.SecretKey,
+		// Large archives can take a long time to upload, so only the connection is bounded
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 proxyFor,
+			ResponseHeaderTimeout: 5 * time.Minute,
+		}},
+	}, nil
+}
+
+// url returns the path style address of the object for name.
+func (s *S3Storage) url(name string) string {
+	key := name
+
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+
+	u := *s.endpoint
+	u.Path = u.Path + "/" + s.bucket + "/" + key
+	u.RawPath = s.endpoint.EscapedPath() + "/" + awsEscape(s.bucket) + "/" + awsEscape(key)
+
+	return u.String()
+}
+
+// Objects larger than s3MultipartThreshold are uploaded in parts of s3PartSize
+const (
+	s3MultipartThreshold = 512 << 20
+	s3PartSize           = 64 << 20
+)
+
+// do sends a signed request for the object name, with the query if it isn't empty.
+func (s *S3Storage) do(method, name, query string, body io.Reader, size int64) (*http.Response, error) {
+	link := s.url(name)
+
+	if query != "" {
+		link += "?" + query
+	}
+
+	req, err := http.NewRequest(method, link, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if size >= 0 {
+		req.ContentLength = size
+	}
+
+	s.sign(req, time.Now().UTC())
+
+	return s.client.Do(req)
+}
+
+// Save uploads r to name with a PUT request, or in parts once it is larger than
+// s3MultipartThreshold.
+func (s *S3Storage) Save(name string, r io.Reader, size int64) error {
+	if size > s3MultipartThreshold {
+		return s.saveParts(name, r, size)
+	}
+
+	// S3 needs the length up front
+	if size < 0 {
+		contents, err := io.ReadAll(r)
+
+		if err != nil {
+			return fmt.Errorf("Could not read %s: %w", name, err)
+		}
+
+		r, size = bytes.NewReader(contents), int64(len(contents))
+	}
+
+	resp, err := s.do(http.MethodPut, name, "", r, size)
+
+	if err != nil {
+		return fmt.Errorf("Could not upload %s: %w", name, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Could not upload %s: %s", name, s3Error(resp))
+	}
+
+	return nil
+}
+
+// Exists checks for name with a HEAD request.
+func (s *S3Storage) Exists(name string) (bool, error) {
+	resp, err := s.do(http.MethodHead, name, "", nil, -1)
+
+	if err != nil {
+		return false, err
+	}
+
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode < 300:
+		return true, nil
+	}
+
+	return false, fmt.Errorf("Could not check %s: %s", name, resp.Status)
+}
+
+// saveParts uploads r to name with a multipart upload, which is aborted if a part fails.
+func (s *S3Storage) saveParts(name string, r io.Reader, size int64) error {
+	resp, err := s.do(http.MethodPost, name, "uploads", nil, 0)
+
+	if err != nil {
+		return fmt.Errorf("Could not upload %s: %w", name, err)
+	}
+
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	err = s3Decode(resp, &upload)
+
+	if err != nil {
+		return fmt.Errorf("Could not upload %s: %w", name, err)
+	}
+
+	uploadID := url.QueryEscape(upload.UploadID)
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+
+	var complete struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+
+	for number, left := 1, size; left > 0; number++ {
+		n := min(left, s3PartSize)
+		resp, err := s.do(http.MethodPut, name, fmt.Sprintf("partNumber=%d&uploadId=%s", number, uploadID), io.LimitReader(r, n), n)
+
+		if err == nil && resp.StatusCode >= 300 {
+			err = fmt.Errorf("%s", s3Error(resp))
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if err != nil {
+			s.abort(name, uploadID)
+			return fmt.Errorf("Could not upload part %d of %s: %w", number, name, err)
+		}
+
+		complete.Parts = append(complete.Parts, part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		left -= n
+	}
+
+	body, err := xml.Marshal(complete)
+
+	if err != nil {
+		s.abort(name, uploadID)
+		return err
+	}
+
+	resp, err = s.do(http.MethodPost, name, "uploadId="+uploadID, bytes.NewReader(body), int64(len(body)))
+
+	if err != nil {
+		s.abort(name, uploadID)
+		return fmt.Errorf("Could not upload %s: %w", name, err)
+	}
+
+	// Completing can fail with a 200 and an error in the body
+	var result struct {
+		XMLName xml.Name
+		Message string
+	}
+
+	if err = s3Decode(resp, &result); err == nil && result.XMLName.Local == "Error" {
+		err = fmt.Errorf("%s", result.Message)
+	}
+
+	if err != nil {
+		s.abort(name, uploadID)
+		return fmt.Errorf("Could not upload %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// abort drops the parts of a multipart upload that failed.
+func (s *S3Storage) abort(name, uploadID string) {
+	if resp, err := s.do(http.MethodDelete, name, "uploadId="+uploadID, nil, -1); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// s3Decode reads the XML response of a successful request into v.
+func s3Decode(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", s3Error(resp))
+	}
+
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// s3Error returns the status of a failed request with the message S3 gave for it.
+func s3Error(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if i, j := bytes.Index(body, []byte("<Message>")), bytes.Index(body, []byte("</Message>")); i >= 0 && j > i {
+		return fmt.Sprintf("%s: %s", resp.Status, body[i+len("<Message>"):j])
+	}
+
+	return resp.Status
+}
+
+// sign adds an AWS Signature Version 4 to the request. The body isn't hashed, which S3
+// allows over HTTPS, so uploads can be streamed.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           stamp,
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but unreserved characters and slashes, the way
+// Signature Version 4 expects object keys to be encoded.
+func awsEscape(s string) string {
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily window of wall clock time, such as 01:00-07:00.
+// Windows may wrap around midnight, e.g. 22:00-06:00.
+type TimeWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// ParseTimeWindow parses a window written as HH:MM-HH:MM.
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("Time window %q must look like 01:00-07:00", s)
+	}
+
+	start, err := parseClock(from)
+
+	if err != nil {
+		return TimeWindow{}, err
+	}
+
+	end, err := parseClock(to)
+
+	if err != nil {
+		return TimeWindow{}, err
+	}
+
+	if start == end {
+		return TimeWindow{}, fmt.Errorf("Time window %q is empty", s)
+	}
+
+	return TimeWindow{start: start, end: end}, nil
+}
+
+// parseClock converts HH:MM into the time since midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time %q, expected HH:MM", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// sinceMidnight returns the wall clock time of t in its location as the time since
+// midnight, which on the days daylight saving time starts or ends isn't how long ago
+// midnight was.
+func sinceMidnight(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+}
+
+// Contains reports whether t falls inside of the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	now := sinceMidnight(t)
+
+	if w.start < w.end {
+		return now >= w.start && now < w.end
+	}
+
+	return now >= w.start || now < w.end
+}
+
+// untilBoundary returns how long until the window next opens or closes.
+func (w TimeWindow) untilBoundary(t time.Time) time.Duration {
+	boundary := w.start
+	if w.Contains(t) {
+		boundary = w.end
+	}
+
+	// By the calendar, as days are 23 or 25 hours long when the clocks change
+	y, m, d := t.Date()
+	at := func(day int) time.Time {
+		return time.Date(y, m, day, int(boundary/time.Hour), int(boundary%time.Hour/time.Minute), 0, 0, t.Location())
+	}
+
+	next := at(d)
+	if !next.After(t) {
+		next = at(d + 1)
+	}
+
+	return next.Sub(t)
+}
+
+// String formats the window the same way it is parsed.
+func (w TimeWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	return clock(w.start) + "-" + clock(w.end)
+}
+
+// enforceWindow keeps gate open only while the current time is inside of the window,
+// until done is closed. Jobs that are already running are allowed to finish.
+func enforceWindow(w TimeWindow, gate *pauseGate, done <-chan struct{}) {
+	for {
+		if w.Contains(time.Now()) {
+			gate.resume()
+		} else if !gate.isPaused() {
+			gate.pause()
+			log.Printf("Outside of the transfer window %s, waiting for it to open", w)
+		}
+
+		select {
+		case <-done:
+			gate.resume()
+			return
+		// Re-check at least every minute in case the clock jumps, e.g. after sleep
+		case <-time.After(min(w.untilBoundary(time.Now()), time.Minute)):
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+
+	if err != nil {
+		t.Fatalf("time.LoadLocation() = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		window string
+		at     time.Time
+		want   bool
+	}{
+		{"inside", "01:00-07:00", time.Date(2026, 6, 10, 3, 0, 0, 0, newYork), true},
+		{"at the start", "01:00-07:00", time.Date(2026, 6, 10, 1, 0, 0, 0, newYork), true},
+		{"at the end", "01:00-07:00", time.Date(2026, 6, 10, 7, 0, 0, 0, newYork), false},
+		{"before", "01:00-07:00", time.Date(2026, 6, 10, 0, 59, 0, 0, newYork), false},
+		{"wrapping, before midnight", "22:00-06:00", time.Date(2026, 6, 10, 23, 0, 0, 0, newYork), true},
+		{"wrapping, after midnight", "22:00-06:00", time.Date(2026, 6, 10, 5, 59, 0, 0, newYork), true},
+		{"wrapping, outside", "22:00-06:00", time.Date(2026, 6, 10, 12, 0, 0, 0, newYork), false},
+		{"clocks going forward", "03:00-04:00", time.Date(2026, 3, 8, 3, 30, 0, 0, newYork), true},
+		{"clocks going back", "00:00-01:30", time.Date(2026, 11, 1, 1, 45, 0, 0, newYork), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseTimeWindow(tt.window)
+
+			if err != nil {
+				t.Fatalf("ParseTimeWindow(%q) = %v", tt.window, err)
+			}
+
+			if got := w.Contains(tt.at); got != tt.want {
+				t.Errorf("%s.Contains(%v) = %v, want %v", tt.window, tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowUntilBoundary(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+
+	if err != nil {
+		t.Fatalf("time.LoadLocation() = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		window string
+		at     time.Time
+		want   time.Duration
+	}{
+		{"until it opens", "01:00-07:00", time.Date(2026, 6, 10, 0, 30, 0, 0, newYork), 30 * time.Minute},
+		{"until it closes", "01:00-07:00", time.Date(2026, 6, 10, 3, 0, 0, 0, newYork), 4 * time.Hour},
+		{"opens tomorrow", "01:00-07:00", time.Date(2026, 6, 10, 8, 0, 0, 0, newYork), 17 * time.Hour},
+		{"wrapping", "22:00-06:00", time.Date(2026, 6, 10, 23, 0, 0, 0, newYork), 7 * time.Hour},
+		{"clocks going forward", "01:00-07:00", time.Date(2026, 3, 8, 1, 30, 0, 0, newYork), 4*time.Hour + 30*time.Minute},
+		{"clocks going forward overnight", "22:00-06:00", time.Date(2026, 3, 7, 23, 0, 0, 0, newYork), 6 * time.Hour},
+		{"clocks going back", "00:00-07:00", time.Date(2026, 11, 1, 0, 30, 0, 0, newYork), 7*time.Hour + 30*time.Minute},
+		{"clocks going back overnight", "22:00-06:00", time.Date(2026, 10, 31, 23, 0, 0, 0, newYork), 8 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseTimeWindow(tt.window)
+
+			if err != nil {
+				t.Fatalf("ParseTimeWindow(%q) = %v", tt.window, err)
+			}
+
+			if got := w.untilBoundary(tt.at); got != tt.want {
+				t.Errorf("%s.untilBoundary(%v) = %v, want %v", tt.window, tt.at, got, tt.want)
+			}
+		})
+	}
+}
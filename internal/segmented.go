@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// minSegmentSize is the least every segment of a file gets, smaller files aren't worth
+// the extra connections.
+const minSegmentSize = 8 << 20
+
+// errRangesRejected is returned when the server sent the whole file instead of a range.
+var errRangesRejected = errors.New("The server doesn't support ranges")
+
+// WithSegments downloads large files in up to segments ranges at once with EngineHTTP and
+// EngineHybrid, which can fill fast connections that a single stream from Bandcamp's CDN
+// doesn't. Servers that don't serve ranges get a single stream.
+func WithSegments(segments int) func(*Downloader) {
+	return func(d *Downloader) {
+		d.segments = segments
+	}
+}
+
+// segment is a range of the file, from next up to and including end.
+type segment struct {
+	next int64
+	end  int64
+}
+
+// segmentWriter writes a segment into its place in the file.
+type segmentWriter struct {
+	t *transfer
+	s *segment
+}
+
+// Write writes p at the next position of the segment.
+func (w segmentWriter) Write(p []byte) (int, error) {
+	n, err := w.t.file.WriteAt(p, w.s.next)
+	w.s.next += int64(n)
+	w.t.advance(n)
+
+	return n, err
+}
+
+// probe requests the first byte of the file to learn its size and whether the server
+// serves ranges.
+func (t *transfer) probe() (bool, error) {
+	resp, stop, err := t.watchedRequest("bytes=0-0")
+
+	if err != nil {
+		return false, err
+	}
+
+	defer stop()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return false, nil
+	}
+
+	size, ok := contentSize(resp)
+
+	if !ok {
+		return false, nil
+	}
+
+	t.total = size
+	t.describe(resp)
+
+	return true, nil
+}
+
+// fetchSegmented downloads the file in up to segments ranges at once, each of which is
+// resumed on its own when it stops. It returns false without an error when the file
+// should be fetched in one stream instead: the server doesn't serve ranges or the file
+// is too small to split.
+func (t *transfer) fetchSegmented(stem string, segments int) (bool, error) {
+	if ok, err := t.probe(); err != nil || !ok {
+		// The stream reports what went wrong, if it keeps going wrong
+		return false, nil
+	}
+
+	segments = min(segments, int(t.total/minSegmentSize))
+
+	if segments < 2 {
+		return false, nil
+	}
+
+	if err := t.file.Truncate(t.total); err != nil {
+		return false, err
+	}
+
+	size := t.total / int64(segments)
+	errs := make([]error, segments)
+	var wg sync.WaitGroup
+
+	for i := range segments {
+		s := &segment{next: int64(i) * size, end: int64(i+1)*size - 1}
+
+		if i == segments-1 {
+			s.end = t.total - 1
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs[i] = t.fetchSegment(stem, s)
+		}()
+	}
+
+	wg.Wait()
+
+	if slices.ContainsFunc(errs, func(err error) bool { return errors.Is(err, errRangesRejected) }) {
+		log.Printf("%s for %s, downloading it in one stream", errRangesRejected, stem)
+		t.written = 0
+
+		return false, nil
+	}
+
+	return true, errors.Join(errs...)
+}
+
+// fetchSegment downloads the segment, resuming where an attempt stopped.
+func (t *transfer) fetchSegment(stem string, s *segment) error {
+	for attempt := 1; ; attempt++ {
+		err := t.fetchRange(s)
+
+		if err == nil || errors.Is(err, errRangesRejected) || errors.Is(err, errTransferTimeout) || t.ctx.Err() != nil || attempt == transferAttempts {
+			return err
+		}
+
+		log.Printf("Transfer of %s stopped at %s, resuming: %v", stem, FormatSize(s.next), err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// fetchRange requests the rest of the segment and writes it into place.
+func (t *transfer) fetchRange(s *segment) error {
+	resp, stop, err := t.watchedRequest(fmt.Sprintf("bytes=%d-%d", s.next, s.end))
+
+	if err != nil {
+		return err
+	}
+
+	defer stop()
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return errRangesRejected
+	default:
+		return fmt.Errorf("%s answered with %s", resp.Request.URL.Host, resp.Status)
+	}
+
+	_, err = io.Copy(segmentWriter{t, s}, resp.Body)
+
+	if err == nil && s.next <= s.end {
+		err = fmt.Errorf("Segment ended %s early", FormatSize(s.end-s.next+1))
+	}
+
+	return err
+}
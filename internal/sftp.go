@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// SFTPStorage uploads downloads to a server over SFTP, such as a NAS:
+//
+//	sftp://me@nas:22/volume1/music
+//
+// It runs the sftp command of OpenSSH, so the server must accept a key from ssh-agent or
+// ~/.ssh without asking for a password.
+type SFTPStorage struct {
+	target string
+	port   string
+	dir    string
+}
+
+// NewSFTPStorage creates an SFTPStorage for a location like "sftp://user@host/path".
+func NewSFTPStorage(location string) (*SFTPStorage, error) {
+	u, err := url.Parse(location)
+
+	if err != nil || u.Scheme != "sftp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("Invalid SFTP location %q, expected sftp://user@host/path", location)
+	}
+
+	if _, err := exec.LookPath("sftp"); err != nil {
+		return nil, fmt.Errorf("The sftp command is needed to upload over SFTP: %w", err)
+	}
+
+	target := u.Hostname()
+
+	if u.User != nil {
+		target = u.User.Username() + "@" + target
+	}
+
+	return &SFTPStorage{target: target, port: u.Port(), dir: path.Clean("/" + u.Path)}, nil
+}
+
+// remote returns the path of name on the server.
+func (s *SFTPStorage) remote(name string) string {
+	return path.Join(s.dir, name)
+}
+
+// batch runs the sftp commands, failing on the first one that fails unless it starts
+// with "-". It returns what sftp printed.
+func (s *SFTPStorage) batch(commands ...string) (string, error) {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command("sftp", append(args, s.target)...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	return output.String(), err
+}
+
+// Save uploads r next to name and renames it into place, so readers never see a partially
+// uploaded file. Downloads are uploaded straight from the file they were saved to, anything
+// else is copied to a temporary file first since sftp only uploads files.
+func (s *SFTPStorage) Save(name string, r io.Reader, size int64) error {
+	var source string
+
+	if file, ok := r.(*os.File); ok {
+		source = file.Name()
+	} else {
+		tmp, err := os.CreateTemp("", "bcdl-sftp-*")
+
+		if err != nil {
+			return fmt.Errorf("Could not stage %s: %w", name, err)
+		}
+
+		defer os.Remove(tmp.Name())
+
+		_, err = io.Copy(tmp, r)
+
+		if err = errors.Join(err, tmp.Close()); err != nil {
+			return fmt.Errorf("Could not stage %s: %w", name, err)
+		}
+
+		source = tmp.Name()
+	}
+
+	target := s.remote(name)
+	partial := target + ".part"
+	var commands []string
+
+	// mkdir fails for directories that exist, which the leading "-" ignores
+	for dir := path.Dir(target); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		commands = append([]string{"-mkdir " + sftpQuote(dir)}, commands...)
+	}
+
+	commands = append(commands,
+		"put "+sftpQuote(source)+" "+sftpQuote(partial),
+		"-rm "+sftpQuote(target),
+		"rename "+sftpQuote(partial)+" "+sftpQuote(target),
+	)
+
+	if output, err := s.batch(commands...); err != nil {
+		return fmt.Errorf("Could not upload %s: %w: %s", name, err, strings.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// Exists lists name on the server.
+func (s *SFTPStorage) Exists(name string) (bool, error) {
+	output, err := s.batch("ls " + sftpQuote(s.remote(name)))
+
+	if err == nil {
+		return true, nil
+	}
+
+	if strings.Contains(output, "not found") || strings.Contains(output, "No such file") {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("Could not check %s: %w: %s", name, err, strings.TrimSpace(output))
+}
+
+// sftpQuote quotes a path for an sftp batch file.
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sharedCopies are the downloads of the other accounts of a shared library, so an item
+// several of them own is only downloaded once, see WithSharedLibrary.
+type sharedCopies struct {
+	lib *library
+	// byID and byTitle hold the records by album and file type, byTitle only those
+	// recorded before item ids were
+	byID    map[string]HistoryEntry
+	byTitle map[string]HistoryEntry
+}
+
+// loadSharedCopies reads what the accounts sharing lib other than user downloaded into it.
+func loadSharedCopies(lib *library, user *User) (*sharedCopies, error) {
+	copies := &sharedCopies{lib: lib, byID: map[string]HistoryEntry{}, byTitle: map[string]HistoryEntry{}}
+
+	for _, stateDir := range libraryStateDirs(lib.dir) {
+		if stateDir == lib.stateDir {
+			continue
+		}
+
+		path := filepath.Join(stateDir, "history.jsonl")
+
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		history, err := LoadHistory(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		records, _ := history.List()
+
+		for _, record := range records {
+			// Without the file name there is no telling whether it is still there
+			if record.File == "" || record.ownedBy(user) {
+				continue
+			}
+
+			if record.ItemID != "" {
+				copies.byID[record.ItemID+"\x00"+string(record.FileType)] = record
+			} else {
+				copies.byTitle[record.Title+"\x00"+string(record.FileType)] = record
+			}
+		}
+	}
+
+	return copies, nil
+}
+
+// find returns the copy of the entry in ft another account downloaded, as long as it is
+// still in the library.
+func (c *sharedCopies) find(entry CollectionEntry, ft FileType) (HistoryEntry, bool) {
+	record, ok := c.byID[entry.id+"\x00"+string(ft)]
+
+	if !ok || entry.id == "" {
+		record, ok = c.byTitle[entry.title+"\x00"+string(ft)]
+	}
+
+	if !ok {
+		return record, false
+	}
+
+	exists, err := c.lib.exists(record.File)
+
+	return record, err == nil && exists
+}
+
+// recordShared records the entry in ft as downloaded for the user when another account of
+// the shared library already has it, instead of downloading it again. It reports whether
+// the entry was taken care of that way. A dry run only reports it.
+func (d *Downloader) recordShared(copies *sharedCopies, history HistoryStore, entry CollectionEntry, ft FileType, opts DownloadOpts) bool {
+	if copies == nil {
+		return false
+	}
+
+	record, ok := copies.find(entry, ft)
+
+	if !ok {
+		return false
+	}
+
+	var err error
+
+	if !d.dryRun {
+		err = history.Add(HistoryEntry{
+			FanID:        d.user.fanID,
+			Username:     d.user.username,
+			ItemID:       entry.id,
+			Title:        entry.title,
+			Artist:       record.Artist,
+			URL:          entry.url.String(),
+			FileType:     ft,
+			File:         record.File,
+			SHA256:       record.SHA256,
+			Gifter:       entry.gifter,
+			DownloadedAt: time.Now(),
+		})
+	}
+
+	if err != nil {
+		log.Printf("Could not record %s in history: %v", entry.title, err)
+		return false
+	}
+
+	item := entryItem(entry, ft)
+	item.Dir = copies.lib.dir
+	item.Path = record.File
+	item.Existing = true
+
+	if opts.OnShared != nil {
+		opts.OnShared(item, record.Username)
+	} else {
+		opts.OnSkip.call(item)
+	}
+
+	return true
+}
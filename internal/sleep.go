@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// How often the clocks are compared, how far they have to drift apart to count as the
+// machine having slept, and how often the session is checked after waking up.
+const (
+	sleepCheckInterval = 5 * time.Second
+	sleepThreshold     = 30 * time.Second
+	wakeAttempts       = 6
+)
+
+// sleepWatcher notices the machine waking up from sleep, e.g. when a laptop lid was closed
+// in the middle of a run. The monotonic clock Go measures elapsed time with doesn't advance
+// while Linux, macOS and Windows are suspended and the wall clock does, so after a sleep
+// the wall clock is ahead by how long it lasted.
+//
+// Nothing tells bcdl that the machine is about to sleep, so the jobs running then fail
+// when their connections die. Those are queued again, and no new job starts until the
+// session was checked after waking up, which also gives the network time to come back.
+type sleepWatcher struct {
+	mu sync.Mutex
+	// last is when the clocks were compared
+	last time.Time
+	// gate holds workers back until the session was checked
+	gate *pauseGate
+	// verify checks that Bandcamp still accepts the session
+	verify func() error
+}
+
+// watchSleep checks the clocks until done is closed, calling verify whenever the machine
+// woke up.
+func watchSleep(verify func() error, done <-chan struct{}) *sleepWatcher {
+	w := &sleepWatcher{last: time.Now(), gate: newPauseGate(), verify: verify}
+
+	go w.run(done)
+
+	return w
+}
+
+// run checks the clocks every sleepCheckInterval.
+func (w *sleepWatcher) run(done <-chan struct{}) {
+	ticker := time.NewTicker(sleepCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check compares how much time passed on both clocks since the last check. When the
+// machine slept, it returns once the session was checked.
+func (w *sleepWatcher) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	slept := sleptSince(w.last)
+	w.last = time.Now()
+
+	if slept > sleepThreshold {
+		w.woke(slept)
+	}
+}
+
+// sleptSince returns how long the machine slept since t, which has to carry a monotonic
+// clock reading.
+func sleptSince(t time.Time) time.Duration {
+	now := time.Now()
+
+	// Round(0) strips the monotonic reading, leaving the wall clock
+	return now.Round(0).Sub(t.Round(0)) - now.Sub(t)
+}
+
+// woke holds back new jobs until the session is accepted again, retrying while the
+// network comes back.
+func (w *sleepWatcher) woke(slept time.Duration) {
+	w.gate.pause()
+	defer w.gate.resume()
+
+	log.Printf("The computer slept for %v, checking the session before continuing", slept.Round(time.Second))
+
+	for attempt := 1; ; attempt++ {
+		err := w.verify()
+
+		if err == nil {
+			log.Println("Session checked, continuing the downloads")
+			return
+		}
+
+		if attempt == wakeAttempts {
+			log.Printf("Could not check the session after waking up, continuing anyway: %v", err)
+			return
+		}
+
+		wait := time.Duration(attempt) * 10 * time.Second
+		log.Printf("Could not check the session after waking up, retrying in %v: %v", wait, err)
+		time.Sleep(wait)
+	}
+}
+
+// interrupted reports whether the machine slept since a job started at start, so its
+// failure is queued again rather than counted. It waits for the session to be checked.
+func (w *sleepWatcher) interrupted(start time.Time) bool {
+	if sleptSince(start) <= sleepThreshold {
+		return false
+	}
+
+	w.check()
+
+	return true
+}
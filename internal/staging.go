@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithStaging downloads, verifies and extracts everything in an incoming directory and
+// only moves the files into the library once the run is done, or in watch mode whenever
+// the queue ran dry, so media servers scanning the library never see half finished
+// albums. An empty incoming stages in .bcdl/incoming of every library. It should be on
+// the same file system as the library, so moving files is a rename.
+func WithStaging(incoming string) func(*Downloader) {
+	return func(d *Downloader) {
+		d.staged = true
+		d.incoming = incoming
+	}
+}
+
+// stage points the library at its incoming directory, after moving in what an interrupted
+// run left there.
+func (d *Downloader) stage(lib *library) error {
+	if _, local := lib.storage.(*LocalStorage); !local {
+		return errors.New("Downloads can only be staged for a library on this machine")
+	}
+
+	lib.incoming = d.incoming
+
+	if lib.incoming == "" {
+		lib.incoming = filepath.Join(lib.dir, ".bcdl", "incoming")
+	}
+
+	if !d.dryRun {
+		if err := os.MkdirAll(lib.incoming, 0o777); err != nil {
+			return fmt.Errorf("Could not create incoming dir: %w", err)
+		}
+
+		if moved, err := lib.promote(); err != nil {
+			return err
+		} else if moved > 0 {
+			log.Printf("Moved %d files an earlier run left in %s into the library", moved, lib.incoming)
+		}
+	}
+
+	lib.storage = NewLocalStorage(lib.incoming)
+
+	return nil
+}
+
+// workDir is where downloads of the library are written and extracted.
+func (lib *library) workDir() string {
+	if lib.incoming != "" {
+		return lib.incoming
+	}
+
+	return lib.dir
+}
+
+// exists checks if name was saved, staged or not.
+func (lib *library) exists(name string) (bool, error) {
+	exists, err := lib.storage.Exists(name)
+
+	if err != nil || exists || lib.incoming == "" {
+		return exists, err
+	}
+
+	return NewLocalStorage(lib.dir).Exists(name)
+}
+
+// localPath returns where name is on disk: in the local storage, or in the library when
+// it is staged and name was moved in by an earlier run.
+func (lib *library) localPath(local *LocalStorage, name string) string {
+	path := local.Path(name)
+
+	if lib.incoming == "" {
+		return path
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return NewLocalStorage(lib.dir).Path(name)
+	}
+
+	return path
+}
+
+// promote moves the files in the incoming directory to the same place in the library,
+// replacing what is there, and returns how many it moved. Archives that don't read back
+// are left behind.
+func (lib *library) promote() (int, error) {
+	moved := 0
+	var dirs []string
+
+	err := lib.withLock(func() error {
+		return filepath.WalkDir(lib.incoming, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if entry.IsDir() {
+				if path != lib.incoming {
+					dirs = append(dirs, path)
+				}
+
+				return nil
+			}
+
+			// Partial files of a crashed run
+			if strings.HasPrefix(entry.Name(), ".bcdl-") {
+				return nil
+			}
+
+			if strings.EqualFold(filepath.Ext(path), ".zip") {
+				if err := VerifyArchive(path); err != nil {
+					log.Printf("Leaving %s in %s: %v", entry.Name(), lib.incoming, err)
+					return nil
+				}
+			}
+
+			rel, err := filepath.Rel(lib.incoming, path)
+
+			if err != nil {
+				return err
+			}
+
+			target := filepath.Join(lib.dir, rel)
+
+			if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+				return fmt.Errorf("Could not create directory: %w", err)
+			}
+
+			if err := os.Rename(path, target); err != nil {
+				return fmt.Errorf("Could not move %s into the library: %w", rel, err)
+			}
+
+			moved++
+
+			return nil
+		})
+	})
+
+	// Deepest first, so parents are empty by the time they are removed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+
+	return moved, err
+}
+
+// promoteStaged moves the staged downloads of the libraries into place, logging what went
+// wrong.
+func (d *Downloader) promoteStaged(libs []*library) {
+	done := map[string]bool{}
+
+	for _, lib := range libs {
+		if lib.incoming == "" || done[lib.incoming] {
+			continue
+		}
+
+		done[lib.incoming] = true
+
+		moved, err := lib.promote()
+
+		if err != nil {
+			log.Printf("Could not move the downloads in %s into the library: %v", lib.incoming, err)
+		}
+
+		if moved > 0 {
+			log.Printf("Moved %d files into %s", moved, lib.dir)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// DefaultStallTimeout is how long DefaultDownloader lets a transfer go without receiving
+// anything before it is resumed.
+const DefaultStallTimeout = 2 * time.Minute
+
+// errTransferTimeout is returned when a transfer took longer than WithTransferTimeout allows.
+var errTransferTimeout = errors.New("The transfer took too long")
+
+// errStalled is returned when a transfer received nothing for as long as WithStallTimeout allows.
+var errStalled = errors.New("The transfer stalled")
+
+// WithTransferTimeout bounds how long the transfer of a file may take once Bandcamp
+// prepared it, unlike WithTimeout which only bounds how long it takes to start. Zero, the
+// default, lets it take as long as it needs.
+func WithTransferTimeout(timeout time.Duration) func(*Downloader) {
+	return func(d *Downloader) {
+		d.limits.timeout = timeout
+	}
+}
+
+// WithStallTimeout gives up on a transfer with EngineHTTP and EngineHybrid once it received
+// nothing for timeout, and resumes it from where it stopped. The browser doesn't report
+// progress, so it is only bounded by WithTransferTimeout. Zero waits forever.
+func WithStallTimeout(timeout time.Duration) func(*Downloader) {
+	return func(d *Downloader) {
+		d.limits.stall = timeout
+	}
+}
+
+// transferLimits are the bounds of a transfer, see WithTransferTimeout and WithStallTimeout.
+type transferLimits struct {
+	timeout time.Duration
+	stall   time.Duration
+}
+
+// watchedRequest requests the file like request, cancelled once the transfer ran past its
+// deadline or the body received nothing for the stall timeout. stop has to be called once
+// the body was read.
+func (t *transfer) watchedRequest(rng string) (*http.Response, func(), error) {
+	ctx, cancel := context.WithCancelCause(t.ctx)
+	stop := func() { cancel(nil) }
+
+	if !t.deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadlineCause(ctx, t.deadline, errTransferTimeout)
+		stop = func() {
+			cancelDeadline()
+			cancel(nil)
+		}
+	}
+
+	resp, err := t.request(ctx, rng)
+
+	if err != nil {
+		stop()
+
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+
+		return nil, nil, err
+	}
+
+	body := &watchedBody{ReadCloser: resp.Body, ctx: ctx}
+
+	if t.limits.stall > 0 {
+		body.stall = t.limits.stall
+		body.timer = time.AfterFunc(body.stall, func() {
+			cancel(fmt.Errorf("%w, nothing arrived for %s", errStalled, body.stall))
+		})
+	}
+
+	resp.Body = body
+
+	return resp, func() {
+		if body.timer != nil {
+			body.timer.Stop()
+		}
+
+		stop()
+	}, nil
+}
+
+// watchedBody is the body of a watched request, which pushes back the stall timeout
+// whenever something arrives.
+type watchedBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	stall time.Duration
+	timer *time.Timer
+}
+
+// Read reads from the body, returning why the request was cancelled if it was.
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if n > 0 && b.timer != nil {
+		b.timer.Reset(b.stall)
+	}
+
+	if err != nil && b.ctx.Err() != nil {
+		err = context.Cause(b.ctx)
+	}
+
+	return n, err
+}
+
+// transferStarted tells the worker running the job that the transfer started, from when on
+// WithTimeout no longer applies.
+func (job downloadJob) transferStarted() {
+	if job.transferring != nil {
+		job.transferring()
+	}
+}
+
+// waitTransfer waits for the browser to finish the download, cancelling it once it took
+// longer than the transfer timeout.
+func (job downloadJob) waitTransfer(dl playwright.Download) error {
+	if job.limits.timeout <= 0 {
+		return dl.Failure()
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- dl.Failure()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(job.limits.timeout):
+		dl.Cancel()
+		return fmt.Errorf("%w, gave up after %s", errTransferTimeout, job.limits.timeout)
+	}
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Names inside of a state archive
+const (
+	stateConfigName  = "config.toml"
+	stateLibraryName = "library"
+)
+
+// ExportState writes a gzipped tar archive of the config file at configPath and everything
+// bcdl keeps in the .bcdl directory of the library at dir: history, collection caches and
+// failed downloads. Either may be empty to leave it out.
+//
+// The identity cookie is never part of the archive. Run `bcdl login` on the new machine.
+func ExportState(w io.Writer, configPath, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if configPath != "" {
+		if err := addStateFile(tw, configPath, stateConfigName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if dir != "" {
+		bcdlDir := filepath.Join(dir, ".bcdl")
+
+		err := filepath.WalkDir(bcdlDir, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !exportedStateFile(entry.Name()) {
+				return err
+			}
+
+			rel, err := filepath.Rel(dir, p)
+
+			if err != nil {
+				return err
+			}
+
+			return addStateFile(tw, p, path.Join(stateLibraryName, filepath.ToSlash(rel)))
+		})
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("Could not export %s: %w", bcdlDir, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("Could not write the state archive: %w", err)
+	}
+
+	return gz.Close()
+}
+
+// exportedStateFile leaves out the library lock and files that are still being written.
+func exportedStateFile(name string) bool {
+	return name != "library.lock" && !strings.HasSuffix(name, ".tmp")
+}
+
+// addStateFile copies the file at src into the archive as name.
+func addStateFile(tw *tar.Writer, src, name string) error {
+	file, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+
+	if err != nil {
+		return fmt.Errorf("Could not write the state archive: %w", err)
+	}
+
+	if _, err = io.Copy(tw, file); err != nil {
+		return fmt.Errorf("Could not write the state archive: %w", err)
+	}
+
+	return nil
+}
+
+// ImportState restores an archive made by ExportState. The config is written to configPath
+// and the library state into the .bcdl directory of dir. Either may be empty to skip it.
+//
+// Existing files are kept unless overwrite is set, and the names of the files restored
+// are returned.
+func ImportState(r io.Reader, configPath, dir string, overwrite bool) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the state archive: %w", err)
+	}
+
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	var restored []string
+
+	for {
+		header, err := tr.Next()
+
+		if errors.Is(err, io.EOF) {
+			return restored, nil
+		}
+
+		if err != nil {
+			return restored, fmt.Errorf("Could not read the state archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		dst, err := stateDestination(header.Name, configPath, dir)
+
+		if err != nil {
+			return restored, err
+		}
+
+		if dst == "" {
+			continue
+		}
+
+		if _, err := os.Stat(dst); err == nil && !overwrite {
+			return restored, fmt.Errorf("Could not restore %s: %w", dst, os.ErrExist)
+		}
+
+		if err = restoreStateFile(tr, dst, header.ModTime); err != nil {
+			return restored, err
+		}
+
+		restored = append(restored, dst)
+	}
+}
+
+// stateDestination maps a name inside of the archive to where it is restored. Names
+// that would end up outside of the library are rejected.
+func stateDestination(name, configPath, dir string) (string, error) {
+	if name == stateConfigName {
+		return configPath, nil
+	}
+
+	rel, ok := strings.CutPrefix(name, stateLibraryName+"/")
+
+	if !ok || dir == "" {
+		return "", nil
+	}
+
+	rel = path.Clean(rel)
+
+	if !strings.HasPrefix(rel, ".bcdl/") || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", fmt.Errorf("Refusing to restore %s from the state archive", name)
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(rel)), nil
+}
+
+// restoreStateFile writes the contents of r to dst through a temporary file.
+func restoreStateFile(r io.Reader, dst string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o777); err != nil {
+		return fmt.Errorf("Could not create %s: %w", filepath.Dir(dst), err)
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return fmt.Errorf("Could not restore %s: %w", dst, err)
+	}
+
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("Could not restore %s: %w", dst, err)
+	}
+
+	if err = out.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Could not restore %s: %w", dst, err)
+	}
+
+	os.Chtimes(tmp, modTime, modTime)
+
+	if err = os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("Could not restore %s: %w", dst, err)
+	}
+
+	return nil
+}
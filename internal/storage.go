@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is where finished downloads are delivered.
+//
+// Names are slash separated paths relative to the root of the storage, e.g.
+// "Artist - Album.zip" or "Artist/Album/cover.jpg".
+type Storage interface {
+	// Save writes the contents of r to name, replacing anything already there.
+	// size is the number of bytes r will produce, or -1 if unknown.
+	Save(name string, r io.Reader, size int64) error
+	// Exists reports whether name has already been saved.
+	Exists(name string) (bool, error)
+}
+
+// OpenStorage creates the Storage for a remote location:
+//
+//	s3://bucket/path         Amazon S3 or, with BCDL_S3_ENDPOINT, a compatible service
+//	b2://bucket/path         Backblaze B2 in the region of AWS_REGION, e.g. us-west-004
+//	sftp://user@host/path    an SSH server
+//
+// S3 and B2 read their keys from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, which for B2
+// are the key id and application key.
+func OpenStorage(location string) (Storage, error) {
+	scheme, rest, _ := strings.Cut(location, "://")
+	creds := S3Credentials{
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Region:    os.Getenv("AWS_REGION"),
+		Endpoint:  os.Getenv("BCDL_S3_ENDPOINT"),
+	}
+
+	switch scheme {
+	case "s3":
+		return NewS3Storage(location, creds)
+	case "b2":
+		if creds.Region == "" {
+			return nil, errors.New("B2 needs the region of the bucket in AWS_REGION, e.g. us-west-004")
+		}
+
+		if creds.Endpoint == "" {
+			creds.Endpoint = fmt.Sprintf("https://s3.%s.backblazeb2.com", creds.Region)
+		}
+
+		return NewS3Storage("s3://"+rest, creds)
+	case "sftp":
+		return NewSFTPStorage(location)
+	}
+
+	return nil, fmt.Errorf("Unsupported storage %q, expected s3://, b2:// or sftp://", location)
+}
+
+// LocalStorage saves downloads into a directory on the local machine. It is the default Storage.
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage creates a LocalStorage rooted at dir.
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{Dir: dir}
+}
+
+// Path returns where name is stored on disk. Long paths are prefixed so Windows
+// accepts them.
+func (s *LocalStorage) Path(name string) string {
+	return longPath(filepath.Join(s.Dir, filepath.FromSlash(name)))
+}
+
+// Save writes r to a temporary file next to the target and renames it into place,
+// so readers never see a partially written file.
+func (s *LocalStorage) Save(name string, r io.Reader, size int64) error {
+	path := s.Path(name)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return fmt.Errorf("Could not create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".bcdl-*")
+
+	if err != nil {
+		return fmt.Errorf("Could not create %s: %w", name, err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return fmt.Errorf("Could not write %s: %w", name, err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Exists reports whether name is already on disk.
+func (s *LocalStorage) Exists(name string) (bool, error) {
+	_, err := os.Stat(s.Path(name))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
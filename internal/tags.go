@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Tags larger than this are not worth reading just for the disc number.
+const maxTagSize = 16 << 20
+
+// readDiscTag returns the disc number stored in the tags of an audio file, or 0 if it
+// has none. Only FLAC and ID3v2 tagged files are understood.
+func readDiscTag(r io.Reader, ext string) int {
+	switch ext {
+	case ".flac":
+		return flacDiscNumber(r)
+	case ".mp3", ".aiff", ".aif", ".wav":
+		return id3DiscNumber(r)
+	}
+
+	return 0
+}
+
+// parseDiscNumber handles values like "2" and "2/3".
+func parseDiscNumber(value string) int {
+	value, _, _ = strings.Cut(strings.TrimSpace(value), "/")
+	n, err := strconv.Atoi(value)
+
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+// flacDiscNumber reads the DISCNUMBER vorbis comment from a FLAC stream.
+func flacDiscNumber(r io.Reader) int {
+	magic := make([]byte, 4)
+
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		return 0
+	}
+
+	for {
+		header := make([]byte, 4)
+
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		if blockType != 4 {
+			if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil || last {
+				return 0
+			}
+
+			continue
+		}
+
+		block := make([]byte, length)
+
+		if _, err := io.ReadFull(r, block); err != nil {
+			return 0
+		}
+
+		return vorbisDiscNumber(block)
+	}
+}
+
+// vorbisDiscNumber searches a vorbis comment block for DISCNUMBER.
+func vorbisDiscNumber(block []byte) int {
+	buf := bytes.NewReader(block)
+
+	var vendorLength uint32
+	if binary.Read(buf, binary.LittleEndian, &vendorLength) != nil {
+		return 0
+	}
+
+	if _, err := buf.Seek(int64(vendorLength), io.SeekCurrent); err != nil {
+		return 0
+	}
+
+	var count uint32
+	if binary.Read(buf, binary.LittleEndian, &count) != nil {
+		return 0
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var length uint32
+		if binary.Read(buf, binary.LittleEndian, &length) != nil || int(length) > buf.Len() {
+			return 0
+		}
+
+		comment := make([]byte, length)
+		buf.Read(comment)
+
+		key, value, ok := strings.Cut(string(comment), "=")
+		if ok && strings.EqualFold(key, "DISCNUMBER") {
+			return parseDiscNumber(value)
+		}
+	}
+
+	return 0
+}
+
+// syncsafe decodes the 7 bits per byte integers ID3v2 uses for sizes.
+func syncsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
+
+// id3DiscNumber reads the TPOS (part of set) frame from an ID3v2.3 or ID3v2.4 tag.
+func id3DiscNumber(r io.Reader) int {
+	header := make([]byte, 10)
+
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "ID3" {
+		return 0
+	}
+
+	version := header[3]
+	size := syncsafe(header[6:10])
+
+	if (version != 3 && version != 4) || size > maxTagSize {
+		return 0
+	}
+
+	tag := make([]byte, size)
+
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return 0
+	}
+
+	pos := 0
+
+	// Skip the extended header
+	if header[5]&0x40 != 0 && len(tag) >= 4 {
+		if version == 4 {
+			pos = syncsafe(tag[:4])
+		} else {
+			pos = int(binary.BigEndian.Uint32(tag[:4])) + 4
+		}
+	}
+
+	for pos+10 <= len(tag) {
+		id := string(tag[pos : pos+4])
+		frameSize := int(binary.BigEndian.Uint32(tag[pos+4 : pos+8]))
+
+		if version == 4 {
+			frameSize = syncsafe(tag[pos+4 : pos+8])
+		}
+
+		// Padding or a corrupt frame
+		if id[0] == 0 || frameSize <= 0 || pos+10+frameSize > len(tag) {
+			return 0
+		}
+
+		if id == "TPOS" {
+			// Skip the text encoding byte. Disc numbers are plain digits in every encoding
+			// once the byte order marks and NUL bytes of UTF-16 are dropped
+			value := bytes.Map(func(r rune) rune {
+				if r == '/' || (r >= '0' && r <= '9') {
+					return r
+				}
+				return -1
+			}, tag[pos+11:pos+10+frameSize])
+
+			return parseDiscNumber(string(value))
+		}
+
+		pos += 10 + frameSize
+	}
+
+	return 0
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phase is one step of downloading an item.
+type Phase string
+
+const (
+	PhaseNavigate Phase = "navigate"
+	PhaseSelect   Phase = "select"
+	PhasePrepare  Phase = "prepare"
+	PhaseTransfer Phase = "transfer"
+	PhaseSave     Phase = "save"
+)
+
+var allPhases = []Phase{PhaseNavigate, PhaseSelect, PhasePrepare, PhaseTransfer, PhaseSave}
+
+// timings collects how long every phase took across all items of a run.
+// A nil *timings ignores everything, so it can be passed around unconditionally.
+type timings struct {
+	mu      sync.Mutex
+	samples map[Phase][]time.Duration
+}
+
+func newTimings() *timings {
+	return &timings{samples: make(map[Phase][]time.Duration)}
+}
+
+// since records the time elapsed since start for the phase.
+func (t *timings) since(phase Phase, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples[phase] = append(t.samples[phase], time.Since(start))
+}
+
+// PhaseStats summarizes the durations of a single phase.
+type PhaseStats struct {
+	Phase Phase
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// TimingReport has the stats of every phase that was recorded, in the order phases happen.
+type TimingReport []PhaseStats
+
+// report computes the percentiles of everything recorded so far.
+func (t *timings) report() TimingReport {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var report TimingReport
+
+	for _, phase := range allPhases {
+		samples := append([]time.Duration(nil), t.samples[phase]...)
+
+		if len(samples) == 0 {
+			continue
+		}
+
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+		report = append(report, PhaseStats{
+			Phase: phase,
+			Count: len(samples),
+			P50:   percentile(samples, 0.5),
+			P90:   percentile(samples, 0.9),
+			P99:   percentile(samples, 0.99),
+			Max:   samples[len(samples)-1],
+		})
+	}
+
+	return report
+}
+
+// percentile uses the nearest rank method on sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// String renders the report as a table.
+func (r TimingReport) String() string {
+	var s strings.Builder
+
+	fmt.Fprintf(&s, "%-10s %6s %10s %10s %10s %10s\n", "phase", "items", "p50", "p90", "p99", "max")
+
+	for _, stats := range r {
+		fmt.Fprintf(&s, "%-10s %6d %10s %10s %10s %10s\n", stats.Phase, stats.Count,
+			stats.P50.Round(time.Millisecond), stats.P90.Round(time.Millisecond),
+			stats.P99.Round(time.Millisecond), stats.Max.Round(time.Millisecond))
+	}
+
+	return s.String()
+}
@@ -0,0 +1,293 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"bcdl/internal"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Queue is the queue management API the dashboard drives. It is satisfied by [internal.Downloader].
+type Queue interface {
+	Download(opts internal.DownloadOpts) error
+	Prioritize(key string) error
+	Cancel(key string) error
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+type itemStatus string
+
+const (
+	statusQueued       itemStatus = "queued"
+	statusPreparing    itemStatus = "preparing"
+	statusTransferring itemStatus = "transferring"
+	statusDone         itemStatus = "done"
+	statusFailed       itemStatus = "failed"
+	statusSkipped      itemStatus = "skipped"
+	statusCancelled    itemStatus = "cancelled"
+	statusDeferred     itemStatus = "deferred"
+)
+
+var statusStyles = map[itemStatus]lipgloss.Style{
+	statusQueued:       lipgloss.NewStyle().Foreground(lipgloss.Color("245")),
+	statusPreparing:    lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	statusTransferring: lipgloss.NewStyle().Foreground(lipgloss.Color("39")),
+	statusDone:         lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+	statusFailed:       lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+	statusSkipped:      lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+	statusCancelled:    lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+	statusDeferred:     lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+}
+
+// statusMsg is sent by the download callbacks whenever an album changes state
+type statusMsg struct {
+	key    string
+	title  string
+	status itemStatus
+}
+
+// downloadDoneMsg is sent once the Download call returns
+type downloadDoneMsg struct {
+	err error
+}
+
+// row is a single album shown on the dashboard
+type row struct {
+	key    string
+	title  string
+	status itemStatus
+}
+
+// dashboard tracks the progress of every album in the run
+type dashboard struct {
+	queue  Queue
+	rows   []row
+	cursor int
+	height int
+	notice string
+	done   bool
+	err    error
+
+	keys DashboardKeyMap
+	help help.Model
+}
+
+// find returns the index of the row for the album with the key or -1
+func (d *dashboard) find(key string) int {
+	for i, r := range d.rows {
+		if r.key == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// moveToFrontOfQueue mirrors a prioritized album by placing it above every other queued row
+func (d *dashboard) moveToFrontOfQueue(i int) {
+	first := i
+
+	for j := 0; j < i; j++ {
+		if d.rows[j].status == statusQueued {
+			first = j
+			break
+		}
+	}
+
+	r := d.rows[i]
+	copy(d.rows[first+1:i+1], d.rows[first:i])
+	d.rows[first] = r
+	d.cursor = first
+}
+
+// Init has nothing to do. The download is started by [RunDashboard]
+func (d dashboard) Init() tea.Cmd {
+	return nil
+}
+
+// Update applies status changes from the download and handles queue management keys
+func (d dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		d.height = msg.Height
+	case statusMsg:
+		if i := d.find(msg.key); i >= 0 {
+			d.rows[i].status = msg.status
+		} else {
+			d.rows = append(d.rows, row{key: msg.key, title: msg.title, status: msg.status})
+		}
+	case downloadDoneMsg:
+		d.done = true
+		d.err = msg.err
+		return d, tea.Quit
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, d.keys.Quit):
+			return d, tea.Quit
+		case key.Matches(msg, d.keys.Up):
+			if d.cursor > 0 {
+				d.cursor--
+			}
+		case key.Matches(msg, d.keys.Down):
+			if d.cursor < len(d.rows)-1 {
+				d.cursor++
+			}
+		case key.Matches(msg, d.keys.Prioritize):
+			if len(d.rows) == 0 {
+				break
+			}
+
+			title := d.rows[d.cursor].title
+			if err := d.queue.Prioritize(d.rows[d.cursor].key); err != nil {
+				d.notice = fmt.Sprintf("Could not prioritize %s: %v", title, err)
+			} else {
+				d.notice = fmt.Sprintf("%s is up next", title)
+				d.moveToFrontOfQueue(d.cursor)
+			}
+		case key.Matches(msg, d.keys.Cancel):
+			if len(d.rows) == 0 {
+				break
+			}
+
+			title := d.rows[d.cursor].title
+			if err := d.queue.Cancel(d.rows[d.cursor].key); err != nil {
+				d.notice = fmt.Sprintf("Could not cancel %s: %v", title, err)
+			} else {
+				d.notice = fmt.Sprintf("Cancelled %s", title)
+			}
+		case key.Matches(msg, d.keys.Pause):
+			if d.queue.Paused() {
+				d.queue.Resume()
+				d.notice = "Resumed"
+			} else {
+				d.queue.Pause()
+				d.notice = "Paused. In-flight downloads will finish"
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// visibleRows returns the range of rows that fit on screen while keeping the cursor visible
+func (d dashboard) visibleRows() (int, int) {
+	// Leave room for the header, notice and help
+	limit := d.height - 6
+
+	if limit <= 0 || limit >= len(d.rows) {
+		return 0, len(d.rows)
+	}
+
+	start := d.cursor - limit/2
+	start = max(0, min(start, len(d.rows)-limit))
+
+	return start, start + limit
+}
+
+// View renders the table of albums and their statuses
+func (d dashboard) View() string {
+	var s strings.Builder
+
+	counts := make(map[itemStatus]int)
+	for _, r := range d.rows {
+		counts[r.status]++
+	}
+
+	s.WriteString(fmt.Sprintf("%d queued, %d in progress, %d done, %d failed",
+		counts[statusQueued], counts[statusPreparing]+counts[statusTransferring], counts[statusDone], counts[statusFailed]))
+
+	if counts[statusDeferred] > 0 {
+		s.WriteString(fmt.Sprintf(", %d deferred for lack of space", counts[statusDeferred]))
+	}
+
+	if d.queue.Paused() {
+		s.WriteString(" (paused)")
+	}
+
+	s.WriteString("\n\n")
+
+	start, end := d.visibleRows()
+	for i := start; i < end; i++ {
+		r := d.rows[i]
+		line := fmt.Sprintf("%-12s %s", statusStyles[r.status].Render(string(r.status)), r.title)
+
+		if i == d.cursor && !d.done {
+			s.WriteString(selectedItemStyle.Render("> " + line))
+		} else {
+			s.WriteString(itemStyle.Render(line))
+		}
+
+		s.WriteString("\n")
+	}
+
+	if d.notice != "" {
+		s.WriteString("\n" + d.notice + "\n")
+	}
+
+	if !d.done {
+		s.WriteString("\n" + d.help.View(d.keys))
+	}
+
+	return s.String()
+}
+
+// RunDashboard runs the download with opts and shows the progress of every album. The
+// callbacks of opts are still called, before the dashboard updates. Queued albums can be
+// prioritized or cancelled, and the whole queue paused, while the run is in progress.
+func RunDashboard(queue Queue, opts internal.DownloadOpts) error {
+	d := dashboard{
+		queue: queue,
+		keys:  DefaultDashboardKeyMap(),
+		help:  help.New(),
+	}
+
+	p := tea.NewProgram(d)
+
+	status := func(next func(internal.Item), s itemStatus) func(internal.Item) {
+		return func(item internal.Item) {
+			if next != nil {
+				next(item)
+			}
+
+			p.Send(statusMsg{key: item.Key(), title: item.Title, status: s})
+		}
+	}
+
+	opts.OnStart = status(opts.OnStart, statusQueued)
+	opts.OnSkip = status(opts.OnSkip, statusSkipped)
+	opts.OnPrepareStart = status(opts.OnPrepareStart, statusPreparing)
+	opts.OnPrepareDone = status(opts.OnPrepareDone, statusTransferring)
+	opts.OnSuccess = status(opts.OnSuccess, statusDone)
+	opts.OnFailure = status(opts.OnFailure, statusFailed)
+	opts.OnCancel = status(opts.OnCancel, statusCancelled)
+	opts.OnDeferred = status(opts.OnDeferred, statusDeferred)
+
+	// Without a callback of their own they are reported through OnFailure
+	if opts.OnRegionLocked != nil {
+		opts.OnRegionLocked = status(opts.OnRegionLocked, statusFailed)
+	}
+
+	go func() {
+		p.Send(downloadDoneMsg{err: queue.Download(opts)})
+	}()
+
+	final, err := p.Run()
+
+	if err != nil {
+		return err
+	}
+
+	if !final.(dashboard).done {
+		return fmt.Errorf("Dashboard closed before the downloads finished")
+	}
+
+	return final.(dashboard).err
+}
@@ -16,6 +16,7 @@ import (
 var (
 	itemStyle         = lipgloss.NewStyle().PaddingLeft(4)
 	selectedItemStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
+	descriptionStyle  = lipgloss.NewStyle().PaddingLeft(7).Faint(true)
 )
 
 // Set up a custom list item
@@ -24,11 +25,15 @@ type item internal.FileType
 // FilterValue returns the empty string. For our simple list, no filtering is allowed
 func (i item) FilterValue() string { return "" }
 
-type itemDelegate struct{}
+// itemDelegate renders the format list. albums is the size of the user's collection
+// if it is known, otherwise 0.
+type itemDelegate struct {
+	albums int
+}
 
 // See the example for a [Simple List]
 // [Simple List]: https://github.com/charmbracelet/bubbletea/blob/0af4525f516ab9150a1cfe5abb68d1fdc145a29c/examples/list-simple/main.go#L31
-func (d itemDelegate) Height() int { return 1 }
+func (d itemDelegate) Height() int { return 2 }
 
 // See the example for a [Simple List]
 // [Simple List]: https://github.com/charmbracelet/bubbletea/blob/0af4525f516ab9150a1cfe5abb68d1fdc145a29c/examples/list-simple/main.go#L32
@@ -55,5 +60,12 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 		}
 	}
 
-	fmt.Fprint(w, fn(str))
+	ft := internal.FileType(i)
+	description := fmt.Sprintf("%s. About %d MB per album", ft.Description(), ft.TypicalAlbumSize()/1_000_000)
+
+	if d.albums > 0 {
+		description = fmt.Sprintf("%s. ≈ %s for your %d albums", ft.Description(), internal.FormatSize(ft.TypicalAlbumSize()*int64(d.albums)), d.albums)
+	}
+
+	fmt.Fprintf(w, "%s\n%s", fn(str), descriptionStyle.Render(description))
 }
@@ -4,9 +4,12 @@ import "github.com/charmbracelet/bubbles/key"
 
 // KeyMap sets up the Key Bindings for the application
 type KeyMap struct {
-	Quit    key.Binding
-	Exit    key.Binding
-	Confirm key.Binding
+	Quit       key.Binding
+	Exit       key.Binding
+	Confirm    key.Binding
+	TogglePath key.Binding
+	NewFolder  key.Binding
+	Cancel     key.Binding
 }
 
 // DefaultKeyMap maps bindings to specific keys
@@ -24,6 +27,18 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "confirm"),
 		),
+		TogglePath: key.NewBinding(
+			key.WithKeys("ctrl+t"),
+			key.WithHelp("ctrl+t", "type path | pick"),
+		),
+		NewFolder: key.NewBinding(
+			key.WithKeys("n"),
+			key.WithHelp("n", "new folder"),
+		),
+		Cancel: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "cancel"),
+		),
 	}
 }
 
@@ -40,3 +55,56 @@ func (k KeyMap) FullHelp() [][]key.Binding {
 		{k.Confirm, k.Quit, k.Exit},
 	}
 }
+
+// DashboardKeyMap sets up the Key Bindings for the progress dashboard
+type DashboardKeyMap struct {
+	Up         key.Binding
+	Down       key.Binding
+	Prioritize key.Binding
+	Cancel     key.Binding
+	Pause      key.Binding
+	Quit       key.Binding
+}
+
+// DefaultDashboardKeyMap maps dashboard bindings to specific keys
+func DefaultDashboardKeyMap() DashboardKeyMap {
+	return DashboardKeyMap{
+		Up: key.NewBinding(
+			key.WithKeys("k", "up"),
+			key.WithHelp("k | ↑", "up"),
+		),
+		Down: key.NewBinding(
+			key.WithKeys("j", "down"),
+			key.WithHelp("j | ↓", "down"),
+		),
+		Prioritize: key.NewBinding(
+			key.WithKeys("t"),
+			key.WithHelp("t", "move to top"),
+		),
+		Cancel: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x", "cancel"),
+		),
+		Pause: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "pause/resume"),
+		),
+		Quit: key.NewBinding(
+			key.WithKeys("ctrl+c"),
+			key.WithHelp("ctrl+c", "quit"),
+		),
+	}
+}
+
+// ShortHelp returns a shortened list of bindings to render for Help
+func (k DashboardKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.Prioritize, k.Cancel, k.Pause, k.Quit}
+}
+
+// FullHelp returns the full list of bindings
+func (k DashboardKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down},
+		{k.Prioritize, k.Cancel, k.Pause, k.Quit},
+	}
+}
@@ -23,6 +23,7 @@ const (
 	showDirectoryPickerState
 	showFormatListState
 	showFilterState
+	showSummaryState
 )
 
 // Outputs stores all of the user's input values
@@ -44,12 +45,25 @@ type model struct {
 	username  textinput.Model
 	identity  textinput.Model
 	directory filepicker.Model
+	path      textinput.Model
 	fileType  list.Model
 	filter    textinput.Model
 	help      help.Model
 
 	keys KeyMap
 	err  error
+
+	// manualPath is set when the directory is typed instead of picked
+	manualPath    bool
+	dirErr        error
+	lastDirectory string
+	// naming is set while the name of a new folder is typed
+	naming     bool
+	folderName textinput.Model
+
+	// startCmd replaces the username prompt's command when it was preset
+	startCmd tea.Cmd
+	done     bool
 }
 
 // Remap filepicker keys to better work with our program
@@ -88,8 +102,18 @@ func New() model {
 	fp.Height = 20
 	fp.KeyMap = directoryPickerKeyMap()
 
+	pathTi := textinput.New()
+	pathTi.CharLimit = 4096
+	pathTi.Width = 120
+	pathTi.ShowSuggestions = true
+
+	folderTi := textinput.New()
+	folderTi.CharLimit = 255
+	folderTi.Width = 120
+	folderTi.Placeholder = "Bandcamp"
+
 	items := []list.Item{}
-	li := list.New(items, itemDelegate{}, 20, 14)
+	li := list.New(items, itemDelegate{}, 80, 22)
 	li.Title = "Choose a file format"
 	li.SetShowStatusBar(false)
 	li.SetFilteringEnabled(false)
@@ -98,26 +122,68 @@ func New() model {
 	filterTi.CharLimit = 512
 	filterTi.Width = 120
 
-	return model{
-		state:     showUsernameState,
-		username:  usernameTi,
-		identity:  identityTi,
-		directory: fp,
-		fileType:  li,
-		filter:    filterTi,
-		help:      help.New(),
-		err:       nil,
-		keys:      DefaultKeyMap(),
+	m := model{
+		state:      showUsernameState,
+		username:   usernameTi,
+		identity:   identityTi,
+		directory:  fp,
+		path:       pathTi,
+		folderName: folderTi,
+		fileType:   li,
+		filter:     filterTi,
+		help:       help.New(),
+		err:        nil,
+		keys:       DefaultKeyMap(),
 	}
+
+	if m.preset() {
+		_, m.startCmd = m.ChangeState(nil)
+	}
+
+	return m
 }
 
-// Init starts the TUI on the username
+// Init starts the TUI on the first prompt that wasn't preset
 func (m model) Init() tea.Cmd {
+	if m.startCmd != nil {
+		return m.startCmd
+	}
+
 	return m.username.Focus()
 }
 
-// ChangeState changes the model state and sets the next part of the UI
+// ChangeState changes the model state and sets the next part of the UI.
+// Prompts whose value was preset are skipped.
 func (m *model) ChangeState(msg tea.Msg) (tea.Model, tea.Cmd) {
+	cmd := m.nextState()
+
+	for !m.done && m.preset() {
+		cmd = m.nextState()
+	}
+
+	return m, cmd
+}
+
+// preset reports whether the value for the current state was provided up front.
+func (m *model) preset() bool {
+	switch m.state {
+	case showUsernameState:
+		return selected.Username != ""
+	case showIdentityState:
+		return selected.Identity != ""
+	case showDirectoryPickerState:
+		return selected.Directory != ""
+	case showFormatListState:
+		return selected.FileType != ""
+	case showFilterState:
+		return selected.Filter != ""
+	}
+
+	return false
+}
+
+// nextState moves to the state after the current one.
+func (m *model) nextState() tea.Cmd {
 	var cmd tea.Cmd
 	switch m.state {
 	case showUsernameState:
@@ -125,9 +191,14 @@ func (m *model) ChangeState(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmd = m.identity.Focus()
 	case showIdentityState:
 		m.state = showDirectoryPickerState
-		cmd = m.directory.Init()
+		cmd = tea.Batch(m.directory.Init(), m.checkDirectory())
 	case showDirectoryPickerState:
 		m.state = showFormatListState
+
+		// Estimate sizes from what the last run into this directory saw
+		if albums, ok := internal.CachedCollectionSize(selected.Directory, selected.Username); ok {
+			m.fileType.SetDelegate(itemDelegate{albums: albums})
+		}
 		items := []list.Item{
 			item(internal.MP3_VO),
 			item(internal.MP3_320),
@@ -144,9 +215,13 @@ func (m *model) ChangeState(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.state = showFilterState
 		cmd = m.filter.Focus()
 	case showFilterState:
+		m.state = showSummaryState
+		m.filter.Blur()
+	case showSummaryState:
+		m.done = true
 		cmd = tea.Quit
 
 	}
 
-	return m, cmd
+	return cmd
 }
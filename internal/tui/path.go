@@ -0,0 +1,196 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// checkDirectory switches to typing the path by hand when the picker can't list the
+// directory it is showing, which would otherwise leave it silently empty.
+func (m *model) checkDirectory() tea.Cmd {
+	current := m.directory.CurrentDirectory
+
+	if m.manualPath || current == m.lastDirectory {
+		return nil
+	}
+
+	m.lastDirectory = current
+
+	if _, err := os.ReadDir(current); err != nil {
+		m.dirErr = describePathError(current, err)
+		m.manualPath = true
+		m.path.SetValue(current)
+		m.path.CursorEnd()
+		m.updatePathSuggestions()
+
+		return m.path.Focus()
+	}
+
+	return nil
+}
+
+// togglePath switches between the picker and typing the path. The picker opens wherever
+// the typed path points to, and typing starts from the directory the picker is showing.
+func (m *model) togglePath() tea.Cmd {
+	if !m.manualPath {
+		m.manualPath = true
+		m.dirErr = nil
+		m.path.SetValue(strings.TrimSuffix(m.directory.CurrentDirectory, string(os.PathSeparator)) + string(os.PathSeparator))
+		m.path.CursorEnd()
+		m.updatePathSuggestions()
+
+		return m.path.Focus()
+	}
+
+	path, err := validateDirectory(m.path.Value())
+
+	if err == nil {
+		_, err = os.ReadDir(path)
+		err = describePathError(path, err)
+	}
+
+	if err != nil {
+		m.dirErr = err
+		return nil
+	}
+
+	m.manualPath = false
+	m.dirErr = nil
+	m.directory.CurrentDirectory = path
+	m.lastDirectory = path
+
+	return m.directory.Init()
+}
+
+// startNewFolder asks for the name of a folder to create in the directory the picker is showing.
+func (m *model) startNewFolder() tea.Cmd {
+	m.naming = true
+	m.dirErr = nil
+	m.folderName.SetValue("")
+
+	return m.folderName.Focus()
+}
+
+// createFolder makes the named folder, selects it and opens it in the picker.
+func (m *model) createFolder() tea.Cmd {
+	name := strings.TrimSpace(m.folderName.Value())
+
+	if name == "" {
+		name = m.folderName.Placeholder
+	}
+
+	path := filepath.Join(m.directory.CurrentDirectory, name)
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		m.dirErr = describePathError(path, err)
+		return nil
+	}
+
+	m.naming = false
+	m.dirErr = nil
+	m.folderName.Blur()
+	selected.Directory = path
+	m.directory.CurrentDirectory = path
+	m.lastDirectory = path
+
+	return m.directory.Init()
+}
+
+// updatePathSuggestions offers the directories matching what has been typed so far.
+// Tab accepts the suggestion shown after the cursor.
+func (m *model) updatePathSuggestions() {
+	typed := m.path.Value()
+	dir, prefix := filepath.Split(typed)
+
+	if dir == "" {
+		m.path.SetSuggestions(nil)
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		m.path.SetSuggestions(nil)
+		return
+	}
+
+	var suggestions []string
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Hidden directories only when asked for
+		if !entry.IsDir() || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+
+		suggestions = append(suggestions, dir+name+string(os.PathSeparator))
+	}
+
+	m.path.SetSuggestions(suggestions)
+}
+
+// validateDirectory expands a typed path and makes sure downloads can be saved there.
+func validateDirectory(path string) (string, error) {
+	path = strings.TrimSpace(path)
+
+	if path == "" {
+		return "", errors.New("Enter a directory")
+	}
+
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return "", err
+		}
+
+		path = filepath.Join(home, rest)
+	}
+
+	path, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+
+	// New directories are created when the download starts, as long as their parent exists
+	if errors.Is(err, os.ErrNotExist) {
+		if parent, perr := os.Stat(filepath.Dir(path)); perr == nil && parent.IsDir() {
+			return path, nil
+		}
+	}
+
+	if err != nil {
+		return "", describePathError(path, err)
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+
+	if _, err := os.ReadDir(path); err != nil {
+		return "", describePathError(path, err)
+	}
+
+	return path, nil
+}
+
+// describePathError turns file system errors into something short enough to show inline.
+func describePathError(path string, err error) error {
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("Permission denied: %s", path)
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%s does not exist", path)
+	}
+
+	return err
+}
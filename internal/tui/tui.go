@@ -5,8 +5,12 @@ import (
 )
 
 // Run sets up and executes the Bubble Tea UI and returns
-// the values the user selected
-func Run() (Outputs, error) {
+// the values the user selected.
+//
+// Values already set in preset, e.g. from command line flags, are kept.
+// Preset values skip their prompt. An empty Filter still asks.
+func Run(preset Outputs) (Outputs, error) {
+	selected = preset
 	model := New()
 	p := tea.NewProgram(model)
 
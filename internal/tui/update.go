@@ -14,10 +14,29 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.naming {
+			return m.updateFolderName(msg)
+		}
+
 		switch {
 		case key.Matches(msg, m.keys.Quit, m.keys.Exit):
 			return m, tea.Quit
+		case key.Matches(msg, m.keys.TogglePath) && m.state == showDirectoryPickerState:
+			return m, m.togglePath()
+		case key.Matches(msg, m.keys.NewFolder) && m.state == showDirectoryPickerState && !m.manualPath:
+			return m, m.startNewFolder()
 		case key.Matches(msg, m.keys.Confirm):
+			if m.state == showDirectoryPickerState && m.manualPath {
+				path, err := validateDirectory(m.path.Value())
+
+				if err != nil {
+					m.dirErr = err
+					return m, nil
+				}
+
+				selected.Directory = path
+			}
+
 			return m.ChangeState(msg)
 		}
 	}
@@ -30,11 +49,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.identity, cmd = m.identity.Update(msg)
 		selected.Identity = m.identity.Value()
 	case showDirectoryPickerState:
+		if m.manualPath {
+			m.path, cmd = m.path.Update(msg)
+			m.updatePathSuggestions()
+			break
+		}
+
 		m.directory, cmd = m.directory.Update(msg)
 
 		if didSelect, path := m.directory.DidSelectFile(msg); didSelect {
 			selected.Directory = path
 		}
+
+		cmd = tea.Batch(cmd, m.checkDirectory())
 	case showFormatListState:
 		m.fileType, cmd = m.fileType.Update(msg)
 		i, ok := m.fileType.SelectedItem().(item)
@@ -49,3 +76,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	return m, cmd
 }
+
+// updateFolderName handles keys while a new folder is being named. Enter creates it and
+// Esc goes back to the picker without quitting.
+func (m model) updateFolderName(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		return m, tea.Quit
+	case key.Matches(msg, m.keys.Cancel):
+		m.naming = false
+		m.dirErr = nil
+		m.folderName.Blur()
+		return m, nil
+	case key.Matches(msg, m.keys.Confirm):
+		return m, m.createFolder()
+	}
+
+	m.folderName, cmd = m.folderName.Update(msg)
+
+	return m, cmd
+}
@@ -3,11 +3,17 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"bcdl/internal"
 
 	"github.com/charmbracelet/bubbles/filepicker"
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
 )
 
+var errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+
 // fpKeyMap is necessary to add additional helper methods
 type fpKeyMap filepicker.KeyMap
 
@@ -42,6 +48,16 @@ func (m model) View() string {
 	case showIdentityState:
 		output = m.textInputView("What's the value of your Identity cookie?", m.identity.View())
 	case showDirectoryPickerState:
+		if m.naming {
+			output = m.folderNameView()
+			break
+		}
+
+		if m.manualPath {
+			output = m.pathView()
+			break
+		}
+
 		var s strings.Builder
 
 		if selected.Directory == "" {
@@ -51,12 +67,70 @@ func (m model) View() string {
 		}
 
 		s.WriteString(fmt.Sprintf("\n\n%s\n%s", m.directory.View(), m.help.View(fpKeyMap(m.directory.KeyMap))))
+		s.WriteString("\n" + m.help.ShortHelpView([]key.Binding{m.keys.TogglePath, m.keys.NewFolder}))
 		output = s.String()
 	case showFormatListState:
 		output = m.withHelp(m.fileType.View())
 	case showFilterState:
 		output = m.textInputView("Filter collection (leave empty to download everything)?", m.filter.View())
+	case showSummaryState:
+		output = m.withHelp(summaryView())
 
 	}
 	return output
 }
+
+// pathView renders the directory prompt when the path is typed by hand.
+func (m model) pathView() string {
+	var s strings.Builder
+
+	if m.dirErr != nil {
+		s.WriteString(errorStyle.Render(m.dirErr.Error()) + "\n\n")
+	}
+
+	s.WriteString(m.textInputView("Type the directory to save your downloads (tab completes):", m.path.View()))
+	s.WriteString("\n" + m.help.ShortHelpView([]key.Binding{m.keys.TogglePath}))
+
+	return s.String()
+}
+
+// folderNameView renders the prompt for the name of a new folder.
+func (m model) folderNameView() string {
+	var s strings.Builder
+
+	if m.dirErr != nil {
+		s.WriteString(errorStyle.Render(m.dirErr.Error()) + "\n\n")
+	}
+
+	s.WriteString(fmt.Sprintf("Name the new folder in %s:\n\n%s\n\n", m.directory.CurrentDirectory, m.folderName.View()))
+	s.WriteString(m.help.ShortHelpView([]key.Binding{m.keys.Confirm, m.keys.Cancel}))
+
+	return s.String()
+}
+
+// summaryView describes what is about to be downloaded, using what earlier runs into the
+// same directory learned about the collection.
+func summaryView() string {
+	var s strings.Builder
+
+	s.WriteString(fmt.Sprintf("Ready to download %s's collection as %s into %s\n\n", selected.Username, selected.FileType, selected.Directory))
+
+	status, ok := internal.CachedLibraryStatus(selected.Directory, selected.Username, selected.FileType)
+
+	if !ok {
+		s.WriteString("This is the first download into this directory, so the size of the collection isn't known yet")
+	} else {
+		size := internal.FormatSize(selected.FileType.TypicalAlbumSize() * int64(status.Pending()))
+
+		s.WriteString(fmt.Sprintf("%d of %d items are already downloaded, %d are left (≈ %s)\n", status.Downloaded, status.Items, status.Pending(), size))
+		s.WriteString(descriptionStyle.UnsetPaddingLeft().Render(fmt.Sprintf("As of %s, new purchases since then are downloaded too", status.CheckedAt.Format(time.DateOnly))))
+	}
+
+	if selected.Filter != "" {
+		s.WriteString(fmt.Sprintf("\n\nOnly items matching %q will be downloaded", selected.Filter))
+	}
+
+	s.WriteString("\n\nPress Enter to start")
+
+	return s.String()
+}
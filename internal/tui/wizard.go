@@ -0,0 +1,425 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"bcdl/internal"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var titleStyle = lipgloss.NewStyle().Bold(true)
+
+// ErrSetupCancelled is returned by RunSetup when the setup was quit before it finished.
+var ErrSetupCancelled = errors.New("Setup cancelled")
+
+// Setup is what the first run setup asks for.
+type Setup struct {
+	Username string
+	// Identity is the cookie captured during the setup, empty if it was skipped
+	Identity   string
+	Directory  string
+	FileType   internal.FileType
+	Layout     Layout
+	WebhookURL string
+}
+
+// Profile returns the settings of the setup as they are kept in the config file.
+func (s Setup) Profile() internal.Profile {
+	return internal.Profile{
+		Username:     s.Username,
+		Directory:    s.Directory,
+		FileType:     string(s.FileType),
+		Extract:      s.Layout.Extract,
+		PathTemplate: s.Layout.PathTemplate,
+		WebhookURL:   s.WebhookURL,
+	}
+}
+
+// Layout is a preset for how downloads are laid out in the directory.
+type Layout struct {
+	Name         string
+	Description  string
+	Extract      bool
+	PathTemplate string
+}
+
+// Layouts are the presets the setup offers.
+var Layouts = []Layout{
+	{Name: "Zip files", Description: "Archives as Bandcamp names them, all in one directory"},
+	{Name: "Artist/Album folders", Description: "Every album unpacked into a folder of its artist, ready for a media server", Extract: true},
+	{Name: "Zip files in Artist folders", Description: "Archives named Album (Year) in a folder per artist", PathTemplate: "{artist}/{album} ({year})"},
+}
+
+type setupStep uint
+
+// The steps of the setup, in order
+const (
+	signInStep setupStep = iota
+	identityStep
+	waitingStep
+	usernameStep
+	directoryStep
+	formatStep
+	layoutStep
+	webhookStep
+	confirmStep
+)
+
+// How the identity cookie can be captured
+const (
+	signInBrowser = "Sign in with a browser window"
+	signInImport  = "Copy the session from your browser"
+	signInPaste   = "Paste the Identity cookie"
+	signInLater   = "Skip, I'll run bcdl login later"
+)
+
+// choice is an entry of the lists of the setup.
+type choice struct {
+	title, desc string
+}
+
+func (c choice) Title() string       { return c.title }
+func (c choice) Description() string { return c.desc }
+func (c choice) FilterValue() string { return c.title }
+
+// identityMsg is the result of capturing the identity cookie.
+type identityMsg struct {
+	identity string
+	err      error
+}
+
+// wizard walks through everything a first run needs and ends with the settings for
+// the config file.
+type wizard struct {
+	step  setupStep
+	setup Setup
+
+	signIn    list.Model
+	identity  textinput.Model
+	username  textinput.Model
+	directory textinput.Model
+	fileType  list.Model
+	layout    list.Model
+	webhook   textinput.Model
+
+	help help.Model
+	keys KeyMap
+	err  error
+	done bool
+}
+
+// newList creates a list of the setup with the items.
+func newList(title string, items []list.Item) list.Model {
+	li := list.New(items, list.NewDefaultDelegate(), 80, 16)
+	li.Title = title
+	li.SetShowStatusBar(false)
+	li.SetFilteringEnabled(false)
+
+	return li
+}
+
+// newInput creates a text input of the setup.
+func newInput(placeholder string, limit int) textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = placeholder
+	ti.CharLimit = limit
+	ti.Width = 120
+
+	return ti
+}
+
+// newWizard creates the setup, with what is already known filled in.
+func newWizard(preset Setup) wizard {
+	var layouts []list.Item
+
+	for _, layout := range Layouts {
+		layouts = append(layouts, choice{layout.Name, layout.Description})
+	}
+
+	var formats []list.Item
+
+	for _, ft := range internal.AllFileTypes {
+		formats = append(formats, item(ft))
+	}
+
+	fileType := list.New(formats, itemDelegate{}, 80, 22)
+	fileType.Title = "Which format should your music be downloaded in?"
+	fileType.SetShowStatusBar(false)
+	fileType.SetFilteringEnabled(false)
+
+	w := wizard{
+		setup: preset,
+		signIn: newList("How do you want to sign into Bandcamp?", []list.Item{
+			choice{signInBrowser, "Opens a browser window, sign in like you always do"},
+			choice{signInImport, "Firefox, Chrome, Chromium or Brave, if you are signed in there"},
+			choice{signInPaste, "From the developer tools of your browser"},
+			choice{signInLater, "Downloads need it, so until then they won't start"},
+		}),
+		identity:  newInput("", 512),
+		username:  newInput("", 128),
+		directory: newInput("~/Music/Bandcamp", 4096),
+		fileType:  fileType,
+		layout:    newList("How should downloads be laid out?", layouts),
+		webhook:   newInput("https://ntfy.sh/my-bandcamp", 2048),
+		help:      help.New(),
+		keys:      DefaultKeyMap(),
+	}
+
+	w.username.SetValue(preset.Username)
+	w.directory.SetValue(preset.Directory)
+
+	return w
+}
+
+// Init starts the setup with the sign in.
+func (w wizard) Init() tea.Cmd {
+	return nil
+}
+
+// captureIdentity signs in with the browser window or copies the session from a browser.
+func captureIdentity(method string) tea.Cmd {
+	return func() tea.Msg {
+		var msg identityMsg
+
+		if method == signInBrowser {
+			msg.identity, msg.err = internal.Login(10 * time.Minute)
+		} else {
+			msg.identity, msg.err = internal.ImportIdentity("auto")
+		}
+
+		return msg
+	}
+}
+
+// Update handles the keys of the current step and moves on when it is confirmed.
+func (w wizard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case identityMsg:
+		if msg.err != nil {
+			w.err = msg.err
+			w.step = signInStep
+			return w, nil
+		}
+
+		w.setup.Identity = msg.identity
+		cmd = w.goTo(usernameStep)
+
+		return w, cmd
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, w.keys.Quit):
+			return w, tea.Quit
+		case key.Matches(msg, w.keys.Exit) && w.step != waitingStep:
+			return w, tea.Quit
+		case key.Matches(msg, w.keys.Confirm) && w.step != waitingStep:
+			cmd = w.confirm()
+			return w, cmd
+		}
+	}
+
+	switch w.step {
+	case signInStep:
+		w.signIn, cmd = w.signIn.Update(msg)
+	case identityStep:
+		w.identity, cmd = w.identity.Update(msg)
+	case usernameStep:
+		w.username, cmd = w.username.Update(msg)
+	case directoryStep:
+		w.directory, cmd = w.directory.Update(msg)
+	case formatStep:
+		w.fileType, cmd = w.fileType.Update(msg)
+	case layoutStep:
+		w.layout, cmd = w.layout.Update(msg)
+	case webhookStep:
+		w.webhook, cmd = w.webhook.Update(msg)
+	}
+
+	return w, cmd
+}
+
+// confirm takes the answer of the current step and moves to the next one.
+func (w *wizard) confirm() tea.Cmd {
+	w.err = nil
+
+	switch w.step {
+	case signInStep:
+		switch w.signIn.SelectedItem().(choice).title {
+		case signInBrowser, signInImport:
+			w.step = waitingStep
+			return captureIdentity(w.signIn.SelectedItem().(choice).title)
+		case signInPaste:
+			return w.goTo(identityStep)
+		}
+
+		return w.goTo(usernameStep)
+	case identityStep:
+		if w.setup.Identity = strings.TrimSpace(w.identity.Value()); w.setup.Identity == "" {
+			w.err = errors.New("Paste the value of the Identity cookie")
+			return nil
+		}
+
+		return w.goTo(usernameStep)
+	case usernameStep:
+		if w.setup.Username = strings.TrimSpace(w.username.Value()); w.setup.Username == "" {
+			w.err = errors.New("Enter your Bandcamp username")
+			return nil
+		}
+
+		return w.goTo(directoryStep)
+	case directoryStep:
+		path := w.directory.Value()
+
+		if path == "" {
+			path = w.directory.Placeholder
+		}
+
+		dir, err := validateDirectory(path)
+
+		if err != nil {
+			w.err = err
+			return nil
+		}
+
+		w.setup.Directory = dir
+
+		return w.goTo(formatStep)
+	case formatStep:
+		if ft, ok := w.fileType.SelectedItem().(item); ok {
+			w.setup.FileType = internal.FileType(ft)
+		}
+
+		return w.goTo(layoutStep)
+	case layoutStep:
+		w.setup.Layout = Layouts[w.layout.Index()]
+
+		return w.goTo(webhookStep)
+	case webhookStep:
+		w.setup.WebhookURL = strings.TrimSpace(w.webhook.Value())
+
+		if w.setup.WebhookURL != "" && !strings.HasPrefix(w.setup.WebhookURL, "http://") && !strings.HasPrefix(w.setup.WebhookURL, "https://") {
+			w.err = errors.New("Enter an http:// or https:// address, or nothing")
+			return nil
+		}
+
+		return w.goTo(confirmStep)
+	case confirmStep:
+		w.done = true
+		return tea.Quit
+	}
+
+	return nil
+}
+
+// goTo moves to step, focusing its input.
+func (w *wizard) goTo(step setupStep) tea.Cmd {
+	w.step = step
+
+	for _, input := range []*textinput.Model{&w.identity, &w.username, &w.directory, &w.webhook} {
+		input.Blur()
+	}
+
+	switch step {
+	case identityStep:
+		return w.identity.Focus()
+	case usernameStep:
+		return w.username.Focus()
+	case directoryStep:
+		return w.directory.Focus()
+	case webhookStep:
+		return w.webhook.Focus()
+	}
+
+	return nil
+}
+
+// View renders the current step.
+func (w wizard) View() string {
+	var s strings.Builder
+
+	s.WriteString(titleStyle.Render(fmt.Sprintf("Setting up bcdl, step %d of 7", w.progress())) + "\n\n")
+
+	if w.err != nil {
+		s.WriteString(errorStyle.Render(w.err.Error()) + "\n\n")
+	}
+
+	switch w.step {
+	case signInStep:
+		s.WriteString(w.signIn.View())
+	case identityStep:
+		s.WriteString("Paste the value of the Identity cookie of bandcamp.com:\n\n" + w.identity.View())
+	case waitingStep:
+		s.WriteString("Waiting for the sign in to finish…")
+		return s.String()
+	case usernameStep:
+		s.WriteString("What's your Bandcamp username?\n\n" + w.username.View())
+	case directoryStep:
+		s.WriteString("Where should downloads be saved?\n\n" + w.directory.View())
+	case formatStep:
+		s.WriteString(w.fileType.View())
+	case layoutStep:
+		s.WriteString(w.layout.View())
+	case webhookStep:
+		s.WriteString("Send a notification to this webhook, e.g. ntfy or Discord, when albums finish (leave empty to skip):\n\n" + w.webhook.View())
+	case confirmStep:
+		s.WriteString(w.summary())
+	}
+
+	return s.String() + "\n\n" + w.help.View(w.keys)
+}
+
+// progress numbers the steps the user sees, which leaves out the alternatives of signing in.
+func (w wizard) progress() int {
+	switch w.step {
+	case signInStep, identityStep, waitingStep:
+		return 1
+	}
+
+	return int(w.step) - int(usernameStep) + 2
+}
+
+// summary lists the answers before they are saved.
+func (w wizard) summary() string {
+	signedIn := "no, run bcdl login before downloading"
+
+	if w.setup.Identity != "" {
+		signedIn = "yes"
+	}
+
+	webhook := "none"
+
+	if w.setup.WebhookURL != "" {
+		webhook = w.setup.WebhookURL
+	}
+
+	return fmt.Sprintf("Signed in: %s\nUsername:  %s\nDirectory: %s\nFormat:    %s\nLayout:    %s\nWebhook:   %s\n\nPress Enter to save this and start downloading",
+		signedIn, w.setup.Username, w.setup.Directory, w.setup.FileType.Label(), w.setup.Layout.Name, webhook)
+}
+
+// RunSetup walks through the setup of the first run. Values set in preset, e.g. from
+// flags, are filled in.
+func RunSetup(preset Setup) (Setup, error) {
+	result, err := tea.NewProgram(newWizard(preset)).Run()
+
+	if err != nil {
+		return preset, err
+	}
+
+	w := result.(wizard)
+
+	if !w.done {
+		return preset, ErrSetupCancelled
+	}
+
+	return w.setup, nil
+}
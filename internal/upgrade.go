@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// UpgradeRule downloads items again in a better format: those downloaded in one of From,
+// or any lossy format when From is empty, are downloaded in To if that was before Before,
+// or longer ago than OlderThan. Zero values match any time.
+type UpgradeRule struct {
+	From      []FileType
+	To        FileType
+	Before    time.Time
+	OlderThan time.Duration
+}
+
+// ParseUpgradeRule parses a rule written as FROM:TO[:WHEN], e.g. "mp3-320:flac:2022-01-01"
+// upgrades everything downloaded in MP3 320 before 2022 to FLAC. FROM is a comma-separated
+// list of file types or "lossy", WHEN a date or an age such as 8760h.
+func ParseUpgradeRule(s string) (UpgradeRule, error) {
+	var rule UpgradeRule
+	parts := strings.Split(s, ":")
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return rule, fmt.Errorf("Invalid upgrade %q, expected FROM:TO[:WHEN], e.g. lossy:flac:2022-01-01", s)
+	}
+
+	if !strings.EqualFold(parts[0], "lossy") {
+		for _, format := range strings.Split(parts[0], ",") {
+			ft, err := ParseFileType(format)
+
+			if err != nil {
+				return rule, err
+			}
+
+			rule.From = append(rule.From, ft)
+		}
+	}
+
+	to, err := ParseFileType(parts[1])
+
+	if err != nil {
+		return rule, err
+	}
+
+	rule.To = to
+
+	if len(parts) == 3 {
+		if rule.Before, err = time.ParseInLocation(time.DateOnly, parts[2], time.Local); err != nil {
+			if rule.OlderThan, err = time.ParseDuration(parts[2]); err != nil {
+				return rule, fmt.Errorf("Invalid upgrade %q, expected a date like 2022-01-01 or an age like 8760h", s)
+			}
+		}
+	}
+
+	return rule, nil
+}
+
+// matches reports whether the rule upgrades a download recorded in the history to ft.
+func (r UpgradeRule) matches(record HistoryEntry, ft FileType, now time.Time) bool {
+	if ft != r.To || record.FileType == ft {
+		return false
+	}
+
+	if len(r.From) == 0 && record.FileType.Lossless() || len(r.From) > 0 && !slices.Contains(r.From, record.FileType) {
+		return false
+	}
+
+	if !r.Before.IsZero() && !record.DownloadedAt.Before(r.Before) {
+		return false
+	}
+
+	return r.OlderThan == 0 || now.Sub(record.DownloadedAt) > r.OlderThan
+}
+
+// WithUpgrades switches the history to telling items apart regardless of format: an item
+// already downloaded in some other format is only downloaded again when one of the rules
+// upgrades it. Without upgrades, switching formats downloads the whole collection again.
+func WithUpgrades(rules ...UpgradeRule) func(*Downloader) {
+	return func(d *Downloader) {
+		d.upgrades = rules
+	}
+}
+
+// downloadedFormats indexes the history of a user by item id and title to find what an
+// item was downloaded in before.
+type downloadedFormats struct {
+	byID    map[string][]HistoryEntry
+	byTitle map[string][]HistoryEntry
+}
+
+// newDownloadedFormats indexes the records of history that belong to user.
+func newDownloadedFormats(history HistoryStore, user *User) (*downloadedFormats, error) {
+	records, err := history.List()
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read history: %w", err)
+	}
+
+	f := &downloadedFormats{byID: map[string][]HistoryEntry{}, byTitle: map[string][]HistoryEntry{}}
+
+	for _, record := range records {
+		if !record.ownedBy(user) {
+			continue
+		}
+
+		if record.ItemID != "" {
+			f.byID[record.ItemID] = append(f.byID[record.ItemID], record)
+		}
+
+		f.byTitle[record.Title] = append(f.byTitle[record.Title], record)
+	}
+
+	return f, nil
+}
+
+// of returns the history records of the entry in any format.
+func (f *downloadedFormats) of(entry CollectionEntry) []HistoryEntry {
+	if entry.id != "" {
+		if records := f.byID[entry.id]; len(records) > 0 {
+			return records
+		}
+	}
+
+	var records []HistoryEntry
+
+	for _, record := range f.byTitle[entry.title] {
+		if sameAlbum(entry.id, entry.title, record.ItemID, record.Title) {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// upgrade decides whether the entry, not yet downloaded in ft, is downloaded: when it
+// never was in any format, or a rule upgrades one of its downloads. The record it
+// upgrades is returned too.
+func (d *Downloader) upgrade(formats *downloadedFormats, entry CollectionEntry, ft FileType) (bool, *HistoryEntry) {
+	records := formats.of(entry)
+
+	if len(records) == 0 {
+		return true, nil
+	}
+
+	now := time.Now()
+
+	for _, record := range records {
+		for _, rule := range d.upgrades {
+			if rule.matches(record, ft, now) {
+				return true, &record
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// DefaultUserAgent is what bcdl tells Bandcamp it is unless ClientOptions says otherwise.
+// It matches the Chromium Playwright installs, so the browser doesn't claim to be a
+// version whose features it lacks.
+const DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
+
+// ClientOptions is how bcdl presents itself to Bandcamp, in the browser and in the
+// requests it sends itself.
+type ClientOptions struct {
+	// UserAgent replaces DefaultUserAgent
+	UserAgent string
+	// Headers are sent along with every request to Bandcamp
+	Headers map[string]string
+	// Locale, e.g. en-GB, is the language of the browser and the Accept-Language header
+	Locale string
+}
+
+// clientOptions is what SetClientOptions set.
+var clientOptions = ClientOptions{UserAgent: DefaultUserAgent}
+
+// SetClientOptions changes how bcdl presents itself from now on.
+func SetClientOptions(opts ClientOptions) {
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+
+	clientOptions = opts
+}
+
+// setClientHeaders sets the User-Agent and the other headers of the client options on req.
+func setClientHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", clientOptions.UserAgent)
+
+	if clientOptions.Locale != "" {
+		req.Header.Set("Accept-Language", clientOptions.Locale)
+	}
+
+	for name, value := range clientOptions.Headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// browserContextOptions returns the options browser contexts are created with.
+func browserContextOptions() playwright.BrowserNewContextOptions {
+	opts := playwright.BrowserNewContextOptions{
+		UserAgent: playwright.String(clientOptions.UserAgent),
+	}
+
+	if clientOptions.Locale != "" {
+		opts.Locale = playwright.String(clientOptions.Locale)
+	}
+
+	if len(clientOptions.Headers) > 0 {
+		opts.ExtraHttpHeaders = clientOptions.Headers
+	}
+
+	return opts
+}
+
+// ParseHeader parses a header given as "Name: value".
+func ParseHeader(header string) (string, string, bool) {
+	name, value, ok := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+
+	if !ok || name == "" {
+		return "", "", false
+	}
+
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), true
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotInHistory is returned by RequeueArchive for files no history entry points to,
+// such as downloads made before bcdl recorded file names.
+var ErrNotInHistory = errors.New("No download in the history saved this file")
+
+// VerifyArchive reads every file in the zip archive at path so truncated downloads and
+// failed checksums are caught.
+func VerifyArchive(path string) error {
+	archive, err := zip.OpenReader(path)
+
+	if err != nil {
+		return fmt.Errorf("Could not open archive: %w", err)
+	}
+
+	defer archive.Close()
+
+	if len(archive.File) == 0 {
+		return errors.New("Archive is empty")
+	}
+
+	for _, f := range archive.File {
+		if err := verifyArchiveFile(f); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyArchiveFile reads f to the end, which is when the zip reader checks its CRC.
+func verifyArchiveFile(f *zip.File) error {
+	r, err := f.Open()
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	_, err = io.Copy(io.Discard, r)
+
+	return err
+}
+
+// VerifyLibrary checks every zip archive in dir and calls fn with the path of each and
+// the problem found, or nil when it is intact. The .bcdl directory is skipped.
+func VerifyLibrary(dir string, fn func(path string, err error)) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && entry.Name() == ".bcdl" {
+			return filepath.SkipDir
+		}
+
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(path), ".zip") {
+			fn(path, VerifyArchive(path))
+		}
+
+		return nil
+	})
+}
+
+// RequeueArchive forgets the download that saved the file at path inside of the library
+// at dir and records it as failed, so the next run or `bcdl retry-failed` downloads it
+// again. It returns the title of the album.
+func RequeueArchive(dir, path string, reason error) (string, error) {
+	name, err := filepath.Rel(dir, path)
+
+	if err != nil {
+		return "", err
+	}
+
+	name = filepath.ToSlash(name)
+
+	for _, stateDir := range libraryStateDirs(dir) {
+		history, err := LoadHistory(filepath.Join(stateDir, "history.jsonl"))
+
+		if err != nil {
+			return "", err
+		}
+
+		entries, err := history.List()
+
+		if err != nil {
+			return "", err
+		}
+
+		for _, entry := range entries {
+			if entry.File != name {
+				continue
+			}
+
+			if err := history.Delete(entry); err != nil {
+				return "", err
+			}
+
+			failures, err := loadFailedJobs(stateDir)
+
+			if err != nil {
+				return "", err
+			}
+
+			return entry.Title, failures.record(FailedJob{
+				Username: entry.Username,
+				ItemID:   entry.ItemID,
+				Title:    entry.Title,
+				URL:      entry.URL,
+				FileType: entry.FileType,
+				Error:    reason.Error(),
+				FailedAt: time.Now(),
+			})
+		}
+	}
+
+	return "", ErrNotInHistory
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// watchPurchases checks the newest items of the collection every interval and hands the
+// ones that weren't seen before to enqueue. It runs until ctx is done.
+//
+// Bandcamp lists the collection by purchase date, so only the newest items, which recent
+// returns, have to be checked. It is called on every check, from the collection page or
+// the API, since a remote browser may have been reconnected to in between.
+func watchPurchases(ctx context.Context, recent func() ([]CollectionEntry, error), interval time.Duration, filter string, seen map[string]bool, enqueue func(CollectionEntry)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		entries, err := recent()
+
+		if err != nil {
+			log.Printf("Could not check for new purchases: %v", err)
+			continue
+		}
+
+		for _, entry := range entries {
+			if seen[entry.key()] || !matchesFilter(entry, filter) {
+				continue
+			}
+
+			seen[entry.key()] = true
+			log.Printf("New purchase: %s by %s", entry.title, entry.artist)
+			enqueue(entry)
+		}
+	}
+}
+
+// matchesFilter reports whether the title or artist of the entry contain filter, like the
+// search box of the collection page.
+func matchesFilter(entry CollectionEntry, filter string) bool {
+	filter = strings.ToLower(filter)
+
+	return strings.Contains(strings.ToLower(entry.title), filter) || strings.Contains(strings.ToLower(entry.artist), filter)
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebDAVStorage uploads downloads to a WebDAV server, such as a Nextcloud music folder:
+//
+//	https://cloud.example.com/remote.php/dav/files/<user>/Music
+type WebDAVStorage struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAVStorage creates a WebDAVStorage rooted at baseURL. Credentials are optional.
+func NewWebDAVStorage(baseURL, username, password string) (*WebDAVStorage, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid WebDAV URL %q", baseURL)
+	}
+
+	return &WebDAVStorage{
+		base:     base,
+		username: username,
+		password: password,
+		// Large archives can take a long time to upload, so only the connection is bounded
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 proxyFor,
+			ResponseHeaderTimeout: 5 * time.Minute,
+		}},
+	}, nil
+}
+
+// url returns the address of name on the server, escaping every path element.
+func (s *WebDAVStorage) url(name string) string {
+	u := *s.base
+
+	for _, part := range strings.Split(name, "/") {
+		if part != "" {
+			u = *u.JoinPath(part)
+		}
+	}
+
+	return u.String()
+}
+
+// do sends a request with the configured credentials.
+func (s *WebDAVStorage) do(method, target string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	if size >= 0 {
+		req.ContentLength = size
+	}
+
+	return s.client.Do(req)
+}
+
+// mkcol creates every collection leading up to name.
+func (s *WebDAVStorage) mkcol(name string) error {
+	parts := strings.Split(name, "/")
+
+	for i := 1; i < len(parts); i++ {
+		resp, err := s.do("MKCOL", s.url(strings.Join(parts[:i], "/")), nil, -1)
+
+		if err != nil {
+			return fmt.Errorf("Could not create WebDAV collection: %w", err)
+		}
+
+		resp.Body.Close()
+
+		// 405 means the collection already exists
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("Could not create WebDAV collection %s: %s", strings.Join(parts[:i], "/"), resp.Status)
+		}
+	}
+
+	return nil
+}
+
+// Save uploads r to name with a PUT request.
+func (s *WebDAVStorage) Save(name string, r io.Reader, size int64) error {
+	if err := s.mkcol(name); err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodPut, s.url(name), r, size)
+
+	if err != nil {
+		return fmt.Errorf("Could not upload %s: %w", name, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Could not upload %s: %s", name, resp.Status)
+	}
+
+	return nil
+}
+
+// Exists checks for name with a HEAD request.
+func (s *WebDAVStorage) Exists(name string) (bool, error) {
+	resp, err := s.do(http.MethodHead, s.url(name), nil, -1)
+
+	if err != nil {
+		return false, err
+	}
+
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode < 300:
+		return true, nil
+	}
+
+	return false, fmt.Errorf("Could not check %s: %s", name, resp.Status)
+}
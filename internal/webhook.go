@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ItemEvent describes what happened to a single album during a run.
+type ItemEvent struct {
+	// Event is one of "success", "failure", "region-locked", "skip" or "cancel", "feed"
+	// for stories of the fan feed, see FeedEvent, "checkpoint", see WithCheckpoints, or
+	// "signed-out", see WithKeepAlive.
+	Event    string   `json:"event"`
+	ID       string   `json:"id,omitempty"`
+	Title    string   `json:"title"`
+	Artist   string   `json:"artist,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	FileType FileType `json:"filetype"`
+	// Path is where the download was saved, relative to the directory of the run
+	Path            string    `json:"path,omitempty"`
+	Bytes           int64     `json:"bytes,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+	Gifter          string    `json:"gifter,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+// RunInfo describes the run an event belongs to.
+type RunInfo struct {
+	Username  string    `json:"username"`
+	Directory string    `json:"directory"`
+	FileType  FileType  `json:"filetype"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// WebhookData is the data model webhook templates are executed against.
+//
+// Digests of several events, see WithWebhookBatching, leave Item empty and list the events
+// in Items instead, with Counts holding how many there are of each kind. Templates can
+// tell them apart with {{if .Items}}.
+//
+// Checkpoint is only set for "checkpoint" events, see WithCheckpoints.
+type WebhookData struct {
+	Item       ItemEvent      `json:"item"`
+	Items      []ItemEvent    `json:"items,omitempty"`
+	Counts     map[string]int `json:"counts,omitempty"`
+	Run        RunInfo        `json:"run"`
+	Checkpoint *Checkpoint    `json:"checkpoint,omitempty"`
+}
+
+// Webhook posts a payload for every album that finishes.
+//
+// Without a template the payload is WebhookData encoded as JSON. With one, the
+// payload is whatever the Go template renders, so it can match any schema the
+// receiving end expects. Templates have a json function for safely embedding values:
+//
+//	{"text": {{json (printf "%s finished: %s" .Run.Username .Item.Title)}}}
+type Webhook struct {
+	URL         string
+	ContentType string
+	template    *template.Template
+	client      *http.Client
+}
+
+// NewWebhook creates a Webhook for the URL. tmpl may be empty to send the default JSON payload.
+func NewWebhook(url, tmpl string) (*Webhook, error) {
+	w := &Webhook{
+		URL:         url,
+		ContentType: "application/json",
+		client:      &http.Client{Transport: newTransport(), Timeout: 30 * time.Second},
+	}
+
+	if tmpl == "" {
+		return w, nil
+	}
+
+	t, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+	}).Parse(tmpl)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse webhook template: %w", err)
+	}
+
+	w.template = t
+
+	return w, nil
+}
+
+// render produces the request body for the data.
+func (w *Webhook) render(data WebhookData) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(data)
+	}
+
+	var body bytes.Buffer
+
+	if err := w.template.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("Could not render webhook template: %w", err)
+	}
+
+	return body.Bytes(), nil
+}
+
+// Send posts the payload for data to the webhook URL.
+func (w *Webhook) Send(data WebhookData) error {
+	body, err := w.render(data)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.URL, w.ContentType, bytes.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("Could not send webhook: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// digest builds the data of a message listing several events, see WebhookData.
+func digest(run RunInfo, events []ItemEvent) WebhookData {
+	if len(events) == 1 {
+		return WebhookData{Item: events[0], Run: run}
+	}
+
+	data := WebhookData{Items: events, Counts: map[string]int{}, Run: run}
+
+	for _, event := range events {
+		data.Counts[event.Event]++
+	}
+
+	return data
+}
+
+// How many messages wait for a slow webhook before further ones are dropped
+const webhookBacklog = 256
+
+// webhookSender posts the messages of a run from its own goroutine, so a slow or
+// unreachable webhook never holds up the downloads.
+type webhookSender struct {
+	webhook *Webhook
+	queue   chan WebhookData
+	done    chan struct{}
+}
+
+// newWebhookSender starts posting messages to the webhook.
+func newWebhookSender(webhook *Webhook) *webhookSender {
+	s := &webhookSender{
+		webhook: webhook,
+		queue:   make(chan WebhookData, webhookBacklog),
+		done:    make(chan struct{}),
+	}
+
+	go s.loop()
+
+	return s
+}
+
+// send queues the message without waiting for it to be posted. It is dropped if the
+// webhook fell too far behind.
+func (s *webhookSender) send(data WebhookData) {
+	select {
+	case s.queue <- data:
+	default:
+		log.Printf("Webhook is falling behind, dropped the message for %s", data.Item.Title)
+	}
+}
+
+// close posts whatever is still queued and waits for it to finish.
+func (s *webhookSender) close() {
+	close(s.queue)
+	<-s.done
+}
+
+func (s *webhookSender) loop() {
+	defer close(s.done)
+
+	for data := range s.queue {
+		if err := s.webhook.Send(data); err != nil {
+			log.Printf("Webhook for %s failed: %v", data.Item.Title, err)
+		}
+	}
+}
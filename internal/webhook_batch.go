@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// WebhookBatching collects events into digests instead of posting one message per album,
+// so a large backfill doesn't flood a chat channel.
+//
+// Events are sent as they happen while things are quiet. Events that finish while the
+// previous message is still being sent, or within Interval of it, are collected and sent
+// together as one digest.
+type WebhookBatching struct {
+	// Interval is the minimum time between two messages
+	Interval time.Duration
+	// MaxItems caps how many events one digest lists, 0 for no limit. The rest go into the next one.
+	MaxItems int
+}
+
+// WithWebhookBatching batches the events of WithWebhook into digests.
+func WithWebhookBatching(batching WebhookBatching) func(*Downloader) {
+	return func(d *Downloader) {
+		d.batching = &batching
+	}
+}
+
+// webhookBatcher sends the events of one run to the webhook from its own goroutine,
+// merging the ones that pile up while it waits.
+type webhookBatcher struct {
+	webhook *Webhook
+	opts    WebhookBatching
+	run     RunInfo
+
+	mu      sync.Mutex
+	pending []ItemEvent
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newWebhookBatcher starts sending events of the run to the webhook.
+func newWebhookBatcher(webhook *Webhook, opts WebhookBatching, run RunInfo) *webhookBatcher {
+	b := &webhookBatcher{
+		webhook: webhook,
+		opts:    opts,
+		run:     run,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go b.loop()
+
+	return b
+}
+
+// add queues the event without waiting for it to be sent.
+func (b *webhookBatcher) add(event ItemEvent) {
+	b.mu.Lock()
+	b.pending = append(b.pending, event)
+	b.mu.Unlock()
+
+	b.signal()
+}
+
+// signal wakes up the loop if it isn't already due to run.
+func (b *webhookBatcher) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close sends whatever is still pending right away and waits for it to finish.
+func (b *webhookBatcher) close() {
+	close(b.stop)
+	<-b.done
+}
+
+// take removes the next digest worth of events from the pending ones.
+func (b *webhookBatcher) take() []ItemEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.pending)
+
+	if b.opts.MaxItems > 0 && n > b.opts.MaxItems {
+		n = b.opts.MaxItems
+	}
+
+	batch := b.pending[:n:n]
+	b.pending = b.pending[n:]
+
+	return batch
+}
+
+func (b *webhookBatcher) loop() {
+	defer close(b.done)
+
+	var last time.Time
+	stopping := false
+
+	for {
+		select {
+		case <-b.wake:
+		case <-b.stop:
+			stopping = true
+		}
+
+		// Let everything that finishes in the meantime join the digest
+		if wait := b.opts.Interval - time.Since(last); wait > 0 && !stopping {
+			select {
+			case <-time.After(wait):
+			case <-b.stop:
+				stopping = true
+			}
+		}
+
+		for {
+			batch := b.take()
+
+			if len(batch) == 0 {
+				break
+			}
+
+			b.send(batch)
+			last = time.Now()
+
+			// The rest waits for the next interval unless the run is over
+			if !stopping {
+				b.signal()
+				break
+			}
+		}
+
+		if stopping {
+			return
+		}
+	}
+}
+
+// send posts a single event as usual and several as a digest.
+func (b *webhookBatcher) send(batch []ItemEvent) {
+	if err := b.webhook.Send(digest(b.run, batch)); err != nil {
+		log.Printf("Webhook for %d events failed: %v", len(batch), err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bcdl/internal"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// runLabel attaches labels and notes to items of the collection, or lists them.
+func runLabel(args []string) {
+	fs := flag.NewFlagSet("label", flag.ExitOnError)
+	outpath := fs.String("outpath", os.Getenv("BCDL_OUTPATH"), "Library directory whose labels to change [$BCDL_OUTPATH]")
+	add := fs.String("add", "", "Comma-separated labels to add to the item, e.g. vinyl-owned")
+	remove := fs.String("remove", "", "Comma-separated labels to remove from the item")
+	note := fs.String("note", "", "Replace the note of the item, an empty note removes it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: bcdl label [flags] [ITEM]\n\nITEM is a title, \"Artist - Title\" or the URL of the item. Without one, every label and note is listed.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *outpath == "" {
+		log.Fatalf("Pass the library directory with --outpath")
+	}
+
+	notes, err := internal.LoadItemNotes(*outpath)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if fs.NArg() == 0 {
+		for _, n := range notes.List() {
+			fmt.Printf("%s  [%s]\n", n.Item, strings.Join(n.Labels, ", "))
+
+			if n.Note != "" {
+				fmt.Printf("    %s\n", strings.ReplaceAll(n.Note, "\n", "\n    "))
+			}
+		}
+
+		return
+	}
+
+	var text *string
+
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "note" {
+			text = note
+		}
+	})
+
+	if *add == "" && *remove == "" && text == nil {
+		log.Fatalf("Pass --add, --remove or --note")
+	}
+
+	if err := notes.Update(strings.Join(fs.Args(), " "), splitList(*add), splitList(*remove), text); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// splitList splits a comma-separated flag, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bcdl/internal"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// runList prints the collection without starting a browser, using the API the collection
+// page loads its items from.
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	username := fs.String("username", os.Getenv("BCDL_USERNAME"), "Fail unless the identity cookie belongs to this username")
+	filter := fs.String("filter", os.Getenv("BCDL_FILTER"), "Only list items whose title or artist contain this")
+	identityFrom := fs.String("identity-from", "", "Use the identity cookie of a browser: firefox, chrome, chromium, brave or auto")
+	cookiesFile := fs.String("cookies-file", "", "Use the identity cookie in a cookies.txt file")
+	outpath := fs.String("outpath", "", "Show the labels of the items from this library, see bcdl label")
+	excludeLabels := fs.String("exclude-label", "", "With --outpath, leave out items with one of these comma-separated labels")
+	onlyLabels := fs.String("only-label", "", "With --outpath, only list items with one of these comma-separated labels")
+	fs.Parse(args)
+
+	creds, err := resolveCredentials(*username, *cookiesFile, *identityFrom)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if creds.identity == "" {
+		log.Fatalf("No identity cookie found. Run `bcdl login` first")
+	}
+
+	collection, err := internal.ListCollection(internal.NewUserWithCookies(*username, creds.identity, creds.cookies), *filter)
+
+	if errors.Is(err, internal.ErrNotSignedIn) {
+		log.Fatalf("%v. Run `bcdl login` to sign in again", err)
+	}
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var notes *internal.ItemNotes
+
+	if *outpath != "" {
+		if notes, err = internal.LoadItemNotes(*outpath); err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		collection = internal.LabelFilter{Only: splitList(*onlyLabels), Exclude: splitList(*excludeLabels)}.Filter(collection, notes)
+	}
+
+	artists := map[string]bool{}
+
+	for _, entry := range collection {
+		artists[entry.Artist()] = true
+		purchased := "          "
+
+		if !entry.Purchased().IsZero() {
+			purchased = entry.Purchased().Format(time.DateOnly)
+		}
+
+		labels := ""
+
+		if note, ok := notes.Find(entry); ok && len(note.Labels) > 0 {
+			labels = "  [" + strings.Join(note.Labels, ", ") + "]"
+		}
+
+		fmt.Printf("%s  %s - %s%s\n", purchased, entry.Artist(), entry.Title(), labels)
+	}
+
+	log.Printf("%d items by %d artists\n", len(collection), len(artists))
+}
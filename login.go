@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bcdl/internal"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+// runLogin opens a browser to sign into Bandcamp and stores the identity cookie for later runs.
+func runLogin(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 10*time.Minute, "How long to wait for the sign in to finish")
+	fromBrowser := fs.String("from-browser", "", "Copy the session from a browser you are already signed into instead: firefox, chrome, chromium, brave or auto")
+	configPath := fs.String("config", os.Getenv("BCDL_CONFIG"), "Config file to read the profile from (default: bcdl/config.toml in your config directory) [$BCDL_CONFIG]")
+	profileName := fs.String("profile", os.Getenv("BCDL_PROFILE"), "Save the identity for the account of this profile of the config file [$BCDL_PROFILE]")
+	username := fs.String("username", os.Getenv("BCDL_USERNAME"), "Save the identity for this Bandcamp account, so every account of a household keeps its own (default: the username of the profile) [$BCDL_USERNAME]")
+	fs.Parse(args)
+
+	if *username == "" {
+		profile, err := loadProfile(*configPath, *profileName)
+
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		*username = profile.Username
+	}
+
+	var identity string
+	var err error
+
+	if *fromBrowser != "" {
+		identity, err = internal.ImportIdentity(*fromBrowser)
+	} else {
+		log.Println("Sign into Bandcamp in the browser window that just opened")
+		identity, err = internal.Login(*timeout)
+	}
+
+	if err != nil {
+		log.Fatalf("Could not log in: %v", err)
+	}
+
+	path, err := internal.SaveIdentity(*username, identity)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("Logged in. The identity cookie was saved to %s and will be used from now on\n", path)
+}
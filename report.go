@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bcdl/internal"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runReport summarizes the purchases and downloads of a year.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	year := fs.Int("year", time.Now().Year(), "Year to summarize")
+	username := fs.String("username", os.Getenv("BCDL_USERNAME"), "Fail unless the identity cookie belongs to this username")
+	outpath := fs.String("outpath", os.Getenv("BCDL_OUTPATH"), "Library directory whose history to count downloads from [$BCDL_OUTPATH]")
+	output := fs.String("output", "", "Write the report to this file instead of printing it. A .html file gets an HTML page")
+	format := fs.String("format", "", "markdown or html (default: by the extension of --output, otherwise markdown)")
+	identityFrom := fs.String("identity-from", "", "Use the identity cookie of a browser: firefox, chrome, chromium, brave or auto")
+	cookiesFile := fs.String("cookies-file", "", "Use the identity cookie in a cookies.txt file")
+	fs.Parse(args)
+
+	if *format == "" {
+		*format = "markdown"
+
+		if ext := strings.ToLower(filepath.Ext(*output)); ext == ".html" || ext == ".htm" {
+			*format = "html"
+		}
+	}
+
+	if *format != "markdown" && *format != "html" {
+		log.Fatalf("Unknown report format %q, use markdown or html", *format)
+	}
+
+	creds, err := resolveCredentials(*username, *cookiesFile, *identityFrom)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if creds.identity == "" {
+		log.Fatalf("No identity cookie found. Run `bcdl login` first")
+	}
+
+	user := internal.NewUserWithCookies(*username, creds.identity, creds.cookies)
+	collection, err := internal.ListCollection(user, "")
+
+	if errors.Is(err, internal.ErrNotSignedIn) {
+		log.Fatalf("%v. Run `bcdl login` to sign in again", err)
+	}
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	report, err := internal.NewYearReport(*year, user, collection, *outpath)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var w io.Writer = os.Stdout
+
+	if *output != "" {
+		file, err := os.Create(*output)
+
+		if err != nil {
+			log.Fatalf("Could not create %s: %v", *output, err)
+		}
+
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "html" {
+		err = report.WriteHTML(w)
+	} else {
+		err = report.WriteMarkdown(w)
+	}
+
+	if err != nil {
+		log.Fatalf("Could not write the report: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bcdl/internal"
+	"bcdl/internal/tui"
+	"errors"
+	"log"
+	"os"
+)
+
+// firstRun reports whether this is the first launch of bcdl, which sets it up: there is
+// no config file yet, the run doesn't say everything it needs to, and someone is at the
+// terminal to ask. It returns where the config file goes.
+func firstRun(configPath string, profile internal.Profile, targets bool) (string, bool) {
+	if configPath == "" {
+		var err error
+
+		if configPath, err = internal.DefaultConfigPath(); err != nil {
+			return "", false
+		}
+	}
+
+	if _, err := os.Stat(configPath); !errors.Is(err, os.ErrNotExist) {
+		return "", false
+	}
+
+	if targets || profile.Username != "" && profile.Directory != "" && profile.FileType != "" {
+		return "", false
+	}
+
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return "", false
+	}
+
+	return configPath, true
+}
+
+// runSetup walks through the setup, saves the identity cookie it captured and writes the
+// answers to the config file at path. It returns profile with the answers.
+func runSetup(path string, profile internal.Profile) internal.Profile {
+	setup, err := tui.RunSetup(tui.Setup{Username: profile.Username, Directory: profile.Directory})
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if setup.Identity != "" {
+		if _, err := internal.SaveIdentity(setup.Username, setup.Identity); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	config := internal.Config{Profile: setup.Profile()}
+
+	if err := internal.SaveConfig(path, config); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("Saved your settings to %s, edit it or pass flags to change them\n", path)
+
+	// Flags still win over the answers
+	return config.Profile.Merge(profile)
+}
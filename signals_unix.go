@@ -0,0 +1,33 @@
+//go:build !windows
+
+package main
+
+import (
+	"bcdl/internal"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handlePauseSignals pauses the downloader on SIGUSR1 and resumes it on SIGUSR2.
+//
+//	kill -USR1 <pid>   # finish in-flight downloads, start nothing new
+//	kill -USR2 <pid>   # carry on
+func handlePauseSignals(dl *internal.Downloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case syscall.SIGUSR1:
+				dl.Pause()
+				log.Println("Paused. In-flight downloads will finish, send SIGUSR2 to resume")
+			case syscall.SIGUSR2:
+				dl.Resume()
+				log.Println("Resumed")
+			}
+		}
+	}()
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "bcdl/internal"
+
+// handlePauseSignals is a no-op on Windows, which has no user defined signals.
+func handlePauseSignals(dl *internal.Downloader) {}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bcdl/internal"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// runState exports or imports everything bcdl remembers, to move it to another machine
+// or back it up alongside the library.
+func runState(args []string) {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, "Usage: bcdl state export|import [flags] FILE")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("state "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("BCDL_CONFIG"), "Config file to include (default: bcdl/config.toml in your config directory) [$BCDL_CONFIG]")
+	outpath := fs.String("outpath", os.Getenv("BCDL_OUTPATH"), "Library directory whose .bcdl state to include [$BCDL_OUTPATH]")
+	overwrite := fs.Bool("overwrite", false, "Replace files that already exist when importing")
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		log.Fatalf("Expected the path of the state archive, e.g. bcdl-state.tar.gz")
+	}
+
+	if *configPath == "" {
+		path, err := internal.DefaultConfigPath()
+
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		*configPath = path
+	}
+
+	archive := fs.Arg(0)
+
+	if args[0] == "export" {
+		file, err := os.Create(archive)
+
+		if err != nil {
+			log.Fatalf("Could not create %s: %v", archive, err)
+		}
+
+		if err = internal.ExportState(file, *configPath, *outpath); err == nil {
+			err = file.Close()
+		}
+
+		if err != nil {
+			file.Close()
+			os.Remove(archive)
+			log.Fatalf("%v", err)
+		}
+
+		log.Printf("Exported the bcdl state to %s. The identity cookie is not included, run `bcdl login` after importing\n", archive)
+		return
+	}
+
+	file, err := os.Open(archive)
+
+	if err != nil {
+		log.Fatalf("Could not open %s: %v", archive, err)
+	}
+
+	defer file.Close()
+
+	restored, err := internal.ImportState(file, *configPath, *outpath, *overwrite)
+
+	for _, path := range restored {
+		log.Printf("Restored %s\n", path)
+	}
+
+	if errors.Is(err, os.ErrExist) {
+		log.Fatalf("%v. Pass --overwrite to replace it", err)
+	}
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bcdl/internal"
+	"errors"
+	"flag"
+	"log"
+	"os"
+)
+
+// runVerify checks the archives in the library for corruption, such as downloads
+// truncated by a flaky connection, and can queue them to be downloaded again.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	outpath := fs.String("outpath", os.Getenv("BCDL_OUTPATH"), "Library directory to check [$BCDL_OUTPATH]")
+	requeue := fs.Bool("requeue", false, "Download corrupted archives again with the next `bcdl retry-failed`")
+	remote := fs.String("remote", "", "Compare a mirror of the library with the local copy instead, e.g. rclone:remote:/music")
+	download := fs.Bool("download", false, "With --remote, stream every file from the mirror for mirrors without checksum support")
+	fs.Parse(args)
+
+	if *outpath == "" {
+		log.Fatalf("Pass the library directory to check with --outpath")
+	}
+
+	if *remote != "" {
+		verifyRemote(*outpath, *remote, *download)
+		return
+	}
+
+	var checked, corrupted int
+
+	err := internal.VerifyLibrary(*outpath, func(path string, err error) {
+		checked++
+
+		if err == nil {
+			return
+		}
+
+		corrupted++
+		log.Printf("Corrupted: %s: %v\n", path, err)
+
+		if !*requeue {
+			return
+		}
+
+		title, err := internal.RequeueArchive(*outpath, path, err)
+
+		if errors.Is(err, internal.ErrNotInHistory) {
+			log.Printf("Could not requeue %s, delete it and download it again: %v\n", path, err)
+		} else if err != nil {
+			log.Printf("Could not requeue %s: %v\n", path, err)
+		} else {
+			log.Printf("Queued %s to be downloaded again\n", title)
+		}
+	})
+
+	if err != nil {
+		log.Fatalf("Could not check %s: %v", *outpath, err)
+	}
+
+	log.Printf("Checked %d archives, %d corrupted\n", checked, corrupted)
+
+	if corrupted > 0 {
+		if *requeue {
+			log.Println("Run `bcdl retry-failed` to download them again")
+		}
+
+		os.Exit(1)
+	}
+}
+
+// verifyRemote reports files that are missing or differ on the mirror of the library.
+func verifyRemote(dir, location string, download bool) {
+	remote, err := internal.ParseRcloneRemote(location)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	report, err := internal.VerifyRemote(dir, remote, download)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, path := range report.Missing {
+		log.Printf("Missing on the mirror: %s\n", path)
+	}
+
+	for _, path := range report.Differ {
+		log.Printf("Differs on the mirror: %s\n", path)
+	}
+
+	for _, path := range report.Failed {
+		log.Printf("Could not check: %s\n", path)
+	}
+
+	log.Printf("%d files match, %d missing, %d differ, %d could not be checked\n", report.Matched, len(report.Missing), len(report.Differ), len(report.Failed))
+
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
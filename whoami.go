@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bcdl/internal"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// runWhoami prints the Bandcamp account the identity cookie signs into.
+func runWhoami(args []string) {
+	fs := flag.NewFlagSet("whoami", flag.ExitOnError)
+	username := fs.String("username", os.Getenv("BCDL_USERNAME"), "Fail unless the identity cookie belongs to this username")
+	identityFrom := fs.String("identity-from", "", "Use the identity cookie of a browser: firefox, chrome, chromium, brave or auto")
+	cookiesFile := fs.String("cookies-file", "", "Use the identity cookie in a cookies.txt file")
+	fs.Parse(args)
+
+	creds, err := resolveCredentials(*username, *cookiesFile, *identityFrom)
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if creds.identity == "" {
+		log.Fatalf("No identity cookie found. Run `bcdl login` first")
+	}
+
+	fan, err := internal.VerifyIdentity(internal.NewUserWithCookies(*username, creds.identity, creds.cookies))
+
+	if errors.Is(err, internal.ErrNotSignedIn) {
+		log.Fatalf("%v. Run `bcdl login` to sign in again", err)
+	}
+
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if fan.Name != "" && fan.Name != fan.Username {
+		fmt.Printf("%s (%s)\n", fan.Username, fan.Name)
+	} else {
+		fmt.Println(fan.Username)
+	}
+}